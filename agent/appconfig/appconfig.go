@@ -97,6 +97,15 @@ func getAppConfigPath() (path string, err error) {
 	return AppConfigPath, err
 }
 
+// DefaultOutputRedactionPatterns returns the patterns redacted by default from the plugin outputs and the results written locally:
+// the AWS access key ids and the credentials set in the output, e.g. password=...
+func DefaultOutputRedactionPatterns() []OutputRedactionPattern {
+	return []OutputRedactionPattern{
+		{Pattern: `(?:AKIA|ASIA)[0-9A-Z]{16}`},
+		{Pattern: `(?i)(?:aws_secret_access_key|aws_session_token|password|passwd|secret|token)["']?\s*[=:]\s*["']?[^\s"',]+`},
+	}
+}
+
 // DefaultConfig returns default ssm agent configuration
 func DefaultConfig() SsmagentConfig {
 
@@ -105,9 +114,23 @@ func DefaultConfig() SsmagentConfig {
 	}
 	var s3 S3Cfg
 	var mds = MdsCfg{
-		CommandWorkersLimit: DefaultCommandWorkersLimit,
-		StopTimeoutMillis:   DefaultStopTimeoutMillis,
-		CommandRetryLimit:   DefaultCommandRetryLimit,
+		CommandWorkersLimit:                         DefaultCommandWorkersLimit,
+		CancelWorkersLimit:                          DefaultCancelWorkersLimit,
+		StopTimeoutMillis:                           DefaultStopTimeoutMillis,
+		CommandRetryLimit:                           DefaultCommandRetryLimit,
+		RebootResumeLimit:                           DefaultRebootResumeLimit,
+		UnsupportedDocumentsLimit:                   DefaultUnsupportedDocumentsLimit,
+		RewriteManagedInstanceIncompatibleDocuments: true,
+		ResumeConcurrencyLimit:                      DefaultResumeConcurrencyLimit,
+		ResumeIntervalMillis:                        DefaultResumeIntervalMillis,
+		OfflineDocumentSettleMillis:                 DefaultOfflineDocumentSettleMillis,
+		MessageOrderingStrategy:                     MessageOrderingStrategyNone,
+		PreconditionNotFoundAction:                  PreconditionNotFoundActionSkip,
+		ForeignDocumentStateAction:                  ForeignDocumentStateActionNone,
+		MessageOrderingTimeoutSeconds:               DefaultMessageOrderingTimeoutSeconds,
+		MessageParseWorkers:                         DefaultMessageParseWorkers,
+		DocumentFailureAlertWindowMinutes:           DefaultDocumentFailureAlertWindowMinutes,
+		OrchestrationDirCreationLimit:               DefaultOrchestrationDirCreationLimit,
 	}
 	var ssm = SsmCfg{
 		HealthFrequencyMinutes:                DefaultSsmHealthFrequencyMinutes,
@@ -116,10 +139,20 @@ func DefaultConfig() SsmagentConfig {
 		CustomInventoryDefaultLocation:        DefaultCustomInventoryFolder,
 		AssociationLogsRetentionDurationHours: DefaultAssociationLogsRetentionDurationHours,
 		RunCommandLogsRetentionDurationHours:  DefaultRunCommandLogsRetentionDurationHours,
+		MaxDocumentLogDeletionsPerRun:         DefaultMaxDocumentLogDeletionsPerRun,
+		CleanupWorkers:                        DefaultCleanupWorkers,
+		DocumentStateCacheSize:                DefaultDocumentStateCacheSize,
+		UnsupportedPluginPolicy:               UnsupportedPluginPolicyFailStep,
+		UnrecognizedPluginStatusPolicy:        UnrecognizedPluginStatusPolicyCoerce,
+		StepDependencyFailurePolicy:           StepDependencyFailurePolicySkip,
+		DocumentLockMode:                      DocumentLockModeNone,
+		StaleDocumentLockSeconds:              DefaultStaleDocumentLockSeconds,
+		OutputRedactionPatterns:               DefaultOutputRedactionPatterns(),
 	}
 	var agent = AgentInfo{
 		Name:                 "amazon-ssm-agent",
 		OrchestrationRootDir: defaultOrchestrationRootDirName,
+		CompressionCodec:     DefaultCompressionCodec,
 	}
 	var os = OsInfo{
 		Lang:    "en-US",
@@ -27,6 +27,7 @@ func parser(config *SsmagentConfig) {
 	config.Agent.Name = getStringValue(config.Agent.Name, DefaultAgentName)
 	config.Agent.OrchestrationRootDir = getStringValue(config.Agent.OrchestrationRootDir, defaultOrchestrationRootDirName)
 	config.Agent.Region = getStringValue(config.Agent.Region, "")
+	config.Agent.CompressionCodec = getStringValue(config.Agent.CompressionCodec, DefaultCompressionCodec)
 
 	// MDS config
 	config.Mds.CommandWorkersLimit = getNumericValue(
@@ -34,11 +35,64 @@ func parser(config *SsmagentConfig) {
 		DefaultCommandWorkersLimitMin,
 		config.Mds.CommandWorkersLimit, // we do not restrict max number of worker limit here
 		DefaultCommandWorkersLimit)
+	config.Mds.CancelWorkersLimit = getNumericValue(
+		config.Mds.CancelWorkersLimit,
+		DefaultCancelWorkersLimitMin,
+		config.Mds.CancelWorkersLimit, // we do not restrict max number of worker limit here
+		DefaultCancelWorkersLimit)
 	config.Mds.CommandRetryLimit = getNumericValue(
 		config.Mds.CommandRetryLimit,
 		DefaultCommandRetryLimitMin,
 		DefaultCommandRetryLimitMax,
 		DefaultCommandRetryLimit)
+	config.Mds.RebootResumeLimit = getNumericValue(
+		config.Mds.RebootResumeLimit,
+		DefaultRebootResumeLimitMin,
+		DefaultRebootResumeLimitMax,
+		DefaultRebootResumeLimit)
+	config.Mds.UnsupportedDocumentsLimit = getNumericValue(
+		config.Mds.UnsupportedDocumentsLimit,
+		DefaultUnsupportedDocumentsLimitMin,
+		DefaultUnsupportedDocumentsLimitMax,
+		DefaultUnsupportedDocumentsLimit)
+	config.Mds.ResumeConcurrencyLimit = getNumericValue(
+		config.Mds.ResumeConcurrencyLimit,
+		DefaultResumeConcurrencyLimitMin,
+		DefaultResumeConcurrencyLimitMax,
+		DefaultResumeConcurrencyLimit)
+	config.Mds.ResumeIntervalMillis = getNumericValue(
+		config.Mds.ResumeIntervalMillis,
+		DefaultResumeIntervalMillisMin,
+		DefaultResumeIntervalMillisMax,
+		DefaultResumeIntervalMillis)
+	config.Mds.OfflineDocumentSettleMillis = getNumericValue(
+		config.Mds.OfflineDocumentSettleMillis,
+		DefaultOfflineDocumentSettleMillisMin,
+		DefaultOfflineDocumentSettleMillisMax,
+		DefaultOfflineDocumentSettleMillis)
+	config.Mds.MessageOrderingTimeoutSeconds = getNumericValue(
+		config.Mds.MessageOrderingTimeoutSeconds,
+		DefaultMessageOrderingTimeoutSecondsMin,
+		DefaultMessageOrderingTimeoutSecondsMax,
+		DefaultMessageOrderingTimeoutSeconds)
+	config.Mds.MessageParseWorkers = getNumericValue(
+		config.Mds.MessageParseWorkers,
+		DefaultMessageParseWorkersMin,
+		DefaultMessageParseWorkersMax,
+		DefaultMessageParseWorkers)
+	config.Mds.OrchestrationDirCreationLimit = getNumericValue(
+		config.Mds.OrchestrationDirCreationLimit,
+		DefaultOrchestrationDirCreationLimitMin,
+		DefaultOrchestrationDirCreationLimitMax,
+		DefaultOrchestrationDirCreationLimit)
+	config.Mds.DocumentFailureAlertWindowMinutes = getNumericValue(
+		config.Mds.DocumentFailureAlertWindowMinutes,
+		DefaultDocumentFailureAlertWindowMinutesMin,
+		DefaultDocumentFailureAlertWindowMinutesMax,
+		DefaultDocumentFailureAlertWindowMinutes)
+	config.Mds.MessageOrderingStrategy = getStringValue(config.Mds.MessageOrderingStrategy, MessageOrderingStrategyNone)
+	config.Mds.PreconditionNotFoundAction = getStringValue(config.Mds.PreconditionNotFoundAction, PreconditionNotFoundActionSkip)
+	config.Mds.ForeignDocumentStateAction = getStringValue(config.Mds.ForeignDocumentStateAction, ForeignDocumentStateActionNone)
 	config.Mds.StopTimeoutMillis = getNumeric64Value(
 		config.Mds.StopTimeoutMillis,
 		DefaultStopTimeoutMillisMin,
@@ -66,7 +120,38 @@ func parser(config *SsmagentConfig) {
 		config.Ssm.RunCommandLogsRetentionDurationHours,
 		DefaultStateOrchestrationLogsRetentionDurationHoursMin,
 		DefaultRunCommandLogsRetentionDurationHours)
+	config.Ssm.MaxDocumentLogDeletionsPerRun = getNumericValueAboveMin(
+		config.Ssm.MaxDocumentLogDeletionsPerRun,
+		DefaultMaxDocumentLogDeletionsPerRunMin,
+		DefaultMaxDocumentLogDeletionsPerRun)
+	config.Ssm.CleanupWorkers = getNumericValue(
+		config.Ssm.CleanupWorkers,
+		DefaultCleanupWorkersMin,
+		DefaultCleanupWorkersMax,
+		DefaultCleanupWorkers)
+	config.Ssm.DocumentStateCacheSize = getNumericValue(
+		config.Ssm.DocumentStateCacheSize,
+		DefaultDocumentStateCacheSizeMin,
+		DefaultDocumentStateCacheSizeMax,
+		DefaultDocumentStateCacheSize)
+	config.Ssm.UnsupportedPluginPolicy = getStringValue(config.Ssm.UnsupportedPluginPolicy, UnsupportedPluginPolicyFailStep)
+	config.Ssm.UnrecognizedPluginStatusPolicy = getStringValue(config.Ssm.UnrecognizedPluginStatusPolicy, UnrecognizedPluginStatusPolicyCoerce)
+	config.Ssm.StepDependencyFailurePolicy = getStringValue(config.Ssm.StepDependencyFailurePolicy, StepDependencyFailurePolicySkip)
+	config.Ssm.DocumentLockMode = getStringValue(config.Ssm.DocumentLockMode, DocumentLockModeNone)
+	config.Ssm.StaleDocumentLockSeconds = getNumericValue(
+		config.Ssm.StaleDocumentLockSeconds,
+		DefaultStaleDocumentLockSecondsMin,
+		DefaultStaleDocumentLockSecondsMax,
+		DefaultStaleDocumentLockSeconds)
 
+	// S3 config
+	// intermediate output uploads are disabled unless an interval is set, in which case it can't be shorter than the minimum
+	if config.S3.OutputFlushIntervalSeconds != 0 {
+		config.S3.OutputFlushIntervalSeconds = getNumericValueAboveMin(
+			config.S3.OutputFlushIntervalSeconds,
+			DefaultS3OutputFlushIntervalSecondsMin,
+			DefaultS3OutputFlushIntervalSecondsMin)
+	}
 }
 
 // getStringValue returns the default value if config is empty, else the config value
@@ -20,13 +20,112 @@ const (
 	// Agent defaults
 	DefaultAgentName = "amazon-ssm-agent"
 
+	// DefaultCompressionCodec is the codec compressing the data persisted by the agent
+	DefaultCompressionCodec = "gzip"
+
+	// UnsupportedPluginPolicyFailStep fails the steps whose plugin isn't supported and runs the other steps
+	UnsupportedPluginPolicyFailStep = "FailStep"
+	// UnsupportedPluginPolicyMarkUnsupported marks the steps whose plugin isn't supported as UnsupportedPlugin and runs the other steps
+	UnsupportedPluginPolicyMarkUnsupported = "MarkUnsupported"
+	// UnsupportedPluginPolicyFailDocument fails all the steps of a document if any of its plugins isn't supported
+	UnsupportedPluginPolicyFailDocument = "FailDocument"
+
+	// UnrecognizedPluginStatusPolicyCoerce fails a step whose plugin reports an unrecognized status, keeping the rest of its result
+	UnrecognizedPluginStatusPolicyCoerce = "Coerce"
+	// UnrecognizedPluginStatusPolicyReject fails a step whose plugin reports an unrecognized status, discarding the result it reported
+	UnrecognizedPluginStatusPolicyReject = "Reject"
+
+	// StepDependencyFailurePolicySkip skips the steps a step they depend on didn't succeed
+	StepDependencyFailurePolicySkip = "Skip"
+	// StepDependencyFailurePolicyFailFast fails the steps a step they depend on didn't succeed, failing the document
+	StepDependencyFailurePolicyFailFast = "FailFast"
+
+	// DocumentLockModeNone only locks the documents within the agent process
+	DocumentLockModeNone = "None"
+	// DocumentLockModeFlock also locks the documents across processes with flock, on a local filesystem
+	DocumentLockModeFlock = "Flock"
+	// DocumentLockModeLockFile also locks the documents across processes with lock files created exclusively,
+	// which works on network filesystems such as NFS where flock doesn't
+	DocumentLockModeLockFile = "LockFile"
+
+	// MessageOrderingStrategyNone processes the messages of a command as they arrive
+	MessageOrderingStrategyNone = "None"
+	// MessageOrderingStrategySequence processes the messages of a command in the order of their sequence numbers
+	MessageOrderingStrategySequence = "Sequence"
+
+	// PreconditionNotFoundActionSkip skips a document whose precondition command can't be found
+	PreconditionNotFoundActionSkip = "Skip"
+	// PreconditionNotFoundActionRun runs a document whose precondition command can't be found
+	PreconditionNotFoundActionRun = "Run"
+
+	// ForeignDocumentStateActionNone only reports the document states recording another instance id
+	ForeignDocumentStateActionNone = "None"
+	// ForeignDocumentStateActionMigrate rewrites the document states recording another instance id to reference the instance
+	ForeignDocumentStateActionMigrate = "Migrate"
+	// ForeignDocumentStateActionQuarantine moves the document states recording another instance id to the corrupt folder
+	ForeignDocumentStateActionQuarantine = "Quarantine"
+
 	DefaultCommandWorkersLimit    = 5
 	DefaultCommandWorkersLimitMin = 1
 
+	DefaultCancelWorkersLimit    = 3
+	DefaultCancelWorkersLimitMin = 1
+
 	DefaultCommandRetryLimit    = 15
 	DefaultCommandRetryLimitMin = 1
 	DefaultCommandRetryLimitMax = 100
 
+	DefaultRebootResumeLimit    = 10
+	DefaultRebootResumeLimitMin = 1
+	DefaultRebootResumeLimitMax = 100
+
+	DefaultUnsupportedDocumentsLimit    = 1000
+	DefaultUnsupportedDocumentsLimitMin = 1
+	DefaultUnsupportedDocumentsLimitMax = 10000
+
+	DefaultResumeConcurrencyLimit    = 0
+	DefaultResumeConcurrencyLimitMin = 0
+	DefaultResumeConcurrencyLimitMax = 1000
+
+	DefaultResumeIntervalMillis    = 1000
+	DefaultResumeIntervalMillisMin = 0
+	DefaultResumeIntervalMillisMax = 600000
+
+	DefaultOfflineDocumentSettleMillis    = 1000
+	DefaultOfflineDocumentSettleMillisMin = 0
+	DefaultOfflineDocumentSettleMillisMax = 60000
+
+	DefaultMessageOrderingTimeoutSeconds    = 30
+	DefaultMessageOrderingTimeoutSecondsMin = 1
+	DefaultMessageOrderingTimeoutSecondsMax = 3600
+
+	DefaultMessageParseWorkers    = 1
+	DefaultMessageParseWorkersMin = 1
+	DefaultMessageParseWorkersMax = 32
+
+	DefaultOrchestrationDirCreationLimit    = 4
+	DefaultOrchestrationDirCreationLimitMin = 1
+	DefaultOrchestrationDirCreationLimitMax = 64
+
+	DefaultDocumentFailureAlertWindowMinutes    = 60
+	DefaultDocumentFailureAlertWindowMinutesMin = 1
+	DefaultDocumentFailureAlertWindowMinutesMax = 10080
+
+	DefaultMaxDocumentLogDeletionsPerRun    = 100
+	DefaultMaxDocumentLogDeletionsPerRunMin = 1
+
+	DefaultCleanupWorkers    = 4
+	DefaultCleanupWorkersMin = 1
+	DefaultCleanupWorkersMax = 64
+
+	DefaultDocumentStateCacheSize    = 0
+	DefaultDocumentStateCacheSizeMin = 0
+	DefaultDocumentStateCacheSizeMax = 4096
+
+	DefaultStaleDocumentLockSeconds    = 300
+	DefaultStaleDocumentLockSecondsMin = 1
+	DefaultStaleDocumentLockSecondsMax = 86400
+
 	DefaultStopTimeoutMillis    = 20000
 	DefaultStopTimeoutMillisMin = 10000
 	DefaultStopTimeoutMillisMax = 1000000
@@ -40,11 +139,15 @@ const (
 	DefaultSsmAssociationFrequencyMinutesMin = 5
 	DefaultSsmAssociationFrequencyMinutesMax = 60
 
+	// S3 defaults
+	DefaultS3OutputFlushIntervalSecondsMin = 30
+
 	//aws-ssm-agent bookkeeping constants
 	DefaultLocationOfPending     = "pending"
 	DefaultLocationOfCurrent     = "current"
 	DefaultLocationOfCompleted   = "completed"
 	DefaultLocationOfCorrupt     = "corrupt"
+	DefaultLocationOfFailed      = "failed"
 	DefaultLocationOfState       = "state"
 	DefaultLocationOfAssociation = "association"
 
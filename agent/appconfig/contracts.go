@@ -24,10 +24,66 @@ type CredentialProfile struct {
 
 // MdsCfg represents configuration for Message delivery service (MDS)
 type MdsCfg struct {
-	Endpoint            string
-	CommandWorkersLimit int
-	StopTimeoutMillis   int64
-	CommandRetryLimit   int
+	Endpoint                string
+	CommandWorkersLimit     int
+	CancelWorkersLimit      int
+	StopTimeoutMillis       int64
+	CommandRetryLimit       int
+	SeparateFailedDocuments bool
+	RebootResumeLimit       int
+	// LocalResultsDir is the folder the final result of each command is written to, as <commandID>.json, empty disables it
+	LocalResultsDir string
+	// UnsupportedDocumentsFile is a JSON array of the names of the documents the agent refuses to run, empty disables it
+	UnsupportedDocumentsFile string
+	// UnsupportedDocumentsLimit caps the number of names loaded from UnsupportedDocumentsFile
+	UnsupportedDocumentsLimit int
+	// RewriteManagedInstanceIncompatibleDocuments replaces the instance metadata calls of the public AWS SSM documents known to need them on managed instances
+	RewriteManagedInstanceIncompatibleDocuments bool
+	// SendOfflineInProgressResponse sends the InProgress response of the documents received from the offline service as well
+	SendOfflineInProgressResponse bool
+	// ResumeConcurrencyLimit caps the number of documents left over by the previous run that run at once, 0 resumes them all at once
+	ResumeConcurrencyLimit int
+	// ResumeIntervalMillis spaces out the submission of the documents resumed beyond ResumeConcurrencyLimit
+	ResumeIntervalMillis int
+	// OfflineDropFolder is the folder the offline command documents are dropped into, empty for the default local command folder
+	OfflineDropFolder string
+	// WatchOfflineDropFolder picks up the offline command documents as soon as they're dropped instead of on the next poll
+	WatchOfflineDropFolder bool
+	// OfflineDocumentSettleMillis is how long an offline command document must go unmodified to be picked up without a ready marker
+	OfflineDocumentSettleMillis int
+	// MessageVisibilitySeconds is how long a message stays invisible to the other pollers once received, a message whose processing
+	// took longer is left unacknowledged as MDS already redelivered it, 0 acknowledges every message
+	MessageVisibilitySeconds int
+	// ProtectedDocuments are the name patterns of the documents left running when the agent stops, up to ProtectedDocumentsStopTimeoutSeconds,
+	// a protected document shut down before it completes is resumed once the agent restarts instead of failing
+	ProtectedDocuments []string
+	// ProtectedDocumentsStopTimeoutSeconds is how long the protected documents may keep running once the agent stops, 0 doesn't wait for them
+	ProtectedDocumentsStopTimeoutSeconds int
+	// MessageOrderingStrategy is how the messages of a command are ordered, one of None or Sequence,
+	// Sequence holds a message back until the messages of its command with a lower sequence number are processed
+	MessageOrderingStrategy string
+	// MessageOrderingTimeoutSeconds is how long a message is held back at most, it's processed anyway past it
+	MessageOrderingTimeoutSeconds int
+	// MessageParseWorkers is how many messages of a poll are parsed at once, they're still acknowledged
+	// and submitted in the order they were received
+	MessageParseWorkers int
+	// DocumentFailureAlertThreshold is how many documents of a name may fail within DocumentFailureAlertWindowMinutes
+	// before an alert is raised, 0 disables the alert
+	DocumentFailureAlertThreshold int
+	// DocumentFailureAlertWindowMinutes is the window the failures of the documents of a name are counted over
+	DocumentFailureAlertWindowMinutes int
+	// PreconditionNotFoundAction is what happens to a document whose precondition command isn't found among the completed
+	// documents, one of Skip or Run
+	PreconditionNotFoundAction string
+	// OrchestrationDirCreationLimit caps how many orchestration directories are created at once, so that a burst of documents
+	// doesn't flood the filesystem with metadata operations
+	OrchestrationDirCreationLimit int
+	// ForeignDocumentStateAction is what happens on start to the document states recording another instance id than the instance's,
+	// e.g. carried over from another host along with its disk, one of None, Migrate or Quarantine
+	ForeignDocumentStateAction string
+	// PersistRawMessages keeps the message each command was received in, redacted with the OutputRedactionPatterns of every plugin,
+	// next to its document state
+	PersistRawMessages bool
 }
 
 // SsmCfg represents configuration for Simple system manager (SSM)
@@ -42,6 +98,78 @@ type SsmCfg struct {
 	CustomInventoryDefaultLocation        string
 	AssociationLogsRetentionDurationHours int
 	RunCommandLogsRetentionDurationHours  int
+	LogsRetentionOverrides                []RetentionOverride
+	CompactStateFolders                   []string
+	// DocumentStateCacheSize is the number of document states read back kept in memory, so that reading a state again
+	// doesn't read and unmarshal its file again until it's written. 0, the default, disables the cache. The cache is bypassed
+	// while the states are signed or VerifyStateChecksums is set, as every read then verifies the state
+	DocumentStateCacheSize int
+	// VerifyStateChecksums records the SHA-256 of each document state persisted in a sidecar and checks it when the state is read,
+	// a state that doesn't match it is moved to the corrupt folder. Signed states are always verified.
+	VerifyStateChecksums bool
+	// CompressCompletedStates gzips the document states moved to the completed folder, they're then persisted as <document id>.gz
+	CompressCompletedStates bool
+	// FsyncDocumentState flushes each document state persisted, and the folder it's renamed into, to stable storage before
+	// the write returns, so that a power loss right after it doesn't lose the state. Each write waits for the disk then,
+	// which slows down the processing of the documents noticeably on slow or busy disks.
+	FsyncDocumentState bool
+	// MaxDocumentLogDeletionsPerRun caps the number of files and orchestration dirs a cleanup of the old documents deletes in one pass
+	MaxDocumentLogDeletionsPerRun int
+	// CleanupWorkers is the number of old documents a cleanup deletes at once
+	CleanupWorkers int
+	// CleanupPauseInFlightThreshold defers the cleanup of the old documents while more documents are in flight, 0 never defers it
+	CleanupPauseInFlightThreshold int
+	// UnsupportedPluginPolicy is how the steps of a document referencing a plugin the agent doesn't support are handled,
+	// one of FailStep, MarkUnsupported or FailDocument
+	UnsupportedPluginPolicy string
+	// UnrecognizedPluginStatusPolicy is how the result of a plugin reporting a status the agent doesn't know is handled,
+	// one of Coerce or Reject
+	UnrecognizedPluginStatusPolicy string
+	// StepDependencyFailurePolicy is how a step is handled when a step it depends on, per its dependsOn, didn't succeed,
+	// one of Skip or FailFast
+	StepDependencyFailurePolicy string
+	// PluginOutputOffloadThresholdBytes is the size above which the output of a plugin is persisted in its own file
+	// instead of the document state, 0 keeps every output in the document state
+	PluginOutputOffloadThresholdBytes int
+	// PluginStateDebounceMillis is how long the in progress updates of the plugin states of a document are held back
+	// to be persisted in a single write, the terminal updates are persisted right away along with them. The updates
+	// held back are lost if the agent crashes in the meantime. 0 persists every update right away
+	PluginStateDebounceMillis int
+	// DocumentLockMode is how the writes of the document states are locked against the other processes sharing the data store,
+	// one of None, Flock or LockFile. LockFile is meant for a data store on a network filesystem such as NFS
+	DocumentLockMode string
+	// StaleDocumentLockSeconds is the age above which the lock file of a document is considered left over by a crashed process
+	// and is broken, with the LockFile mode
+	StaleDocumentLockSeconds int
+	// MaxRetainedDocuments caps the number of completed documents kept on the instance whatever their age,
+	// the oldest ones are deleted above it, 0 disables the cap
+	MaxRetainedDocuments int
+	// DataStoreSizeCapMB is the size the data store of the instance may use, the oldest documents are purged above it, 0 disables the cap
+	DataStoreSizeCapMB int
+	// OutputRedactionPatterns are the patterns redacted from the plugin outputs before they are persisted or uploaded,
+	// and from the results written to Mds.LocalResultsDir. They default to DefaultOutputRedactionPatterns
+	OutputRedactionPatterns []OutputRedactionPattern
+	// EncryptedStateFields are the json paths of the fields of the document states encrypted at rest, e.g. InstancePluginsInformation.*.Configuration.Properties,
+	// they're left in plain text until a key is provided
+	EncryptedStateFields []string
+	// StateEncryptionKeyFile is the file of the AES key the whole document states are encrypted with at rest, base64 encoded,
+	// empty leaves them in plain text. With StateEncryptionKmsKeyID the file holds the key encrypted by KMS instead, it's created on first use.
+	StateEncryptionKeyFile string
+	// StateEncryptionKmsKeyID is the id of the KMS key encrypting the key of StateEncryptionKeyFile
+	StateEncryptionKmsKeyID string
+}
+
+// RetentionOverride extends the retention of the state and orchestration logs of the documents whose name matches the pattern
+type RetentionOverride struct {
+	DocumentNamePattern    string
+	RetentionDurationHours int
+}
+
+// OutputRedactionPattern is a regular expression whose matches are redacted from the outputs of the plugin,
+// of every plugin when the plugin name is empty
+type OutputRedactionPattern struct {
+	PluginName string
+	Pattern    string
 }
 
 // AgentInfo represents metadata for amazon-ssm-agent
@@ -51,6 +179,12 @@ type AgentInfo struct {
 	Region               string
 	OrchestrationRootDir string
 	DownloadRootDir      string
+	CompressionCodec     string
+	// PartitionOrchestrationDirsByDate creates the orchestration dirs of the commands under <yyyy>/<mm>/<dd> partitions
+	// of the orchestration root dir, the day they're received, instead of directly under the root dir
+	PartitionOrchestrationDirsByDate bool
+	// FallbackInstanceID is the instance id the document states are persisted under when the instance id lookup fails, empty disables it
+	FallbackInstanceID string
 }
 
 // MfsCfg represents configuration for HummingBird service (MFS)
@@ -69,10 +203,11 @@ type OsInfo struct {
 
 // S3Cfg represents configurations related to S3 bucket and key for SSM
 type S3Cfg struct {
-	Endpoint  string
-	Region    string
-	LogBucket string
-	LogKey    string
+	Endpoint                   string
+	Region                     string
+	LogBucket                  string
+	LogKey                     string
+	OutputFlushIntervalSeconds int
 }
 
 // BirdwatcherCfg represents configuration related to ConfigurePackage Birdwatcher integration
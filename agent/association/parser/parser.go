@@ -117,6 +117,7 @@ func newDocumentInfo(rawData *model.InstanceAssociation, payload *messageContrac
 	documentInfo.IsCommand = false
 	documentInfo.DocumentStatus = contracts.ResultStatusInProgress
 	documentInfo.DocumentTraceOutput = ""
+	documentInfo.OrchestrationRetentionHours = payload.OrchestrationRetentionHours
 
 	return *documentInfo
 }
@@ -15,6 +15,7 @@
 package processor
 
 import (
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/association/model"
 	"github.com/aws/amazon-ssm-agent/agent/association/parser"
 	"github.com/aws/amazon-ssm-agent/agent/context"
@@ -33,13 +34,23 @@ var sys system = &systemImp{}
 
 // bookkeepingService represents the dependency for docmanager
 type bookkeepingService interface {
-	DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, isIntendedFileNameFormat func(string) bool, formOrchestrationFolderName func(string) string)
+	DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, maxDeletions int, isIntendedFileNameFormat func(string) bool, formOrchestrationFolderName func(string) string)
+	EnforceDataStoreSizeCap(log log.T, instanceID string, maxBytes int64) int64
+	EnforceDocumentCountLimit(log log.T, instanceID, orchestrationRootDirName string, maxDocuments int) int
 }
 
 type assocBookkeepingService struct{}
 
-func (assocBookkeepingService) DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, isIntendedFileNameFormat func(string) bool, formOrchestrationFolderName func(string) string) {
-	docmanager.DeleteOldDocumentFolderLogs(log, instanceID, orchestrationRootDirName, retentionDurationHours, isIntendedFileNameFormat, formOrchestrationFolderName)
+func (assocBookkeepingService) DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, maxDeletions int, isIntendedFileNameFormat func(string) bool, formOrchestrationFolderName func(string) string) {
+	docmanager.DeleteOldDocumentFolderLogs(log, instanceID, orchestrationRootDirName, retentionDurationHours, retentionOverrides, maxDeletions, isIntendedFileNameFormat, formOrchestrationFolderName)
+}
+
+func (assocBookkeepingService) EnforceDataStoreSizeCap(log log.T, instanceID string, maxBytes int64) int64 {
+	return docmanager.EnforceDataStoreSizeCap(log, instanceID, maxBytes)
+}
+
+func (assocBookkeepingService) EnforceDocumentCountLimit(log log.T, instanceID, orchestrationRootDirName string, maxDocuments int) int {
+	return docmanager.EnforceDocumentCountLimit(log, instanceID, orchestrationRootDirName, maxDocuments)
 }
 
 // system represents the dependency for platform
@@ -17,6 +17,7 @@ package processor
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"path/filepath"
@@ -66,6 +67,15 @@ type Processor struct {
 
 var lock sync.RWMutex
 
+// Assign method to global variables to allow unittest to override
+var inFlightDocuments = processor.InFlightDocuments
+
+// cleanupLoadCheckInterval is how often a deferred cleanup checks if the load went down
+var cleanupLoadCheckInterval = 30 * time.Second
+
+// cleanupScheduled is set while a cleanup of the old association logs is waiting or running
+var cleanupScheduled int32
+
 // NewAssociationProcessor returns a new Processor with the given context.
 func NewAssociationProcessor(context context.T, instanceID string) *Processor {
 	assocContext := context.With("[" + name + "]")
@@ -541,12 +551,7 @@ func (r *Processor) lisenToResponses() {
 			instanceID, _ := sys.InstanceID()
 			//clean association logs once the document state is moved to completed
 			//clean completed document state files and orchestration dirs. Takes care of only files generated by association in the folder
-			go assocBookkeeping.DeleteOldDocumentFolderLogs(log,
-				instanceID,
-				r.context.AppConfig().Agent.OrchestrationRootDir,
-				r.context.AppConfig().Ssm.AssociationLogsRetentionDurationHours,
-				isAssociationLogFile,
-				formAssociationOrchestrationFolder)
+			go r.deleteOldLogsWhenIdle(log, instanceID)
 			//TODO move this part to service
 			schedulemanager.UpdateNextScheduledDate(log, res.AssociationID)
 			signal.ExecuteAssociation(log)
@@ -556,6 +561,45 @@ func (r *Processor) lisenToResponses() {
 	}
 }
 
+// deleteOldLogsWhenIdle cleans the old association logs, deferring the cleanup while the processors have more documents in flight
+// than the configured threshold so that it doesn't compete with them for disk I/O. One cleanup at most waits at any time.
+func (r *Processor) deleteOldLogsWhenIdle(log log.T, instanceID string) {
+	if !atomic.CompareAndSwapInt32(&cleanupScheduled, 0, 1) {
+		log.Debug("a cleanup of the old association logs is already scheduled")
+		return
+	}
+	defer atomic.StoreInt32(&cleanupScheduled, 0)
+
+	config := r.context.AppConfig()
+	for threshold := config.Ssm.CleanupPauseInFlightThreshold; threshold > 0; {
+		inFlight := inFlightDocuments()
+		if inFlight <= threshold {
+			break
+		}
+		log.Debugf("%v documents in flight, deferring the cleanup of the old association logs", inFlight)
+		time.Sleep(cleanupLoadCheckInterval)
+	}
+
+	assocBookkeeping.DeleteOldDocumentFolderLogs(log,
+		instanceID,
+		config.Agent.OrchestrationRootDir,
+		config.Ssm.AssociationLogsRetentionDurationHours,
+		config.Ssm.LogsRetentionOverrides,
+		config.Ssm.MaxDocumentLogDeletionsPerRun,
+		isAssociationLogFile,
+		formAssociationOrchestrationFolder)
+
+	// nor the number of documents low enough
+	if maxDocuments := config.Ssm.MaxRetainedDocuments; maxDocuments > 0 {
+		assocBookkeeping.EnforceDocumentCountLimit(log, instanceID, config.Agent.OrchestrationRootDir, maxDocuments)
+	}
+
+	// the retention alone may not keep the data store small enough
+	if sizeCapMB := config.Ssm.DataStoreSizeCapMB; sizeCapMB > 0 {
+		assocBookkeeping.EnforceDataStoreSizeCap(log, instanceID, int64(sizeCapMB)*1024*1024)
+	}
+}
+
 // isAssociationLogFile checks whether the file name passed is of the format of Association Files
 func isAssociationLogFile(fileName string) (matched bool) {
 	matched, _ = regexp.MatchString("^[a-f0-9]{8}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{4}-[a-f0-9]{12}\\.[0-9]{4}-[0-9]{2}-[0-9]{2}.*$", fileName)
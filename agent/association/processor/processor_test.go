@@ -16,6 +16,7 @@ package processor
 
 import (
 	"errors"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -26,6 +27,7 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	docModel "github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/mock"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	messageContracts "github.com/aws/amazon-ssm-agent/agent/runcommand/contracts"
@@ -242,6 +244,51 @@ func mockParser(parserMock *parserMock, payload *messageContracts.SendCommandPay
 		mock.AnythingOfType("*model.InstanceAssociation")).Return(docState)
 }
 
+func TestDeleteOldLogsDeferredWhileUnderLoad(t *testing.T) {
+	inFlight := int64(5)
+	inFlightDocuments = func() int { return int(atomic.LoadInt64(&inFlight)) }
+	cleanupLoadCheckInterval = 10 * time.Millisecond
+	defer func() {
+		inFlightDocuments = processor.InFlightDocuments
+		cleanupLoadCheckInterval = 30 * time.Second
+		assocBookkeeping = &assocBookkeepingService{}
+	}()
+
+	cleaned := make(chan bool, 1)
+	bookkeeping := bookkeepingMock{}
+	bookkeeping.On("DeleteOldDocumentFolderLogs", mock.Anything, "i-test", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Run(func(args mock.Arguments) {
+		cleaned <- true
+	}).Return()
+	assocBookkeeping = &bookkeeping
+
+	config := appconfig.DefaultConfig()
+	config.Ssm.CleanupPauseInFlightThreshold = 2
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	r := createProcessor()
+	r.context = ctx
+
+	go r.deleteOldLogsWhenIdle(ctx.Log(), "i-test")
+
+	select {
+	case <-cleaned:
+		assert.Fail(t, "cleanup ran while the documents in flight are above the threshold")
+	case <-time.After(100 * time.Millisecond):
+	}
+	// a second cleanup doesn't queue up behind the deferred one
+	r.deleteOldLogsWhenIdle(ctx.Log(), "i-test")
+	bookkeeping.AssertNotCalled(t, "DeleteOldDocumentFolderLogs", mock.Anything, "i-test", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+
+	atomic.StoreInt64(&inFlight, 2)
+	select {
+	case <-cleaned:
+	case <-time.After(time.Second):
+		assert.Fail(t, "cleanup didn't resume once the load went down")
+	}
+	bookkeeping.AssertNumberOfCalls(t, "DeleteOldDocumentFolderLogs", 1)
+}
+
 func createProcessor() *Processor {
 	processor := Processor{}
 	processor.context = context.NewMockDefault()
@@ -265,3 +312,43 @@ func createAssociationRawData() []*model.InstanceAssociation {
 
 	return []*model.InstanceAssociation{&assocRawData}
 }
+
+func TestDeleteOldLogsWhenIdleEnforcesDataStoreSizeCap(t *testing.T) {
+	defer func() { assocBookkeeping = &assocBookkeepingService{} }()
+	bookkeeping := bookkeepingMock{}
+	bookkeeping.On("DeleteOldDocumentFolderLogs", mock.Anything, "i-test", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	bookkeeping.On("EnforceDataStoreSizeCap", mock.Anything, "i-test", int64(5*1024*1024)).Return(int64(0))
+	assocBookkeeping = &bookkeeping
+
+	config := appconfig.DefaultConfig()
+	config.Ssm.DataStoreSizeCapMB = 5
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	r := createProcessor()
+	r.context = ctx
+
+	r.deleteOldLogsWhenIdle(ctx.Log(), "i-test")
+
+	bookkeeping.AssertExpectations(t)
+}
+
+func TestDeleteOldLogsWhenIdleEnforcesDocumentCountLimit(t *testing.T) {
+	defer func() { assocBookkeeping = &assocBookkeepingService{} }()
+	bookkeeping := bookkeepingMock{}
+	bookkeeping.On("DeleteOldDocumentFolderLogs", mock.Anything, "i-test", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return()
+	bookkeeping.On("EnforceDocumentCountLimit", mock.Anything, "i-test", mock.Anything, 10).Return(0)
+	assocBookkeeping = &bookkeeping
+
+	config := appconfig.DefaultConfig()
+	config.Ssm.MaxRetainedDocuments = 10
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	r := createProcessor()
+	r.context = ctx
+
+	r.deleteOldLogsWhenIdle(ctx.Log(), "i-test")
+
+	bookkeeping.AssertExpectations(t)
+}
@@ -15,6 +15,7 @@
 package processor
 
 import (
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/association/model"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	docModel "github.com/aws/amazon-ssm-agent/agent/docmanager/model"
@@ -40,8 +41,23 @@ type bookkeepingMock struct {
 }
 
 // PersistData mocks implementation for PersistData
-func (m *bookkeepingMock) PersistData(log log.T, commandID, instanceID, locationFolder string, object interface{}) {
-	m.Called(log, commandID, instanceID, locationFolder, object)
+func (m *bookkeepingMock) PersistData(log log.T, commandID, instanceID, locationFolder string, object interface{}) error {
+	return m.Called(log, commandID, instanceID, locationFolder, object).Error(0)
+}
+
+// DeleteOldDocumentFolderLogs mocks implementation for DeleteOldDocumentFolderLogs
+func (m *bookkeepingMock) DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, maxDeletions int, isIntendedFileNameFormat func(string) bool, formOrchestrationFolderName func(string) string) {
+	m.Called(log, instanceID, orchestrationRootDirName, retentionDurationHours, retentionOverrides, maxDeletions)
+}
+
+// EnforceDataStoreSizeCap mocks implementation for EnforceDataStoreSizeCap
+func (m *bookkeepingMock) EnforceDataStoreSizeCap(log log.T, instanceID string, maxBytes int64) int64 {
+	return m.Called(log, instanceID, maxBytes).Get(0).(int64)
+}
+
+// EnforceDocumentCountLimit mocks implementation for EnforceDocumentCountLimit
+func (m *bookkeepingMock) EnforceDocumentCountLimit(log log.T, instanceID, orchestrationRootDirName string, maxDocuments int) int {
+	return m.Called(log, instanceID, orchestrationRootDirName, maxDocuments).Int(0)
 }
 
 type parserMock struct {
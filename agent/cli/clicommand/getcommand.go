@@ -151,6 +151,9 @@ func (c *GetOfflineCommand) getCommandStatus(commandID string, showDetails bool)
 	if c.isCommandInState(appconfig.DefaultLocationOfCompleted, commandID) {
 		return nil, "Complete"
 	}
+	if c.isCommandInState(appconfig.DefaultLocationOfFailed, commandID) {
+		return nil, "Failed"
+	}
 	if c.isCommandInState(appconfig.DefaultLocationOfPending, commandID) {
 		return nil, "Pending"
 	}
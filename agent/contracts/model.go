@@ -29,6 +29,8 @@ const (
 	ResultStatusCancelled        ResultStatus = "Cancelled"
 	ResultStatusTimedOut         ResultStatus = "TimedOut"
 	ResultStatusSkipped          ResultStatus = "Skipped"
+	// ResultStatusUnsupportedPlugin marks a step whose plugin isn't supported by the agent, see appconfig.UnsupportedPluginPolicyMarkUnsupported
+	ResultStatusUnsupportedPlugin ResultStatus = "UnsupportedPlugin"
 )
 
 func (rs ResultStatus) IsSuccess() bool {
@@ -40,6 +42,17 @@ func (rs ResultStatus) IsSuccess() bool {
 	}
 }
 
+// IsRecognized returns if the status is one of the statuses a plugin can report
+func (rs ResultStatus) IsRecognized() bool {
+	switch rs {
+	case ResultStatusNotStarted, ResultStatusInProgress, ResultStatusSuccess, ResultStatusSuccessAndReboot, ResultStatusPassedAndReboot,
+		ResultStatusFailed, ResultStatusCancelled, ResultStatusTimedOut, ResultStatusSkipped, ResultStatusUnsupportedPlugin:
+		return true
+	default:
+		return false
+	}
+}
+
 func (rs ResultStatus) IsReboot() bool {
 	switch rs {
 	case ResultStatusPassedAndReboot, ResultStatusSuccessAndReboot:
@@ -53,6 +66,7 @@ func (rs ResultStatus) IsReboot() bool {
 func MergeResultStatus(current ResultStatus, new ResultStatus) (merged ResultStatus) {
 	orderedResultStatus := [...]ResultStatus{
 		ResultStatusSkipped,
+		ResultStatusUnsupportedPlugin,
 		ResultStatusSuccess,
 		ResultStatusSuccessAndReboot,
 		ResultStatusPassedAndReboot,
@@ -168,6 +182,7 @@ type InstancePluginConfig struct {
 	Settings      interface{}         `json:"settings"`
 	Timeout       int                 `json:"timeoutSeconds"`
 	Preconditions map[string][]string `json:"precondition"`
+	DependsOn     []string            `json:"dependsOn"` // names of the steps that must succeed first
 }
 
 // DocumentContent object which represents ssm document content.
@@ -208,6 +223,7 @@ type PluginRuntimeStatus struct {
 	OutputS3KeyPrefix  string       `json:"outputS3KeyPrefix"`
 	StandardOutput     string       `json:"standardOutput"`
 	StandardError      string       `json:"standardError"`
+	Progress           int          `json:"progress,omitempty"`
 }
 
 // AgentConfiguration is a struct that stores information about the agent and instance.
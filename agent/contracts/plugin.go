@@ -16,6 +16,7 @@ package contracts
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/context"
@@ -52,6 +53,25 @@ type PluginResult struct {
 	Error              error        `json:"-"`
 	StandardOutput     string       `json:"standardOutput"`
 	StandardError      string       `json:"standardError"`
+	OutputTruncated    bool         `json:"outputTruncated"`
+	OutputTruncatedAt  int          `json:"outputTruncatedAt"`
+	Progress           int          `json:"progress,omitempty"`
+	CancelReason       string       `json:"cancelReason,omitempty"`
+	OutputRedacted     bool         `json:"outputRedacted,omitempty"`
+}
+
+// MarkOutputTruncation records whether the plugin output was cut by the output size cap,
+// and the byte offset in the output where the truncation occurred
+func (r *PluginResult) MarkOutputTruncation() {
+	output, ok := r.Output.(string)
+	if !ok {
+		return
+	}
+	r.OutputTruncatedAt = OutputTruncationIndex(output)
+	r.OutputTruncated = r.OutputTruncatedAt >= 0
+	if !r.OutputTruncated {
+		r.OutputTruncatedAt = 0
+	}
 }
 
 // IPlugin is interface for authoring a functionality of work.
@@ -89,6 +109,8 @@ type Configuration struct {
 	DefaultWorkingDirectory string
 	Preconditions           map[string][]string
 	IsPreconditionEnabled   bool
+	// DependsOn are the ids of the steps of the document that must succeed before this one runs
+	DependsOn []string `json:",omitempty"`
 }
 
 // Plugin wraps the plugin configuration and plugin result.
@@ -107,6 +129,7 @@ type PluginOutput struct {
 	Status   ResultStatus
 	Stdout   string
 	Stderr   string
+	Redacted bool
 }
 
 func (p *PluginOutput) Merge(log log.T, mergeOutput PluginOutput) {
@@ -116,6 +139,7 @@ func (p *PluginOutput) Merge(log log.T, mergeOutput PluginOutput) {
 		p.ExitCode = mergeOutput.ExitCode
 	}
 	p.Status = MergeResultStatus(p.Status, mergeOutput.Status)
+	p.Redacted = p.Redacted || mergeOutput.Redacted
 }
 
 func (p *PluginOutput) String() (response string) {
@@ -242,6 +266,17 @@ func TruncateOutput(stdout string, stderr string, capacity int) (response string
 	return fmt.Sprint(stdout[:truncSize-errorSize], truncOut, errorTitle, stderr)
 }
 
+// OutputTruncationIndex returns the byte offset of the first truncation marker added by TruncateOutput,
+// or -1 if the output was not truncated
+func OutputTruncationIndex(output string) int {
+	outIndex := strings.Index(output, truncOut)
+	errIndex := strings.Index(output, truncError)
+	if outIndex < 0 || (errIndex >= 0 && errIndex < outIndex) {
+		return errIndex
+	}
+	return outIndex
+}
+
 // Check if precondition support is enabled by checking document schema version
 func IsPreconditionEnabled(schemaVersion string) (response bool) {
 	response = false
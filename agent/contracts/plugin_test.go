@@ -61,6 +61,27 @@ func TestTruncateOutput(t *testing.T) {
 	}
 }
 
+func TestMarkOutputTruncation(t *testing.T) {
+	for i, test := range testData {
+		result := PluginResult{Output: TruncateOutput(test.stdout, test.stderr, test.capacity)}
+		result.MarkOutputTruncation()
+		expectedTruncated := test.stdout == longMessage || test.stderr == longMessage
+		assert.Equal(t, expectedTruncated, result.OutputTruncated, "failed test case: %v", i)
+		if result.OutputTruncated {
+			assert.True(t, result.OutputTruncatedAt <= test.capacity, "failed test case: %v", i)
+		} else {
+			assert.Equal(t, 0, result.OutputTruncatedAt, "failed test case: %v", i)
+		}
+	}
+}
+
+func TestOutputTruncationIndex(t *testing.T) {
+	assert.Equal(t, -1, OutputTruncationIndex("sample output"))
+	assert.Equal(t, len("sample"), OutputTruncationIndex("sample"+truncOut))
+	assert.Equal(t, len("sample"), OutputTruncationIndex("sample"+truncError))
+	assert.Equal(t, len("out"), OutputTruncationIndex("out"+truncOut+"err"+truncError))
+}
+
 var logger = log.NewMockLog()
 
 func TestSucceeded(t *testing.T) {
@@ -0,0 +1,93 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// MarkResultUnacknowledged records that the final result of the document couldn't be delivered to MDS,
+// the document is flagged as executed offline so that its result is sent again once MDS is reachable.
+// The document may still be in the current folder, its move to a terminal folder keeps the flag.
+func MarkResultUnacknowledged(log log.T, documentID, instanceID string) error {
+	locationFolders := append([]string{appconfig.DefaultLocationOfCurrent}, terminalLocationFolders...)
+	return updateDocumentInfo(log, documentID, instanceID, locationFolders, func(docInfo *model.DocumentInfo) {
+		docInfo.ExecutedOffline = true
+		docInfo.ResultAcknowledged = false
+	})
+}
+
+// MarkResultAcknowledged records that MDS accepted the final result of the document executed offline
+func MarkResultAcknowledged(log log.T, documentID, instanceID string) error {
+	return updateDocumentInfo(log, documentID, instanceID, terminalLocationFolders, func(docInfo *model.DocumentInfo) {
+		docInfo.ResultAcknowledged = true
+	})
+}
+
+// UnacknowledgedDocuments returns the ids of the completed documents executed offline whose final result MDS never acknowledged
+func UnacknowledgedDocuments(log log.T, instanceID string) (documentIDs []string) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+	for _, locationFolder := range terminalLocationFolders {
+		files, err := store.List(DocumentStateDir(instanceID, locationFolder))
+		if err != nil {
+			log.Debugf("failed to list the documents of %v: %v", locationFolder, err)
+			continue
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, moveIntermediateSuffix) {
+				continue
+			}
+			documentID := documentIDOfStateFile(file)
+			docInfo := GetDocumentInfo(log, documentID, instanceID, locationFolder)
+			if docInfo.ExecutedOffline && !docInfo.ResultAcknowledged {
+				documentIDs = append(documentIDs, documentID)
+			}
+		}
+	}
+	return
+}
+
+// updateDocumentInfo applies the update to the document info of the document found in the first of the given folders holding it,
+// the document lock is held from the lookup to the write so that a concurrent move can't lose the update
+func updateDocumentInfo(log log.T, documentID, instanceID string, locationFolders []string, update func(docInfo *model.DocumentInfo)) error {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+
+	docMutex := lockDocument(instanceID, documentID)
+	defer unlockDocument(instanceID, documentID, docMutex)
+
+	for _, locationFolder := range locationFolders {
+		absoluteFileName := docStateFileName(documentID, instanceID, locationFolder)
+		if !docStateExists(absoluteFileName) {
+			continue
+		}
+		docState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+		if err != nil {
+			return err
+		}
+		update(&docState.DocumentInformation)
+		setDocState(log, docState, absoluteFileName, locationFolder)
+		return nil
+	}
+	return fmt.Errorf("document %v not found in %v", documentID, locationFolders)
+}
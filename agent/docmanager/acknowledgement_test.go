@@ -0,0 +1,63 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func persistTestDocument(documentID, locationFolder string, executedOffline, acknowledged bool) {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = documentID
+	docState.DocumentInformation.MessageID = "aws.ssm." + documentID + "." + testInstanceID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+	docState.DocumentInformation.ExecutedOffline = executedOffline
+	docState.DocumentInformation.ResultAcknowledged = acknowledged
+	PersistData(testLog, documentID, testInstanceID, locationFolder, docState)
+}
+
+func TestUnacknowledgedDocuments(t *testing.T) {
+	defer setTestDataStore(t)()
+	persistTestDocument("online", appconfig.DefaultLocationOfCompleted, false, false)
+	persistTestDocument("offline", appconfig.DefaultLocationOfCompleted, true, false)
+	persistTestDocument("offlineFailed", appconfig.DefaultLocationOfFailed, true, false)
+	persistTestDocument("offlineSent", appconfig.DefaultLocationOfCompleted, true, true)
+	persistTestDocument("offlineRunning", appconfig.DefaultLocationOfCurrent, true, false)
+
+	assert.Equal(t, []string{"offline", "offlineFailed"}, UnacknowledgedDocuments(testLog, testInstanceID))
+
+	assert.NoError(t, MarkResultAcknowledged(testLog, "offline", testInstanceID))
+
+	assert.Equal(t, []string{"offlineFailed"}, UnacknowledgedDocuments(testLog, testInstanceID))
+	docInfo := GetDocumentInfo(testLog, "offline", testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.True(t, docInfo.ExecutedOffline)
+	assert.True(t, docInfo.ResultAcknowledged)
+}
+
+func TestMarkResultUnacknowledgedBeforeTheDocumentIsMoved(t *testing.T) {
+	defer setTestDataStore(t)()
+	persistTestDocument(testDocumentID, appconfig.DefaultLocationOfCurrent, false, false)
+
+	assert.NoError(t, MarkResultUnacknowledged(testLog, testDocumentID, testInstanceID))
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	assert.Equal(t, []string{testDocumentID}, UnacknowledgedDocuments(testLog, testInstanceID))
+	assert.Error(t, MarkResultUnacknowledged(testLog, "unknownDocument", testInstanceID))
+}
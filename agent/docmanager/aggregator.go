@@ -43,6 +43,7 @@ func prepareRuntimeStatus(log log.T, pluginResult contracts.PluginResult) contra
 		EndDateTime:    times.ToIso8601UTC(pluginResult.EndDateTime),
 		StandardOutput: pluginResult.StandardOutput,
 		StandardError:  pluginResult.StandardError,
+		Progress:       pluginResult.Progress,
 	}
 
 	if pluginResult.OutputS3BucketName != "" {
@@ -88,8 +89,10 @@ func DocumentResultAggregator(log log.T,
 		//	  with number of failed/cancelled items.
 		//    TODO : We need to handle above to be able to send document traceoutput in case of document level errors.
 
-		// Skipped is a form of success
-		successCounts := runtimeStatusCounts[string(contracts.ResultStatusSuccess)] + runtimeStatusCounts[string(contracts.ResultStatusSkipped)]
+		// Skipped is a form of success, so are the steps marked as UnsupportedPlugin when the policy lets the document run without them
+		successCounts := runtimeStatusCounts[string(contracts.ResultStatusSuccess)] +
+			runtimeStatusCounts[string(contracts.ResultStatusSkipped)] +
+			runtimeStatusCounts[string(contracts.ResultStatusUnsupportedPlugin)]
 
 		if runtimeStatusCounts[string(contracts.ResultStatusSuccessAndReboot)] > 0 {
 			documentStatus = contracts.ResultStatusSuccessAndReboot
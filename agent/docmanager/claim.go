@@ -0,0 +1,90 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// claimedFolderName is the document state folder holding the markers of the documents picked up for execution
+const claimedFolderName = "claimed"
+
+// ClaimDocument atomically records that the document has been picked up for execution.
+// The marker is persisted in the document store so it survives an agent restart; claimed is false if the document was already claimed.
+func ClaimDocument(log log.T, documentID, instanceID string) (claimed bool, err error) {
+	if len(documentID) == 0 {
+		return false, fmt.Errorf("document id is empty")
+	}
+	if err = ValidateDataStorePath(); err != nil {
+		return
+	}
+	if err = ValidateInstanceID(instanceID); err != nil {
+		return
+	}
+	claimedDir := DocumentStateDir(instanceID, claimedFolderName)
+	if err = fileutil.MakeDirs(claimedDir); err != nil {
+		return
+	}
+	err = store.Create(filepath.Join(claimedDir, documentID), nil)
+	if os.IsExist(err) {
+		log.Debugf("document %v has already been claimed", documentID)
+		return false, nil
+	}
+	if err != nil {
+		return
+	}
+	return true, nil
+}
+
+// IsDocumentCompleted checks if the document state is present in one of the terminal folders
+func IsDocumentCompleted(documentID, instanceID string) bool {
+	if len(documentID) == 0 {
+		return false
+	}
+	for _, locationFolder := range terminalLocationFolders {
+		if docStateExists(docStateFileName(documentID, instanceID, locationFolder)) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReclaimCompletedDocument claims again a document that has completed so that it's executed once more,
+// its state is removed from the terminal folders first; claimed is false if the document wasn't completed.
+func ReclaimCompletedDocument(log log.T, documentID, instanceID string) (claimed bool, err error) {
+	if !IsDocumentCompleted(documentID, instanceID) {
+		return false, nil
+	}
+	for _, locationFolder := range terminalLocationFolders {
+		if docStateExists(docStateFileName(documentID, instanceID, locationFolder)) {
+			RemoveData(log, documentID, instanceID, locationFolder)
+		}
+	}
+	removeClaim(log, documentID, instanceID)
+	return ClaimDocument(log, documentID, instanceID)
+}
+
+// removeClaim deletes the claim marker of the document
+func removeClaim(log log.T, documentID, instanceID string) {
+	markerPath := filepath.Join(DocumentStateDir(instanceID, claimedFolderName), documentID)
+	if err := store.Delete(markerPath); err != nil && !os.IsNotExist(err) {
+		log.Debugf("Error deleting claim marker %v: %v", markerPath, err)
+	}
+}
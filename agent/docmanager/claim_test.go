@@ -0,0 +1,154 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClaimDocumentConcurrently(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	attempts := 10
+	var claims int32
+	var m sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			claimed, err := ClaimDocument(testLog, testDocumentID, testInstanceID)
+			assert.NoError(t, err)
+			if claimed {
+				m.Lock()
+				claims++
+				m.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), claims)
+
+	// the marker is on disk, so the document stays claimed for any later delivery
+	claimed, err := ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+}
+
+func TestClaimDocumentThroughTheStore(t *testing.T) {
+	defer setTestDataStore(t)()
+	memoryStore := newMemoryDocumentStore()
+	defer setTestDocumentStore(memoryStore)()
+
+	claimed, err := ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	claimed, err = ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+	_, err = memoryStore.Stat(filepath.Join(DocumentStateDir(testInstanceID, claimedFolderName), testDocumentID))
+	assert.NoError(t, err)
+
+	removeClaim(testLog, testDocumentID, testInstanceID)
+	claimed, err = ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestRemoveClaim(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	claimed, err := ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	removeClaim(testLog, testDocumentID, testInstanceID)
+
+	claimed, err = ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestClaimIsReleasedWhenDocumentIsGivenUpOn(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	// a completed document stays claimed
+	PersistData(testLog, "completedDocument", testInstanceID, appconfig.DefaultLocationOfCurrent, model.DocumentState{})
+	claimed, err := ClaimDocument(testLog, "completedDocument", testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	MoveDocumentState(testLog, "completedDocument", testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+	claimed, err = ClaimDocument(testLog, "completedDocument", testInstanceID)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	// a document moved to the corrupt folder, e.g. as it failed to be submitted, is claimed again on its redelivery
+	PersistData(testLog, "failedDocument", testInstanceID, appconfig.DefaultLocationOfPending, model.DocumentState{})
+	claimed, err = ClaimDocument(testLog, "failedDocument", testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	MoveDocumentState(testLog, "failedDocument", testInstanceID, appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCorrupt)
+	claimed, err = ClaimDocument(testLog, "failedDocument", testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// so is a document whose state is quarantined as corrupt
+	writeMalformedDocState(t, "corruptDocument", appconfig.DefaultLocationOfCurrent)
+	claimed, err = ClaimDocument(testLog, "corruptDocument", testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	_, err = GetDocumentInterimStateE(testLog, "corruptDocument", testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.True(t, isCorruptState(err))
+	claimed, err = ClaimDocument(testLog, "corruptDocument", testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+}
+
+func TestIsDocumentCompleted(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	assert.False(t, IsDocumentCompleted(testDocumentID, testInstanceID))
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+	assert.True(t, IsDocumentCompleted(testDocumentID, testInstanceID))
+}
+
+func TestReclaimCompletedDocument(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	claimed, err := ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+
+	// a document that hasn't completed isn't reclaimed
+	claimed, err = ReclaimCompletedDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+	claimed, err = ReclaimCompletedDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, claimed)
+	assert.False(t, IsDocumentCompleted(testDocumentID, testInstanceID))
+
+	claimed, err = ClaimDocument(testLog, testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.False(t, claimed)
+}
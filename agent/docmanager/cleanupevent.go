@@ -0,0 +1,57 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
+)
+
+// CleanupSummary sums up a sweep of DeleteOldDocumentFolderLogs through the terminal documents of an instance
+type CleanupSummary struct {
+	InstanceID string
+	// Examined is the count of documents the sweep went through
+	Examined int
+	// Deleted is the count of documents deleted
+	Deleted int
+	// Skipped is the count of documents examined and kept, because they're still retained or couldn't be deleted
+	Skipped int
+	// BytesFreed is the size of the document state files and orchestration dirs deleted
+	BytesFreed int64
+	Duration   time.Duration
+}
+
+// CleanupListener is called with the summary of each completed sweep of the data store cleanup
+type CleanupListener func(summary CleanupSummary)
+
+// OnCleanupCompleted is invoked at the end of each sweep of DeleteOldDocumentFolderLogs,
+// nil only reports the sweep to the log and the metrics
+var OnCleanupCompleted CleanupListener
+
+// reportCleanupCompleted reports the completed sweep to the log, the metrics and the listener
+func reportCleanupCompleted(log log.T, summary CleanupSummary) {
+	log.Infof("data store cleanup of instance %v completed in %v: %v documents examined, %v deleted, %v skipped, %v bytes freed",
+		summary.InstanceID, summary.Duration, summary.Examined, summary.Deleted, summary.Skipped, summary.BytesFreed)
+	metrics.DefaultSink.IncrCounter(metrics.CleanupSweeps, 1)
+	metrics.DefaultSink.IncrCounter(metrics.CleanupExaminedDocuments, float64(summary.Examined))
+	metrics.DefaultSink.IncrCounter(metrics.CleanupSkippedDocuments, float64(summary.Skipped))
+	metrics.DefaultSink.IncrCounter(metrics.CleanupFreedBytes, float64(summary.BytesFreed))
+	metrics.DefaultSink.Observe(metrics.CleanupSweepSeconds, summary.Duration.Seconds())
+	if OnCleanupCompleted != nil {
+		OnCleanupCompleted(summary)
+	}
+}
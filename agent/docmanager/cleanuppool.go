@@ -0,0 +1,141 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// cleanupWorkers returns the number of old documents DeleteOldDocumentFolderLogs deletes at once
+var cleanupWorkers = func() int {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return appconfig.DefaultCleanupWorkers
+	}
+	return config.Ssm.CleanupWorkers
+}
+
+// cleanupPool runs the cleanup action on up to workers documents at once. An action only starts if the max deletions budget
+// still allows it should all the actions in flight succeed, so that the documents deleted are counted against the budget
+// as they are when the actions run one after the other. With a single worker the actions run in the walking goroutine.
+type cleanupPool struct {
+	log          log.T
+	action       cleanupAction
+	workers      chan struct{}
+	maxDeletions int
+	// deletions counts the files and orchestration dirs deleted, 2 for each successful action as when run one after the other
+	deletions int
+	inFlight  int
+	m         sync.Mutex
+	done      *sync.Cond
+	wg        sync.WaitGroup
+}
+
+// newCleanupPool returns a pool running the action on up to workers documents at once, 1 if workers isn't positive
+func newCleanupPool(log log.T, workers, maxDeletions int, action cleanupAction) *cleanupPool {
+	if workers < 1 {
+		workers = 1
+	}
+	pool := &cleanupPool{log: log, action: action, workers: make(chan struct{}, workers), maxDeletions: maxDeletions}
+	pool.done = sync.NewCond(&pool.m)
+	return pool
+}
+
+// reserve waits until an action may start within the budget, it returns false once the budget is exhausted
+func (p *cleanupPool) reserve() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for {
+		if p.deletions > p.maxDeletions {
+			return false
+		}
+		if p.deletions+2*p.inFlight <= p.maxDeletions {
+			p.inFlight++
+			return true
+		}
+		// the budget depends on the outcome of the actions in flight
+		p.done.Wait()
+	}
+}
+
+// exhausted returns true if no more action may start
+func (p *cleanupPool) exhausted() bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.deletions > p.maxDeletions
+}
+
+// run runs the action reserved for the document in a worker, once one is free
+func (p *cleanupPool) run(completedFile, completedLogFullPath, orchestrationDirFullPath string) {
+	if cap(p.workers) == 1 {
+		p.complete(p.action(completedFile, completedLogFullPath, orchestrationDirFullPath))
+		return
+	}
+	p.workers <- struct{}{}
+	p.wg.Add(1)
+	go func() {
+		succeeded := false
+		defer func() {
+			if msg := recover(); msg != nil {
+				p.log.Errorf("cleanup of document %v failed with message %v", completedFile, msg)
+			}
+			p.complete(succeeded)
+			<-p.workers
+			p.wg.Done()
+		}()
+		succeeded = p.action(completedFile, completedLogFullPath, orchestrationDirFullPath)
+	}()
+}
+
+// complete counts the outcome of an action against the budget
+func (p *cleanupPool) complete(succeeded bool) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.inFlight--
+	if succeeded {
+		p.deletions += 2
+	}
+	p.done.Broadcast()
+}
+
+// wait waits for the actions in flight to complete
+func (p *cleanupPool) wait() {
+	p.wg.Wait()
+}
+
+// pathLocks serializes the cleanup actions on the same path, e.g. of the documents sharing an orchestration dir
+type pathLocks struct {
+	locks map[string]*sync.Mutex
+	m     sync.Mutex
+}
+
+// lock locks the path and returns the function unlocking it
+func (p *pathLocks) lock(path string) func() {
+	p.m.Lock()
+	if p.locks == nil {
+		p.locks = make(map[string]*sync.Mutex)
+	}
+	pathLock, found := p.locks[path]
+	if !found {
+		pathLock = &sync.Mutex{}
+		p.locks[path] = pathLock
+	}
+	p.m.Unlock()
+	pathLock.Lock()
+	return pathLock.Unlock
+}
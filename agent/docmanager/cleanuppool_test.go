@@ -0,0 +1,112 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestCleanupWorkers(workers int) func() {
+	origWorkers := cleanupWorkers
+	cleanupWorkers = func() int { return workers }
+	return func() { cleanupWorkers = origWorkers }
+}
+
+func TestDeleteOldDocumentFolderLogsConcurrentlyHonorsMaxDeletions(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestCleanupWorkers(8)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return strings.HasPrefix(fileName, "document") }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	oldTime := time.Now().Add(-48 * time.Hour)
+	nDocuments := 200
+	for i := 0; i < nDocuments; i++ {
+		documentID := fmt.Sprintf("document%v", i)
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+		assert.NoError(t, fileutil.MakeDirs(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), documentID)))
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), oldTime, oldTime))
+	}
+	var summaries []CleanupSummary
+	defer func() { OnCleanupCompleted = nil }()
+	OnCleanupCompleted = func(summary CleanupSummary) { summaries = append(summaries, summary) }
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, 50, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	deletedStates, deletedDirs := 0, 0
+	for i := 0; i < nDocuments; i++ {
+		documentID := fmt.Sprintf("document%v", i)
+		if !fileutil.Exists(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)) {
+			deletedStates++
+		}
+		if !fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), documentID)) {
+			deletedDirs++
+		}
+	}
+	// as many documents as when they're deleted one after the other
+	assert.Equal(t, 26, deletedStates)
+	assert.Equal(t, 26, deletedDirs)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, 26, summaries[0].Deleted)
+	}
+}
+
+func TestCleanupPoolBoundsWorkersAndCountsSuccessesOnly(t *testing.T) {
+	var running, maxRunning, actions int
+	var m sync.Mutex
+	action := func(completedFile, completedLogFullPath, orchestrationDirFullPath string) bool {
+		m.Lock()
+		running++
+		actions++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		m.Unlock()
+		time.Sleep(time.Millisecond)
+		m.Lock()
+		running--
+		m.Unlock()
+		// the odd documents fail to be deleted and don't count against the budget
+		return !strings.HasSuffix(completedFile, "odd")
+	}
+	pool := newCleanupPool(testLog, 3, 10, action)
+
+	started := 0
+	for i := 0; i < 100 && pool.reserve(); i++ {
+		completedFile := "even"
+		if i%2 == 1 {
+			completedFile = "odd"
+		}
+		pool.run(completedFile, "", "")
+		started++
+	}
+	pool.wait()
+
+	assert.True(t, maxRunning <= 3)
+	assert.Equal(t, started, actions)
+	assert.Equal(t, 12, pool.deletions)
+	assert.True(t, pool.exhausted())
+}
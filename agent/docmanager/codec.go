@@ -0,0 +1,129 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+)
+
+// Codec compresses the data persisted by the document manager.
+// The compressed data starts with the magic bytes of the codec so readers can tell which codec wrote it.
+type Codec interface {
+	// Name is the name selecting the codec in the agent configuration
+	Name() string
+	// Magic returns the bytes every compressed data of the codec starts with
+	Magic() []byte
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// gzipCodec is the default codec
+type gzipCodec struct{}
+
+// Name returns the name of the gzip codec
+func (gzipCodec) Name() string {
+	return appconfig.DefaultCompressionCodec
+}
+
+// Magic returns the gzip header id
+func (gzipCodec) Magic() []byte {
+	return []byte{0x1f, 0x8b}
+}
+
+// Compress gzips the data
+func (gzipCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress gunzips the data
+func (gzipCodec) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+var codecsLock sync.RWMutex
+var codecs = map[string]Codec{appconfig.DefaultCompressionCodec: gzipCodec{}}
+
+// RegisterCodec makes the codec available for selection in the agent configuration, e.g. zstd
+func RegisterCodec(codec Codec) error {
+	if len(codec.Magic()) == 0 {
+		return fmt.Errorf("codec %v has no magic bytes", codec.Name())
+	}
+	codecsLock.Lock()
+	defer codecsLock.Unlock()
+	for name, registered := range codecs {
+		if name != codec.Name() && bytes.Equal(registered.Magic(), codec.Magic()) {
+			return fmt.Errorf("codec %v has the same magic bytes as codec %v", codec.Name(), name)
+		}
+	}
+	codecs[codec.Name()] = codec
+	return nil
+}
+
+// GetCodec returns the registered codec with the given name
+func GetCodec(name string) (Codec, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	codec, found := codecs[name]
+	if !found {
+		return nil, fmt.Errorf("compression codec %v is not registered", name)
+	}
+	return codec, nil
+}
+
+// Compress compresses the data with the codec selected in the agent configuration, falling back to gzip
+func Compress(data []byte) ([]byte, error) {
+	name := appconfig.DefaultCompressionCodec
+	if config, err := appconfig.Config(false); err == nil {
+		name = config.Agent.CompressionCodec
+	}
+	codec, err := GetCodec(name)
+	if err != nil {
+		if codec, err = GetCodec(appconfig.DefaultCompressionCodec); err != nil {
+			return nil, err
+		}
+	}
+	return codec.Compress(data)
+}
+
+// Decompress decompresses the data with the codec its magic bytes identify, data written by no registered codec is returned as is
+func Decompress(data []byte) ([]byte, error) {
+	codecsLock.RLock()
+	defer codecsLock.RUnlock()
+	for _, codec := range codecs {
+		if bytes.HasPrefix(data, codec.Magic()) {
+			return codec.Decompress(data)
+		}
+	}
+	return data, nil
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+// zlibCodec stands for a codec registered on top of gzip, e.g. zstd
+type zlibCodec struct{}
+
+func (zlibCodec) Name() string { return "zlib" }
+
+func (zlibCodec) Magic() []byte { return []byte{0x78, 0x9c} }
+
+func (zlibCodec) Compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := zlib.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	err := writer.Close()
+	return buf.Bytes(), err
+}
+
+func (zlibCodec) Decompress(data []byte) ([]byte, error) {
+	reader, err := zlib.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+func registerTestCodec(t *testing.T) func() {
+	assert.NoError(t, RegisterCodec(zlibCodec{}))
+	return func() {
+		codecsLock.Lock()
+		defer codecsLock.Unlock()
+		delete(codecs, zlibCodec{}.Name())
+	}
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	defer registerTestCodec(t)()
+
+	data := []byte(`{"DocumentInformation":{"DocumentID":"13e8e6ad-e195-4ccb-86ee-328153b0dafe"}}`)
+	for _, name := range []string{appconfig.DefaultCompressionCodec, zlibCodec{}.Name()} {
+		codec, err := GetCodec(name)
+		assert.NoError(t, err)
+		compressed, err := codec.Compress(data)
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(compressed, codec.Magic()), name)
+
+		decompressed, err := Decompress(compressed)
+		assert.NoError(t, err)
+		assert.Equal(t, data, decompressed, name)
+	}
+}
+
+func TestDecompressMixedCodecDirectory(t *testing.T) {
+	defer registerTestCodec(t)()
+
+	dir := t.TempDir()
+	contents := map[string][]byte{
+		"gzip":         []byte("compressed with gzip"),
+		"zlib":         []byte("compressed with zlib"),
+		"uncompressed": []byte(`{"written":"before compression"}`),
+	}
+	for name, content := range contents {
+		data := content
+		if name != "uncompressed" {
+			codec, err := GetCodec(name)
+			assert.NoError(t, err)
+			data, err = codec.Compress(content)
+			assert.NoError(t, err)
+		}
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, len(contents))
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, file.Name()))
+		assert.NoError(t, err)
+		decompressed, err := Decompress(data)
+		assert.NoError(t, err)
+		assert.Equal(t, contents[file.Name()], decompressed, file.Name())
+	}
+}
+
+func TestRegisterCodecWithConflictingMagic(t *testing.T) {
+	defer registerTestCodec(t)()
+
+	assert.Error(t, RegisterCodec(conflictingCodec{}))
+	_, err := GetCodec(conflictingCodec{}.Name())
+	assert.Error(t, err)
+}
+
+// conflictingCodec claims the gzip magic bytes
+type conflictingCodec struct{ zlibCodec }
+
+func (conflictingCodec) Name() string { return "conflicting" }
+
+func (conflictingCodec) Magic() []byte { return gzipCodec{}.Magic() }
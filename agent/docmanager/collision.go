@@ -0,0 +1,109 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// OrchestrationDirCollisionError reports commands sharing an orchestration dir, their outputs would be merged
+type OrchestrationDirCollisionError struct {
+	OrchestrationDir string
+	CommandIDs       []string
+}
+
+func (e *OrchestrationDirCollisionError) Error() string {
+	return fmt.Sprintf("orchestration directory %v is shared by commands %v", e.OrchestrationDir, e.CommandIDs)
+}
+
+// orchestrationDirOwners maps the orchestration dirs of the documents of the instance to the command id of each document using them
+type orchestrationDirOwners map[string]map[string]string
+
+// collectOrchestrationDirOwners reads the orchestration dirs of the documents found in the state folders of the instance
+func collectOrchestrationDirOwners(log log.T, instanceID string) orchestrationDirOwners {
+	owners := make(orchestrationDirOwners)
+	for _, locationFolder := range reconciledFolders {
+		files, err := store.List(DocumentStateDir(instanceID, locationFolder))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			documentID := documentIDOfStateFile(file)
+			docState := GetDocumentInterimState(log, documentID, instanceID, locationFolder)
+			for _, orchestrationDir := range documentOrchestrationDirs(docState) {
+				owners.add(orchestrationDir, documentID, docState.DocumentInformation.CommandID)
+			}
+		}
+	}
+	return owners
+}
+
+// add records that the document of the given command uses the orchestration dir
+func (o orchestrationDirOwners) add(orchestrationDir, documentID, commandID string) {
+	orchestrationDir = filepath.Clean(orchestrationDir)
+	if o[orchestrationDir] == nil {
+		o[orchestrationDir] = make(map[string]string)
+	}
+	o[orchestrationDir][documentID] = commandID
+}
+
+// collision returns an error if a document other than documentID, of a command other than commandID, uses the orchestration dir
+func (o orchestrationDirOwners) collision(orchestrationDir, documentID, commandID string) error {
+	orchestrationDir = filepath.Clean(orchestrationDir)
+	commandIDs := map[string]bool{commandID: true}
+	for ownerID, ownerCommandID := range o[orchestrationDir] {
+		if ownerID != documentID {
+			commandIDs[ownerCommandID] = true
+		}
+	}
+	if len(commandIDs) < 2 {
+		return nil
+	}
+	err := &OrchestrationDirCollisionError{OrchestrationDir: orchestrationDir}
+	for id := range commandIDs {
+		err.CommandIDs = append(err.CommandIDs, id)
+	}
+	sort.Strings(err.CommandIDs)
+	return err
+}
+
+// CheckOrchestrationDirCollision returns an OrchestrationDirCollisionError if a document of a command other than commandID,
+// found in the state folders of the instance, already uses the orchestration dir, documentID itself is ignored.
+// It is meant to run before a document is scheduled, so that the outputs of two commands are never merged.
+func CheckOrchestrationDirCollision(log log.T, instanceID, documentID, commandID, orchestrationDir string) error {
+	if orchestrationDir == "" || checkDataStorePath(log, instanceID) != nil {
+		return nil
+	}
+	return collectOrchestrationDirOwners(log, instanceID).collision(orchestrationDir, documentID, commandID)
+}
+
+// commandID returns the command id recorded for the document using the orchestration dir
+func (o orchestrationDirOwners) commandID(orchestrationDir, documentID string) string {
+	return o[filepath.Clean(orchestrationDir)][documentID]
+}
+
+// remove forgets the orchestration dirs of the deleted document
+func (o orchestrationDirOwners) remove(documentID string) {
+	for orchestrationDir, documents := range o {
+		delete(documents, documentID)
+		if len(documents) == 0 {
+			delete(o, orchestrationDir)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// persistCommandDocument persists the document of the command running in the orchestration dir
+func persistCommandDocument(documentID, commandID, orchestrationDir, locationFolder string) {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = documentID
+	docState.DocumentInformation.CommandID = commandID
+	docState.DocumentInformation.OrchestrationDirectory = orchestrationDir
+	PersistData(testLog, documentID, testInstanceID, locationFolder, docState)
+}
+
+func TestCheckOrchestrationDirCollision(t *testing.T) {
+	defer setTestDataStore(t)()
+	sharedDir := filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), "shared")
+	persistCommandDocument("document1", "command1", sharedDir, appconfig.DefaultLocationOfCurrent)
+
+	err := CheckOrchestrationDirCollision(testLog, testInstanceID, "document2", "command2", sharedDir+string(filepath.Separator))
+
+	if assert.IsType(t, &OrchestrationDirCollisionError{}, err) {
+		collision := err.(*OrchestrationDirCollisionError)
+		assert.Equal(t, sharedDir, collision.OrchestrationDir)
+		assert.Equal(t, []string{"command1", "command2"}, collision.CommandIDs)
+	}
+	// the same command, the document itself, or another dir don't collide
+	assert.NoError(t, CheckOrchestrationDirCollision(testLog, testInstanceID, "document2", "command1", sharedDir))
+	assert.NoError(t, CheckOrchestrationDirCollision(testLog, testInstanceID, "document1", "command2", sharedDir))
+	assert.NoError(t, CheckOrchestrationDirCollision(testLog, testInstanceID, "document2", "command2", sharedDir+"2"))
+}
+
+func TestDeleteOldDocumentFolderLogsKeepsSharedOrchestrationDir(t *testing.T) {
+	defer setTestDataStore(t)()
+	orchestrationRootDirName := "awsrunCommand"
+	sharedDir := filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), "shared")
+	assert.NoError(t, fileutil.MakeDirs(sharedDir))
+	persistCommandDocument("documentOld", "command1", sharedDir, appconfig.DefaultLocationOfCompleted)
+	persistCommandDocument("documentRecent", "command2", sharedDir, appconfig.DefaultLocationOfCompleted)
+	age := func(documentID string) {
+		modTime := time.Now().Add(-48 * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+	cleanup := func() {
+		DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun,
+			func(fileName string) bool { return true },
+			func(fileName string) string { return fileName })
+	}
+	age("documentOld")
+
+	cleanup()
+
+	// the outputs of command2 are still in the shared dir
+	assert.False(t, fileutil.Exists(docStateFileName("documentOld", testInstanceID, appconfig.DefaultLocationOfCompleted)))
+	assert.True(t, fileutil.Exists(sharedDir))
+
+	age("documentRecent")
+
+	cleanup()
+
+	assert.False(t, fileutil.Exists(docStateFileName("documentRecent", testInstanceID, appconfig.DefaultLocationOfCompleted)))
+	assert.False(t, fileutil.Exists(sharedDir))
+}
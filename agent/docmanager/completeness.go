@@ -0,0 +1,60 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+)
+
+// IncompleteDocumentError reports a document state holding fewer plugins than its document declared,
+// some of its steps were lost, e.g. by a crash while the state was written
+type IncompleteDocumentError struct {
+	DocumentID    string
+	DeclaredSteps int
+	PresentSteps  int
+}
+
+func (e *IncompleteDocumentError) Error() string {
+	return fmt.Sprintf("document %v declares %v steps but its state holds %v, %v steps are missing",
+		e.DocumentID, e.DeclaredSteps, e.PresentSteps, e.MissingSteps())
+}
+
+// MissingSteps returns the number of steps lost from the document state
+func (e *IncompleteDocumentError) MissingSteps() int {
+	return e.DeclaredSteps - e.PresentSteps
+}
+
+// ValidateDocumentCompleteness checks the document state holds all the plugins its document declared,
+// it returns an IncompleteDocumentError when some are missing.
+// The states persisted before the plugin count was recorded can't be checked and are considered complete.
+func ValidateDocumentCompleteness(docState model.DocumentState) error {
+	declared := docState.DocumentInformation.PluginCount
+	present := 0
+	for _, pluginState := range docState.InstancePluginsInformation {
+		if pluginState.Id != "" {
+			present++
+		}
+	}
+	if declared == 0 || present >= declared {
+		return nil
+	}
+	return &IncompleteDocumentError{
+		DocumentID:    docState.DocumentInformation.DocumentID,
+		DeclaredSteps: declared,
+		PresentSteps:  present,
+	}
+}
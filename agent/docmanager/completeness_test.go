@@ -0,0 +1,65 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// documentWithSteps returns the state of a document declaring the given number of steps
+func documentWithSteps(declared int, stepIDs ...string) model.DocumentState {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.PluginCount = declared
+	for _, id := range stepIDs {
+		docState.InstancePluginsInformation = append(docState.InstancePluginsInformation, model.PluginState{Id: id, Name: "aws:runScript"})
+	}
+	return docState
+}
+
+func TestValidateDocumentCompleteness(t *testing.T) {
+	assert.NoError(t, ValidateDocumentCompleteness(documentWithSteps(2, "step1", "step2")))
+	// states persisted before the plugin count was recorded are considered complete
+	assert.NoError(t, ValidateDocumentCompleteness(documentWithSteps(0, "step1")))
+
+	err := ValidateDocumentCompleteness(documentWithSteps(3, "step1"))
+	if assert.IsType(t, &IncompleteDocumentError{}, err) {
+		incomplete := err.(*IncompleteDocumentError)
+		assert.Equal(t, testDocumentID, incomplete.DocumentID)
+		assert.Equal(t, 2, incomplete.MissingSteps())
+	}
+
+	// a plugin entry left without its id by a partial write is missing as well
+	err = ValidateDocumentCompleteness(documentWithSteps(2, "step1", ""))
+	if assert.IsType(t, &IncompleteDocumentError{}, err) {
+		assert.Equal(t, 1, err.(*IncompleteDocumentError).MissingSteps())
+	}
+}
+
+func TestValidateDocumentCompletenessOfPersistedState(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := documentWithSteps(3, "step1", "step2", "step3")
+	docState.InstancePluginsInformation = docState.InstancePluginsInformation[:2]
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	resumed := GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	assert.Equal(t, 3, resumed.DocumentInformation.PluginCount)
+	assert.Error(t, ValidateDocumentCompleteness(resumed))
+}
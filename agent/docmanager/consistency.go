@@ -0,0 +1,93 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// Discrepancy is a mismatch between the persisted result of a plugin and the outputs saved in its orchestration dir
+type Discrepancy struct {
+	PluginID string
+	Reason   string
+}
+
+func (d Discrepancy) String() string {
+	return fmt.Sprintf("plugin %v: %v", d.PluginID, d.Reason)
+}
+
+// VerifyDocumentConsistency cross-checks the persisted plugin results of the completed command against the outputs saved
+// in the orchestration dirs of its plugins, and returns the discrepancies found, none if they're consistent.
+// The persisted outputs may be truncated, they only have to start the saved ones. The error tells why the command couldn't be verified, os.ErrNotExist if it isn't completed.
+func VerifyDocumentConsistency(log log.T, commandID, instanceID string) (discrepancies []Discrepancy, err error) {
+	docState, err := GetDocumentInterimStateE(log, commandID, instanceID, FindTerminalLocationFolder(commandID, instanceID))
+	if err != nil {
+		return nil, err
+	}
+	for _, pluginState := range docState.InstancePluginsInformation {
+		for _, reason := range verifyPluginConsistency(log, pluginState.Configuration.OrchestrationDirectory, pluginState.Result) {
+			discrepancies = append(discrepancies, Discrepancy{PluginID: pluginState.Id, Reason: reason})
+		}
+	}
+	return discrepancies, nil
+}
+
+// verifyPluginConsistency returns the reasons the plugin result doesn't match the outputs saved in its orchestration dir
+func verifyPluginConsistency(log log.T, orchestrationDirectory string, result contracts.PluginResult) (reasons []string) {
+	if result.Status.IsSuccess() && result.Code != 0 {
+		reasons = append(reasons, fmt.Sprintf("status %v recorded with exit code %v", result.Status, result.Code))
+	}
+	if orchestrationDirectory == "" {
+		return
+	}
+	ran := result.Status != "" && result.Status != contracts.ResultStatusNotStarted && result.Status != contracts.ResultStatusSkipped
+	outputs := []struct {
+		name      string
+		fileName  string
+		persisted string
+	}{
+		{"standard output", stdoutFileName, result.StandardOutput},
+		{"standard error", stderrFileName, result.StandardError},
+	}
+	for _, output := range outputs {
+		paths := findOutputFiles(log, orchestrationDirectory, output.fileName)
+		switch {
+		case len(paths) > 0 && !ran:
+			reasons = append(reasons, fmt.Sprintf("%v saved for a plugin with status %v", output.name, result.Status))
+		case len(paths) == 0 && output.persisted != "":
+			reasons = append(reasons, fmt.Sprintf("%v missing from %v", output.name, orchestrationDirectory))
+		case len(paths) > 0 && output.persisted != "":
+			saved, err := readOutputPrefix(&outputFilesReader{paths: paths}, len(output.persisted))
+			if err != nil {
+				reasons = append(reasons, fmt.Sprintf("%v can't be read: %v", output.name, err))
+			} else if saved != output.persisted {
+				reasons = append(reasons, fmt.Sprintf("%v differs from the one saved in %v", output.name, orchestrationDirectory))
+			}
+		}
+	}
+	return
+}
+
+// readOutputPrefix reads the first n bytes of the output
+func readOutputPrefix(reader io.ReadCloser, n int) (string, error) {
+	defer reader.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(reader, int64(n)))
+	return string(content), err
+}
@@ -0,0 +1,83 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/stretchr/testify/assert"
+)
+
+// persistPluginResult replaces the result persisted for the plugin of the completed document
+func persistPluginResult(t *testing.T, result contracts.PluginResult) {
+	pluginState := GetPluginState(testLog, "aws:runScript", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	if assert.NotNil(t, pluginState) {
+		pluginState.Result = result
+		PersistPluginState(testLog, *pluginState, "aws:runScript", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	}
+}
+
+func TestVerifyDocumentConsistencyWithConsistentOutput(t *testing.T) {
+	defer setTestDataStore(t)()
+	stdout, stderr := persistDocumentWithOutput(t)
+	// the persisted standard output is truncated, which is consistent
+	persistPluginResult(t, contracts.PluginResult{Status: contracts.ResultStatusSuccess, StandardOutput: stdout[:25], StandardError: stderr})
+
+	discrepancies, err := VerifyDocumentConsistency(testLog, testDocumentID, testInstanceID)
+
+	assert.NoError(t, err)
+	assert.Empty(t, discrepancies)
+}
+
+func TestVerifyDocumentConsistencyReportsDiscrepancies(t *testing.T) {
+	defer setTestDataStore(t)()
+	stdout, _ := persistDocumentWithOutput(t)
+	persistPluginResult(t, contracts.PluginResult{Status: contracts.ResultStatusSuccess, Code: 1, StandardOutput: "c" + stdout[1:]})
+
+	discrepancies, err := VerifyDocumentConsistency(testLog, testDocumentID, testInstanceID)
+
+	assert.NoError(t, err)
+	if assert.Len(t, discrepancies, 2) {
+		assert.Equal(t, "aws:runScript", discrepancies[0].PluginID)
+		assert.Contains(t, discrepancies[0].Reason, "exit code 1")
+		assert.Equal(t, "aws:runScript", discrepancies[1].PluginID)
+		assert.Contains(t, discrepancies[1].Reason, "standard output differs")
+	}
+}
+
+func TestVerifyDocumentConsistencyReportsOutputOfPluginNotRun(t *testing.T) {
+	defer setTestDataStore(t)()
+	persistDocumentWithOutput(t)
+	persistPluginResult(t, contracts.PluginResult{Status: contracts.ResultStatusSkipped})
+
+	discrepancies, err := VerifyDocumentConsistency(testLog, testDocumentID, testInstanceID)
+
+	assert.NoError(t, err)
+	if assert.Len(t, discrepancies, 2) {
+		assert.Contains(t, discrepancies[0].Reason, "standard output saved for a plugin with status Skipped")
+		assert.Contains(t, discrepancies[1].Reason, "standard error saved for a plugin with status Skipped")
+	}
+}
+
+func TestVerifyDocumentConsistencyOfUnknownCommand(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	_, err := VerifyDocumentConsistency(testLog, testDocumentID, testInstanceID)
+
+	assert.True(t, os.IsNotExist(err))
+}
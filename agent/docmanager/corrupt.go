@@ -0,0 +1,93 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// CorruptStateError reports a document state that can't be unmarshalled,
+// QuarantinePath is where the state was moved to, empty if it wasn't moved
+type CorruptStateError struct {
+	Path           string
+	QuarantinePath string
+	Err            error
+}
+
+func (e *CorruptStateError) Error() string {
+	if e.QuarantinePath == "" {
+		return fmt.Sprintf("document state %v is corrupt: %v", e.Path, e.Err)
+	}
+	return fmt.Sprintf("document state %v is corrupt, moved it to %v: %v", e.Path, e.QuarantinePath, e.Err)
+}
+
+// isCorruptState returns whether the error reports a corrupt document state
+func isCorruptState(err error) bool {
+	_, corrupt := err.(*CorruptStateError)
+	return corrupt
+}
+
+// quarantineDocState moves the corrupt document state out of its folder into the corrupt folder of the instance,
+// so that it's neither processed as an empty document nor overwritten. It returns the path the state was moved to.
+func quarantineDocState(log log.T, absoluteFileName, instanceID string) (string, error) {
+	corruptDir := DocumentStateDir(instanceID, appconfig.DefaultLocationOfCorrupt)
+	srcDir, fileName := filepath.Split(absoluteFileName)
+	if filepath.Clean(srcDir) == corruptDir {
+		return absoluteFileName, nil
+	}
+	if err := fileutil.MakeDirsWithExecuteAccess(corruptDir); err != nil {
+		return "", err
+	}
+	quarantinePath := filepath.Join(corruptDir, fileName)
+	if err := store.Move(absoluteFileName, quarantinePath); err != nil {
+		return "", err
+	}
+	log.Warnf("moved the corrupt document state %v to %v", absoluteFileName, quarantinePath)
+	// the document won't run to completion, a redelivery of it is executed
+	removeClaim(log, documentIDOfStateFile(fileName), instanceID)
+	return quarantinePath, nil
+}
+
+// getDocStateForUpdate is getDocState for a caller holding the lock of the document for writing,
+// a corrupt state is moved to the corrupt folder of the instance and QuarantinePath of the CorruptStateError tells where
+func getDocStateForUpdate(log log.T, fileName, instanceID string) (model.DocumentState, error) {
+	docState, err := getDocState(log, fileName, instanceID)
+	corrupt, ok := err.(*CorruptStateError)
+	if !ok {
+		return docState, err
+	}
+	if corrupt.QuarantinePath, err = quarantineDocState(log, storedDocStateFileName(corrupt.Path), instanceID); err != nil {
+		log.Errorf("failed to move the corrupt document state %v to the corrupt folder: %v", fileName, err)
+	}
+	return docState, corrupt
+}
+
+// quarantineCorruptDocument moves the state a reader found corrupt to the corrupt folder once it holds the lock of the document
+// for writing, the readers hold it for reading only. It returns the CorruptStateError of the state still corrupt then,
+// the error of the reader otherwise.
+func quarantineCorruptDocument(log log.T, documentID, instanceID, locationFolder string, readErr error) error {
+	docMutex := lockDocument(instanceID, documentID)
+	defer unlockDocument(instanceID, documentID, docMutex)
+	if _, err := getDocStateForUpdate(log, docStateFileName(documentID, instanceID, locationFolder), instanceID); isCorruptState(err) {
+		return err
+	}
+	return readErr
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// writeMalformedDocState writes a truncated document state in the given folder, returns its path
+func writeMalformedDocState(t *testing.T, documentID, locationFolder string) string {
+	fileName := docStateFileName(documentID, testInstanceID, locationFolder)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(`{"DocumentInformation": {"DocumentID": "`+documentID), appconfig.ReadWriteAccess))
+	return fileName
+}
+
+func TestGetDocStateLeavesMalformedState(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+
+	docState, err := getDocState(testLog, fileName, testInstanceID)
+
+	assert.Equal(t, model.DocumentState{}, docState)
+	if assert.IsType(t, &CorruptStateError{}, err) {
+		assert.Empty(t, err.(*CorruptStateError).QuarantinePath)
+	}
+	assert.True(t, fileutil.Exists(fileName))
+}
+
+func TestGetDocStateForUpdateQuarantinesMalformedState(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+
+	docState, err := getDocStateForUpdate(testLog, fileName, testInstanceID)
+
+	assert.Equal(t, model.DocumentState{}, docState)
+	if assert.IsType(t, &CorruptStateError{}, err) {
+		quarantinePath := err.(*CorruptStateError).QuarantinePath
+		assert.Equal(t, filepath.Join(DocumentStateDir(testInstanceID, appconfig.DefaultLocationOfCorrupt), testDocumentID), quarantinePath)
+		assert.Contains(t, quarantinePath, testInstanceID)
+		assert.True(t, fileutil.Exists(quarantinePath))
+	}
+	assert.False(t, fileutil.Exists(fileName))
+}
+
+func TestGetDocStateOfMissingFileIsNotCorrupt(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	_, err := getDocState(testLog, docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent), testInstanceID)
+
+	assert.Error(t, err)
+	assert.False(t, isCorruptState(err))
+}
+
+func TestMalformedStateIsNotOverwritten(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	quarantinePath := filepath.Join(DocumentStateDir(testInstanceID, appconfig.DefaultLocationOfCorrupt), testDocumentID)
+
+	// the writes reading the state first don't persist it back as an empty document
+	writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+	pluginState := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	PersistPluginState(testLog, pluginState, "plugin1", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.False(t, fileutil.Exists(fileName))
+	assert.True(t, fileutil.Exists(quarantinePath))
+
+	writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+	PersistDocumentInfo(testLog, model.DocumentInfo{DocumentID: testDocumentID}, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.False(t, fileutil.Exists(fileName))
+
+	writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+	assert.IsType(t, &CorruptStateError{}, ResetInterruptedPlugins(testLog, testDocumentID, testInstanceID, []string{"plugin1"}))
+	assert.False(t, fileutil.Exists(fileName))
+
+	writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+	docState := GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.Empty(t, docState.DocumentInformation.DocumentID)
+	assert.False(t, fileutil.Exists(fileName))
+}
+
+func TestGetDocumentInterimStateE(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	// no state
+	docState, err := GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.Equal(t, os.ErrNotExist, err)
+	assert.Equal(t, model.DocumentState{}, docState)
+
+	// valid state
+	persisted := model.DocumentState{}
+	persisted.DocumentInformation.DocumentID = testDocumentID
+	persisted.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, persisted))
+	docState, err = GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.NoError(t, err)
+	assert.Equal(t, testDocumentID, docState.DocumentInformation.DocumentID)
+	assert.Equal(t, contracts.ResultStatusInProgress, docState.DocumentInformation.DocumentStatus)
+
+	// corrupt state
+	writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+	docState, err = GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.IsType(t, &CorruptStateError{}, err)
+	assert.False(t, os.IsNotExist(err))
+	assert.Equal(t, model.DocumentState{}, docState)
+
+	// the compatible variant returns an empty state in every failure case
+	assert.Equal(t, model.DocumentState{}, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestReaderQuarantinesUnderTheWriteLock(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := writeMalformedDocState(t, testDocumentID, appconfig.DefaultLocationOfCurrent)
+
+	// another reader holds the lock of the document
+	docMutex := rLockDocument(testInstanceID, testDocumentID)
+	read := make(chan error)
+	go func() {
+		_, err := GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+		read <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	assert.True(t, fileutil.Exists(fileName))
+
+	rUnlockDocument(docMutex)
+	err := <-read
+	if assert.IsType(t, &CorruptStateError{}, err) {
+		assert.NotEmpty(t, err.(*CorruptStateError).QuarantinePath)
+	}
+	assert.False(t, fileutil.Exists(fileName))
+}
@@ -0,0 +1,182 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// lockFolderName is the document state folder holding the lock files of the documents locked across processes
+const lockFolderName = "locks"
+
+// lockFileSuffix is the suffix of the lock file of a document
+const lockFileSuffix = ".lock"
+
+// lockFileRetryInterval is how long a lock file held by another process is waited for before it's tried again
+var lockFileRetryInterval = 50 * time.Millisecond
+
+// fileLocker locks a document across the processes sharing the data store, on top of the in-process lock of the document
+type fileLocker interface {
+	// Lock blocks until the lock at path is acquired and returns the function releasing it
+	Lock(path string) (unlock func(), err error)
+}
+
+// documentFileLocker returns the locker of the documents across processes per the DocumentLockMode setting,
+// nil if the documents are only locked within the agent process
+var documentFileLocker = func() fileLocker {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return nil
+	}
+	switch config.Ssm.DocumentLockMode {
+	case appconfig.DocumentLockModeFlock:
+		return flockLocker{}
+	case appconfig.DocumentLockModeLockFile:
+		return lockFileLocker{
+			fs:            osLockFileFS{},
+			stale:         time.Duration(config.Ssm.StaleDocumentLockSeconds) * time.Second,
+			retryInterval: lockFileRetryInterval,
+		}
+	}
+	return nil
+}
+
+// heldFileLocks are the functions releasing the cross-process locks of the documents locked for writing
+var heldFileLocks = make(map[documentLockKey]func())
+var heldFileLocksLock sync.Mutex
+
+// lockFilePath returns the path of the lock file of the document
+func lockFilePath(instanceID, id string) string {
+	return filepath.Join(DocumentStateDir(instanceID, lockFolderName), id+lockFileSuffix)
+}
+
+// lockDocumentFile locks the document across processes, the caller holds the in-process lock of the document for writing.
+// The document is only locked within the process if the lock can't be acquired.
+func lockDocumentFile(instanceID, id string) {
+	locker := documentFileLocker()
+	if locker == nil {
+		return
+	}
+	unlock, err := locker.Lock(lockFilePath(instanceID, id))
+	if err != nil {
+		log.Logger().Warnf("document %v is only locked within the agent process: %v", id, err)
+		return
+	}
+	heldFileLocksLock.Lock()
+	defer heldFileLocksLock.Unlock()
+	heldFileLocks[documentLockKey{instanceID, id}] = unlock
+}
+
+// unlockDocumentFile releases the cross-process lock of the document, if it's held
+func unlockDocumentFile(instanceID, id string) {
+	key := documentLockKey{instanceID, id}
+	heldFileLocksLock.Lock()
+	unlock, held := heldFileLocks[key]
+	delete(heldFileLocks, key)
+	heldFileLocksLock.Unlock()
+	if held {
+		unlock()
+	}
+}
+
+// lockFileFS is the filesystem the lock files are created in
+type lockFileFS interface {
+	// CreateExclusive creates the file with the content, an error satisfying os.IsExist if it already exists
+	CreateExclusive(path string, content []byte) error
+	// ModTime returns the modification time of the file, an error satisfying os.IsNotExist if it doesn't exist
+	ModTime(path string) (time.Time, error)
+	// Remove deletes the file, an error satisfying os.IsNotExist if it doesn't exist
+	Remove(path string) error
+}
+
+// lockFileLocker locks with lock files created exclusively, which is atomic on NFS unlike flock.
+// A lock file older than stale is considered left over by a process that crashed while holding it and is broken.
+type lockFileLocker struct {
+	fs            lockFileFS
+	stale         time.Duration
+	retryInterval time.Duration
+}
+
+// Lock creates the lock file once it doesn't exist, or is stale, and returns the function deleting it
+func (l lockFileLocker) Lock(path string) (unlock func(), err error) {
+	hostname, _ := os.Hostname()
+	owner := []byte(fmt.Sprintf("%v %v", hostname, os.Getpid()))
+	for {
+		err = l.fs.CreateExclusive(path, owner)
+		if err == nil {
+			return func() { l.fs.Remove(path) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		modTime, err := l.fs.ModTime(path)
+		if os.IsNotExist(err) {
+			// released in the meantime
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if l.stale > 0 && time.Since(modTime) > l.stale {
+			if err = l.fs.Remove(path); err != nil && !os.IsNotExist(err) {
+				return nil, err
+			}
+			continue
+		}
+		time.Sleep(l.retryInterval)
+	}
+}
+
+// osLockFileFS creates the lock files in the filesystem of the data store
+type osLockFileFS struct{}
+
+// CreateExclusive creates the file with O_EXCL, creating its dir first if needed
+func (osLockFileFS) CreateExclusive(path string, content []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(int(appconfig.ReadWriteAccess)))
+	if os.IsNotExist(err) {
+		if err = fileutil.MakeDirs(filepath.Dir(path)); err != nil {
+			return err
+		}
+		file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(int(appconfig.ReadWriteAccess)))
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = file.Write(content)
+	return err
+}
+
+// ModTime returns the modification time of the file
+func (osLockFileFS) ModTime(path string) (time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return info.ModTime(), nil
+}
+
+// Remove deletes the file
+func (osLockFileFS) Remove(path string) error {
+	return os.Remove(path)
+}
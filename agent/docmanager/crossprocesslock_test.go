@@ -0,0 +1,213 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNFS models the lock files of a network filesystem: creating a file exclusively is atomic across clients,
+// and the only way to tell a lock left over by a crashed client is its modification time
+type fakeNFS struct {
+	files map[string]time.Time
+	m     sync.Mutex
+}
+
+func newFakeNFS() *fakeNFS {
+	return &fakeNFS{files: make(map[string]time.Time)}
+}
+
+func (f *fakeNFS) CreateExclusive(path string, content []byte) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if _, exists := f.files[path]; exists {
+		return &os.PathError{Op: "open", Path: path, Err: os.ErrExist}
+	}
+	f.files[path] = time.Now()
+	return nil
+}
+
+func (f *fakeNFS) ModTime(path string) (time.Time, error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	modTime, exists := f.files[path]
+	if !exists {
+		return time.Time{}, &os.PathError{Op: "stat", Path: path, Err: os.ErrNotExist}
+	}
+	return modTime, nil
+}
+
+func (f *fakeNFS) Remove(path string) error {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if _, exists := f.files[path]; !exists {
+		return &os.PathError{Op: "remove", Path: path, Err: os.ErrNotExist}
+	}
+	delete(f.files, path)
+	return nil
+}
+
+func (f *fakeNFS) exists(path string) bool {
+	f.m.Lock()
+	defer f.m.Unlock()
+	_, exists := f.files[path]
+	return exists
+}
+
+func setTestFileLocker(locker fileLocker) func() {
+	origLocker := documentFileLocker
+	documentFileLocker = func() fileLocker { return locker }
+	return func() { documentFileLocker = origLocker }
+}
+
+func TestLockFileLockerExcludesConcurrentHolders(t *testing.T) {
+	fs := newFakeNFS()
+	path := "/nfs/locks/document.lock"
+	var holders, maxHolders, acquisitions int
+	var counters sync.Mutex
+	var wg sync.WaitGroup
+	// each locker stands for a process of its own, sharing the filesystem only
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			locker := lockFileLocker{fs: fs, stale: time.Hour, retryInterval: time.Millisecond}
+			for j := 0; j < 10; j++ {
+				unlock, err := locker.Lock(path)
+				if !assert.NoError(t, err) {
+					return
+				}
+				counters.Lock()
+				holders++
+				acquisitions++
+				if holders > maxHolders {
+					maxHolders = holders
+				}
+				counters.Unlock()
+				time.Sleep(100 * time.Microsecond)
+				counters.Lock()
+				holders--
+				counters.Unlock()
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, maxHolders)
+	assert.Equal(t, 80, acquisitions)
+	assert.False(t, fs.exists(path))
+}
+
+func TestLockFileLockerBreaksStaleLock(t *testing.T) {
+	fs := newFakeNFS()
+	path := "/nfs/locks/document.lock"
+	// left over by a process that crashed while holding it
+	fs.files[path] = time.Now().Add(-time.Hour)
+	locker := lockFileLocker{fs: fs, stale: time.Minute, retryInterval: time.Millisecond}
+
+	unlock, err := locker.Lock(path)
+
+	assert.NoError(t, err)
+	assert.True(t, fs.exists(path))
+	unlock()
+	assert.False(t, fs.exists(path))
+}
+
+func TestLockFileLockerWaitsForFreshLock(t *testing.T) {
+	fs := newFakeNFS()
+	path := "/nfs/locks/document.lock"
+	fs.files[path] = time.Now()
+	locker := lockFileLocker{fs: fs, stale: time.Hour, retryInterval: time.Millisecond}
+
+	acquired := make(chan bool)
+	go func() {
+		unlock, err := locker.Lock(path)
+		assert.NoError(t, err)
+		close(acquired)
+		unlock()
+	}()
+
+	select {
+	case <-acquired:
+		assert.Fail(t, "a lock held by another process was acquired")
+	case <-time.After(50 * time.Millisecond):
+	}
+	fs.Remove(path)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the released lock wasn't acquired")
+	}
+}
+
+func TestLockDocumentHoldsLockFile(t *testing.T) {
+	defer setTestDataStore(t)()
+	fs := newFakeNFS()
+	defer setTestFileLocker(lockFileLocker{fs: fs, stale: time.Hour, retryInterval: time.Millisecond})()
+	path := lockFilePath(testInstanceID, testDocumentID)
+
+	docMutex := lockDocument(testInstanceID, testDocumentID)
+	assert.True(t, fs.exists(path))
+	unlockDocument(testInstanceID, testDocumentID, docMutex)
+	assert.False(t, fs.exists(path))
+
+	// the writes of the document states take the lock file
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, model.DocumentState{}))
+	assert.False(t, fs.exists(path))
+	assert.Empty(t, heldFileLocks)
+}
+
+func TestFlockLockerExcludesConcurrentHolders(t *testing.T) {
+	defer setTestDataStore(t)()
+	path := lockFilePath(testInstanceID, testDocumentID)
+	var holders, maxHolders int
+	var counters sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				unlock, err := flockLocker{}.Lock(path)
+				if err != nil {
+					// flock isn't supported on this platform
+					return
+				}
+				counters.Lock()
+				holders++
+				if holders > maxHolders {
+					maxHolders = holders
+				}
+				counters.Unlock()
+				time.Sleep(100 * time.Microsecond)
+				counters.Lock()
+				holders--
+				counters.Unlock()
+				unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.True(t, maxHolders <= 1)
+}
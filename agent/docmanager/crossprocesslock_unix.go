@@ -0,0 +1,63 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build darwin freebsd linux netbsd openbsd
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+)
+
+// flockLocker locks with flock on the lock file, the lock is released by the kernel if the process dies
+type flockLocker struct{}
+
+// Lock flocks the lock file and returns the function deleting it and releasing the flock.
+// The file is deleted while flocked, so a flock acquired on a file that's no longer at path is dropped and tried again.
+func (flockLocker) Lock(path string) (unlock func(), err error) {
+	if err = fileutil.MakeDirs(filepath.Dir(path)); err != nil {
+		return nil, err
+	}
+	for {
+		file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, os.FileMode(int(appconfig.ReadWriteAccess)))
+		if err != nil {
+			return nil, err
+		}
+		if err = syscall.Flock(int(file.Fd()), syscall.LOCK_EX); err != nil {
+			file.Close()
+			return nil, err
+		}
+		flocked, fErr := file.Stat()
+		current, pErr := os.Stat(path)
+		if fErr == nil && pErr == nil && os.SameFile(flocked, current) {
+			return func() {
+				os.Remove(path)
+				syscall.Flock(int(file.Fd()), syscall.LOCK_UN)
+				file.Close()
+			}, nil
+		}
+		file.Close()
+		if fErr != nil {
+			return nil, fErr
+		}
+		if pErr != nil && !os.IsNotExist(pErr) {
+			return nil, pErr
+		}
+	}
+}
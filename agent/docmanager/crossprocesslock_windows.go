@@ -0,0 +1,27 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// +build windows
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import "fmt"
+
+// flockLocker locks with flock, which windows doesn't support
+type flockLocker struct{}
+
+// Lock fails, the documents are only locked within the agent process
+func (flockLocker) Lock(path string) (unlock func(), err error) {
+	return nil, fmt.Errorf("flock is not supported on windows, use the LockFile document lock mode instead")
+}
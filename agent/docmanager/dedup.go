@@ -0,0 +1,66 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// DedupKeyFunc returns the key of the document telling whether it duplicates another one, e.g. its document name and a hash of its parameters.
+// Documents with the same key are duplicates, a document with an empty key duplicates no other document.
+type DedupKeyFunc func(docState model.DocumentState) string
+
+// DocumentDedupKey computes the keys the documents are deduplicated on, in addition to their document id.
+// nil deduplicates the documents on their document id only, i.e. their command id.
+var DocumentDedupKey DedupKeyFunc
+
+// FindExecutingDuplicate returns the id of the pending or running document the given document duplicates, found is false if there is none.
+// The document is a duplicate of itself if it's already pending or running.
+func FindExecutingDuplicate(log log.T, docState model.DocumentState) (duplicateID string, found bool) {
+	documentID := docState.DocumentInformation.DocumentID
+	instanceID := docState.DocumentInformation.InstanceID
+	if IsDocumentCurrentlyExecuting(documentID, instanceID) {
+		return documentID, true
+	}
+	dedupKey := DocumentDedupKey
+	if dedupKey == nil || checkDataStorePath(log, instanceID) != nil {
+		return "", false
+	}
+	key := dedupKey(docState)
+	if key == "" {
+		return "", false
+	}
+	for _, locationFolder := range []string{appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCurrent} {
+		files, err := store.List(DocumentStateDir(instanceID, locationFolder))
+		if err != nil {
+			log.Debugf("failed to list the documents of %v: %v", locationFolder, err)
+			continue
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, moveIntermediateSuffix) {
+				continue
+			}
+			executing := GetDocumentInterimState(log, documentIDOfStateFile(file), instanceID, locationFolder)
+			if executing.DocumentInformation.DocumentID != "" && dedupKey(executing) == key {
+				return executing.DocumentInformation.DocumentID, true
+			}
+		}
+	}
+	return "", false
+}
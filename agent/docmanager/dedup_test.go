@@ -0,0 +1,86 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// parametersDedupKey keys the documents on their name and a hash of the parameters of their plugins
+func parametersDedupKey(docState model.DocumentState) string {
+	var properties []interface{}
+	for _, pluginState := range docState.InstancePluginsInformation {
+		properties = append(properties, pluginState.Configuration.Properties)
+	}
+	content, _ := jsonutil.Marshal(properties)
+	hash := sha256.Sum256([]byte(content))
+	return docState.DocumentInformation.DocumentName + "/" + hex.EncodeToString(hash[:])
+}
+
+// runCommandDocState returns the state of a AWS-RunShellScript command running the given script
+func runCommandDocState(commandID, script string) model.DocumentState {
+	docState := model.DocumentState{DocumentType: model.SendCommand}
+	docState.DocumentInformation.DocumentID = commandID
+	docState.DocumentInformation.CommandID = commandID
+	docState.DocumentInformation.InstanceID = testInstanceID
+	docState.DocumentInformation.DocumentName = "AWS-RunShellScript"
+	pluginState := model.PluginState{Id: "aws:runShellScript", Name: "aws:runShellScript"}
+	pluginState.Configuration.Properties = map[string]interface{}{"runCommand": []interface{}{script}}
+	docState.InstancePluginsInformation = []model.PluginState{pluginState}
+	return docState
+}
+
+func TestFindExecutingDuplicateWithCustomDedupKey(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func() { DocumentDedupKey = nil }()
+	DocumentDedupKey = parametersDedupKey
+	PersistData(testLog, "command1", testInstanceID, appconfig.DefaultLocationOfPending, runCommandDocState("command1", "reboot"))
+	PersistData(testLog, "command2", testInstanceID, appconfig.DefaultLocationOfCurrent, runCommandDocState("command2", "yum update -y"))
+
+	duplicateID, found := FindExecutingDuplicate(testLog, runCommandDocState("command3", "reboot"))
+	assert.True(t, found)
+	assert.Equal(t, "command1", duplicateID)
+
+	duplicateID, found = FindExecutingDuplicate(testLog, runCommandDocState("command4", "yum update -y"))
+	assert.True(t, found)
+	assert.Equal(t, "command2", duplicateID)
+
+	_, found = FindExecutingDuplicate(testLog, runCommandDocState("command5", "uptime"))
+	assert.False(t, found)
+
+	// the completed commands aren't executing anymore
+	MoveDocumentState(testLog, "command1", testInstanceID, appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCompleted)
+	_, found = FindExecutingDuplicate(testLog, runCommandDocState("command3", "reboot"))
+	assert.False(t, found)
+}
+
+func TestFindExecutingDuplicateDefaultsToTheCommandID(t *testing.T) {
+	defer setTestDataStore(t)()
+	PersistData(testLog, "command1", testInstanceID, appconfig.DefaultLocationOfPending, runCommandDocState("command1", "reboot"))
+
+	_, found := FindExecutingDuplicate(testLog, runCommandDocState("command2", "reboot"))
+	assert.False(t, found)
+
+	duplicateID, found := FindExecutingDuplicate(testLog, runCommandDocState("command1", "reboot"))
+	assert.True(t, found)
+	assert.Equal(t, "command1", duplicateID)
+}
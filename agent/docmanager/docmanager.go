@@ -15,21 +15,26 @@
 package docmanager
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
-)
-
-const (
-	maxLogFileDeletions int = 100
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
+	"github.com/aws/amazon-ssm-agent/agent/times"
 )
 
 type validString func(string) bool
@@ -38,45 +43,212 @@ type modifyString func(string) string
 //TODO:  Revisit this when making Persistence invasive - i.e failure in file-systems should resort to Agent crash instead of swallowing errors
 
 var lock sync.RWMutex
-var docLock = make(map[string]*sync.RWMutex)
+var docLock = make(map[documentLockKey]*sync.RWMutex)
+
+// documentLockKey identifies the lock of a document, documents of different instances never share a lock
+type documentLockKey struct {
+	instanceID string
+	fileName   string
+}
+
+// terminalLocationFolders are the state folders of the documents whose execution is over
+var terminalLocationFolders = []string{appconfig.DefaultLocationOfCompleted, appconfig.DefaultLocationOfFailed}
+
+// Assign the data store root to a global variable to allow unittest to override
+var dataStorePath = appconfig.DefaultDataStorePath
+
+// compactStateFolders returns the folders whose document states are persisted as compact json
+var compactStateFolders = func() []string {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return nil
+	}
+	return config.Ssm.CompactStateFolders
+}
+
+// ValidateDataStorePath checks the data store root is an absolute path,
+// document states must never be persisted relative to the working directory of the agent
+func ValidateDataStorePath() error {
+	if dataStorePath == "" {
+		return fmt.Errorf("data store path is empty")
+	}
+	if !filepath.IsAbs(dataStorePath) {
+		return fmt.Errorf("data store path %v is not absolute", dataStorePath)
+	}
+	return nil
+}
+
+// checkDataStorePath validates the data store root and the instance id before the document states of the instance are accessed,
+// and logs why they can't be
+func checkDataStorePath(log log.T, instanceID string) error {
+	err := ValidateDataStorePath()
+	if err == nil {
+		err = ValidateInstanceID(instanceID)
+	}
+	if err != nil {
+		log.Errorf("refusing to access the document state: %v", err)
+	}
+	return err
+}
 
 // GetDocumentInterimState returns CommandState object after reading file <fileName> from locationFolder
-// under defaultLogDir/instanceID
+// under defaultLogDir/instanceID, an empty state if it couldn't be read
 func GetDocumentInterimState(log log.T, fileName, instanceID, locationFolder string) model.DocumentState {
+	docState, _ := GetDocumentInterimStateE(log, fileName, instanceID, locationFolder)
+	return docState
+}
 
-	rLockDocument(fileName)
-	defer rUnlockDocument(fileName)
+// GetDocumentInterimStateE returns the document state read from file <fileName> from locationFolder under defaultLogDir/instanceID,
+// the error is os.ErrNotExist if there is no such state, and a CorruptStateError if the state can't be unmarshalled
+func GetDocumentInterimStateE(log log.T, fileName, instanceID, locationFolder string) (model.DocumentState, error) {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return model.DocumentState{}, err
+	}
+	flushPluginStates(fileName, instanceID)
 
 	absoluteFileName := docStateFileName(fileName, instanceID, locationFolder)
 
-	docState := getDocState(log, absoluteFileName)
+	docMutex := rLockDocument(instanceID, fileName)
+	docState, err := getDocState(log, absoluteFileName, instanceID)
+	if err == nil {
+		rehydratePluginOutputs(log, instanceID, &docState)
+	}
+	rUnlockDocument(docMutex)
 
-	return docState
+	if isCorruptState(err) {
+		err = quarantineCorruptDocument(log, fileName, instanceID, locationFolder, err)
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = os.ErrNotExist
+		}
+		return model.DocumentState{}, err
+	}
+	return docState, nil
 }
 
-// PersistData stores the given object in the file-system in pretty Json indented format
-// This will override the contents of an already existing file
-func PersistData(log log.T, fileName, instanceID, locationFolder string, object interface{}) {
+// TerminalLocationFolder returns the folder a document with the given final status is moved to once its execution is over
+func TerminalLocationFolder(status contracts.ResultStatus, separateFailedDocuments bool) string {
+	if separateFailedDocuments && (status == contracts.ResultStatusFailed || status == contracts.ResultStatusTimedOut) {
+		return appconfig.DefaultLocationOfFailed
+	}
+	return appconfig.DefaultLocationOfCompleted
+}
+
+// isTerminalLocationFolder checks if the given folder holds the documents whose execution is over
+func isTerminalLocationFolder(locationFolder string) bool {
+	for _, terminalLocationFolder := range terminalLocationFolders {
+		if locationFolder == terminalLocationFolder {
+			return true
+		}
+	}
+	return false
+}
+
+// FindTerminalLocationFolder returns the terminal folder holding the document state, Completed if the document isn't found in any
+func FindTerminalLocationFolder(documentID, instanceID string) string {
+	for _, locationFolder := range terminalLocationFolders {
+		if docStateExists(docStateFileName(documentID, instanceID, locationFolder)) {
+			return locationFolder
+		}
+	}
+	return appconfig.DefaultLocationOfCompleted
+}
+
+// LookupCompleted returns the document info of the document whose execution is over, found is false if no terminal folder holds it
+func LookupCompleted(log log.T, documentID, instanceID string) (docInfo model.DocumentInfo, found bool) {
+	for _, locationFolder := range terminalLocationFolders {
+		if docStateExists(docStateFileName(documentID, instanceID, locationFolder)) {
+			docInfo = GetDocumentInfo(log, documentID, instanceID, locationFolder)
+			return docInfo, docInfo.DocumentID != ""
+		}
+	}
+	return
+}
+
+// GetFinalResult rebuilds the document result, including each plugin's result, from the state persisted in the terminal folder
+func GetFinalResult(log log.T, documentID, instanceID string) contracts.DocumentResult {
+	docState := GetDocumentInterimState(log, documentID, instanceID, FindTerminalLocationFolder(documentID, instanceID))
+
+	pluginResults := make(map[string]*contracts.PluginResult)
+	for _, pluginState := range docState.InstancePluginsInformation {
+		pluginResult := pluginState.Result
+		if pluginResult.PluginName == "" {
+			pluginResult.PluginName = pluginState.Name
+		}
+		pluginResults[pluginState.Id] = &pluginResult
+	}
+
+	docInfo := docState.DocumentInformation
+	return contracts.DocumentResult{
+		DocumentName:    docInfo.DocumentName,
+		DocumentVersion: docInfo.DocumentVersion,
+		MessageID:       docInfo.MessageID,
+		AssociationID:   docInfo.AssociationID,
+		PluginResults:   pluginResults,
+		Status:          docInfo.DocumentStatus,
+		NPlugins:        len(docState.InstancePluginsInformation),
+	}
+}
 
-	lockDocument(fileName)
-	defer unlockDocument(fileName)
+// PersistData stores the given object in the file-system in pretty Json indented format, or compact Json in the folders configured so
+// This will override the contents of an already existing file, the error tells why the state couldn't be persisted
+func PersistData(log log.T, fileName, instanceID, locationFolder string, object interface{}) error {
+	return PersistDataCtx(context.Background(), log, fileName, instanceID, locationFolder, object)
+}
+
+// PersistDataCtx is PersistData giving up on the state if ctx is done before the lock of the document is acquired,
+// ctx.Err() is returned then and nothing is written
+func PersistDataCtx(ctx context.Context, log log.T, fileName, instanceID, locationFolder string, object interface{}) (err error) {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+	flushPluginStates(fileName, instanceID)
+
+	docMutex, err := lockDocumentCtx(ctx, instanceID, fileName)
+	if err != nil {
+		log.Debugf("not persisting the state of document %v: %v", fileName, err)
+		return err
+	}
+	defer unlockDocument(instanceID, fileName, docMutex)
+
+	start := time.Now()
+	defer func() { DefaultMetrics.RecordPersist(time.Since(start), err) }()
 
 	absoluteFileName := docStateFileName(fileName, instanceID, locationFolder)
 
+	switch docState := object.(type) {
+	case model.DocumentState:
+		offloadPluginOutputs(log, fileName, instanceID, &docState)
+		object = versionedDocState{StateSchemaVersion: CurrentStateSchemaVersion, DocumentState: docState}
+	case *model.DocumentState:
+		offloaded := *docState
+		offloadPluginOutputs(log, fileName, instanceID, &offloaded)
+		object = versionedDocState{StateSchemaVersion: CurrentStateSchemaVersion, DocumentState: offloaded}
+	}
+
 	content, err := jsonutil.Marshal(object)
 	if err != nil {
 		log.Errorf("encountered error with message %v while marshalling %v to string", err, object)
-	} else {
-		if fileutil.Exists(absoluteFileName) {
-			log.Debugf("overwriting contents of %v", absoluteFileName)
-		}
-		log.Tracef("persisting interim state %v in file %v", jsonutil.Indent(content), absoluteFileName)
-		if s, err := fileutil.WriteIntoFileWithPermissions(absoluteFileName, jsonutil.Indent(content), os.FileMode(int(appconfig.ReadWriteAccess))); s && err == nil {
-			log.Debugf("successfully persisted interim state in %v", locationFolder)
-		} else {
-			log.Debugf("persisting interim state in %v failed with error %v", locationFolder, err)
-		}
+		metrics.DefaultSink.IncrCounter(metrics.DocumentPersistFailure, 1)
+		return fmt.Errorf("failed to marshal the state of document %v: %v", fileName, err)
+	}
+	if content, err = encryptStateFields(content); err != nil {
+		log.Errorf("encountered error with message %v while encrypting the fields of the interim state", err)
+		metrics.DefaultSink.IncrCounter(metrics.DocumentPersistFailure, 1)
+		return fmt.Errorf("failed to encrypt the state of document %v: %v", fileName, err)
 	}
+	log.Tracef("persisting interim state %v in file %v", jsonutil.Indent(content), absoluteFileName)
+	formatted := formatDocState(content, locationFolder)
+	if err := putDocState(absoluteFileName, locationFolder, []byte(formatted)); err != nil {
+		log.Debugf("persisting interim state in %v failed with error %v", locationFolder, err)
+		metrics.DefaultSink.IncrCounter(metrics.DocumentPersistFailure, 1)
+		return fmt.Errorf("failed to persist the state of document %v in %v: %v", fileName, locationFolder, err)
+	}
+	log.Debugf("successfully persisted interim state in %v", locationFolder)
+	signDocState(log, absoluteFileName, formatted)
+	metrics.DefaultSink.IncrCounter(metrics.DocumentPersistSuccess, 1)
+	return nil
 }
 
 // IsDocumentCurrentlyExecuting checks if document already present in Pending or Current folder
@@ -86,73 +258,195 @@ func IsDocumentCurrentlyExecuting(fileName, instanceID string) bool {
 		return false
 	}
 
-	lockDocument(fileName)
-	defer unlockDocument(fileName)
+	docMutex := lockDocument(instanceID, fileName)
+	defer unlockDocument(instanceID, fileName, docMutex)
 
 	absoluteFileName := docStateFileName(fileName, instanceID, appconfig.DefaultLocationOfPending)
-	if fileutil.Exists(absoluteFileName) {
+	if docStateExists(absoluteFileName) {
 		return true
 	}
 	absoluteFileName = docStateFileName(fileName, instanceID, appconfig.DefaultLocationOfCurrent)
-	return fileutil.Exists(absoluteFileName)
+	return docStateExists(absoluteFileName)
+}
+
+// DocumentNames returns the name of each document persisted in the given folder, keyed by document id
+func DocumentNames(log log.T, instanceID, locationFolder string) map[string]string {
+	names := make(map[string]string)
+	if checkDataStorePath(log, instanceID) != nil {
+		return names
+	}
+	files, err := store.List(DocumentStateDir(instanceID, locationFolder))
+	if err != nil {
+		log.Debugf("failed to list the documents of %v: %v", locationFolder, err)
+		return names
+	}
+	for _, file := range files {
+		if strings.HasSuffix(file, moveIntermediateSuffix) {
+			continue
+		}
+		documentID := documentIDOfStateFile(file)
+		names[documentID] = GetDocumentInfo(log, documentID, instanceID, locationFolder).DocumentName
+	}
+	return names
+}
+
+// DocumentFilter selects the documents listed by ListDocuments, its empty fields match every document
+type DocumentFilter struct {
+	DocumentType model.DocumentType
+	Status       contracts.ResultStatus
+}
+
+// matches returns true if the document state is selected by the filter
+func (f DocumentFilter) matches(docState model.DocumentState) bool {
+	return (f.DocumentType == "" || docState.DocumentType == f.DocumentType) &&
+		(f.Status == "" || docState.DocumentInformation.DocumentStatus == f.Status)
+}
+
+// ListDocuments returns the document info of the documents persisted in the given folder selected by the filter, oldest created first.
+// The documents whose state can't be read are left out, there are none if the folder doesn't exist.
+func ListDocuments(log log.T, instanceID, locationFolder string, filter DocumentFilter) ([]model.DocumentInfo, error) {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return nil, err
+	}
+	files, err := store.List(DocumentStateDir(instanceID, locationFolder))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the documents of %v: %v", locationFolder, err)
+	}
+	var docInfos []model.DocumentInfo
+	for _, file := range files {
+		if strings.HasSuffix(file, moveIntermediateSuffix) {
+			continue
+		}
+		documentID := documentIDOfStateFile(file)
+		docMutex := rLockDocument(instanceID, documentID)
+		docState, err := getDocState(log, docStateFileName(documentID, instanceID, locationFolder), instanceID)
+		rUnlockDocument(docMutex)
+		if isCorruptState(err) {
+			quarantineCorruptDocument(log, documentID, instanceID, locationFolder, err)
+		}
+		if err != nil || !filter.matches(docState) {
+			continue
+		}
+		docInfos = append(docInfos, docState.DocumentInformation)
+	}
+	sort.SliceStable(docInfos, func(i, j int) bool {
+		return times.ParseIso8601UTC(docInfos[i].CreatedDate).Before(times.ParseIso8601UTC(docInfos[j].CreatedDate))
+	})
+	return docInfos, nil
 }
 
 // RemoveData deletes the fileName from locationFolder under defaultLogDir/instanceID
 func RemoveData(log log.T, commandID, instanceID, locationFolder string) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+	flushPluginStates(commandID, instanceID)
 
 	absoluteFileName := docStateFileName(commandID, instanceID, locationFolder)
 
-	err := fileutil.DeleteFile(absoluteFileName)
+	err := deleteDocState(absoluteFileName)
 	if err != nil {
 		log.Errorf("encountered error %v while deleting file %v", err, absoluteFileName)
 	} else {
 		log.Debugf("successfully deleted file %v", absoluteFileName)
+		removeSignature(log, absoluteFileName)
+		removeSummary(log, absoluteFileName)
+		removeOffloadedOutputs(log, commandID, instanceID)
+		removeRawMessage(log, commandID, instanceID)
 	}
 }
 
 // MoveDocumentState moves the document file to target location
 func MoveDocumentState(log log.T, fileName, instanceID, srcLocationFolder, dstLocationFolder string) {
+	MoveDocumentStateCtx(context.Background(), log, fileName, instanceID, srcLocationFolder, dstLocationFolder)
+}
+
+// MoveDocumentStateCtx is MoveDocumentState leaving the document where it is if ctx is done before the lock of the document is acquired,
+// ctx.Err() is returned then. The error of the move is returned if the document couldn't be moved.
+func MoveDocumentStateCtx(ctx context.Context, log log.T, fileName, instanceID, srcLocationFolder, dstLocationFolder string) error {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+	flushPluginStates(fileName, instanceID)
 
 	//get a lock for documentID specific lock
-	lockDocument(fileName)
+	docMutex, err := lockDocumentCtx(ctx, instanceID, fileName)
+	if err != nil {
+		log.Debugf("not moving document %v from %v to %v: %v", fileName, srcLocationFolder, dstLocationFolder, err)
+		return err
+	}
 
-	absoluteSource := path.Join(appconfig.DefaultDataStorePath,
+	err = moveDocState(log, fileName, instanceID, srcLocationFolder, dstLocationFolder)
+
+	//release documentID specific lock - before deleting the entry from the map
+	unlockDocument(instanceID, fileName, docMutex)
+	if err != nil {
+		return err
+	}
+
+	//delete documentID specific lock if document has finished executing. This is to avoid documentLock growing too much in memory.
+	//This is done by ensuring that as soon as document finishes executing it is removed from documentLock
+	//Its safe to assume that document has finished executing if it is being moved to one of the terminal folders
+	if isTerminalLocationFolder(dstLocationFolder) {
+		deleteLock(instanceID, fileName)
+	}
+	return nil
+}
+
+// moveDocState moves the document state to the target folder, formats it for the folder and summarizes it if the folder is terminal.
+// The caller holds the lock of the document for writing, nothing but the move is done if the move fails.
+func moveDocState(log log.T, fileName, instanceID, srcLocationFolder, dstLocationFolder string) error {
+	absoluteSource := path.Join(dataStorePath,
 		instanceID,
 		appconfig.DefaultDocumentRootDirName,
 		appconfig.DefaultLocationOfState,
 		srcLocationFolder)
 
-	absoluteDestination := path.Join(appconfig.DefaultDataStorePath,
+	absoluteDestination := path.Join(dataStorePath,
 		instanceID,
 		appconfig.DefaultDocumentRootDirName,
 		appconfig.DefaultLocationOfState,
 		dstLocationFolder)
 
-	if s, err := fileutil.MoveFile(fileName, absoluteSource, absoluteDestination); s && err == nil {
-		log.Debugf("moved file %v from %v to %v successfully", fileName, srcLocationFolder, dstLocationFolder)
-	} else {
+	// a gzipped state keeps its format until it's rewritten for the destination folder
+	storedSource := storedDocStateFileName(path.Join(absoluteSource, fileName))
+	storedDestination := path.Join(absoluteDestination, fileName) + strings.TrimPrefix(storedSource, path.Join(absoluteSource, fileName))
+	docStateCache.invalidate(path.Join(absoluteSource, fileName))
+	docStateCache.invalidate(path.Join(absoluteDestination, fileName))
+	if err := store.Move(storedSource, storedDestination); err != nil {
 		log.Debugf("moving file %v from %v to %v failed with error %v", fileName, srcLocationFolder, dstLocationFolder, err)
+		return fmt.Errorf("failed to move document %v from %v to %v: %v", fileName, srcLocationFolder, dstLocationFolder, err)
 	}
-
-	//release documentID specific lock - before deleting the entry from the map
-	unlockDocument(fileName)
-
-	//delete documentID specific lock if document has finished executing. This is to avoid documentLock growing too much in memory.
-	//This is done by ensuring that as soon as document finishes executing it is removed from documentLock
-	//Its safe to assume that document has finished executing if it is being moved to appconfig.DefaultLocationOfCompleted
-	if dstLocationFolder == appconfig.DefaultLocationOfCompleted {
-		deleteLock(fileName)
+	log.Debugf("moved file %v from %v to %v successfully", fileName, srcLocationFolder, dstLocationFolder)
+	reformatDocState(log, path.Join(absoluteDestination, fileName), dstLocationFolder)
+	if isTerminalLocationFolder(dstLocationFolder) {
+		writeDocumentSummary(log, path.Join(absoluteDestination, fileName), instanceID, dstLocationFolder)
+	}
+	// a document given up on never completes, its claim is released so that a redelivery of it is executed
+	if dstLocationFolder == appconfig.DefaultLocationOfCorrupt {
+		removeClaim(log, fileName, instanceID)
 	}
+	return nil
 }
 
 // GetDocumentInfo returns the document info for the specified fileName
 func GetDocumentInfo(log log.T, fileName, instanceID, locationFolder string) model.DocumentInfo {
-	rLockDocument(fileName)
-	defer rUnlockDocument(fileName)
+	if checkDataStorePath(log, instanceID) != nil {
+		return model.DocumentInfo{}
+	}
+	flushPluginStates(fileName, instanceID)
 
 	absoluteFileName := docStateFileName(fileName, instanceID, locationFolder)
 
-	commandState := getDocState(log, absoluteFileName)
+	docMutex := rLockDocument(instanceID, fileName)
+	commandState, err := getDocState(log, absoluteFileName, instanceID)
+	rUnlockDocument(docMutex)
+	if isCorruptState(err) {
+		quarantineCorruptDocument(log, fileName, instanceID, locationFolder, err)
+	}
 
 	return commandState.DocumentInformation
 }
@@ -160,36 +454,192 @@ func GetDocumentInfo(log log.T, fileName, instanceID, locationFolder string) mod
 // PersistDocumentInfo stores the given PluginState in file-system in pretty Json indented format
 // This will override the contents of an already existing file
 func PersistDocumentInfo(log log.T, docInfo model.DocumentInfo, fileName, instanceID, locationFolder string) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+	flushPluginStates(fileName, instanceID)
 
 	absoluteFileName := docStateFileName(fileName, instanceID, locationFolder)
 
 	//get documentID specific write lock
-	lockDocument(fileName)
-	defer unlockDocument(fileName)
+	docMutex := lockDocument(instanceID, fileName)
+	defer unlockDocument(instanceID, fileName, docMutex)
 
 	//Plugins should safely assume that there already
 	//exists a persisted interim state file - if not then it should throw error
 
 	//read command state from file-system first
-	commandState := getDocState(log, absoluteFileName)
+	commandState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if isCorruptState(err) {
+		log.Errorf("not persisting the document info of %v: %v", fileName, err)
+		return
+	}
 
 	commandState.DocumentInformation = docInfo
 
 	setDocState(log, commandState, absoluteFileName, locationFolder)
 }
 
+// ForceCompleteDocument marks the document stuck in the Current folder with the given status and reason,
+// and moves it to the given terminal folder. The document stays locked from its read to its move, so that
+// no other writer updates or moves it in between.
+func ForceCompleteDocument(log log.T, documentID, instanceID string, status contracts.ResultStatus, reason, terminalFolder string) error {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+	flushPluginStates(documentID, instanceID)
+
+	absoluteFileName := docStateFileName(documentID, instanceID, appconfig.DefaultLocationOfCurrent)
+
+	docMutex := lockDocument(instanceID, documentID)
+	if !docStateExists(absoluteFileName) {
+		unlockDocument(instanceID, documentID, docMutex)
+		return fmt.Errorf("document %v is not in progress", documentID)
+	}
+	commandState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if err != nil {
+		unlockDocument(instanceID, documentID, docMutex)
+		return err
+	}
+	commandState.DocumentInformation.DocumentStatus = status
+	commandState.DocumentInformation.DocumentTraceOutput = reason
+	if status == contracts.ResultStatusFailed || status == contracts.ResultStatusTimedOut {
+		commandState.DocumentInformation.LastError = truncateLastError(reason)
+	}
+	setDocState(log, commandState, absoluteFileName, appconfig.DefaultLocationOfCurrent)
+	err = moveDocState(log, documentID, instanceID, appconfig.DefaultLocationOfCurrent, terminalFolder)
+	unlockDocument(instanceID, documentID, docMutex)
+	if err != nil {
+		return err
+	}
+	if isTerminalLocationFolder(terminalFolder) {
+		deleteLock(instanceID, documentID)
+	}
+	return nil
+}
+
+// ResetInterruptedPlugins marks the document of the Current folder and its failed plugins with the given names back in progress,
+// so that the plugins a shutdown interrupted run again when the document is resumed
+func ResetInterruptedPlugins(log log.T, documentID, instanceID string, pluginNames []string) error {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+
+	absoluteFileName := docStateFileName(documentID, instanceID, appconfig.DefaultLocationOfCurrent)
+
+	docMutex := lockDocument(instanceID, documentID)
+	defer unlockDocument(instanceID, documentID, docMutex)
+
+	if !docStateExists(absoluteFileName) {
+		return fmt.Errorf("document %v is not in progress", documentID)
+	}
+	interrupted := make(map[string]bool)
+	for _, pluginName := range pluginNames {
+		interrupted[pluginName] = true
+	}
+	commandState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if err != nil {
+		return err
+	}
+	for index, pluginState := range commandState.InstancePluginsInformation {
+		if interrupted[pluginState.Name] && pluginState.Result.Status == contracts.ResultStatusFailed {
+			log.Infof("plugin %v of document %v was interrupted by the shutdown, it will run again", pluginState.Id, documentID)
+			commandState.InstancePluginsInformation[index].Result.Status = contracts.ResultStatusInProgress
+		}
+	}
+	commandState.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	setDocState(log, commandState, absoluteFileName, appconfig.DefaultLocationOfCurrent)
+	return nil
+}
+
+// SetDocumentLastError records the concise reason the document failed in its state persisted in the given folder
+func SetDocumentLastError(log log.T, documentID, instanceID, locationFolder, lastError string) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+
+	absoluteFileName := docStateFileName(documentID, instanceID, locationFolder)
+
+	docMutex := lockDocument(instanceID, documentID)
+	defer unlockDocument(instanceID, documentID, docMutex)
+
+	commandState, _ := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if commandState.DocumentInformation.DocumentID == "" {
+		log.Debugf("document %v not found in %v, skip recording its last error", documentID, locationFolder)
+		return
+	}
+	commandState.DocumentInformation.LastError = truncateLastError(lastError)
+
+	setDocState(log, commandState, absoluteFileName, locationFolder)
+}
+
+// GetDocumentLastError returns the reason the document failed, empty if it didn't fail or isn't found in the given folder
+func GetDocumentLastError(log log.T, documentID, instanceID, locationFolder string) string {
+	return GetDocumentInfo(log, documentID, instanceID, locationFolder).LastError
+}
+
+// maxLastErrorLength caps the length of the last error of a document, it's meant to be a summary
+const maxLastErrorLength = 1024
+
+// SummarizePluginFailure returns the last error of a document from the results of its plugins,
+// the first failing plugin, by plugin id, gives the reason
+func SummarizePluginFailure(pluginResults map[string]*contracts.PluginResult) string {
+	pluginIDs := make([]string, 0, len(pluginResults))
+	for pluginID := range pluginResults {
+		pluginIDs = append(pluginIDs, pluginID)
+	}
+	sort.Strings(pluginIDs)
+	for _, pluginID := range pluginIDs {
+		result := pluginResults[pluginID]
+		if result == nil || result.Status.IsSuccess() || result.Status == contracts.ResultStatusSkipped ||
+			result.Status == contracts.ResultStatusUnsupportedPlugin {
+			continue
+		}
+		reason := ""
+		if result.Error != nil {
+			reason = result.Error.Error()
+		} else if result.StandardError != "" {
+			reason = result.StandardError
+		} else if output, ok := result.Output.(string); ok {
+			reason = output
+		}
+		if reason == "" {
+			return truncateLastError(fmt.Sprintf("step %v %v", pluginID, result.Status))
+		}
+		return truncateLastError(fmt.Sprintf("step %v %v: %v", pluginID, result.Status, strings.TrimSpace(reason)))
+	}
+	return ""
+}
+
+// truncateLastError cuts the last error down to maxLastErrorLength
+func truncateLastError(lastError string) string {
+	if len(lastError) <= maxLastErrorLength {
+		return lastError
+	}
+	return lastError[:maxLastErrorLength]
+}
+
 // GetPluginState returns PluginState after reading fileName from given locationFolder under defaultLogDir/instanceID
 func GetPluginState(log log.T, pluginID, commandID, instanceID, locationFolder string) *model.PluginState {
-
-	rLockDocument(commandID)
-	defer rUnlockDocument(commandID)
+	if checkDataStorePath(log, instanceID) != nil {
+		return nil
+	}
+	flushPluginStates(commandID, instanceID)
 
 	absoluteFileName := docStateFileName(commandID, instanceID, locationFolder)
 
-	commandState := getDocState(log, absoluteFileName)
+	docMutex := rLockDocument(instanceID, commandID)
+	commandState, err := getDocState(log, absoluteFileName, instanceID)
+	if isCorruptState(err) {
+		rUnlockDocument(docMutex)
+		quarantineCorruptDocument(log, commandID, instanceID, locationFolder, err)
+		return nil
+	}
+	defer rUnlockDocument(docMutex)
 
 	for _, pluginState := range commandState.InstancePluginsInformation {
 		if pluginState.Id == pluginID {
+			rehydratePluginOutput(log, instanceID, &pluginState)
 			return &pluginState
 		}
 	}
@@ -198,28 +648,52 @@ func GetPluginState(log log.T, pluginID, commandID, instanceID, locationFolder s
 }
 
 // PersistPluginState stores the given PluginState in file-system in pretty Json indented format
-// This will override the contents of an already existing file
+// This will override the contents of an already existing file.
+// With the PluginStateDebounceMillis setting, the in progress updates are held back to be persisted along with the next ones.
 func PersistPluginState(log log.T, pluginState model.PluginState, pluginID, commandID, instanceID, locationFolder string) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+
+	update := pluginStateUpdate{pluginID: pluginID, pluginState: pluginState}
+	if window := pluginStateDebounce(); window > 0 {
+		pluginStateBatches.add(log, update, commandID, instanceID, locationFolder, window)
+		return
+	}
+
+	docMutex := lockDocument(instanceID, commandID)
+	defer unlockDocument(instanceID, commandID, docMutex)
 
-	lockDocument(commandID)
-	defer unlockDocument(commandID)
+	persistPluginStates(log, []pluginStateUpdate{update}, commandID, instanceID, locationFolder)
+}
 
+// persistPluginStates stores the given plugin states in the state of the document in a single write, the document is locked by the caller
+func persistPluginStates(log log.T, updates []pluginStateUpdate, commandID, instanceID, locationFolder string) {
 	absoluteFileName := docStateFileName(commandID, instanceID, locationFolder)
 
 	//Plugins should safely assume that there already
 	//exists a persisted interim state file - if not then it should throw error
-	commandState := getDocState(log, absoluteFileName)
+	commandState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if isCorruptState(err) {
+		log.Errorf("not persisting the state of the plugins of %v: %v", commandID, err)
+		return
+	}
 
-	//TODO:  after adding unit-tests for persist data - this can be removed
-	if commandState.InstancePluginsInformation == nil {
-		pluginsInfo := []model.PluginState{}
-		pluginsInfo = append(pluginsInfo, pluginState)
-		commandState.InstancePluginsInformation = pluginsInfo
-	} else {
-		for index, plugin := range commandState.InstancePluginsInformation {
-			if plugin.Id == pluginID {
-				commandState.InstancePluginsInformation[index] = pluginState
-				break
+	for _, update := range updates {
+		pluginState := update.pluginState
+		offloadPluginOutput(log, commandID, instanceID, &pluginState)
+
+		//TODO:  after adding unit-tests for persist data - this can be removed
+		if commandState.InstancePluginsInformation == nil {
+			pluginsInfo := []model.PluginState{}
+			pluginsInfo = append(pluginsInfo, pluginState)
+			commandState.InstancePluginsInformation = pluginsInfo
+		} else {
+			for index, plugin := range commandState.InstancePluginsInformation {
+				if plugin.Id == update.pluginID {
+					commandState.InstancePluginsInformation[index] = pluginState
+					break
+				}
 			}
 		}
 	}
@@ -227,25 +701,112 @@ func PersistPluginState(log log.T, pluginState model.PluginState, pluginID, comm
 	setDocState(log, commandState, absoluteFileName, locationFolder)
 }
 
+// AmendPluginResult overwrites the result of a plugin of a document that completed, e.g. once the asynchronous upload of its logs is over.
+// The document stays in its terminal folder with its terminal status, the amendment is recorded in its document info.
+func AmendPluginResult(log log.T, commandID, instanceID, pluginID string, result contracts.PluginResult) error {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+
+	docMutex := lockDocument(instanceID, commandID)
+	defer unlockDocument(instanceID, commandID, docMutex)
+
+	for _, locationFolder := range terminalLocationFolders {
+		absoluteFileName := docStateFileName(commandID, instanceID, locationFolder)
+		if !docStateExists(absoluteFileName) {
+			continue
+		}
+		commandState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+		if err != nil {
+			return err
+		}
+		if !isTerminalStatus(commandState.DocumentInformation.DocumentStatus) {
+			return fmt.Errorf("document %v has status %v, only the results of a completed document can be amended", commandID, commandState.DocumentInformation.DocumentStatus)
+		}
+		for index, plugin := range commandState.InstancePluginsInformation {
+			if plugin.Id != pluginID {
+				continue
+			}
+			commandState.InstancePluginsInformation[index].Result = result
+			offloadPluginOutput(log, commandID, instanceID, &commandState.InstancePluginsInformation[index])
+			commandState.DocumentInformation.Amendments = append(commandState.DocumentInformation.Amendments, model.PluginResultAmendment{
+				PluginID:    pluginID,
+				AmendedDate: times.ToIso8601UTC(time.Now()),
+			})
+			log.Infof("amending the result of plugin %v of document %v in %v", pluginID, commandID, locationFolder)
+			setDocState(log, commandState, absoluteFileName, locationFolder)
+			return nil
+		}
+		return fmt.Errorf("document %v has no plugin %v", commandID, pluginID)
+	}
+	return fmt.Errorf("document %v is not complete", commandID)
+}
+
 // DocumentStateDir returns absolute filename where command states are persisted
 func DocumentStateDir(instanceID, locationFolder string) string {
-	return filepath.Join(appconfig.DefaultDataStorePath,
+	return filepath.Join(dataStorePath,
 		instanceID,
 		appconfig.DefaultDocumentRootDirName,
 		appconfig.DefaultLocationOfState,
 		locationFolder)
 }
 
+// stateFolders are the document state folders the agent needs to process documents
+var stateFolders = []string{
+	appconfig.DefaultLocationOfPending,
+	appconfig.DefaultLocationOfCurrent,
+	appconfig.DefaultLocationOfCompleted,
+	appconfig.DefaultLocationOfFailed,
+	appconfig.DefaultLocationOfCorrupt}
+
+// EnsureStateFolders creates the missing document state folders of the instance and verifies each of them is writable,
+// the error lists every folder that isn't ready
+func EnsureStateFolders(log log.T, instanceID string) error {
+	if err := ValidateDataStorePath(); err != nil {
+		return err
+	}
+	if err := ValidateInstanceID(instanceID); err != nil {
+		return err
+	}
+	var failures []string
+	for _, locationFolder := range stateFolders {
+		stateDir := DocumentStateDir(instanceID, locationFolder)
+		if err := fileutil.MakeDirsWithExecuteAccess(stateDir); err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		probe, err := ioutil.TempFile(stateDir, ".probe")
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("directory %v is not writable. %v", stateDir, err))
+			continue
+		}
+		probe.Close()
+		if err = os.Remove(probe.Name()); err != nil {
+			log.Debugf("Error deleting file %v: %v", probe.Name(), err)
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("document state folders are not ready: %v", strings.Join(failures, "; "))
+	}
+	return nil
+}
+
 // orchestrationDir returns the absolute path of the orchestration directory
 func orchestrationDir(instanceID, orchestrationRootDirName string) string {
-	return path.Join(appconfig.DefaultDataStorePath,
+	return path.Join(dataStorePath,
 		instanceID,
 		appconfig.DefaultDocumentRootDirName,
 		orchestrationRootDirName)
 }
 
-// DeleteOldDocumentFolderLogs deletes the logs from document/state/completed and document/orchestration folders older than retention duration which satisfy the file name format
-func DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, isIntendedFileNameFormat validString, formOrchestrationFolderName modifyString) {
+// DeleteOldDocumentFolderLogs deletes the logs from document/state/completed, document/state/failed and document/orchestration folders older than retention duration which satisfy the file name format,
+// the documents declaring their own retention are kept for that retention instead,
+// and the documents whose name matches one of the retention overrides are kept for the longer retention of the override
+func DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, maxDeletions int, isIntendedFileNameFormat validString, formOrchestrationFolderName modifyString) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+
 	defer func() {
 		// recover in case the function panics
 		if msg := recover(); msg != nil {
@@ -253,74 +814,360 @@ func DeleteOldDocumentFolderLogs(log log.T, instanceID, orchestrationRootDirName
 		}
 	}()
 
-	// Form the path for completed document state dir
-	completedDir := DocumentStateDir(instanceID, appconfig.DefaultLocationOfCompleted)
+	summary := CleanupSummary{InstanceID: instanceID}
+	start := time.Now()
+
+	// Finish the deletion of the orchestration dirs earlier passes couldn't fully remove
+	deleteLeftovers(log, instanceID)
 
 	// Form the path for orchestration logs dir
 	orchestrationRootDir := orchestrationDir(instanceID, orchestrationRootDirName)
 
-	if !fileutil.Exists(completedDir) {
-		log.Debugf("Completed log directory doesn't exist: %v", completedDir)
+	// an orchestration dir shared by documents of several commands is kept until its last document is deleted
+	owners := collectOrchestrationDirOwners(log, instanceID)
+	// the documents are deleted concurrently, the documents sharing an orchestration dir one after the other
+	var sweepLock sync.Mutex
+	var orchestrationDirLocks pathLocks
+	collision := func(orchestrationDirFullPath, completedFile string) error {
+		sweepLock.Lock()
+		defer sweepLock.Unlock()
+		return owners.collision(orchestrationDirFullPath, completedFile, owners.commandID(orchestrationDirFullPath, completedFile))
+	}
+
+	summary.Examined = walkOldTerminalDocuments(log, instanceID, orchestrationRootDir, retentionDurationHours, retentionOverrides, maxDeletions, isIntendedFileNameFormat, formOrchestrationFolderName, cleanupWorkers(),
+		func(completedFile, completedLogFullPath, orchestrationDirFullPath string) (deleted bool) {
+			var freed int64
+			defer orchestrationDirLocks.lock(filepath.Clean(orchestrationDirFullPath))()
+			// the lock of a deleted document is dropped once released, so that the locks don't pile up
+			defer func() {
+				if deleted {
+					deleteLock(instanceID, completedFile)
+				}
+			}()
+			// hold the document lock for the whole deletion, so that a reader sees either the document or a clean not found,
+			// never a document whose logs or signature are already gone
+			docMutex := lockDocument(instanceID, completedFile)
+			defer unlockDocument(instanceID, completedFile, docMutex)
+
+			if err := collision(orchestrationDirFullPath, completedFile); err != nil {
+				log.Warnf("keeping the orchestration dir of document %v: %v", completedFile, err)
+			} else {
+				log.Debugf("Attempting Deletion of folder : %v", orchestrationDirFullPath)
+
+				size, sizeErr := fileutil.GetPathSize(orchestrationDirFullPath)
+				if err = deleteOrchestrationDir(log, orchestrationDirFullPath); err != nil {
+					// Some files of the orchestration dir are still held, leave them to a later pass instead of keeping the document state file forever
+					log.Debugf("Error deleting dir %v, recording it for a later pass: %v", orchestrationDirFullPath, err)
+					recordLeftover(log, instanceID, orchestrationDirFullPath)
+				} else if sizeErr == nil {
+					freed += size
+				}
+			}
+
+			// Delete the document state file
+			log.Debugf("Attempting Deletion of file : %v", completedLogFullPath)
+
+			info, statErr := statDocState(completedLogFullPath)
+			if err := deleteDocState(completedLogFullPath); err != nil {
+				log.Debugf("Error deleting file %v: %v", completedLogFullPath, err)
+				metrics.DefaultSink.IncrCounter(metrics.CleanupFailedDeletions, 1)
+				return false
+			}
+			if statErr == nil {
+				freed += info.Size
+			}
+
+			// The document can no longer be looked up, drop its claim marker and signature as well
+			removeClaim(log, completedFile, instanceID)
+			removeSignature(log, completedLogFullPath)
+			removeSummary(log, completedLogFullPath)
+			removeOffloadedOutputs(log, completedFile, instanceID)
+			removeRawMessage(log, completedFile, instanceID)
+			metrics.DefaultSink.IncrCounter(metrics.CleanupDeletedDocuments, 1)
+			sweepLock.Lock()
+			defer sweepLock.Unlock()
+			owners.remove(completedFile)
+			summary.Deleted++
+			summary.BytesFreed += freed
+			return true
+		})
+
+	summary.Skipped = summary.Examined - summary.Deleted
+	summary.Duration = time.Since(start)
+	reportCleanupCompleted(log, summary)
+	DefaultMetrics.RecordCleanup(summary.Deleted)
+	log.Debugf("Completed DeleteOldDocumentFolderLogs")
+}
+
+// RetainMostRecentCompleted keeps the n most recently modified documents of the completed folder and deletes all the others
+// along with their orchestration dirs, regardless of their age
+func RetainMostRecentCompleted(log log.T, instanceID string, n int) {
+	if checkDataStorePath(log, instanceID) != nil {
 		return
 	}
 
-	completedFiles, err := fileutil.GetFileNames(completedDir)
+	completedDir := DocumentStateDir(instanceID, appconfig.DefaultLocationOfCompleted)
+	completedFiles, err := store.List(completedDir)
+	if os.IsNotExist(err) {
+		log.Debugf("Completed log directory doesn't exist: %v", completedDir)
+		return
+	}
 	if err != nil {
 		log.Debugf("Failed to read files under %v", err)
 		return
 	}
-
-	if completedFiles == nil || len(completedFiles) == 0 {
-		log.Debugf("Completed log directory %v is invalid or empty", completedDir)
+	if n < 0 {
+		n = 0
+	}
+	if len(completedFiles) <= n {
 		return
 	}
 
-	// Go through all log files in the completed logs dir, delete max maxLogFileDeletions files and the corresponding dirs from orchestration folder
-	countOfDeletions := 0
+	modTimes := make(map[string]time.Time)
 	for _, completedFile := range completedFiles {
+		if info, err := store.Stat(filepath.Join(completedDir, completedFile)); err == nil {
+			modTimes[completedFile] = info.ModTime
+		}
+	}
+	// newest first
+	sort.Slice(completedFiles, func(i, j int) bool {
+		return modTimes[completedFiles[i]].After(modTimes[completedFiles[j]])
+	})
+
+	for _, completedFile := range completedFiles[n:] {
+		fileName := documentIDOfStateFile(completedFile)
+		docState := GetDocumentInterimState(log, fileName, instanceID, appconfig.DefaultLocationOfCompleted)
+		for _, orchestrationDirFullPath := range documentOrchestrationDirs(docState) {
+			log.Debugf("Attempting Deletion of folder : %v", orchestrationDirFullPath)
+			if err = deleteOrchestrationDir(log, orchestrationDirFullPath); err != nil {
+				log.Debugf("Error deleting dir %v, recording it for a later pass: %v", orchestrationDirFullPath, err)
+				recordLeftover(log, instanceID, orchestrationDirFullPath)
+			}
+		}
 
-		completedLogFullPath := filepath.Join(completedDir, completedFile)
+		completedLogFullPath := filepath.Join(completedDir, fileName)
+		log.Debugf("Attempting Deletion of file : %v", completedLogFullPath)
+		docMutex := lockDocument(instanceID, fileName)
+		err = deleteDocState(completedLogFullPath)
+		unlockDocument(instanceID, fileName, docMutex)
+		if err != nil {
+			log.Debugf("Error deleting file %v: %v", completedLogFullPath, err)
+			continue
+		}
+		deleteLock(instanceID, fileName)
+		removeClaim(log, fileName, instanceID)
+		removeSignature(log, completedLogFullPath)
+		removeSummary(log, completedLogFullPath)
+		removeOffloadedOutputs(log, fileName, instanceID)
+		removeRawMessage(log, fileName, instanceID)
+	}
+}
 
-		//Checking for the file name format so that the function only deletes the files it is called to do. Also checking whether the file is beyond retention time.
-		if isIntendedFileNameFormat(completedFile) && isOlderThan(log, completedLogFullPath, retentionDurationHours) {
-			//The file name is valid for deletion and is also old. Go ahead for deletion.
-			orchestrationFolder := formOrchestrationFolderName(completedFile)
-			orchestrationDirFullPath := filepath.Join(orchestrationRootDir, orchestrationFolder)
+// documentOrchestrationDirs returns the orchestration dirs holding the plugin outputs of the document,
+// the dir recorded in the document if any, else the parent dirs of the plugin outputs
+func documentOrchestrationDirs(docState model.DocumentState) (orchestrationDirs []string) {
+	if orchestrationDir := docState.DocumentInformation.OrchestrationDirectory; orchestrationDir != "" {
+		return []string{orchestrationDir}
+	}
+	found := make(map[string]bool)
+	for _, pluginState := range docState.InstancePluginsInformation {
+		if pluginState.Configuration.OrchestrationDirectory == "" {
+			continue
+		}
+		orchestrationDir := filepath.Dir(pluginState.Configuration.OrchestrationDirectory)
+		if !found[orchestrationDir] {
+			found[orchestrationDir] = true
+			orchestrationDirs = append(orchestrationDirs, orchestrationDir)
+		}
+	}
+	return
+}
 
-			log.Debugf("Attempting Deletion of folder : %v", orchestrationDirFullPath)
+// CleanupEstimate reports what DeleteOldDocumentFolderLogs would remove
+type CleanupEstimate struct {
+	Documents int
+	Bytes     int64
+}
 
-			err := fileutil.DeleteDirectory(orchestrationDirFullPath)
-			if err != nil {
-				log.Debugf("Error deleting dir %v: %v", orchestrationDirFullPath, err)
-				continue
+// EstimateCleanup walks the documents DeleteOldDocumentFolderLogs would delete with the same parameters, and returns their count
+// along with the size of their state files and orchestration dirs, without deleting anything
+func EstimateCleanup(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, maxDeletions int, isIntendedFileNameFormat validString, formOrchestrationFolderName modifyString) (estimate CleanupEstimate) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+
+	orchestrationRootDir := orchestrationDir(instanceID, orchestrationRootDirName)
+
+	walkOldTerminalDocuments(log, instanceID, orchestrationRootDir, retentionDurationHours, retentionOverrides, maxDeletions, isIntendedFileNameFormat, formOrchestrationFolderName, 1,
+		func(completedFile, completedLogFullPath, orchestrationDirFullPath string) bool {
+			estimate.Documents++
+			if info, err := statDocState(completedLogFullPath); err == nil {
+				estimate.Bytes += info.Size
+			}
+			if size, err := fileutil.GetPathSize(orchestrationDirFullPath); err == nil {
+				estimate.Bytes += size
 			}
+			return true
+		})
+	return
+}
 
-			// Deletion of orchestration dir was successful. Delete the document state file
-			log.Debugf("Attempting Deletion of file : %v", completedLogFullPath)
+// PlanCleanup is the dry run of DeleteOldDocumentFolderLogs, it walks the documents it would delete with the same parameters
+// and returns the orchestration dirs and document state files it would remove, without deleting anything
+func PlanCleanup(log log.T, instanceID, orchestrationRootDirName string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, maxDeletions int, isIntendedFileNameFormat validString, formOrchestrationFolderName modifyString) (paths []string) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
 
-			err = fileutil.DeleteDirectory(completedLogFullPath)
+	// the orchestration dirs earlier passes couldn't fully remove are deleted first
+	for _, leftover := range readLeftovers(log, instanceID) {
+		if fileutil.Exists(leftover) {
+			paths = append(paths, leftover)
+		}
+	}
 
-			if err != nil {
-				log.Debugf("Error deleting file %v: %v", completedLogFullPath, err)
-				continue
+	orchestrationRootDir := orchestrationDir(instanceID, orchestrationRootDirName)
+	owners := collectOrchestrationDirOwners(log, instanceID)
+
+	walkOldTerminalDocuments(log, instanceID, orchestrationRootDir, retentionDurationHours, retentionOverrides, maxDeletions, isIntendedFileNameFormat, formOrchestrationFolderName, 1,
+		func(completedFile, completedLogFullPath, orchestrationDirFullPath string) bool {
+			if err := owners.collision(orchestrationDirFullPath, completedFile, owners.commandID(orchestrationDirFullPath, completedFile)); err != nil {
+				log.Debugf("the orchestration dir of document %v would be kept: %v", completedFile, err)
+			} else if fileutil.Exists(orchestrationDirFullPath) {
+				paths = append(paths, orchestrationDirFullPath)
 			}
+			paths = append(paths, completedLogFullPath)
+			owners.remove(completedFile)
+			return true
+		})
+	return
+}
+
+// cleanupAction processes a document selected for cleanup, returns false if the document couldn't be processed
+type cleanupAction func(completedFile, completedLogFullPath, orchestrationDirFullPath string) bool
+
+// walkOldTerminalDocuments goes through the terminal folders one after the other and runs the action on the documents older than retention duration
+// which satisfy the file name format, on up to workers documents at once; all of the folders share the max deletions budget.
+// It returns once all the actions completed.
+func walkOldTerminalDocuments(log log.T, instanceID, orchestrationRootDir string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, maxDeletions int, isIntendedFileNameFormat validString, formOrchestrationFolderName modifyString, workers int, action cleanupAction) (examined int) {
+	pool := newCleanupPool(log, workers, maxDeletions, action)
+	defer pool.wait()
+	for _, locationFolder := range terminalLocationFolders {
+		examined += walkOldDocuments(log, instanceID, locationFolder, orchestrationRootDir, retentionDurationHours, retentionOverrides, isIntendedFileNameFormat, formOrchestrationFolderName, pool)
+		if pool.exhausted() {
+			break
+		}
+	}
+	return
+}
+
+// walkOldDocuments runs the action of the pool on the document states of the given terminal folder older than retention duration
+// as long as the budget of the pool allows it, it returns the count of documents of the folder it examined
+func walkOldDocuments(log log.T, instanceID, locationFolder, orchestrationRootDir string, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride, isIntendedFileNameFormat validString, formOrchestrationFolderName modifyString, pool *cleanupPool) int {
+	// Form the path for terminal document state dir
+	completedDir := DocumentStateDir(instanceID, locationFolder)
+
+	completedFiles, err := store.List(completedDir)
+	if os.IsNotExist(err) {
+		log.Debugf("Completed log directory doesn't exist: %v", completedDir)
+		return 0
+	}
+	if err != nil {
+		log.Debugf("Failed to read files under %v", err)
+		return 0
+	}
 
-			// Deletion of both document state and orchestration file was successful
-			countOfDeletions += 2
-			if countOfDeletions > maxLogFileDeletions {
+	if completedFiles == nil || len(completedFiles) == 0 {
+		log.Debugf("Completed log directory %v is invalid or empty", completedDir)
+		return 0
+	}
+
+	examined := 0
+
+	// Go through all log files in the completed logs dir, delete max maxDeletions files and the corresponding dirs from orchestration folder
+	for _, storedFile := range completedFiles {
+
+		completedFile := documentIDOfStateFile(storedFile)
+		completedLogFullPath := filepath.Join(completedDir, completedFile)
+
+		//Checking for the file name format so that the function only deletes the files it is called to do. Also checking whether the file is beyond retention time.
+		if !isIntendedFileNameFormat(completedFile) {
+			continue
+		}
+		examined++
+		// the documents too recent for any retention to be over are skipped before their state is read
+		if !isOlderThan(log, filepath.Join(completedDir, storedFile), shortestRetentionHours(retentionDurationHours)) {
+			continue
+		}
+		docInfo := GetDocumentInfo(log, completedFile, instanceID, locationFolder)
+		if isOlderThan(log, filepath.Join(completedDir, storedFile), documentRetentionHours(log, docInfo, retentionDurationHours, retentionOverrides)) {
+			//The file name is valid for deletion and is also old. Go ahead for deletion.
+			orchestrationDirFullPath := cleanupOrchestrationDir(log, docInfo, orchestrationRootDir, formOrchestrationFolderName(completedFile))
+
+			if !pool.reserve() {
 				break
 			}
-
+			pool.run(completedFile, completedLogFullPath, orchestrationDirFullPath)
 		}
 
 	}
 
-	log.Debugf("Completed DeleteOldDocumentFolderLogs")
+	return examined
 }
 
-// isOlderThan checks whether the file is older than the retention duration
+// cleanupOrchestrationDir returns the orchestration dir of the document to clean up: the dir recorded in the document,
+// or the dir derived from its file name for the documents persisted before it was recorded.
+// A recorded dir outside of the orchestration root dir is never cleaned up.
+func cleanupOrchestrationDir(log log.T, docInfo model.DocumentInfo, orchestrationRootDir, orchestrationFolder string) string {
+	derived := filepath.Join(orchestrationRootDir, orchestrationFolder)
+	recorded := docInfo.OrchestrationDirectory
+	if recorded == "" {
+		return derived
+	}
+	if relative, err := filepath.Rel(orchestrationRootDir, recorded); err != nil || relative == "." || strings.HasPrefix(relative, "..") {
+		log.Warnf("the orchestration dir %v recorded in document %v is outside of %v, cleaning up %v instead", recorded, docInfo.DocumentID, orchestrationRootDir, derived)
+		return derived
+	}
+	return filepath.Clean(recorded)
+}
+
+// documentRetentionHours returns the retention duration of the document: the retention the document declared if any, else the given retention,
+// extended by the first retention override matching the name of the document
+func documentRetentionHours(log log.T, docInfo model.DocumentInfo, retentionDurationHours int, retentionOverrides []appconfig.RetentionOverride) int {
+	if docInfo.OrchestrationRetentionHours > 0 {
+		retentionDurationHours = docInfo.OrchestrationRetentionHours
+	}
+	for _, override := range retentionOverrides {
+		matched, err := path.Match(override.DocumentNamePattern, docInfo.DocumentName)
+		if err != nil {
+			log.Debugf("Invalid retention override pattern %v: %v", override.DocumentNamePattern, err)
+			continue
+		}
+		if matched {
+			if override.RetentionDurationHours > retentionDurationHours {
+				retentionDurationHours = override.RetentionDurationHours
+			}
+			break
+		}
+	}
+	return retentionDurationHours
+}
+
+// minDocumentRetentionHours is the shortest retention hint of a document, the hints are whole hours
+const minDocumentRetentionHours = 1
+
+// shortestRetentionHours returns the shortest retention a document may have, the retention hint of a document
+// can be shorter than the agent wide retention but is at least an hour
+func shortestRetentionHours(retentionDurationHours int) int {
+	if retentionDurationHours < minDocumentRetentionHours {
+		return retentionDurationHours
+	}
+	return minDocumentRetentionHours
+}
+
+// isOlderThan checks whether the document state is older than the retention duration
 func isOlderThan(log log.T, fileFullPath string, retentionDurationHours int) bool {
-	modificationTime, err := fileutil.GetFileModificationTime(fileFullPath)
+	info, err := store.Stat(fileFullPath)
 
 	if err != nil {
 		log.Debugf("Failed to get modification time %v", err)
@@ -328,98 +1175,284 @@ func isOlderThan(log log.T, fileFullPath string, retentionDurationHours int) boo
 	}
 
 	// Check whether the current time is after modification time plus the retention duration
-	return modificationTime.Add(time.Hour * time.Duration(retentionDurationHours)).Before(time.Now())
+	return info.ModTime.Add(time.Hour * time.Duration(retentionDurationHours)).Before(time.Now())
 }
 
-// getDocState reads commandState from given file
-func getDocState(log log.T, fileName string) model.DocumentState {
+// getDocState reads commandState from given file, a CorruptStateError is returned along with the empty state for a state
+// that can't be unmarshalled. The state is left where it is, see getDocStateForUpdate.
+// A state persisted in an older schema version is upgraded in memory, it's never written back from here: the readers hold
+// the lock of the document for reading only, and the next write of the document persists it in the current version.
+// A state of a newer version is left as is and an UnsupportedStateVersionError is returned.
+func getDocState(log log.T, fileName, instanceID string) (model.DocumentState, error) {
+	cached, hit, cacheKey, cacheable := cachedDocState(fileName)
+	if hit {
+		log.Tracef("interim CommandState of %v read from the cache", fileName)
+		return cached, nil
+	}
 
-	var commandState model.DocumentState
-	err := jsonutil.UnmarshalFile(fileName, &commandState)
+	commandState, err := readDocState(fileName)
 	if err != nil {
 		log.Errorf("encountered error with message %v while reading Interim state of command from file - %v", err, fileName)
+		if checksumErr, ok := err.(*ChecksumError); ok {
+			// the state parses but its content is no longer the one persisted, it's as unusable as a state that doesn't parse
+			err = &CorruptStateError{Path: checksumErr.Path, Err: checksumErr}
+		}
+		if isCorruptState(err) {
+			return model.DocumentState{}, err
+		}
+		return commandState, err
+	}
+	if cacheable {
+		cacheDocState(cacheKey, commandState)
+	}
+	//logging interim state as read from the file
+	jsonString, err := jsonutil.Marshal(commandState)
+	if err != nil {
+		log.Errorf("encountered error with message %v while marshalling %v to string", err, commandState)
 	} else {
-		//logging interim state as read from the file
-		jsonString, err := jsonutil.Marshal(commandState)
-		if err != nil {
-			log.Errorf("encountered error with message %v while marshalling %v to string", err, commandState)
-		} else {
-			log.Tracef("interim CommandState read from file-system - %v", jsonutil.Indent(jsonString))
+		log.Tracef("interim CommandState read from file-system - %v", jsonutil.Indent(jsonString))
+	}
+
+	return commandState, nil
+}
+
+// readDocState reads the document state from the given file, verifying its signature if the signing is enabled,
+// upgraded to the current schema version
+func readDocState(fileName string) (commandState model.DocumentState, err error) {
+	content, err := getDocStateContent(fileName)
+	if err != nil {
+		return
+	}
+	if err = verifyDocState(fileName, content); err != nil {
+		return
+	}
+	if content, err = decryptStateFields(content); err != nil {
+		return
+	}
+	if content, err = migrateDocState(fileName, content); err != nil {
+		return
+	}
+	var versioned versionedDocState
+	if err = json.Unmarshal(content, &versioned); err != nil {
+		err = &CorruptStateError{Path: fileName, Err: err}
+	}
+	commandState = versioned.DocumentState
+	return
+}
+
+// formatDocState formats the json content of a document state the way the given folder keeps it,
+// compact in the folders listed in the CompactStateFolders setting and indented in the others
+func formatDocState(content, locationFolder string) string {
+	for _, folder := range compactStateFolders() {
+		if folder == locationFolder {
+			return jsonutil.Compact(content)
 		}
 	}
+	return jsonutil.Indent(content)
+}
 
-	return commandState
+// reformatDocState rewrites the document state moved to the given folder if the folder keeps it in another format
+func reformatDocState(log log.T, absoluteFileName, locationFolder string) {
+	content, err := getDocStateContent(absoluteFileName)
+	if err != nil {
+		log.Debugf("failed to read %v to format it for %v: %v", absoluteFileName, locationFolder, err)
+		return
+	}
+	if !json.Valid(content) {
+		log.Debugf("%v is not valid json, leaving it as is", absoluteFileName)
+		return
+	}
+	// don't sign over a state that fails its verification
+	if err = verifyDocState(absoluteFileName, content); err != nil {
+		log.Warnf("leaving %v as is: %v", absoluteFileName, err)
+		return
+	}
+	formatted := formatDocState(string(content), locationFolder)
+	storedFileName, staleFileName := absoluteFileName, absoluteFileName+compressedStateSuffix
+	stored := []byte(formatted)
+	if compressesStates(locationFolder) {
+		storedFileName, staleFileName = staleFileName, storedFileName
+		if stored, err = (gzipCodec{}).Compress(stored); err != nil {
+			log.Debugf("failed to compress %v for %v: %v", absoluteFileName, locationFolder, err)
+			return
+		}
+	}
+	if stored, err = sealDocState(stored); err != nil {
+		log.Debugf("failed to encrypt %v for %v: %v", absoluteFileName, locationFolder, err)
+		return
+	}
+	if formatted == string(content) && storedDocStateFileName(absoluteFileName) == storedFileName {
+		return
+	}
+	// rewrite through an intermediate file so a crash never leaves a half written state, see ReconcileDocumentStates
+	intermediateFileName := absoluteFileName + moveIntermediateSuffix
+	if err = store.Put(intermediateFileName, stored); err != nil {
+		log.Debugf("failed to format %v for %v: %v", absoluteFileName, locationFolder, err)
+		return
+	}
+	if err = store.Move(intermediateFileName, storedFileName); err != nil {
+		log.Debugf("failed to format %v for %v: %v", absoluteFileName, locationFolder, err)
+		store.Delete(intermediateFileName)
+		return
+	}
+	store.Delete(staleFileName)
+	signDocState(log, absoluteFileName, formatted)
 }
 
 // setDocState persists given commandState
 func setDocState(log log.T, commandState model.DocumentState, absoluteFileName, locationFolder string) {
-
-	content, err := jsonutil.Marshal(commandState)
+	start := time.Now()
+	content, err := jsonutil.Marshal(versionedDocState{StateSchemaVersion: CurrentStateSchemaVersion, DocumentState: commandState})
 	if err != nil {
 		log.Errorf("encountered error with message %v while marshalling %v to string", err, commandState)
+	} else if content, err = encryptStateFields(content); err != nil {
+		log.Errorf("encountered error with message %v while encrypting the fields of the interim state, it isn't persisted", err)
 	} else {
-		if fileutil.Exists(absoluteFileName) {
-			log.Debugf("overwriting contents of %v", absoluteFileName)
-		}
 		log.Tracef("persisting interim state %v in file %v", jsonutil.Indent(content), absoluteFileName)
-		if s, err := fileutil.WriteIntoFileWithPermissions(absoluteFileName, jsonutil.Indent(content), os.FileMode(int(appconfig.ReadWriteAccess))); s && err == nil {
+		formatted := formatDocState(content, locationFolder)
+		if err = putDocState(absoluteFileName, locationFolder, []byte(formatted)); err == nil {
 			log.Debugf("successfully persisted interim state in %v", locationFolder)
+			signDocState(log, absoluteFileName, formatted)
 		} else {
 			log.Debugf("persisting interim state in %v failed with error %v", locationFolder, err)
 		}
 	}
+	DefaultMetrics.RecordPersist(time.Since(start), err)
 }
 
-// rLockDocument locks id specific RWMutex for reading
-func rLockDocument(id string) {
-	//check if document lock even exists
-	if !doesLockExist(id) {
-		createLock(id)
+// lookupDocumentLock is assigned to a variable to allow unittest to override
+var lookupDocumentLock = documentLock
+
+// rLockDocument locks id specific RWMutex of the instance for reading, and returns it for rUnlockDocument
+func rLockDocument(instanceID, id string) *sync.RWMutex {
+	for {
+		mutex := lookupDocumentLock(instanceID, id)
+		mutex.RLock()
+		if isCurrentLock(instanceID, id, mutex) {
+			return mutex
+		}
+		mutex.RUnlock()
 	}
+}
 
-	docLock[id].RLock()
+// rUnlockDocument releases a single RLock of the mutex returned by rLockDocument.
+// The mutex isn't looked up again, its entry may have been deleted while it was held.
+func rUnlockDocument(mutex *sync.RWMutex) {
+	mutex.RUnlock()
+}
+
+// lockDocument locks id specific RWMutex of the instance for writing, and the document across processes per the DocumentLockMode setting.
+// It returns the mutex for unlockDocument.
+func lockDocument(instanceID, id string) *sync.RWMutex {
+	for {
+		mutex := lookupDocumentLock(instanceID, id)
+		mutex.Lock()
+		if isCurrentLock(instanceID, id, mutex) {
+			lockDocumentFile(instanceID, id)
+			return mutex
+		}
+		mutex.Unlock()
+	}
 }
 
-// rUnlockDocument releases id specific single RLock
-func rUnlockDocument(id string) {
-	docLock[id].RUnlock()
+// lockDocumentCtx locks id specific RWMutex of the instance for writing unless ctx is done first, ctx.Err() is returned then.
+// A lock acquired once ctx is done is released right away. It returns the mutex for unlockDocument.
+func lockDocumentCtx(ctx context.Context, instanceID, id string) (*sync.RWMutex, error) {
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		mutex := lookupDocumentLock(instanceID, id)
+		if err := lockMutexCtx(ctx, mutex); err != nil {
+			return nil, err
+		}
+		if isCurrentLock(instanceID, id, mutex) {
+			lockDocumentFile(instanceID, id)
+			return mutex, nil
+		}
+		mutex.Unlock()
+	}
 }
 
-// lockDocument locks id specific RWMutex for writing
-func lockDocument(id string) {
-	//check if document lock even exists
-	if !doesLockExist(id) {
-		createLock(id)
+// lockMutexCtx locks the mutex for writing unless ctx is done first, ctx.Err() is returned then
+func lockMutexCtx(ctx context.Context, mutex *sync.RWMutex) error {
+	if mutex.TryLock() {
+		return nil
+	}
+	if ctx.Done() == nil {
+		// ctx is never done, e.g. context.Background()
+		mutex.Lock()
+		return nil
 	}
+	acquired := make(chan bool)
+	go func() {
+		mutex.Lock()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		return nil
+	case <-ctx.Done():
+		go func() {
+			<-acquired
+			mutex.Unlock()
+		}()
+		return ctx.Err()
+	}
+}
+
+// isCurrentLock returns true if the mutex just acquired is still the lock of the id of the instance. The lock of a document
+// is dropped once free, see ReleaseDocumentLock, so it may have been dropped between its lookup and its acquisition:
+// the caller holding it would then not exclude the callers of the lock created since. A held lock is never dropped.
+func isCurrentLock(instanceID, id string, mutex *sync.RWMutex) bool {
+	lock.RLock()
+	defer lock.RUnlock()
+	return docLock[documentLockKey{instanceID, id}] == mutex
+}
 
-	docLock[id].Lock()
+// unlockDocument releases the mutex of the instance returned by lockDocument, and the lock of the document across processes.
+// The mutex isn't looked up again, its entry may have been deleted while it was held.
+func unlockDocument(instanceID, id string, mutex *sync.RWMutex) {
+	unlockDocumentFile(instanceID, id)
+	mutex.Unlock()
 }
 
-// unlockDocument releases id specific Lock for writing
-func unlockDocument(id string) {
-	docLock[id].Unlock()
+// documentLock returns the lock of the given id of the instance, it's created if it doesn't exist yet
+func documentLock(instanceID, id string) *sync.RWMutex {
+	lock.RLock()
+	mutex, ok := docLock[documentLockKey{instanceID, id}]
+	lock.RUnlock()
+	if ok {
+		return mutex
+	}
+	return createLock(instanceID, id)
 }
 
-// doesLockExist returns true if there exists documentLock for given id
-func doesLockExist(id string) bool {
+// doesLockExist returns true if there exists documentLock for given id of the instance
+func doesLockExist(instanceID, id string) bool {
 	lock.RLock()
 	defer lock.RUnlock()
-	_, ok := docLock[id]
+	_, ok := docLock[documentLockKey{instanceID, id}]
 	return ok
 }
 
-// createLock creates id specific lock (RWMutex)
-func createLock(id string) {
+// createLock creates id specific lock (RWMutex) of the instance, unless another caller created it first,
+// and returns it. The check and the creation are done under the same lock so that an id never maps to two locks.
+func createLock(instanceID, id string) *sync.RWMutex {
 	lock.Lock()
 	defer lock.Unlock()
-	docLock[id] = &sync.RWMutex{}
+	key := documentLockKey{instanceID, id}
+	if mutex, ok := docLock[key]; ok {
+		return mutex
+	}
+	mutex := &sync.RWMutex{}
+	docLock[key] = mutex
+	return mutex
 }
 
-// deleteLock deletes id specific lock
-func deleteLock(id string) {
-	lock.Lock()
-	defer lock.Unlock()
-	delete(docLock, id)
+// deleteLock deletes id specific lock of the instance unless it's held, see ReleaseDocumentLock
+func deleteLock(instanceID, id string) {
+	ReleaseDocumentLock(instanceID, id)
 }
 
 // docStateFileName returns absolute filename where command states are persisted
@@ -0,0 +1,1188 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testInstanceID = "i-400e1090"
+	testDocumentID = "13e8e6ad-e195-4ccb-86ee-328153b0dafe"
+)
+
+var testLog = log.NewMockLog()
+
+// setTestDataStore points the data store to a temporary directory and returns a function restoring it
+var origCompactStateFolders = compactStateFolders
+
+func setTestDataStore(t testing.TB) func() {
+	dir, err := ioutil.TempDir("", "docmanager")
+	assert.NoError(t, err)
+	origDataStorePath := dataStorePath
+	dataStorePath = dir
+	for _, location := range []string{
+		appconfig.DefaultLocationOfPending,
+		appconfig.DefaultLocationOfCurrent,
+		appconfig.DefaultLocationOfCompleted,
+		appconfig.DefaultLocationOfFailed,
+		appconfig.DefaultLocationOfCorrupt} {
+		assert.NoError(t, fileutil.MakeDirs(DocumentStateDir(testInstanceID, location)))
+	}
+	return func() {
+		dataStorePath = origDataStorePath
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLookupCompleted(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	// a document still running isn't completed
+	_, found := LookupCompleted(testLog, testDocumentID, testInstanceID)
+	assert.False(t, found)
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfFailed)
+	docInfo, found := LookupCompleted(testLog, testDocumentID, testInstanceID)
+	assert.True(t, found)
+	assert.Equal(t, contracts.ResultStatusFailed, docInfo.DocumentStatus)
+}
+
+func TestGetFinalResultWithTruncatedOutput(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	pluginResult := contracts.PluginResult{
+		PluginName: "aws:runShellScript",
+		Status:     contracts.ResultStatusSuccess,
+		Output:     contracts.TruncateOutput(strings.Repeat("a", contracts.MaximumPluginOutputSize*2), "", contracts.MaximumPluginOutputSize),
+	}
+	pluginResult.MarkOutputTruncation()
+	docState := model.DocumentState{
+		DocumentInformation: model.DocumentInfo{
+			DocumentID:     testDocumentID,
+			MessageID:      "aws.ssm." + testDocumentID + "." + testInstanceID,
+			DocumentName:   "AWS-RunShellScript",
+			DocumentStatus: contracts.ResultStatusSuccess,
+		},
+		InstancePluginsInformation: []model.PluginState{
+			{
+				Id:     "runShellScript",
+				Name:   "aws:runShellScript",
+				Result: pluginResult,
+			},
+		},
+	}
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+
+	result := GetFinalResult(testLog, testDocumentID, testInstanceID)
+
+	assert.Equal(t, contracts.ResultStatusSuccess, result.Status)
+	assert.Equal(t, 1, result.NPlugins)
+	if assert.NotNil(t, result.PluginResults["runShellScript"]) {
+		assert.True(t, result.PluginResults["runShellScript"].OutputTruncated)
+		assert.Equal(t, pluginResult.OutputTruncatedAt, result.PluginResults["runShellScript"].OutputTruncatedAt)
+	}
+}
+
+// makeReadOnly makes the document state folder read only, root ignores the permissions
+// so the folder is replaced with a file there, which can't be written into either
+func makeReadOnly(t *testing.T, locationFolder string) {
+	stateDir := DocumentStateDir(testInstanceID, locationFolder)
+	if os.Geteuid() != 0 {
+		assert.NoError(t, os.Chmod(stateDir, 0500))
+		return
+	}
+	assert.NoError(t, os.RemoveAll(stateDir))
+	assert.NoError(t, ioutil.WriteFile(stateDir, nil, 0400))
+}
+
+func TestPersistDataReturnsWriteError(t *testing.T) {
+	defer setTestDataStore(t)()
+	makeReadOnly(t, appconfig.DefaultLocationOfPending)
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+
+	err := PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending, docState)
+
+	assert.Error(t, err)
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending)))
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState))
+}
+
+func TestPersistDataReturnsMarshalError(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	err := PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, make(chan int))
+
+	assert.Error(t, err)
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+}
+
+func TestPersistDataCtxWithCancelledContext(t *testing.T) {
+	defer setTestDataStore(t)()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+
+	err := PersistDataCtx(ctx, testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+}
+
+func TestPersistDataCtxGivesUpWaitingForTheLock(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docMutex := lockDocument(testInstanceID, testDocumentID)
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	err := PersistDataCtx(ctx, testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+	// the lock isn't left held by the abandoned attempt once its holder releases it
+	unlockDocument(testInstanceID, testDocumentID, docMutex)
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState))
+	assert.True(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+}
+
+func TestMoveDocumentStateCtxWithCancelledContext(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := MoveDocumentStateCtx(ctx, testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	assert.Equal(t, context.Canceled, err)
+	assert.True(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)))
+}
+
+func TestMoveDocumentStateCtxReturnsTheMoveError(t *testing.T) {
+	defer setTestDataStore(t)()
+	docMutex := lockDocument(testInstanceID, testDocumentID)
+	unlockDocument(testInstanceID, testDocumentID, docMutex)
+	defer deleteLock(testInstanceID, testDocumentID)
+
+	// there is no state to move
+	err := MoveDocumentStateCtx(context.Background(), testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	assert.Error(t, err)
+	completedFileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.False(t, fileutil.Exists(completedFileName))
+	assert.False(t, fileutil.Exists(summaryFileName(completedFileName)))
+	// the document isn't over, its lock is kept
+	assert.True(t, doesLockExist(testInstanceID, testDocumentID))
+}
+
+func TestResetInterruptedPlugins(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Id: "plugin1", Name: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess}},
+		{Id: "plugin2", Name: "aws:runPatchBaseline", Result: contracts.PluginResult{Status: contracts.ResultStatusFailed}},
+		{Id: "plugin3", Name: "aws:runPowerShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusFailed}},
+	}
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	assert.NoError(t, ResetInterruptedPlugins(testLog, testDocumentID, testInstanceID, []string{"aws:runShellScript", "aws:runPatchBaseline"}))
+
+	docState = GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.Equal(t, contracts.ResultStatusInProgress, docState.DocumentInformation.DocumentStatus)
+	assert.Equal(t, contracts.ResultStatusSuccess, docState.InstancePluginsInformation[0].Result.Status)
+	assert.Equal(t, contracts.ResultStatusInProgress, docState.InstancePluginsInformation[1].Result.Status)
+	assert.Equal(t, contracts.ResultStatusFailed, docState.InstancePluginsInformation[2].Result.Status)
+
+	assert.Error(t, ResetInterruptedPlugins(testLog, "missingDocument", testInstanceID, nil))
+	deleteLock(testInstanceID, "missingDocument")
+}
+
+func TestMoveDocumentStateToTerminalFolder(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	testCases := []struct {
+		documentID              string
+		status                  contracts.ResultStatus
+		separateFailedDocuments bool
+		expectedFolder          string
+	}{
+		{"successDocument", contracts.ResultStatusSuccess, true, appconfig.DefaultLocationOfCompleted},
+		{"failedDocument", contracts.ResultStatusFailed, true, appconfig.DefaultLocationOfFailed},
+		{"timedOutDocument", contracts.ResultStatusTimedOut, true, appconfig.DefaultLocationOfFailed},
+		{"failedSharedDocument", contracts.ResultStatusFailed, false, appconfig.DefaultLocationOfCompleted},
+	}
+	for _, tc := range testCases {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentID = tc.documentID
+		docState.DocumentInformation.DocumentStatus = tc.status
+		PersistData(testLog, tc.documentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+		terminalFolder := TerminalLocationFolder(tc.status, tc.separateFailedDocuments)
+		assert.Equal(t, tc.expectedFolder, terminalFolder)
+		MoveDocumentState(testLog, tc.documentID, testInstanceID, appconfig.DefaultLocationOfCurrent, terminalFolder)
+
+		assert.True(t, fileutil.Exists(docStateFileName(tc.documentID, testInstanceID, tc.expectedFolder)))
+		assert.Equal(t, tc.expectedFolder, FindTerminalLocationFolder(tc.documentID, testInstanceID))
+		assert.True(t, IsDocumentCompleted(tc.documentID, testInstanceID))
+		assert.Equal(t, tc.status, GetFinalResult(testLog, tc.documentID, testInstanceID).Status)
+	}
+}
+
+func TestDeleteOldDocumentFolderLogsHonorsMaxDeletions(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return strings.HasPrefix(fileName, "document") }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	oldTime := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 5; i++ {
+		documentID := fmt.Sprintf("document%v", i)
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+		assert.NoError(t, fileutil.MakeDirs(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), documentID)))
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), oldTime, oldTime))
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, 2, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	deletedStates, deletedDirs := 0, 0
+	for i := 0; i < 5; i++ {
+		documentID := fmt.Sprintf("document%v", i)
+		if !fileutil.Exists(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)) {
+			deletedStates++
+		}
+		if !fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), documentID)) {
+			deletedDirs++
+		}
+	}
+	assert.Equal(t, 2, deletedStates)
+	assert.Equal(t, 2, deletedDirs)
+}
+
+func TestDeleteOldDocumentFolderLogsDeletesTheLocks(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return strings.HasPrefix(fileName, "lockedDocument") }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	oldTime := time.Now().Add(-48 * time.Hour)
+	for i := 0; i < 3; i++ {
+		documentID := fmt.Sprintf("lockedDocument%v", i)
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), oldTime, oldTime))
+		assert.True(t, doesLockExist(testInstanceID, documentID))
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, 100, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	for i := 0; i < 3; i++ {
+		documentID := fmt.Sprintf("lockedDocument%v", i)
+		assert.False(t, fileutil.Exists(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)))
+		assert.False(t, doesLockExist(testInstanceID, documentID))
+	}
+}
+
+func TestRetainMostRecentCompletedDeletesTheLocks(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	for i := 0; i < 3; i++ {
+		documentID := fmt.Sprintf("retainedDocument%v", i)
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+		modTime := time.Now().Add(time.Duration(i-3) * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+
+	RetainMostRecentCompleted(testLog, testInstanceID, 1)
+
+	for i := 0; i < 2; i++ {
+		assert.False(t, doesLockExist(testInstanceID, fmt.Sprintf("retainedDocument%v", i)))
+	}
+	assert.True(t, fileutil.Exists(docStateFileName("retainedDocument2", testInstanceID, appconfig.DefaultLocationOfCompleted)))
+}
+
+func TestPlanCleanupMatchesDeletion(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return strings.HasPrefix(fileName, "document") }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	oldTime := time.Now().Add(-48 * time.Hour)
+
+	var candidates []string
+	documents := []struct {
+		documentID     string
+		locationFolder string
+		old            bool
+	}{
+		{"documentOldCompleted", appconfig.DefaultLocationOfCompleted, true},
+		{"documentOldFailed", appconfig.DefaultLocationOfFailed, true},
+		{"documentRecent", appconfig.DefaultLocationOfCompleted, false},
+		{"unexpectedName", appconfig.DefaultLocationOfCompleted, true},
+	}
+	for _, doc := range documents {
+		PersistData(testLog, doc.documentID, testInstanceID, doc.locationFolder, model.DocumentState{})
+		documentDir := filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), doc.documentID)
+		assert.NoError(t, fileutil.MakeDirs(filepath.Join(documentDir, "plugin")))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(documentDir, "plugin", "stdout"), []byte("output"), 0600))
+		stateFile := docStateFileName(doc.documentID, testInstanceID, doc.locationFolder)
+		if doc.old {
+			assert.NoError(t, os.Chtimes(stateFile, oldTime, oldTime))
+		}
+		candidates = append(candidates, documentDir, stateFile)
+	}
+
+	planned := PlanCleanup(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+	assert.Len(t, planned, 4)
+
+	// the dry run leaves everything in place
+	for _, candidate := range candidates {
+		assert.True(t, fileutil.Exists(candidate), candidate)
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	var deleted []string
+	for _, candidate := range candidates {
+		if !fileutil.Exists(candidate) {
+			deleted = append(deleted, candidate)
+		}
+	}
+	sort.Strings(deleted)
+	sort.Strings(planned)
+	assert.Equal(t, deleted, planned)
+}
+
+func TestEstimateCleanupMatchesDeletion(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return strings.HasPrefix(fileName, "document") }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	oldTime := time.Now().Add(-48 * time.Hour)
+
+	documents := []struct {
+		documentID     string
+		locationFolder string
+		old            bool
+	}{
+		{"documentOldCompleted", appconfig.DefaultLocationOfCompleted, true},
+		{"documentOldFailed", appconfig.DefaultLocationOfFailed, true},
+		{"documentRecent", appconfig.DefaultLocationOfCompleted, false},
+		{"unexpectedName", appconfig.DefaultLocationOfCompleted, true},
+	}
+	for _, doc := range documents {
+		PersistData(testLog, doc.documentID, testInstanceID, doc.locationFolder, model.DocumentState{})
+		pluginDir := filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), doc.documentID, "plugin")
+		assert.NoError(t, fileutil.MakeDirs(pluginDir))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, "stdout"), []byte(strings.Repeat("a", 100)), 0600))
+		if doc.old {
+			assert.NoError(t, os.Chtimes(docStateFileName(doc.documentID, testInstanceID, doc.locationFolder), oldTime, oldTime))
+		}
+	}
+	sizeBefore, err := fileutil.GetPathSize(dataStorePath)
+	assert.NoError(t, err)
+
+	estimate := EstimateCleanup(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+	assert.Equal(t, 2, estimate.Documents)
+
+	// the estimate leaves everything in place
+	sizeAfterEstimate, err := fileutil.GetPathSize(dataStorePath)
+	assert.NoError(t, err)
+	assert.Equal(t, sizeBefore, sizeAfterEstimate)
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	sizeAfterCleanup, err := fileutil.GetPathSize(dataStorePath)
+	assert.NoError(t, err)
+	assert.Equal(t, estimate.Bytes, sizeBefore-sizeAfterCleanup)
+	deleted := 0
+	for _, doc := range documents {
+		if !fileutil.Exists(docStateFileName(doc.documentID, testInstanceID, doc.locationFolder)) {
+			deleted++
+		}
+	}
+	assert.Equal(t, estimate.Documents, deleted)
+}
+
+func TestDeleteOldDocumentFolderLogsWithRetentionOverrides(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return strings.HasPrefix(fileName, "document") }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	retentionOverrides := []appconfig.RetentionOverride{
+		{DocumentNamePattern: "Compliance-*", RetentionDurationHours: 72},
+		{DocumentNamePattern: "[", RetentionDurationHours: 72},
+	}
+
+	documents := []struct {
+		documentID   string
+		documentName string
+		ageHours     time.Duration
+		kept         bool
+	}{
+		{"documentRecent", "AWS-RunShellScript", 1, true},
+		{"documentOld", "AWS-RunShellScript", 48, false},
+		{"documentOldCompliance", "Compliance-Scan", 48, true},
+		{"documentExpiredCompliance", "Compliance-Scan", 96, false},
+		{"unexpectedNameCompliance", "Compliance-Scan", 96, true},
+	}
+	for _, doc := range documents {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentName = doc.documentName
+		PersistData(testLog, doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+		assert.NoError(t, fileutil.MakeDirs(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), doc.documentID)))
+		modTime := time.Now().Add(-doc.ageHours * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+
+	estimate := EstimateCleanup(testLog, testInstanceID, orchestrationRootDirName, 24, retentionOverrides, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+	assert.Equal(t, 2, estimate.Documents)
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, retentionOverrides, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	for _, doc := range documents {
+		assert.Equal(t, doc.kept, fileutil.Exists(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)), doc.documentID)
+		assert.Equal(t, doc.kept, fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), doc.documentID)), doc.documentID)
+	}
+}
+
+func TestDeleteOldDocumentFolderLogsUsesRecordedOrchestrationDir(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	orchestrationRootDir := orchestrationDir(testInstanceID, orchestrationRootDirName)
+	isIntendedFileNameFormat := func(fileName string) bool { return true }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+
+	documents := []struct {
+		documentID  string
+		recordedDir string
+		deletedDir  string
+		keptDir     string
+	}{
+		// the dir the document ran in is deleted, not the dir derived from its name
+		{"documentRecorded", filepath.Join(orchestrationRootDir, "runs", "documentRecorded"), filepath.Join(orchestrationRootDir, "runs", "documentRecorded"), filepath.Join(orchestrationRootDir, "documentRecorded")},
+		// documents persisted before the dir was recorded
+		{"documentLegacy", "", filepath.Join(orchestrationRootDir, "documentLegacy"), ""},
+		// a recorded dir outside of the orchestration root is left alone
+		{"documentOutside", filepath.Join(dataStorePath, "elsewhere"), filepath.Join(orchestrationRootDir, "documentOutside"), filepath.Join(dataStorePath, "elsewhere")},
+	}
+	for _, doc := range documents {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentID = doc.documentID
+		docState.DocumentInformation.OrchestrationDirectory = doc.recordedDir
+		PersistData(testLog, doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+		for _, dir := range []string{doc.deletedDir, doc.keptDir} {
+			if dir != "" {
+				assert.NoError(t, fileutil.MakeDirs(dir))
+			}
+		}
+		modTime := time.Now().Add(-48 * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	for _, doc := range documents {
+		assert.False(t, fileutil.Exists(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)), doc.documentID)
+		assert.False(t, fileutil.Exists(doc.deletedDir), doc.documentID)
+		if doc.keptDir != "" {
+			assert.True(t, fileutil.Exists(doc.keptDir), doc.documentID)
+		}
+	}
+}
+
+func TestReadDocumentsDuringCleanup(t *testing.T) {
+	defer setTestDataStore(t)()
+	StateSigningKey = func() ([]byte, error) { return []byte("test signing key"), nil }
+	defer func() { StateSigningKey = nil }()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return true }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	var documentIDs []string
+	for i := 0; i < 20; i++ {
+		documentID := fmt.Sprintf("document%v", i)
+		documentIDs = append(documentIDs, documentID)
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentID = documentID
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+		assert.NoError(t, fileutil.MakeDirs(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), documentID, "aws:runScript")))
+		modTime := time.Now().Add(-48 * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+		// the locks of the documents exist before the readers and the cleanup race on them
+		GetDocumentInterimState(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	}
+
+	stop := make(chan bool)
+	var readers sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				for _, documentID := range documentIDs {
+					docMutex := rLockDocument(testInstanceID, documentID)
+					docState, err := readDocState(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+					orchestrationExists := fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), documentID))
+					rUnlockDocument(docMutex)
+					if err != nil {
+						// the document is either fully there or cleanly gone
+						assert.True(t, os.IsNotExist(err), "%v: %v", documentID, err)
+						continue
+					}
+					assert.Equal(t, documentID, docState.DocumentInformation.DocumentID)
+					assert.True(t, orchestrationExists, documentID)
+				}
+			}
+		}()
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+	close(stop)
+	readers.Wait()
+
+	for _, documentID := range documentIDs {
+		assert.False(t, fileutil.Exists(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)), documentID)
+	}
+}
+
+func TestForceCompleteDocument(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	err := ForceCompleteDocument(testLog, testDocumentID, testInstanceID, contracts.ResultStatusFailed, "worker died", appconfig.DefaultLocationOfCompleted)
+	assert.NoError(t, err)
+
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+	docInfo := GetDocumentInfo(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, contracts.ResultStatusFailed, docInfo.DocumentStatus)
+	assert.Equal(t, "worker died", docInfo.DocumentTraceOutput)
+	assert.Equal(t, "worker died", GetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+
+	// the document is no longer in progress
+	err = ForceCompleteDocument(testLog, testDocumentID, testInstanceID, contracts.ResultStatusFailed, "worker died", appconfig.DefaultLocationOfCompleted)
+	assert.Error(t, err)
+}
+
+func TestForceCompleteDocumentHoldsTheLockUntilMoved(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	// a writer holds the document, the force completion waits for it to release the document before reading it
+	docMutex := lockDocument(testInstanceID, testDocumentID)
+	done := make(chan error)
+	go func() {
+		done <- ForceCompleteDocument(testLog, testDocumentID, testInstanceID, contracts.ResultStatusFailed, "worker died", appconfig.DefaultLocationOfCompleted)
+	}()
+	select {
+	case <-done:
+		assert.Fail(t, "the document was force completed while locked")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.True(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+	docState.DocumentInformation.DocumentName = "updated"
+	setDocState(testLog, docState, docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent), appconfig.DefaultLocationOfCurrent)
+	unlockDocument(testInstanceID, testDocumentID, docMutex)
+
+	assert.NoError(t, <-done)
+	assert.False(t, doesLockExist(testInstanceID, testDocumentID))
+	docInfo := GetDocumentInfo(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	// the update of the writer is kept
+	assert.Equal(t, "updated", docInfo.DocumentName)
+	assert.Equal(t, contracts.ResultStatusFailed, docInfo.DocumentStatus)
+}
+
+func TestDocumentLastError(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	// the document isn't persisted yet
+	SetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, "step failed")
+	assert.Empty(t, GetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	assert.Empty(t, GetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+
+	SetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, "step failed")
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, "step failed", GetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+
+	// the last error is a summary
+	SetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, strings.Repeat("a", 2*maxLastErrorLength))
+	assert.Len(t, GetDocumentLastError(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted), maxLastErrorLength)
+}
+
+func TestSummarizePluginFailure(t *testing.T) {
+	testCases := []struct {
+		pluginResults map[string]*contracts.PluginResult
+		expected      string
+	}{
+		{
+			map[string]*contracts.PluginResult{
+				"plugin1": {Status: contracts.ResultStatusSuccess},
+				"plugin2": {Status: contracts.ResultStatusSkipped},
+			},
+			"",
+		},
+		{
+			map[string]*contracts.PluginResult{
+				"plugin1": {Status: contracts.ResultStatusSuccess},
+				"plugin3": {Status: contracts.ResultStatusFailed, StandardError: "access denied"},
+				"plugin2": {Status: contracts.ResultStatusFailed, Error: fmt.Errorf("exit status 1"), StandardError: "not found\n"},
+			},
+			"step plugin2 Failed: exit status 1",
+		},
+		{
+			map[string]*contracts.PluginResult{
+				"plugin1": {Status: contracts.ResultStatusFailed, StandardError: "not found\n"},
+			},
+			"step plugin1 Failed: not found",
+		},
+		{
+			map[string]*contracts.PluginResult{
+				"plugin1": {Status: contracts.ResultStatusTimedOut},
+			},
+			"step plugin1 TimedOut",
+		},
+	}
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, SummarizePluginFailure(testCase.pluginResults))
+	}
+}
+
+func TestDocumentLocksAreIsolatedPerInstance(t *testing.T) {
+	otherInstanceID := "i-500e1090"
+	defer deleteLock(testInstanceID, testDocumentID)
+	defer deleteLock(otherInstanceID, testDocumentID)
+
+	docMutex := lockDocument(testInstanceID, testDocumentID)
+	assert.True(t, doesLockExist(testInstanceID, testDocumentID))
+	assert.False(t, doesLockExist(otherInstanceID, testDocumentID))
+
+	// the same file name under another instance can be locked while the first instance holds its lock
+	locked := make(chan bool)
+	go func() {
+		docMutex := lockDocument(otherInstanceID, testDocumentID)
+		unlockDocument(otherInstanceID, testDocumentID, docMutex)
+		locked <- true
+	}()
+	select {
+	case <-locked:
+	case <-time.After(time.Second):
+		assert.Fail(t, "the lock of the other instance is blocked by the first instance")
+	}
+
+	deleteLock(otherInstanceID, testDocumentID)
+	assert.True(t, doesLockExist(testInstanceID, testDocumentID))
+	unlockDocument(testInstanceID, testDocumentID, docMutex)
+}
+
+func TestConcurrentLockingCreatesOneLockPerDocument(t *testing.T) {
+	documentID := "concurrentlyLocked"
+	defer deleteLock(testInstanceID, documentID)
+	const goroutines = 100
+	start := make(chan struct{})
+	mutexes := make(chan *sync.RWMutex, goroutines)
+	counter := 0
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			mutexes <- documentLock(testInstanceID, documentID)
+			docMutex := lockDocument(testInstanceID, documentID)
+			// a read-modify-write only the document lock keeps from losing updates
+			current := counter
+			time.Sleep(time.Microsecond)
+			counter = current + 1
+			unlockDocument(testInstanceID, documentID, docMutex)
+		}()
+	}
+	close(start)
+	wg.Wait()
+	close(mutexes)
+
+	assert.Equal(t, goroutines, counter)
+	first := <-mutexes
+	for mutex := range mutexes {
+		assert.True(t, first == mutex, "the document maps to more than one lock")
+	}
+}
+
+func TestRetainMostRecentCompleted(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRoot := orchestrationDir(testInstanceID, "awsrunCommand")
+	var documentIDs []string
+	for i := 0; i < 5; i++ {
+		documentID := fmt.Sprintf("document%v", i)
+		documentIDs = append(documentIDs, documentID)
+
+		pluginDir := filepath.Join(orchestrationRoot, documentID, "aws:runScript")
+		assert.NoError(t, fileutil.MakeDirs(pluginDir))
+		docState := model.DocumentState{}
+		docState.InstancePluginsInformation = []model.PluginState{{}}
+		docState.InstancePluginsInformation[0].Configuration.OrchestrationDirectory = pluginDir
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+
+		// document0 is the oldest, document4 the newest
+		modTime := time.Now().Add(time.Duration(i-5) * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+
+	RetainMostRecentCompleted(testLog, testInstanceID, 2)
+
+	for i, documentID := range documentIDs {
+		kept := i >= 3
+		assert.Equal(t, kept, fileutil.Exists(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)), documentID)
+		assert.Equal(t, kept, fileutil.Exists(filepath.Join(orchestrationRoot, documentID)), documentID)
+	}
+	assert.True(t, fileutil.Exists(orchestrationRoot))
+}
+
+func TestInvalidDataStorePathIsRefused(t *testing.T) {
+	workingDir, err := os.Getwd()
+	assert.NoError(t, err)
+	defer func() { dataStorePath = appconfig.DefaultDataStorePath }()
+
+	for _, storePath := range []string{"", "relative/store"} {
+		dataStorePath = storePath
+		assert.Error(t, ValidateDataStorePath(), storePath)
+
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentID = testDocumentID
+		PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+		PersistDocumentInfo(testLog, docState.DocumentInformation, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+		MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+		assert.Empty(t, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent).DocumentInformation.DocumentID)
+		assert.Nil(t, GetPluginState(testLog, "plugin", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+		assert.Error(t, ForceCompleteDocument(testLog, testDocumentID, testInstanceID, contracts.ResultStatusFailed, "", appconfig.DefaultLocationOfCompleted))
+		claimed, err := ClaimDocument(testLog, testDocumentID, testInstanceID)
+		assert.Error(t, err)
+		assert.False(t, claimed)
+
+		// nothing is written relative to the working directory
+		assert.False(t, fileutil.Exists(filepath.Join(workingDir, storePath, testInstanceID)), storePath)
+	}
+
+	dataStorePath = t.TempDir()
+	assert.NoError(t, ValidateDataStorePath())
+}
+
+func TestDeleteOldDocumentFolderLogsWithDocumentRetentionHints(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return true }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+
+	documents := []struct {
+		documentID     string
+		retentionHours int
+		ageHours       time.Duration
+		kept           bool
+	}{
+		{"documentShortHint", 12, 18, false},
+		{"documentLongHint", 72, 48, true},
+		{"documentExpiredLongHint", 72, 96, false},
+		{"documentWithoutHint", 0, 48, false},
+		{"documentRecentWithoutHint", 0, 18, true},
+	}
+	for _, doc := range documents {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.OrchestrationRetentionHours = doc.retentionHours
+		PersistData(testLog, doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+		assert.NoError(t, fileutil.MakeDirs(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), doc.documentID)))
+		modTime := time.Now().Add(-doc.ageHours * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	for _, doc := range documents {
+		assert.Equal(t, doc.kept, fileutil.Exists(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)), doc.documentID)
+		assert.Equal(t, doc.kept, fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), doc.documentID)), doc.documentID)
+	}
+}
+
+func TestWalkOldTerminalDocumentsDoesNotReadRecentDocuments(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestDocStateCacheSize(0)()
+	countingStore := &countingDocumentStore{gets: make(map[string]int)}
+	defer setTestDocumentStore(countingStore)()
+
+	documents := []struct {
+		documentID string
+		age        time.Duration
+		read       bool
+	}{
+		{"documentRecent", 10 * time.Minute, false},
+		{"documentWithinRetention", 2 * time.Hour, true},
+		{"documentOld", 48 * time.Hour, true},
+	}
+	for _, doc := range documents {
+		PersistData(testLog, doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+		modTime := time.Now().Add(-doc.age)
+		assert.NoError(t, os.Chtimes(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+	// only the reads of the walk are counted
+	countingStore.gets = make(map[string]int)
+
+	var walked []string
+	examined := walkOldTerminalDocuments(testLog, testInstanceID, orchestrationDir(testInstanceID, "awsrunCommand"), 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun,
+		func(string) bool { return true }, func(fileName string) string { return fileName }, 1,
+		func(completedFile, completedLogFullPath, orchestrationDirFullPath string) bool {
+			walked = append(walked, completedFile)
+			return true
+		})
+
+	assert.Equal(t, len(documents), examined)
+	assert.Equal(t, []string{"documentOld"}, walked)
+	for _, doc := range documents {
+		// a document may ask for a retention shorter than the agent wide one, only the documents younger than an hour are skipped unread
+		assert.Equal(t, doc.read, countingStore.reads(docStateFileName(doc.documentID, testInstanceID, appconfig.DefaultLocationOfCompleted)) > 0, doc.documentID)
+	}
+}
+
+func TestEnsureStateFolders(t *testing.T) {
+	defer setTestDataStore(t)()
+	otherInstanceID := "i-500e1090"
+
+	// every folder is missing for a new instance
+	assert.NoError(t, EnsureStateFolders(testLog, otherInstanceID))
+	for _, locationFolder := range stateFolders {
+		assert.True(t, fileutil.Exists(DocumentStateDir(otherInstanceID, locationFolder)), locationFolder)
+		files, err := ioutil.ReadDir(DocumentStateDir(otherInstanceID, locationFolder))
+		assert.NoError(t, err)
+		assert.Empty(t, files, "the writability probe is left behind in %v", locationFolder)
+	}
+
+	// folders that can't be created are all reported
+	assert.NoError(t, os.RemoveAll(filepath.Join(dataStorePath, testInstanceID)))
+	assert.NoError(t, fileutil.MakeDirs(DocumentStateDir(testInstanceID, "")))
+	blocked := []string{appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted}
+	for _, locationFolder := range blocked {
+		assert.NoError(t, ioutil.WriteFile(DocumentStateDir(testInstanceID, locationFolder), []byte{}, 0600))
+	}
+	err := EnsureStateFolders(testLog, testInstanceID)
+	if assert.Error(t, err) {
+		for _, locationFolder := range blocked {
+			assert.Contains(t, err.Error(), DocumentStateDir(testInstanceID, locationFolder))
+		}
+	}
+	assert.True(t, fileutil.Exists(DocumentStateDir(testInstanceID, appconfig.DefaultLocationOfPending)))
+	assert.True(t, fileutil.Exists(DocumentStateDir(testInstanceID, appconfig.DefaultLocationOfFailed)))
+}
+
+func TestPersistDataFormatPerFolder(t *testing.T) {
+	defer setTestDataStore(t)()
+	compactStateFolders = func() []string {
+		return []string{appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCurrent}
+	}
+	defer func() { compactStateFolders = origCompactStateFolders }()
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	docState.InstancePluginsInformation = []model.PluginState{{Id: "aws:runScript", Name: "aws:runScript"}}
+	readFile := func(locationFolder string) string {
+		content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, locationFolder))
+		assert.NoError(t, err)
+		return string(content)
+	}
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending, docState)
+	assert.NotContains(t, readFile(appconfig.DefaultLocationOfPending), "\n")
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending))
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCurrent)
+	assert.NotContains(t, readFile(appconfig.DefaultLocationOfCurrent), "\n")
+
+	// the states moving to a pretty folder are indented
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+	assert.Contains(t, readFile(appconfig.DefaultLocationOfCompleted), "\n  ")
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+
+	docInfo := GetDocumentInfo(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	docInfo.DocumentStatus = contracts.ResultStatusSuccess
+	PersistDocumentInfo(testLog, docInfo, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Contains(t, readFile(appconfig.DefaultLocationOfCompleted), "\n  ")
+}
+
+func TestGetDocumentInterimStateReadsEitherFormat(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func() { compactStateFolders = origCompactStateFolders }()
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+
+	// the folder may hold states of both formats, e.g. after the configuration changed
+	for _, compact := range [][]string{{appconfig.DefaultLocationOfCompleted}, nil} {
+		compactStateFolders = func() []string { return compact }
+		PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+		assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+		assert.Equal(t, docState.DocumentInformation, GetDocumentInfo(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+	}
+}
+
+func TestReconcileDocumentStates(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	persistWithStatus := func(documentID, locationFolder string, status contracts.ResultStatus) {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentID = documentID
+		docState.DocumentInformation.DocumentStatus = status
+		PersistData(testLog, documentID, testInstanceID, locationFolder, docState)
+	}
+	locationsOf := func(documentID string) (locations []string) {
+		for _, locationFolder := range reconciledFolders {
+			if fileutil.Exists(docStateFileName(documentID, testInstanceID, locationFolder)) {
+				locations = append(locations, locationFolder)
+			}
+			assert.False(t, fileutil.Exists(docStateFileName(documentID, testInstanceID, locationFolder)+moveIntermediateSuffix))
+		}
+		return
+	}
+
+	// the move to completed went through but the pending copy wasn't removed
+	persistWithStatus("completed", appconfig.DefaultLocationOfPending, contracts.ResultStatusSuccess)
+	persistWithStatus("completed", appconfig.DefaultLocationOfCompleted, contracts.ResultStatusSuccess)
+	// the completed copy doesn't record a terminal status, the document is still running
+	persistWithStatus("running", appconfig.DefaultLocationOfCurrent, contracts.ResultStatusInProgress)
+	persistWithStatus("running", appconfig.DefaultLocationOfCompleted, contracts.ResultStatusInProgress)
+	// the rewrite of a moved document stopped before its rename
+	persistWithStatus("rewritten", appconfig.DefaultLocationOfCurrent, contracts.ResultStatusFailed)
+	assert.NoError(t, os.Rename(
+		docStateFileName("rewritten", testInstanceID, appconfig.DefaultLocationOfCurrent),
+		docStateFileName("rewritten", testInstanceID, appconfig.DefaultLocationOfFailed)+moveIntermediateSuffix))
+	persistWithStatus("rewritten", appconfig.DefaultLocationOfCurrent, contracts.ResultStatusInProgress)
+	// the rewrite of a moved document was cut half way
+	persistWithStatus("truncated", appconfig.DefaultLocationOfCompleted, contracts.ResultStatusSuccess)
+	assert.NoError(t, ioutil.WriteFile(docStateFileName("truncated", testInstanceID, appconfig.DefaultLocationOfCompleted)+moveIntermediateSuffix, []byte(`{"DocumentInformation":`), 0600))
+
+	ReconcileDocumentStates(testLog, testInstanceID)
+
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCompleted}, locationsOf("completed"))
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCurrent}, locationsOf("running"))
+	assert.Equal(t, []string{appconfig.DefaultLocationOfFailed}, locationsOf("rewritten"))
+	assert.Equal(t, contracts.ResultStatusFailed, GetDocumentInfo(testLog, "rewritten", testInstanceID, appconfig.DefaultLocationOfFailed).DocumentStatus)
+	assert.Equal(t, []string{appconfig.DefaultLocationOfCompleted}, locationsOf("truncated"))
+	assert.Equal(t, contracts.ResultStatusSuccess, GetDocumentInfo(testLog, "truncated", testInstanceID, appconfig.DefaultLocationOfCompleted).DocumentStatus)
+}
+
+func TestDocumentNames(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	for documentID, documentName := range map[string]string{"doc1": "AWS-RunShellScript", "doc2": "AWS-RunPowerShellScript"} {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentID = documentID
+		docState.DocumentInformation.DocumentName = documentName
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfPending, docState)
+	}
+
+	assert.Equal(t, map[string]string{"doc1": "AWS-RunShellScript", "doc2": "AWS-RunPowerShellScript"},
+		DocumentNames(testLog, testInstanceID, appconfig.DefaultLocationOfPending))
+	assert.Empty(t, DocumentNames(testLog, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestListDocuments(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	seeds := []struct {
+		documentID   string
+		documentType model.DocumentType
+		status       contracts.ResultStatus
+		createdDate  string
+	}{
+		{"sendCommandRecent", model.SendCommand, contracts.ResultStatusInProgress, "2017-06-10T01:23:07.853Z"},
+		{"association", model.Association, contracts.ResultStatusInProgress, "2017-06-09T01:23:07.853Z"},
+		{"sendCommandOld", model.SendCommand, contracts.ResultStatusInProgress, "2017-06-08T01:23:07.853Z"},
+		{"sendCommandPending", model.SendCommand, contracts.ResultStatusNotStarted, "2017-06-07T01:23:07.853Z"},
+	}
+	for _, seed := range seeds {
+		docState := model.DocumentState{DocumentType: seed.documentType}
+		docState.DocumentInformation.DocumentID = seed.documentID
+		docState.DocumentInformation.DocumentStatus = seed.status
+		docState.DocumentInformation.CreatedDate = seed.createdDate
+		PersistData(testLog, seed.documentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	}
+	documentIDs := func(filter DocumentFilter) (documentIDs []string) {
+		docInfos, err := ListDocuments(testLog, testInstanceID, appconfig.DefaultLocationOfCurrent, filter)
+		assert.NoError(t, err)
+		for _, docInfo := range docInfos {
+			documentIDs = append(documentIDs, docInfo.DocumentID)
+		}
+		return
+	}
+
+	assert.Equal(t, []string{"sendCommandPending", "sendCommandOld", "association", "sendCommandRecent"}, documentIDs(DocumentFilter{}))
+	assert.Equal(t, []string{"sendCommandOld", "sendCommandRecent"},
+		documentIDs(DocumentFilter{DocumentType: model.SendCommand, Status: contracts.ResultStatusInProgress}))
+	assert.Equal(t, []string{"association"}, documentIDs(DocumentFilter{DocumentType: model.Association}))
+	assert.Empty(t, documentIDs(DocumentFilter{Status: contracts.ResultStatusFailed}))
+
+	docInfos, err := ListDocuments(testLog, testInstanceID, appconfig.DefaultLocationOfPending, DocumentFilter{})
+	assert.NoError(t, err)
+	assert.Empty(t, docInfos)
+}
+
+func TestPersistAndCleanupReportMetrics(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func(previous metrics.Sink) { metrics.DefaultSink = previous }(metrics.DefaultSink)
+	sink := metrics.NewPrometheusSink()
+	metrics.DefaultSink = sink
+
+	orchestrationRootDirName := "awsrunCommand"
+	for _, documentID := range []string{"documentRecent", "documentOld"} {
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+	}
+	// a channel can't be marshalled
+	PersistData(testLog, "documentInvalid", testInstanceID, appconfig.DefaultLocationOfCompleted, make(chan int))
+	modTime := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, os.Chtimes(docStateFileName("documentOld", testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun,
+		func(fileName string) bool { return true },
+		func(fileName string) string { return fileName })
+
+	lines := strings.Split(string(sink.Render()), "\n")
+	assert.Contains(t, lines, metrics.DocumentPersistSuccess+" 2")
+	assert.Contains(t, lines, metrics.DocumentPersistFailure+" 1")
+	assert.Contains(t, lines, metrics.CleanupDeletedDocuments+" 1")
+}
+
+func TestDeleteOldDocumentFolderLogsReportsCompletion(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func(previous metrics.Sink) { metrics.DefaultSink = previous }(metrics.DefaultSink)
+	sink := metrics.NewPrometheusSink()
+	metrics.DefaultSink = sink
+	var summaries []CleanupSummary
+	defer func() { OnCleanupCompleted = nil }()
+	OnCleanupCompleted = func(summary CleanupSummary) { summaries = append(summaries, summary) }
+
+	orchestrationRootDirName := "awsrunCommand"
+	modTime := time.Now().Add(-48 * time.Hour)
+	var bytesFreed int64
+	for _, documentID := range []string{"documentOld1", "documentOld2", "documentRecent"} {
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+		documentDir := filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), documentID)
+		assert.NoError(t, fileutil.MakeDirs(documentDir))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(documentDir, "stdout"), []byte(strings.Repeat("x", 100)), 0600))
+		if documentID == "documentRecent" {
+			continue
+		}
+		for _, path := range []string{docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), documentDir} {
+			size, err := fileutil.GetPathSize(path)
+			assert.NoError(t, err)
+			bytesFreed += size
+		}
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun,
+		func(fileName string) bool { return true },
+		func(fileName string) string { return fileName })
+
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, testInstanceID, summaries[0].InstanceID)
+		assert.Equal(t, 3, summaries[0].Examined)
+		assert.Equal(t, 2, summaries[0].Deleted)
+		assert.Equal(t, 1, summaries[0].Skipped)
+		assert.Equal(t, bytesFreed, summaries[0].BytesFreed)
+		assert.True(t, summaries[0].Duration > 0)
+	}
+	lines := strings.Split(string(sink.Render()), "\n")
+	assert.Contains(t, lines, metrics.CleanupSweeps+" 1")
+	assert.Contains(t, lines, metrics.CleanupExaminedDocuments+" 3")
+	assert.Contains(t, lines, metrics.CleanupSkippedDocuments+" 1")
+	assert.Contains(t, lines, fmt.Sprintf("%v %v", metrics.CleanupFreedBytes, bytesFreed))
+}
+
+func TestAmendPluginResult(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, StandardOutput: "uploading"}},
+		{Id: "plugin2", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess}},
+	}
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+
+	amended := contracts.PluginResult{Status: contracts.ResultStatusSuccess, StandardOutput: "uploaded"}
+	err := AmendPluginResult(testLog, testDocumentID, testInstanceID, "plugin1", amended)
+
+	assert.NoError(t, err)
+	amendedState := GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, contracts.ResultStatusSuccess, amendedState.DocumentInformation.DocumentStatus)
+	assert.Equal(t, "uploaded", amendedState.InstancePluginsInformation[0].Result.StandardOutput)
+	assert.Equal(t, docState.InstancePluginsInformation[1], amendedState.InstancePluginsInformation[1])
+	if assert.Len(t, amendedState.DocumentInformation.Amendments, 1) {
+		assert.Equal(t, "plugin1", amendedState.DocumentInformation.Amendments[0].PluginID)
+		assert.NotEmpty(t, amendedState.DocumentInformation.Amendments[0].AmendedDate)
+	}
+
+	// unknown plugins and documents that haven't completed can't be amended
+	assert.Error(t, AmendPluginResult(testLog, testDocumentID, testInstanceID, "plugin3", amended))
+	PersistData(testLog, "documentRunning", testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	assert.Error(t, AmendPluginResult(testLog, "documentRunning", testInstanceID, "plugin1", amended))
+}
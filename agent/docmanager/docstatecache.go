@@ -0,0 +1,193 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"container/list"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+)
+
+// docStateCacheSize returns the number of document states kept in memory, 0 disables the cache
+var docStateCacheSize = func() int {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return 0
+	}
+	return config.Ssm.DocumentStateCacheSize
+}
+
+// docStateCacheKey identifies the content of a document state file, a state whose file changed since it was cached is read again
+type docStateCacheKey struct {
+	fileName string
+	modTime  time.Time
+	size     int64
+}
+
+// docStateCacheEntry is a document state read from the file of the key
+type docStateCacheEntry struct {
+	key      docStateCacheKey
+	docState model.DocumentState
+}
+
+// docStateLRU keeps the document states most recently read, evicting the least recently used ones above its size.
+// The states are copied in and out so that the callers modifying the state they read never modify the cached one.
+type docStateLRU struct {
+	entries map[string]*list.Element
+	// order holds the entries, the most recently used first
+	order *list.List
+	m     sync.Mutex
+}
+
+var docStateCache = docStateLRU{entries: make(map[string]*list.Element), order: list.New()}
+
+// docStateCacheKeyOf returns the key of the document state as the store reports it, false if the store can't stat the state
+func docStateCacheKeyOf(fileName string) (docStateCacheKey, bool) {
+	info, err := statDocState(fileName)
+	if err != nil {
+		return docStateCacheKey{}, false
+	}
+	return docStateCacheKey{fileName: fileName, modTime: info.ModTime, size: info.Size}, true
+}
+
+// docStateCacheCapacity returns the number of document states the cache keeps, 0 if it's disabled. The cache is bypassed
+// while the states are signed or checksummed, so that every read of a state verifies it.
+func docStateCacheCapacity() int {
+	if StateSigningKey != nil || stateChecksumsEnabled() {
+		return 0
+	}
+	return docStateCacheSize()
+}
+
+// get returns a copy of the state cached for the key, false if none is or it was cached for another version of the file
+func (c *docStateLRU) get(key docStateCacheKey) (model.DocumentState, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	element, found := c.entries[key.fileName]
+	if !found {
+		return model.DocumentState{}, false
+	}
+	entry := element.Value.(*docStateCacheEntry)
+	if entry.key != key {
+		c.order.Remove(element)
+		delete(c.entries, key.fileName)
+		return model.DocumentState{}, false
+	}
+	c.order.MoveToFront(element)
+	return copyDocState(entry.docState), true
+}
+
+// put caches a copy of the state read for the key, evicting the least recently used states above size
+func (c *docStateLRU) put(key docStateCacheKey, docState model.DocumentState, size int) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if element, found := c.entries[key.fileName]; found {
+		c.order.Remove(element)
+	}
+	c.entries[key.fileName] = c.order.PushFront(&docStateCacheEntry{key: key, docState: copyDocState(docState)})
+	for c.order.Len() > size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*docStateCacheEntry).key.fileName)
+	}
+}
+
+// invalidate drops the state cached for the file, it's called whenever the file is written or deleted
+func (c *docStateLRU) invalidate(fileName string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if element, found := c.entries[fileName]; found {
+		c.order.Remove(element)
+		delete(c.entries, fileName)
+	}
+}
+
+// cachedDocState returns the state cached for the file if the cache is enabled and the file didn't change since,
+// the key to cache the state read otherwise
+func cachedDocState(fileName string) (docState model.DocumentState, hit bool, key docStateCacheKey, cacheable bool) {
+	if docStateCacheCapacity() <= 0 {
+		return
+	}
+	if key, cacheable = docStateCacheKeyOf(fileName); !cacheable {
+		return
+	}
+	docState, hit = docStateCache.get(key)
+	return
+}
+
+// cacheDocState caches the state read from the file for the key
+func cacheDocState(key docStateCacheKey, docState model.DocumentState) {
+	if size := docStateCacheCapacity(); size > 0 {
+		docStateCache.put(key, docState, size)
+	}
+}
+
+// copyDocState returns a deep copy of the document state
+func copyDocState(docState model.DocumentState) model.DocumentState {
+	return deepCopy(reflect.ValueOf(docState)).Interface().(model.DocumentState)
+}
+
+// deepCopy returns a copy of the value sharing no pointer, slice or map with it through the exported fields of its structs,
+// the unexported fields, e.g. of time.Time, are copied as is
+func deepCopy(value reflect.Value) reflect.Value {
+	switch value.Kind() {
+	case reflect.Ptr:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		copied := reflect.New(value.Type().Elem())
+		copied.Elem().Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Interface:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(deepCopy(value.Elem()))
+		return copied
+	case reflect.Struct:
+		copied := reflect.New(value.Type()).Elem()
+		copied.Set(value)
+		for i := 0; i < value.NumField(); i++ {
+			if copied.Field(i).CanSet() {
+				copied.Field(i).Set(deepCopy(value.Field(i)))
+			}
+		}
+		return copied
+	case reflect.Slice:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		copied := reflect.MakeSlice(value.Type(), value.Len(), value.Len())
+		for i := 0; i < value.Len(); i++ {
+			copied.Index(i).Set(deepCopy(value.Index(i)))
+		}
+		return copied
+	case reflect.Map:
+		if value.IsNil() {
+			return reflect.Zero(value.Type())
+		}
+		copied := reflect.MakeMapWithSize(value.Type(), value.Len())
+		for _, mapKey := range value.MapKeys() {
+			copied.SetMapIndex(mapKey, deepCopy(value.MapIndex(mapKey)))
+		}
+		return copied
+	}
+	return value
+}
@@ -0,0 +1,181 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"container/list"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// countingDocumentStore counts the reads of the document states of the local filesystem
+type countingDocumentStore struct {
+	FileDocumentStore
+	gets map[string]int
+	m    sync.Mutex
+}
+
+func (s *countingDocumentStore) Get(absoluteFileName string) ([]byte, error) {
+	s.m.Lock()
+	s.gets[absoluteFileName]++
+	s.m.Unlock()
+	return s.FileDocumentStore.Get(absoluteFileName)
+}
+
+func (s *countingDocumentStore) reads(absoluteFileName string) int {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.gets[absoluteFileName]
+}
+
+func setTestDocStateCacheSize(size int) func() {
+	origSize := docStateCacheSize
+	docStateCacheSize = func() int { return size }
+	return func() { docStateCacheSize = origSize }
+}
+
+func persistTestCachedDocState(t *testing.T) string {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.InstancePluginsInformation = []model.PluginState{{Id: "plugin1", Result: contracts.PluginResult{Output: map[string]interface{}{"lines": []interface{}{"one"}}}}}
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState))
+	return docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+}
+
+func TestGetDocStateCacheHitAvoidsDiskRead(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestDocStateCacheSize(4)()
+	countingStore := &countingDocumentStore{gets: make(map[string]int)}
+	defer setTestDocumentStore(countingStore)()
+	fileName := persistTestCachedDocState(t)
+
+	first, err := getDocState(testLog, fileName, testInstanceID)
+	assert.NoError(t, err)
+	reads := countingStore.reads(fileName)
+	second, err := getDocState(testLog, fileName, testInstanceID)
+	assert.NoError(t, err)
+
+	assert.Equal(t, reads, countingStore.reads(fileName))
+	assert.Equal(t, first, second)
+
+	// the state returned is a copy, modifying it leaves the cached one alone
+	second.InstancePluginsInformation[0].Id = "modified"
+	second.InstancePluginsInformation[0].Result.Output.(map[string]interface{})["lines"].([]interface{})[0] = "modified"
+	third, _ := getDocState(testLog, fileName, testInstanceID)
+	assert.Equal(t, first, third)
+}
+
+func TestGetDocStateCacheInvalidatedByWrite(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestDocStateCacheSize(4)()
+	countingStore := &countingDocumentStore{gets: make(map[string]int)}
+	defer setTestDocumentStore(countingStore)()
+	fileName := persistTestCachedDocState(t)
+	getDocState(testLog, fileName, testInstanceID)
+
+	pluginState := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "done"}}
+	PersistPluginState(testLog, pluginState, "plugin1", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	reads := countingStore.reads(fileName)
+	docState, err := getDocState(testLog, fileName, testInstanceID)
+
+	assert.NoError(t, err)
+	assert.True(t, countingStore.reads(fileName) > reads)
+	assert.Equal(t, "done", docState.InstancePluginsInformation[0].Result.Output)
+}
+
+func TestGetDocStateCacheDisabled(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestDocStateCacheSize(0)()
+	countingStore := &countingDocumentStore{gets: make(map[string]int)}
+	defer setTestDocumentStore(countingStore)()
+	fileName := persistTestCachedDocState(t)
+
+	getDocState(testLog, fileName, testInstanceID)
+	reads := countingStore.reads(fileName)
+	getDocState(testLog, fileName, testInstanceID)
+
+	assert.True(t, countingStore.reads(fileName) > reads)
+}
+
+func TestGetDocStateCacheBypassedWhileChecksumsAreVerified(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestDocStateCacheSize(4)()
+	defer setTestStateChecksums(true)()
+	countingStore := &countingDocumentStore{gets: make(map[string]int)}
+	defer setTestDocumentStore(countingStore)()
+	fileName := persistTestCachedDocState(t)
+	_, err := getDocState(testLog, fileName, testInstanceID)
+	assert.NoError(t, err)
+	reads := countingStore.reads(fileName)
+
+	// the state is corrupted in place, keeping its size and modification time
+	info, err := os.Stat(fileName)
+	assert.NoError(t, err)
+	content, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	i := strings.Index(string(content), testDocumentID)
+	content[i] ^= 0x20
+	assert.NoError(t, ioutil.WriteFile(fileName, content, 0600))
+	assert.NoError(t, os.Chtimes(fileName, info.ModTime(), info.ModTime()))
+
+	_, err = getDocState(testLog, fileName, testInstanceID)
+
+	assert.True(t, countingStore.reads(fileName) > reads)
+	assert.IsType(t, &CorruptStateError{}, err)
+}
+
+func TestDocStateCacheKeyedThroughTheStore(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestDocStateCacheSize(4)()
+	memoryStore := newMemoryDocumentStore()
+	defer setTestDocumentStore(memoryStore)()
+	fileName := persistTestCachedDocState(t)
+
+	// the state is nowhere on the local filesystem, the store still tells whether it changed
+	key, cacheable := docStateCacheKeyOf(fileName)
+	assert.True(t, cacheable)
+	memoryStore.modTimes[fileName] = key.modTime.Add(time.Second)
+	updatedKey, cacheable := docStateCacheKeyOf(fileName)
+	assert.True(t, cacheable)
+	assert.NotEqual(t, key, updatedKey)
+
+	_, cacheable = docStateCacheKeyOf(docStateFileName("missingDocument", testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.False(t, cacheable)
+}
+
+func TestDocStateLRUEvictsLeastRecentlyUsed(t *testing.T) {
+	cache := docStateLRU{entries: make(map[string]*list.Element), order: list.New()}
+	for _, fileName := range []string{"a", "b", "c"} {
+		cache.put(docStateCacheKey{fileName: fileName}, model.DocumentState{SchemaVersion: fileName}, 2)
+	}
+
+	_, foundA := cache.get(docStateCacheKey{fileName: "a"})
+	_, foundB := cache.get(docStateCacheKey{fileName: "b"})
+	_, staleC := cache.get(docStateCacheKey{fileName: "c", size: 1})
+
+	assert.False(t, foundA)
+	assert.True(t, foundB)
+	assert.False(t, staleC)
+	assert.Equal(t, 1, cache.order.Len())
+}
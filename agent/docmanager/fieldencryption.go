@@ -0,0 +1,216 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+)
+
+// encryptedFieldKey is the only key of the json object replacing an encrypted field of a persisted document state
+const encryptedFieldKey = "EncryptedField"
+
+// EncryptionKeyProvider returns the AES key, of 16, 24 or 32 bytes, the fields of the persisted document states are encrypted with.
+type EncryptionKeyProvider func() ([]byte, error)
+
+// StateFieldEncryptionKey provides the key of the AES-GCM encrypting the fields of the document states
+// listed in the Ssm.EncryptedStateFields setting, nil leaves them in plain text
+var StateFieldEncryptionKey EncryptionKeyProvider
+
+// encryptedStateFields returns the json paths of the fields encrypted in the persisted document states
+var encryptedStateFields = func() []string {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return nil
+	}
+	return config.Ssm.EncryptedStateFields
+}
+
+// encryptStateFields encrypts the fields of the json document state at the configured paths, the other fields are left as is.
+// A path is made of the json keys leading to the field separated by dots, * matching every element of an array or object.
+func encryptStateFields(content string) (string, error) {
+	paths := encryptedStateFields()
+	if StateFieldEncryptionKey == nil || len(paths) == 0 {
+		return content, nil
+	}
+	aead, err := stateFieldCipher()
+	if err != nil {
+		return "", err
+	}
+	state, err := decodeJSON([]byte(content))
+	if err != nil {
+		return "", err
+	}
+	for _, path := range paths {
+		if state, err = encryptPath(aead, state, strings.Split(path, ".")); err != nil {
+			return "", fmt.Errorf("failed to encrypt %v: %v", path, err)
+		}
+	}
+	encrypted, err := json.Marshal(state)
+	return string(encrypted), err
+}
+
+// decryptStateFields decrypts every encrypted field of the json document state, whatever the paths configured when it was persisted
+func decryptStateFields(content []byte) ([]byte, error) {
+	if !bytes.Contains(content, []byte(`"`+encryptedFieldKey+`"`)) {
+		return content, nil
+	}
+	if StateFieldEncryptionKey == nil {
+		return nil, errors.New("the document state has encrypted fields but no key to decrypt them")
+	}
+	aead, err := stateFieldCipher()
+	if err != nil {
+		return nil, err
+	}
+	state, err := decodeJSON(content)
+	if err != nil {
+		// left to the unmarshalling of the state to report
+		return content, nil
+	}
+	if state, err = decryptFields(aead, state); err != nil {
+		return nil, err
+	}
+	return json.Marshal(state)
+}
+
+// stateFieldCipher returns the AES-GCM cipher of the key provided by StateFieldEncryptionKey
+func stateFieldCipher() (cipher.AEAD, error) {
+	return newStateCipher(StateFieldEncryptionKey)
+}
+
+// newStateCipher returns the AES-GCM cipher of the key provided
+func newStateCipher(provider EncryptionKeyProvider) (cipher.AEAD, error) {
+	key, err := provider()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the document state encryption key: %v", err)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decodeJSON decodes the json content keeping its numbers as they're written
+func decodeJSON(content []byte) (value interface{}, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(content))
+	decoder.UseNumber()
+	err = decoder.Decode(&value)
+	return
+}
+
+// encryptPath replaces the fields of the value at the path by their encryption
+func encryptPath(aead cipher.AEAD, value interface{}, path []string) (interface{}, error) {
+	if len(path) == 0 {
+		if isEncryptedField(value) {
+			return value, nil
+		}
+		return encryptField(aead, value)
+	}
+	var err error
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			if path[0] == "*" || path[0] == key {
+				if typed[key], err = encryptPath(aead, child, path[1:]); err != nil {
+					return nil, err
+				}
+			}
+		}
+	case []interface{}:
+		if path[0] != "*" {
+			return value, nil
+		}
+		for i, child := range typed {
+			if typed[i], err = encryptPath(aead, child, path[1:]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// decryptFields replaces every encrypted field found in the value by its decryption
+func decryptFields(aead cipher.AEAD, value interface{}) (interface{}, error) {
+	if isEncryptedField(value) {
+		return decryptField(aead, value.(map[string]interface{})[encryptedFieldKey].(string))
+	}
+	var err error
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		for key, child := range typed {
+			if typed[key], err = decryptFields(aead, child); err != nil {
+				return nil, err
+			}
+		}
+	case []interface{}:
+		for i, child := range typed {
+			if typed[i], err = decryptFields(aead, child); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// isEncryptedField returns true if the value is the json object replacing an encrypted field
+func isEncryptedField(value interface{}) bool {
+	object, ok := value.(map[string]interface{})
+	if !ok || len(object) != 1 {
+		return false
+	}
+	_, ok = object[encryptedFieldKey].(string)
+	return ok
+}
+
+// encryptField returns the json object holding the encryption of the json value, the nonce followed by the sealed value
+func encryptField(aead cipher.AEAD, value interface{}) (interface{}, error) {
+	plaintext, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nonce, nonce, plaintext, nil)
+	return map[string]interface{}{encryptedFieldKey: base64.StdEncoding.EncodeToString(sealed)}, nil
+}
+
+// decryptField returns the json value of the encrypted field
+func decryptField(aead cipher.AEAD, encrypted string) (interface{}, error) {
+	sealed, err := base64.StdEncoding.DecodeString(encrypted)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("the encrypted field is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt a field of the document state: %v", err)
+	}
+	return decodeJSON(plaintext)
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// setTestFieldEncryption encrypts the properties of the plugins with a test key, returns the function restoring the plain text states
+func setTestFieldEncryption() func() {
+	origEncryptedStateFields := encryptedStateFields
+	encryptedStateFields = func() []string { return []string{"InstancePluginsInformation.*.Configuration.Properties"} }
+	StateFieldEncryptionKey = func() ([]byte, error) { return []byte("0123456789abcdef0123456789abcdef"), nil }
+	return func() {
+		encryptedStateFields = origEncryptedStateFields
+		StateFieldEncryptionKey = nil
+	}
+}
+
+// sensitiveDocState returns a document state whose plugin properties hold a secret
+func sensitiveDocState() model.DocumentState {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentName = "AWS-RunShellScript"
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	pluginState := model.PluginState{Id: "aws:runShellScript", Name: "aws:runShellScript"}
+	pluginState.Configuration.Properties = map[string]interface{}{"runCommand": []interface{}{"echo s3cr3t"}, "timeoutSeconds": "3600"}
+	docState.InstancePluginsInformation = []model.PluginState{pluginState}
+	return docState
+}
+
+func TestPersistDataEncryptsSensitiveFields(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestFieldEncryption()()
+	docState := sensitiveDocState()
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cr3t")
+	assert.NotContains(t, string(content), "timeoutSeconds")
+	assert.Contains(t, string(content), encryptedFieldKey)
+	// the other fields stay readable
+	assert.Contains(t, string(content), "AWS-RunShellScript")
+	assert.Contains(t, string(content), "aws:runShellScript")
+	assert.Contains(t, string(content), string(contracts.ResultStatusInProgress))
+
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.Equal(t, docState.InstancePluginsInformation[0], *GetPluginState(testLog, "aws:runShellScript", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestEncryptedFieldsSurviveTheMoveOfTheDocument(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestFieldEncryption()()
+	docState := sensitiveDocState()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), "s3cr3t")
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+}
+
+func TestReadEncryptedFieldsWithoutKey(t *testing.T) {
+	defer setTestDataStore(t)()
+	restore := setTestFieldEncryption()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, sensitiveDocState())
+	restore()
+
+	_, err := GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	assert.Error(t, err)
+	_, corrupt := err.(*CorruptStateError)
+	assert.False(t, corrupt)
+}
+
+func TestPersistDataLeavesFieldsInPlainTextWithoutKey(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestFieldEncryption()()
+	StateFieldEncryptionKey = nil
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, sensitiveDocState())
+
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.NoError(t, err)
+	assert.Contains(t, string(content), "s3cr3t")
+}
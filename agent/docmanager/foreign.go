@@ -0,0 +1,100 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// ForeignDocumentState is a document state persisted under the instance that records another instance id,
+// e.g. carried over from another host along with its disk
+type ForeignDocumentState struct {
+	DocumentID     string
+	LocationFolder string
+	// InstanceID is the instance id recorded in the state
+	InstanceID string
+}
+
+// FindForeignDocumentStates returns the document states persisted under the instance that record another instance id,
+// the corrupt folder is left out as its states aren't processed
+func FindForeignDocumentStates(log log.T, instanceID string) (foreign []ForeignDocumentState) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+	for _, locationFolder := range stateFolders {
+		if locationFolder == appconfig.DefaultLocationOfCorrupt {
+			continue
+		}
+		files, err := store.List(DocumentStateDir(instanceID, locationFolder))
+		if err != nil {
+			log.Debugf("skip looking for the foreign documents of %v: %v", locationFolder, err)
+			continue
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, moveIntermediateSuffix) {
+				continue
+			}
+			documentID := documentIDOfStateFile(file)
+			docMutex := rLockDocument(instanceID, documentID)
+			docState, err := getDocState(log, docStateFileName(documentID, instanceID, locationFolder), instanceID)
+			rUnlockDocument(docMutex)
+			if isCorruptState(err) {
+				quarantineCorruptDocument(log, documentID, instanceID, locationFolder, err)
+			}
+			if err != nil {
+				continue
+			}
+			if recorded := docState.DocumentInformation.InstanceID; recorded != "" && recorded != instanceID {
+				foreign = append(foreign, ForeignDocumentState{DocumentID: documentID, LocationFolder: locationFolder, InstanceID: recorded})
+			}
+		}
+	}
+	return
+}
+
+// ReconcileForeignDocumentStates handles the document states of the instance recording another instance id as the action says,
+// one of the ForeignDocumentStateAction settings: Migrate rewrites them to reference the instance and its paths,
+// Quarantine moves them to the corrupt folder and None only reports them. It returns the foreign states found,
+// it must run before the documents are processed.
+func ReconcileForeignDocumentStates(log log.T, instanceID, action string) []ForeignDocumentState {
+	foreign := FindForeignDocumentStates(log, instanceID)
+	for _, state := range foreign {
+		switch action {
+		case appconfig.ForeignDocumentStateActionMigrate:
+			log.Infof("migrating document %v of instance %v to %v", state.DocumentID, state.InstanceID, instanceID)
+			if err := rebaseDocState(log, state.DocumentID, state.LocationFolder, state.InstanceID, instanceID); err != nil {
+				log.Errorf("failed to migrate document %v of instance %v: %v", state.DocumentID, state.InstanceID, err)
+			}
+		case appconfig.ForeignDocumentStateActionQuarantine:
+			quarantineForeignDocState(log, state, instanceID)
+		default:
+			log.Warnf("document %v in %v records instance %v instead of %v", state.DocumentID, state.LocationFolder, state.InstanceID, instanceID)
+		}
+	}
+	return foreign
+}
+
+// quarantineForeignDocState moves the foreign document state to the corrupt folder of the instance
+func quarantineForeignDocState(log log.T, state ForeignDocumentState, instanceID string) {
+	docMutex := lockDocument(instanceID, state.DocumentID)
+	defer unlockDocument(instanceID, state.DocumentID, docMutex)
+	absoluteFileName := docStateFileName(state.DocumentID, instanceID, state.LocationFolder)
+	if _, err := quarantineDocState(log, storedDocStateFileName(absoluteFileName), instanceID); err != nil {
+		log.Errorf("failed to move document %v of instance %v to the corrupt folder: %v", state.DocumentID, state.InstanceID, err)
+	}
+}
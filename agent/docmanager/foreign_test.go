@@ -0,0 +1,88 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const foreignInstanceID = "i-0f0r0e1g2n"
+
+// persistForeignDocState persists a state of the current instance recording the foreign instance id,
+// and one recording the current instance id, it returns the ids of the two documents
+func persistForeignDocState(t *testing.T) (foreignDocumentID, localDocumentID string) {
+	foreignDocumentID, localDocumentID = "foreignDocument", "localDocument"
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = foreignDocumentID
+	docState.DocumentInformation.InstanceID = foreignInstanceID
+	docState.DocumentInformation.OrchestrationDirectory = filepath.Join(dataStorePath, foreignInstanceID, "document", "orchestration", foreignDocumentID)
+	assert.NoError(t, PersistData(testLog, foreignDocumentID, testInstanceID, appconfig.DefaultLocationOfPending, docState))
+	docState.DocumentInformation.DocumentID = localDocumentID
+	docState.DocumentInformation.InstanceID = testInstanceID
+	docState.DocumentInformation.OrchestrationDirectory = ""
+	assert.NoError(t, PersistData(testLog, localDocumentID, testInstanceID, appconfig.DefaultLocationOfPending, docState))
+	return
+}
+
+func TestFindForeignDocumentStates(t *testing.T) {
+	defer setTestDataStore(t)()
+	foreignDocumentID, _ := persistForeignDocState(t)
+
+	foreign := FindForeignDocumentStates(testLog, testInstanceID)
+
+	assert.Equal(t, []ForeignDocumentState{{DocumentID: foreignDocumentID, LocationFolder: appconfig.DefaultLocationOfPending, InstanceID: foreignInstanceID}}, foreign)
+}
+
+func TestReconcileForeignDocumentStatesMigrates(t *testing.T) {
+	defer setTestDataStore(t)()
+	foreignDocumentID, localDocumentID := persistForeignDocState(t)
+
+	foreign := ReconcileForeignDocumentStates(testLog, testInstanceID, appconfig.ForeignDocumentStateActionMigrate)
+
+	assert.Len(t, foreign, 1)
+	docInfo := GetDocumentInfo(testLog, foreignDocumentID, testInstanceID, appconfig.DefaultLocationOfPending)
+	assert.Equal(t, testInstanceID, docInfo.InstanceID)
+	assert.Equal(t, filepath.Join(dataStorePath, testInstanceID, "document", "orchestration", foreignDocumentID), docInfo.OrchestrationDirectory)
+	assert.Equal(t, testInstanceID, GetDocumentInfo(testLog, localDocumentID, testInstanceID, appconfig.DefaultLocationOfPending).InstanceID)
+	assert.Empty(t, FindForeignDocumentStates(testLog, testInstanceID))
+}
+
+func TestReconcileForeignDocumentStatesQuarantines(t *testing.T) {
+	defer setTestDataStore(t)()
+	foreignDocumentID, localDocumentID := persistForeignDocState(t)
+
+	foreign := ReconcileForeignDocumentStates(testLog, testInstanceID, appconfig.ForeignDocumentStateActionQuarantine)
+
+	assert.Len(t, foreign, 1)
+	assert.False(t, fileutil.Exists(docStateFileName(foreignDocumentID, testInstanceID, appconfig.DefaultLocationOfPending)))
+	assert.True(t, fileutil.Exists(docStateFileName(foreignDocumentID, testInstanceID, appconfig.DefaultLocationOfCorrupt)))
+	assert.True(t, fileutil.Exists(docStateFileName(localDocumentID, testInstanceID, appconfig.DefaultLocationOfPending)))
+}
+
+func TestReconcileForeignDocumentStatesOnlyReports(t *testing.T) {
+	defer setTestDataStore(t)()
+	foreignDocumentID, _ := persistForeignDocState(t)
+
+	foreign := ReconcileForeignDocumentStates(testLog, testInstanceID, appconfig.ForeignDocumentStateActionNone)
+
+	assert.Len(t, foreign, 1)
+	assert.Equal(t, foreignInstanceID, GetDocumentInfo(testLog, foreignDocumentID, testInstanceID, appconfig.DefaultLocationOfPending).InstanceID)
+}
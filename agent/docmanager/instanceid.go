@@ -0,0 +1,67 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"strings"
+)
+
+// placeholderInstanceIDs are the values a failed instance id lookup is known to leave behind
+var placeholderInstanceIDs = map[string]bool{
+	"unknown":   true,
+	"none":      true,
+	"null":      true,
+	"nil":       true,
+	"undefined": true,
+}
+
+// InstanceIDLookup returns the id of the instance the agent runs on
+type InstanceIDLookup func() (string, error)
+
+// ValidateInstanceID checks the instance id can isolate the document states of the instance,
+// an empty or placeholder id would mix them up with the ones of other identities
+func ValidateInstanceID(instanceID string) error {
+	trimmed := strings.TrimSpace(instanceID)
+	if trimmed == "" {
+		return fmt.Errorf("instance id is empty")
+	}
+	if placeholderInstanceIDs[strings.ToLower(trimmed)] {
+		return fmt.Errorf("instance id %q is a placeholder", instanceID)
+	}
+	if trimmed != instanceID || trimmed == "." || trimmed == ".." || strings.ContainsAny(instanceID, `/\`) {
+		return fmt.Errorf("instance id %q is not a valid folder name", instanceID)
+	}
+	return nil
+}
+
+// ResolveInstanceID returns the id the document states of the instance are persisted under, from lookup,
+// or the fallback id if the lookup fails or returns an invalid id. It returns an error if none of them is valid.
+func ResolveInstanceID(lookup InstanceIDLookup, fallback string) (string, error) {
+	instanceID, err := lookup()
+	if err == nil {
+		err = ValidateInstanceID(instanceID)
+	}
+	if err == nil {
+		return instanceID, nil
+	}
+	if fallback == "" {
+		return "", fmt.Errorf("unable to resolve the instance id: %v", err)
+	}
+	if fallbackErr := ValidateInstanceID(fallback); fallbackErr != nil {
+		return "", fmt.Errorf("unable to resolve the instance id: %v, and the fallback is invalid: %v", err, fallbackErr)
+	}
+	return fallback, nil
+}
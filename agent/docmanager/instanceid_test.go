@@ -0,0 +1,74 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateInstanceID(t *testing.T) {
+	for _, instanceID := range []string{"i-400e1090", "mi-0123456789abcdef0"} {
+		assert.NoError(t, ValidateInstanceID(instanceID), instanceID)
+	}
+	for _, instanceID := range []string{"", "  ", "unknown", "NULL", "undefined", " i-400e1090", "..", "i-400e1090/..", `i\400e1090`} {
+		assert.Error(t, ValidateInstanceID(instanceID), instanceID)
+	}
+}
+
+func TestResolveInstanceID(t *testing.T) {
+	lookupFailure := func() (string, error) { return "", errors.New("metadata unreachable") }
+	lookupPlaceholder := func() (string, error) { return "unknown", nil }
+	lookupSuccess := func() (string, error) { return testInstanceID, nil }
+
+	instanceID, err := ResolveInstanceID(lookupSuccess, "mi-fallback")
+	assert.NoError(t, err)
+	assert.Equal(t, testInstanceID, instanceID)
+
+	for _, lookup := range []InstanceIDLookup{lookupFailure, lookupPlaceholder} {
+		instanceID, err = ResolveInstanceID(lookup, "mi-fallback")
+		assert.NoError(t, err)
+		assert.Equal(t, "mi-fallback", instanceID)
+
+		_, err = ResolveInstanceID(lookup, "")
+		assert.Error(t, err)
+		_, err = ResolveInstanceID(lookup, "null")
+		assert.Error(t, err)
+	}
+}
+
+func TestDocumentStatesRequireInstanceID(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+
+	PersistData(testLog, testDocumentID, "", appconfig.DefaultLocationOfPending, docState)
+
+	// nothing is written next to the folders of the instances
+	files, err := ioutil.ReadDir(dataStorePath)
+	assert.NoError(t, err)
+	if assert.Len(t, files, 1) {
+		assert.Equal(t, testInstanceID, files[0].Name())
+	}
+	assert.Empty(t, GetDocumentInterimState(testLog, testDocumentID, "", appconfig.DefaultLocationOfPending).DocumentInformation.DocumentID)
+	assert.Error(t, EnsureStateFolders(testLog, ""))
+	_, err = ClaimDocument(testLog, testDocumentID, "")
+	assert.Error(t, err)
+}
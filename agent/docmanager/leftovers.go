@@ -0,0 +1,128 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// leftoversFileName is the file under the document root dir recording the orchestration dirs that couldn't be fully deleted
+const leftoversFileName = "leftovers"
+
+// maxOrchestrationDeletionAttempts is the number of times the deletion of an orchestration dir is tried in a single cleanup pass
+const maxOrchestrationDeletionAttempts = 3
+
+// Assign the deletion and the delay between its attempts to global variables to allow unittest to override
+var deleteDirectory = fileutil.DeleteDirectory
+var orchestrationDeletionRetryDelay = 500 * time.Millisecond
+
+var leftoversLock sync.Mutex
+
+// deleteOrchestrationDir deletes the orchestration dir, retrying when some of its files couldn't be removed
+func deleteOrchestrationDir(log log.T, orchestrationDirFullPath string) (err error) {
+	for attempt := 1; attempt <= maxOrchestrationDeletionAttempts; attempt++ {
+		if err = deleteDirectory(orchestrationDirFullPath); err == nil {
+			removeEmptyPartitionDirs(log, orchestrationDirFullPath)
+			return
+		}
+		log.Debugf("Attempt %v to delete dir %v failed: %v", attempt, orchestrationDirFullPath, err)
+		if attempt < maxOrchestrationDeletionAttempts {
+			time.Sleep(orchestrationDeletionRetryDelay)
+		}
+	}
+	return
+}
+
+// recordLeftover remembers the partially deleted orchestration dir so a later cleanup pass can finish its deletion
+func recordLeftover(log log.T, instanceID, orchestrationDirFullPath string) {
+	leftoversLock.Lock()
+	defer leftoversLock.Unlock()
+
+	leftovers := readLeftovers(log, instanceID)
+	for _, leftover := range leftovers {
+		if leftover == orchestrationDirFullPath {
+			return
+		}
+	}
+	writeLeftovers(log, instanceID, append(leftovers, orchestrationDirFullPath))
+}
+
+// deleteLeftovers retries the deletion of the orchestration dirs recorded by earlier cleanup passes
+func deleteLeftovers(log log.T, instanceID string) {
+	leftoversLock.Lock()
+	defer leftoversLock.Unlock()
+
+	leftovers := readLeftovers(log, instanceID)
+	if len(leftovers) == 0 {
+		return
+	}
+	var remaining []string
+	for _, leftover := range leftovers {
+		if err := deleteDirectory(leftover); err != nil {
+			log.Debugf("Dir %v still can't be deleted: %v", leftover, err)
+			remaining = append(remaining, leftover)
+		}
+	}
+	writeLeftovers(log, instanceID, remaining)
+}
+
+// readLeftovers returns the orchestration dirs pending deletion for the instance
+func readLeftovers(log log.T, instanceID string) (leftovers []string) {
+	leftoversPath := leftoversFilePath(instanceID)
+	if !fileutil.Exists(leftoversPath) {
+		return
+	}
+	if err := jsonutil.UnmarshalFile(leftoversPath, &leftovers); err != nil {
+		log.Errorf("Failed to read the orchestration dirs pending deletion from %v: %v", leftoversPath, err)
+	}
+	return
+}
+
+// writeLeftovers persists the orchestration dirs pending deletion for the instance, the record is removed when there is none left
+func writeLeftovers(log log.T, instanceID string, leftovers []string) {
+	leftoversPath := leftoversFilePath(instanceID)
+	if len(leftovers) == 0 {
+		if fileutil.Exists(leftoversPath) {
+			if err := fileutil.DeleteFile(leftoversPath); err != nil {
+				log.Debugf("Error deleting file %v: %v", leftoversPath, err)
+			}
+		}
+		return
+	}
+	content, err := jsonutil.Marshal(leftovers)
+	if err != nil {
+		log.Errorf("Failed to marshal the orchestration dirs pending deletion: %v", err)
+		return
+	}
+	if _, err = fileutil.WriteIntoFileWithPermissions(leftoversPath, content, os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		log.Errorf("Failed to record the orchestration dirs pending deletion in %v: %v", leftoversPath, err)
+	}
+}
+
+// leftoversFilePath returns the path of the leftovers record of the instance
+func leftoversFilePath(instanceID string) string {
+	return filepath.Join(dataStorePath,
+		instanceID,
+		appconfig.DefaultDocumentRootDirName,
+		leftoversFileName)
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// stubHeldFile makes the deletion of dir fail, leaving its held file behind, until the returned release is called
+func stubHeldFile(dir string) (release func(), attempts *int) {
+	held := true
+	attempts = new(int)
+	deleteDirectory = func(dirName string) error {
+		if dirName == dir && held {
+			*attempts++
+			fileutil.DeleteFile(filepath.Join(dir, "stderr"))
+			return fmt.Errorf("stdout is held open")
+		}
+		return fileutil.DeleteDirectory(dirName)
+	}
+	return func() { held = false }, attempts
+}
+
+func TestDeleteOldDocumentFolderLogsWithHeldOrchestrationFile(t *testing.T) {
+	defer setTestDataStore(t)()
+	orchestrationDeletionRetryDelay = 0
+	defer func() {
+		deleteDirectory = fileutil.DeleteDirectory
+		orchestrationDeletionRetryDelay = 500 * time.Millisecond
+	}()
+
+	orchestrationRootDirName := "awsrunCommand"
+	isIntendedFileNameFormat := func(fileName string) bool { return true }
+	formOrchestrationFolderName := func(fileName string) string { return fileName }
+	oldTime := time.Now().Add(-48 * time.Hour)
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{})
+	stateFile := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.NoError(t, os.Chtimes(stateFile, oldTime, oldTime))
+	pluginDir := filepath.Join(orchestrationDir(testInstanceID, orchestrationRootDirName), testDocumentID)
+	assert.NoError(t, fileutil.MakeDirs(pluginDir))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, "stdout"), []byte("out"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(pluginDir, "stderr"), []byte("err"), 0600))
+
+	release, attempts := stubHeldFile(pluginDir)
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+
+	// the deletion is retried, the state file goes away and the half deleted dir is left for a later pass
+	assert.Equal(t, maxOrchestrationDeletionAttempts, *attempts)
+	assert.False(t, fileutil.Exists(stateFile))
+	assert.True(t, fileutil.Exists(filepath.Join(pluginDir, "stdout")))
+	assert.False(t, fileutil.Exists(filepath.Join(pluginDir, "stderr")))
+	assert.Equal(t, []string{pluginDir}, readLeftovers(testLog, testInstanceID))
+
+	// the file is still held during the next pass
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+	assert.True(t, fileutil.Exists(pluginDir))
+	assert.Equal(t, []string{pluginDir}, readLeftovers(testLog, testInstanceID))
+
+	release()
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun, isIntendedFileNameFormat, formOrchestrationFolderName)
+	assert.False(t, fileutil.Exists(pluginDir))
+	assert.Empty(t, readLeftovers(testLog, testInstanceID))
+	assert.False(t, fileutil.Exists(leftoversFilePath(testInstanceID)))
+}
+
+func TestDeleteOrchestrationDirRetriesTransientFailure(t *testing.T) {
+	orchestrationDeletionRetryDelay = 0
+	defer func() {
+		deleteDirectory = fileutil.DeleteDirectory
+		orchestrationDeletionRetryDelay = 500 * time.Millisecond
+	}()
+
+	attempts := 0
+	deleteDirectory = func(dirName string) error {
+		attempts++
+		if attempts == 1 {
+			return fmt.Errorf("file is held open")
+		}
+		return nil
+	}
+	assert.NoError(t, deleteOrchestrationDir(testLog, "orchestration"))
+	assert.Equal(t, 2, attempts)
+}
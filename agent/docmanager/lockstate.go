@@ -0,0 +1,111 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"sort"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// DocumentLockState is the snapshot of the in-memory lock of a document
+type DocumentLockState struct {
+	InstanceID string
+	DocumentID string
+	// Held is whether the lock appeared held, for reading or for writing, when the snapshot was taken
+	Held bool
+	// HeldForWriting is whether the lock appeared held for writing, Held is then set as well
+	HeldForWriting bool
+}
+
+// DumpLockState returns a snapshot of the document locks, sorted by instance and document, to diagnose lock leaks or deadlocks.
+// Whether a lock is held is a best-effort indication: it's probed without waiting, and may have changed by the time the snapshot returns.
+// It is safe to call at any time, a lock is only ever taken when it's free and released right away.
+func DumpLockState() []DocumentLockState {
+	lock.RLock()
+	defer lock.RUnlock()
+
+	states := make([]DocumentLockState, 0, len(docLock))
+	for key, docMutex := range docLock {
+		state := DocumentLockState{InstanceID: key.instanceID, DocumentID: key.fileName}
+		if docMutex.TryLock() {
+			docMutex.Unlock()
+		} else {
+			state.Held = true
+			if docMutex.TryRLock() {
+				docMutex.RUnlock()
+			} else {
+				state.HeldForWriting = true
+			}
+		}
+		states = append(states, state)
+	}
+	sort.Slice(states, func(i, j int) bool {
+		if states[i].InstanceID != states[j].InstanceID {
+			return states[i].InstanceID < states[j].InstanceID
+		}
+		return states[i].DocumentID < states[j].DocumentID
+	})
+	return states
+}
+
+// ReleaseDocumentLock drops the in-memory lock of a document whose processing is over so that the locks don't pile up,
+// a lock that's held is left alone. The lock is created again if the document is accessed later on.
+func ReleaseDocumentLock(instanceID, documentID string) bool {
+	lock.Lock()
+	defer lock.Unlock()
+	key := documentLockKey{instanceID, documentID}
+	docMutex, ok := docLock[key]
+	if !ok || !docMutex.TryLock() {
+		return false
+	}
+	delete(docLock, key)
+	docMutex.Unlock()
+	return true
+}
+
+// SweepDocumentLocks releases the locks of the documents whose state is no longer in any state folder of their instance,
+// e.g. left over by the documents that failed to move or were removed, and returns how many locks were released
+func SweepDocumentLocks(log log.T) (released int) {
+	lock.RLock()
+	keys := make([]documentLockKey, 0, len(docLock))
+	for key := range docLock {
+		keys = append(keys, key)
+	}
+	lock.RUnlock()
+
+	for _, key := range keys {
+		if hasDocState(key.instanceID, key.fileName) {
+			continue
+		}
+		if ReleaseDocumentLock(key.instanceID, key.fileName) {
+			released++
+		}
+	}
+	if released > 0 {
+		log.Debugf("released %v orphaned document locks", released)
+	}
+	return
+}
+
+// hasDocState returns true if the document has a state in one of the state folders of the instance
+func hasDocState(instanceID, documentID string) bool {
+	for _, locationFolder := range stateFolders {
+		if docStateExists(docStateFileName(documentID, instanceID, locationFolder)) {
+			return true
+		}
+	}
+	return false
+}
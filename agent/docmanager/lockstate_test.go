@@ -0,0 +1,208 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// findLockState returns the state of the lock of the document in the dump, nil if the document has no lock
+func findLockState(states []DocumentLockState, instanceID, documentID string) *DocumentLockState {
+	for _, state := range states {
+		if state.InstanceID == instanceID && state.DocumentID == documentID {
+			return &state
+		}
+	}
+	return nil
+}
+
+// setTestDocumentLocks gives the test an empty lock map, the locks left behind by the other tests are restored afterwards
+func setTestDocumentLocks() func() {
+	lock.Lock()
+	defer lock.Unlock()
+	origDocLock := docLock
+	docLock = make(map[documentLockKey]*sync.RWMutex)
+	return func() {
+		lock.Lock()
+		defer lock.Unlock()
+		docLock = origDocLock
+	}
+}
+
+func TestDumpLockState(t *testing.T) {
+	defer setTestDocumentLocks()()
+	writeLocked := lockDocument(testInstanceID, "writeLocked")
+	readLocked := rLockDocument(testInstanceID, "readLocked")
+	rUnlockDocument(rLockDocument(testInstanceID, "released"))
+	defer func() {
+		unlockDocument(testInstanceID, "writeLocked", writeLocked)
+		rUnlockDocument(readLocked)
+		for _, documentID := range []string{"writeLocked", "readLocked", "released"} {
+			deleteLock(testInstanceID, documentID)
+		}
+	}()
+
+	states := DumpLockState()
+
+	assert.Equal(t, &DocumentLockState{InstanceID: testInstanceID, DocumentID: "writeLocked", Held: true, HeldForWriting: true}, findLockState(states, testInstanceID, "writeLocked"))
+	assert.Equal(t, &DocumentLockState{InstanceID: testInstanceID, DocumentID: "readLocked", Held: true}, findLockState(states, testInstanceID, "readLocked"))
+	assert.Equal(t, &DocumentLockState{InstanceID: testInstanceID, DocumentID: "released"}, findLockState(states, testInstanceID, "released"))
+	assert.Nil(t, findLockState(states, testInstanceID, "unknown"))
+
+	// the dump doesn't disturb the locks
+	assert.False(t, docLock[documentLockKey{testInstanceID, "writeLocked"}].TryRLock())
+	released := docLock[documentLockKey{testInstanceID, "released"}]
+	assert.True(t, released.TryLock())
+	released.Unlock()
+}
+
+func TestDumpLockStateIsSorted(t *testing.T) {
+	for _, key := range []documentLockKey{{"i-2", "b"}, {"i-1", "b"}, {"i-1", "a"}} {
+		createLock(key.instanceID, key.fileName)
+		defer deleteLock(key.instanceID, key.fileName)
+	}
+
+	var dumped []documentLockKey
+	for _, state := range DumpLockState() {
+		if state.InstanceID == "i-1" || state.InstanceID == "i-2" {
+			dumped = append(dumped, documentLockKey{state.InstanceID, state.DocumentID})
+		}
+	}
+
+	assert.Equal(t, []documentLockKey{{"i-1", "a"}, {"i-1", "b"}, {"i-2", "b"}}, dumped)
+}
+
+func TestReleaseDocumentLock(t *testing.T) {
+	defer setTestDocumentLocks()()
+	createLock(testInstanceID, "released")
+	docMutex := lockDocument(testInstanceID, "held")
+	defer func() {
+		unlockDocument(testInstanceID, "held", docMutex)
+		deleteLock(testInstanceID, "held")
+	}()
+
+	assert.True(t, ReleaseDocumentLock(testInstanceID, "released"))
+	assert.False(t, doesLockExist(testInstanceID, "released"))
+	// a held lock is kept, its holder would otherwise lose the exclusion
+	assert.False(t, ReleaseDocumentLock(testInstanceID, "held"))
+	assert.True(t, doesLockExist(testInstanceID, "held"))
+	assert.False(t, ReleaseDocumentLock(testInstanceID, "unknown"))
+}
+
+func TestSweepDocumentLocksShrinksLockMap(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestDocumentLocks()()
+	documentIDs := []string{"document1", "document2", "document3"}
+	for _, documentID := range documentIDs {
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCurrent, model.DocumentState{})
+	}
+	// the last document is still running
+	running := documentIDs[len(documentIDs)-1]
+	defer deleteLock(testInstanceID, running)
+	lock.RLock()
+	before := len(docLock)
+	lock.RUnlock()
+
+	// the other documents complete and their states are removed, their locks are left behind
+	for _, documentID := range documentIDs[:len(documentIDs)-1] {
+		RemoveData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+		assert.True(t, doesLockExist(testInstanceID, documentID))
+	}
+
+	released := SweepDocumentLocks(testLog)
+
+	lock.RLock()
+	after := len(docLock)
+	lock.RUnlock()
+	assert.Equal(t, 2, released)
+	assert.Equal(t, before-released, after)
+	for _, documentID := range documentIDs[:len(documentIDs)-1] {
+		assert.False(t, doesLockExist(testInstanceID, documentID))
+	}
+	assert.True(t, doesLockExist(testInstanceID, running))
+}
+
+func TestUnlockDocumentWhoseLockWasDropped(t *testing.T) {
+	docMutex := lockDocument(testInstanceID, "dropped")
+	readMutex := rLockDocument(testInstanceID, "droppedRead")
+	// the entries of the locks are dropped while they're held
+	lock.Lock()
+	delete(docLock, documentLockKey{testInstanceID, "dropped"})
+	delete(docLock, documentLockKey{testInstanceID, "droppedRead"})
+	lock.Unlock()
+
+	// the mutexes held are released, not fresh ones
+	unlockDocument(testInstanceID, "dropped", docMutex)
+	rUnlockDocument(readMutex)
+	assert.True(t, docMutex.TryLock())
+	assert.True(t, readMutex.TryLock())
+	assert.False(t, doesLockExist(testInstanceID, "dropped"))
+}
+
+func TestDeleteLockKeepsHeldLock(t *testing.T) {
+	docMutex := lockDocument(testInstanceID, "held")
+
+	deleteLock(testInstanceID, "held")
+	assert.True(t, doesLockExist(testInstanceID, "held"))
+
+	unlockDocument(testInstanceID, "held", docMutex)
+	deleteLock(testInstanceID, "held")
+	assert.False(t, doesLockExist(testInstanceID, "held"))
+}
+
+func TestLockDocumentRetriesLockReleasedBeforeItsAcquisition(t *testing.T) {
+	defer func() { lookupDocumentLock = documentLock }()
+	for _, lockFunc := range []func() (*sync.RWMutex, func()){
+		func() (*sync.RWMutex, func()) {
+			docMutex := lockDocument(testInstanceID, "swept")
+			return docMutex, func() { unlockDocument(testInstanceID, "swept", docMutex) }
+		},
+		func() (*sync.RWMutex, func()) {
+			docMutex, err := lockDocumentCtx(context.Background(), testInstanceID, "swept")
+			assert.NoError(t, err)
+			return docMutex, func() { unlockDocument(testInstanceID, "swept", docMutex) }
+		},
+		func() (*sync.RWMutex, func()) {
+			docMutex := rLockDocument(testInstanceID, "swept")
+			return docMutex, func() { rUnlockDocument(docMutex) }
+		},
+	} {
+		// the lock is released, e.g. by the lock sweeper, between its lookup and its acquisition
+		var stale *sync.RWMutex
+		lookupDocumentLock = func(instanceID, id string) *sync.RWMutex {
+			mutex := documentLock(instanceID, id)
+			if stale == nil {
+				stale = mutex
+				assert.True(t, ReleaseDocumentLock(instanceID, id))
+			}
+			return mutex
+		}
+
+		docMutex, unlock := lockFunc()
+
+		// the lock acquired is the one other callers get, not the stale one
+		assert.False(t, docMutex == stale)
+		assert.True(t, isCurrentLock(testInstanceID, "swept", docMutex))
+		assert.True(t, stale.TryLock())
+		unlock()
+		deleteLock(testInstanceID, "swept")
+	}
+}
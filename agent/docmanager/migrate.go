@@ -0,0 +1,180 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// MigrateInstanceData moves the document data persisted under oldInstanceID to newInstanceID, for an instance registered again
+// under a new id that kept its disk. The document states are updated to reference the new instance id and the moved orchestration
+// dirs, the message ids are kept as they identify the messages MDS delivered. The files already moved are left as they are,
+// so an interrupted migration can be run again. It has to run before the documents of either instance are processed.
+func MigrateInstanceData(log log.T, oldInstanceID, newInstanceID string) error {
+	if err := ValidateDataStorePath(); err != nil {
+		return err
+	}
+	for _, instanceID := range []string{oldInstanceID, newInstanceID} {
+		if err := ValidateInstanceID(instanceID); err != nil {
+			return err
+		}
+	}
+	if oldInstanceID == newInstanceID {
+		return nil
+	}
+	oldRoot := filepath.Join(dataStorePath, oldInstanceID)
+	newRoot := filepath.Join(dataStorePath, newInstanceID)
+	// the document states are moved through the store, the files left under the old root are their sidecars and the orchestration dirs
+	failures := moveInstanceDocStates(log, oldInstanceID, newInstanceID)
+	if fileutil.Exists(oldRoot) {
+		log.Infof("migrating the document data of instance %v to %v", oldInstanceID, newInstanceID)
+		failures = append(failures, moveInstanceFiles(log, oldRoot, newRoot)...)
+	}
+	// the states moved by an interrupted migration may not have been updated yet
+	for _, locationFolder := range stateFolders {
+		files, err := store.List(DocumentStateDir(newInstanceID, locationFolder))
+		if err != nil {
+			continue
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, moveIntermediateSuffix) {
+				continue
+			}
+			if err = rebaseDocState(log, documentIDOfStateFile(file), locationFolder, oldInstanceID, newInstanceID); err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+	if len(failures) > 0 {
+		return fmt.Errorf("failed to migrate the document data of instance %v to %v: %v", oldInstanceID, newInstanceID, strings.Join(failures, "; "))
+	}
+	if fileutil.Exists(oldRoot) {
+		if err := os.RemoveAll(oldRoot); err != nil {
+			return fmt.Errorf("failed to remove the migrated data of instance %v: %v", oldInstanceID, err)
+		}
+	}
+	return nil
+}
+
+// moveInstanceDocStates moves the document states of the state folders, and the claim markers, of oldInstanceID to the same folders of newInstanceID,
+// a state already present under newInstanceID was moved by a previous migration and its leftover is deleted
+func moveInstanceDocStates(log log.T, oldInstanceID, newInstanceID string) (failures []string) {
+	// the claim markers are kept in the store along with the states
+	for _, locationFolder := range append([]string{claimedFolderName}, stateFolders...) {
+		oldDir, newDir := DocumentStateDir(oldInstanceID, locationFolder), DocumentStateDir(newInstanceID, locationFolder)
+		files, err := store.List(oldDir)
+		if err != nil {
+			continue
+		}
+		if err = fileutil.MakeDirsWithExecuteAccess(newDir); err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		for _, file := range files {
+			source, destination := filepath.Join(oldDir, file), filepath.Join(newDir, file)
+			docStateCache.invalidate(source)
+			docStateCache.invalidate(destination)
+			if _, err = store.Stat(destination); err == nil {
+				log.Debugf("%v was already migrated", destination)
+				if err = store.Delete(source); err != nil {
+					failures = append(failures, err.Error())
+				}
+				continue
+			}
+			if err = store.Move(source, destination); err != nil {
+				failures = append(failures, err.Error())
+			}
+		}
+	}
+	return
+}
+
+// moveInstanceFiles moves each file under oldRoot to the same relative path under newRoot, a file already present
+// under newRoot was moved by a previous migration and its leftover under oldRoot is removed
+func moveInstanceFiles(log log.T, oldRoot, newRoot string) (failures []string) {
+	filepath.Walk(oldRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			failures = append(failures, err.Error())
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relativePath, err := filepath.Rel(oldRoot, path)
+		if err != nil {
+			failures = append(failures, err.Error())
+			return nil
+		}
+		destination := filepath.Join(newRoot, relativePath)
+		if fileutil.Exists(destination) {
+			log.Debugf("%v was already migrated", destination)
+			if err = os.Remove(path); err != nil {
+				failures = append(failures, err.Error())
+			}
+			return nil
+		}
+		if err = fileutil.MakeDirsWithExecuteAccess(filepath.Dir(destination)); err != nil {
+			failures = append(failures, err.Error())
+			return nil
+		}
+		if err = os.Rename(path, destination); err != nil {
+			failures = append(failures, err.Error())
+		}
+		return nil
+	})
+	return
+}
+
+// rebaseDocState updates the document state moved from oldInstanceID to reference newInstanceID and the paths under it
+func rebaseDocState(log log.T, fileName, locationFolder, oldInstanceID, newInstanceID string) error {
+	docMutex := lockDocument(newInstanceID, fileName)
+	defer unlockDocument(newInstanceID, fileName, docMutex)
+
+	absoluteFileName := docStateFileName(fileName, newInstanceID, locationFolder)
+	docState, err := getDocStateForUpdate(log, absoluteFileName, newInstanceID)
+	if err != nil {
+		return err
+	}
+	if docState.DocumentInformation.InstanceID != oldInstanceID {
+		return nil
+	}
+	oldRoot := filepath.Join(dataStorePath, oldInstanceID)
+	newRoot := filepath.Join(dataStorePath, newInstanceID)
+	rebase := func(path string) string {
+		if relativePath, err := filepath.Rel(oldRoot, path); err == nil && relativePath != ".." && !strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+			return filepath.Join(newRoot, relativePath)
+		}
+		return path
+	}
+	docState.DocumentInformation.InstanceID = newInstanceID
+	if docState.DocumentInformation.OrchestrationDirectory != "" {
+		docState.DocumentInformation.OrchestrationDirectory = rebase(docState.DocumentInformation.OrchestrationDirectory)
+	}
+	for i := range docState.InstancePluginsInformation {
+		configuration := &docState.InstancePluginsInformation[i].Configuration
+		if configuration.OrchestrationDirectory != "" {
+			configuration.OrchestrationDirectory = rebase(configuration.OrchestrationDirectory)
+		}
+	}
+	log.Debugf("document %v migrated to instance %v", fileName, newInstanceID)
+	setDocState(log, docState, absoluteFileName, locationFolder)
+	return nil
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const oldTestInstanceID = "i-0123456789abcdef0"
+
+// seedOldInstanceDocument persists a document of the old instance in the location folder, with an orchestration output
+func seedOldInstanceDocument(t *testing.T, documentID, locationFolder string) {
+	orchestrationDir := filepath.Join(orchestrationDir(oldTestInstanceID, "awsrunCommand"), documentID)
+	assert.NoError(t, fileutil.MakeDirs(orchestrationDir))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(orchestrationDir, "stdout"), []byte("output"), 0600))
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = documentID
+	docState.DocumentInformation.InstanceID = oldTestInstanceID
+	docState.DocumentInformation.MessageID = "aws.ssm." + documentID + "." + oldTestInstanceID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+	docState.DocumentInformation.OrchestrationDirectory = orchestrationDir
+	docState.InstancePluginsInformation = []model.PluginState{{
+		Id:            "plugin1",
+		Configuration: contracts.Configuration{OrchestrationDirectory: filepath.Join(orchestrationDir, "plugin1")},
+	}}
+	assert.NoError(t, fileutil.MakeDirs(DocumentStateDir(oldTestInstanceID, locationFolder)))
+	assert.NoError(t, PersistData(testLog, documentID, oldTestInstanceID, locationFolder, docState))
+}
+
+func assertDocumentMigrated(t *testing.T, documentID, locationFolder string) {
+	docState, err := getDocState(testLog, docStateFileName(documentID, testInstanceID, locationFolder), testInstanceID)
+	assert.NoError(t, err)
+	newOrchestrationDir := filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), documentID)
+	assert.Equal(t, testInstanceID, docState.DocumentInformation.InstanceID)
+	assert.Equal(t, "aws.ssm."+documentID+"."+oldTestInstanceID, docState.DocumentInformation.MessageID)
+	assert.Equal(t, newOrchestrationDir, docState.DocumentInformation.OrchestrationDirectory)
+	assert.Equal(t, filepath.Join(newOrchestrationDir, "plugin1"), docState.InstancePluginsInformation[0].Configuration.OrchestrationDirectory)
+	assert.True(t, fileutil.Exists(filepath.Join(newOrchestrationDir, "stdout")))
+}
+
+func TestMigrateInstanceData(t *testing.T) {
+	defer setTestDataStore(t)()
+	seedOldInstanceDocument(t, "document1", appconfig.DefaultLocationOfCompleted)
+	seedOldInstanceDocument(t, "document2", appconfig.DefaultLocationOfPending)
+
+	assert.NoError(t, MigrateInstanceData(testLog, oldTestInstanceID, testInstanceID))
+
+	assertDocumentMigrated(t, "document1", appconfig.DefaultLocationOfCompleted)
+	assertDocumentMigrated(t, "document2", appconfig.DefaultLocationOfPending)
+	assert.False(t, fileutil.Exists(filepath.Join(dataStorePath, oldTestInstanceID)))
+
+	// migrating again changes nothing
+	assert.NoError(t, MigrateInstanceData(testLog, oldTestInstanceID, testInstanceID))
+	assertDocumentMigrated(t, "document1", appconfig.DefaultLocationOfCompleted)
+	assertDocumentMigrated(t, "document2", appconfig.DefaultLocationOfPending)
+}
+
+func TestMigrateInstanceDataResumesInterruptedMigration(t *testing.T) {
+	defer setTestDataStore(t)()
+	seedOldInstanceDocument(t, "document1", appconfig.DefaultLocationOfCompleted)
+	seedOldInstanceDocument(t, "document2", appconfig.DefaultLocationOfCompleted)
+	// the first document was moved but not updated before the migration was interrupted
+	oldRoot := filepath.Join(dataStorePath, oldTestInstanceID)
+	newRoot := filepath.Join(dataStorePath, testInstanceID)
+	oldStatePath := docStateFileName("document1", oldTestInstanceID, appconfig.DefaultLocationOfCompleted)
+	relativePath, err := filepath.Rel(oldRoot, oldStatePath)
+	assert.NoError(t, err)
+	assert.NoError(t, os.Rename(oldStatePath, filepath.Join(newRoot, relativePath)))
+
+	assert.NoError(t, MigrateInstanceData(testLog, oldTestInstanceID, testInstanceID))
+
+	assertDocumentMigrated(t, "document1", appconfig.DefaultLocationOfCompleted)
+	assertDocumentMigrated(t, "document2", appconfig.DefaultLocationOfCompleted)
+	assert.False(t, fileutil.Exists(oldRoot))
+}
+
+func TestMigrateInstanceDataRejectsInvalidInstanceIDs(t *testing.T) {
+	defer setTestDataStore(t)()
+	assert.Error(t, MigrateInstanceData(testLog, "", testInstanceID))
+	assert.Error(t, MigrateInstanceData(testLog, oldTestInstanceID, "unknown"))
+	assert.NoError(t, MigrateInstanceData(testLog, testInstanceID, testInstanceID))
+}
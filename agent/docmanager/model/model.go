@@ -40,6 +40,8 @@ type PluginState struct {
 	Name          string
 	Result        contracts.PluginResult
 	Id            string
+	// OutputFile references the file the output of the result was offloaded to, the output is then left out of the state
+	OutputFile string `json:",omitempty"`
 }
 
 // DocumentInfo represents information stored as interim state for a document
@@ -62,6 +64,39 @@ type DocumentInfo struct {
 	DocumentTraceOutput string
 	RuntimeStatus       map[string]*contracts.PluginRuntimeStatus
 	RunCount            int
+	// SupersedesCommandID is the command this document replaces, if any
+	SupersedesCommandID string
+	// SequenceNumber orders the messages of the command, starting at 1, 0 if the message isn't sequenced
+	SequenceNumber int `json:",omitempty"`
+	// PreconditionCommandID is the command that has to have succeeded for this document to run, if any
+	PreconditionCommandID string `json:",omitempty"`
+	// SupersededBy is the command that replaced this document before it could finish
+	SupersededBy string
+	// RebootCount is the number of reboots the document requested so far
+	RebootCount int
+	// OrchestrationRetentionHours is how long the document asked its logs to be kept, the agent wide retention applies when 0
+	OrchestrationRetentionHours int
+	// OrchestrationDirectory is the directory the plugins of the document wrote their outputs in, empty if unknown
+	OrchestrationDirectory string `json:",omitempty"`
+	// PluginCount is the number of plugins the document declared when it was received, 0 if unknown
+	PluginCount int `json:",omitempty"`
+	// LastError is a concise reason of the failure of the document, set when it completes as Failed or TimedOut
+	LastError string `json:",omitempty"`
+	// Amendments records the plugin results corrected after the document completed, oldest first
+	Amendments []PluginResultAmendment `json:",omitempty"`
+	// ExecutedOffline is set when the document completed while MDS was unreachable, its final result then has to be sent again
+	ExecutedOffline bool `json:",omitempty"`
+	// ResultAcknowledged is set once the final result of a document executed offline was accepted by MDS
+	ResultAcknowledged bool `json:",omitempty"`
+	// ResultHash is the hash of the final status and plugin results of the document, recorded when it completes
+	ResultHash string `json:",omitempty"`
+}
+
+// PluginResultAmendment records the correction of the result of a plugin of a completed document
+type PluginResultAmendment struct {
+	PluginID string
+	// AmendedDate is when the result was corrected, in ISO 8601
+	AmendedDate string
 }
 
 // DocumentState represents information relevant to a command that gets executed by agent
@@ -78,6 +113,11 @@ func (c *DocumentState) IsRebootRequired() bool {
 	return c.DocumentInformation.DocumentStatus == contracts.ResultStatusSuccessAndReboot
 }
 
+// IsSuperseded returns if the document was cancelled in favor of a newer command
+func (c *DocumentState) IsSuperseded() bool {
+	return c.DocumentInformation.SupersededBy != ""
+}
+
 // IsAssociation returns if documentType is association
 func (c *DocumentState) IsAssociation() bool {
 	return c.DocumentType == Association
@@ -0,0 +1,141 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// offloadedFolderName is the document state folder holding the plugin outputs too large to be kept in the document states,
+// in a sub folder per document
+const offloadedFolderName = "offloaded"
+
+// invalidOutputFileChars matches the characters of a plugin id that can't be used in a file name
+var invalidOutputFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// pluginOutputOffloadThreshold returns the size above which a plugin output is offloaded, 0 disables the offloading
+var pluginOutputOffloadThreshold = func() int {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return 0
+	}
+	return config.Ssm.PluginOutputOffloadThresholdBytes
+}
+
+// offloadedOutputPath returns the absolute path of the file referenced by the plugin state
+func offloadedOutputPath(instanceID, outputFile string) string {
+	return filepath.Join(DocumentStateDir(instanceID, offloadedFolderName), outputFile)
+}
+
+// offloadPluginOutput moves the output of the plugin to its own file if it's larger than the threshold,
+// the output is kept in the plugin state if it can't be written
+func offloadPluginOutput(log log.T, documentID, instanceID string, pluginState *model.PluginState) {
+	threshold := pluginOutputOffloadThreshold()
+	if threshold <= 0 || pluginState.Result.Output == nil {
+		return
+	}
+	content, err := json.Marshal(pluginState.Result.Output)
+	if err != nil {
+		log.Errorf("encountered error with message %v while marshalling the output of plugin %v", err, pluginState.Id)
+		return
+	}
+	outputFile := filepath.Join(documentID, invalidOutputFileChars.ReplaceAllString(pluginState.Id, "_"))
+	outputPath := offloadedOutputPath(instanceID, outputFile)
+	if len(content) <= threshold {
+		// an earlier output of the plugin may have been offloaded
+		if fileutil.Exists(outputPath) {
+			removeOffloadedOutput(log, instanceID, outputFile)
+		}
+		pluginState.OutputFile = ""
+		return
+	}
+	if err = fileutil.MakeDirs(filepath.Dir(outputPath)); err != nil {
+		log.Errorf("failed to create the folder of the offloaded output of plugin %v: %v", pluginState.Id, err)
+		return
+	}
+	if s, err := fileutil.WriteIntoFileWithPermissions(outputPath, string(content), os.FileMode(int(appconfig.ReadWriteAccess))); !s || err != nil {
+		log.Errorf("failed to offload the output of plugin %v to %v: %v", pluginState.Id, outputPath, err)
+		return
+	}
+	log.Debugf("offloaded the %v bytes output of plugin %v to %v", len(content), pluginState.Id, outputPath)
+	pluginState.Result.Output = nil
+	pluginState.OutputFile = outputFile
+}
+
+// offloadPluginOutputs offloads the large plugin outputs of the document state,
+// the plugin states are copied so that the caller keeps the outputs
+func offloadPluginOutputs(log log.T, documentID, instanceID string, docState *model.DocumentState) {
+	if pluginOutputOffloadThreshold() <= 0 || len(docState.InstancePluginsInformation) == 0 {
+		return
+	}
+	pluginStates := make([]model.PluginState, len(docState.InstancePluginsInformation))
+	copy(pluginStates, docState.InstancePluginsInformation)
+	for index := range pluginStates {
+		offloadPluginOutput(log, documentID, instanceID, &pluginStates[index])
+	}
+	docState.InstancePluginsInformation = pluginStates
+}
+
+// rehydratePluginOutput reads the offloaded output of the plugin back into its result
+func rehydratePluginOutput(log log.T, instanceID string, pluginState *model.PluginState) {
+	if pluginState.OutputFile == "" {
+		return
+	}
+	outputPath := offloadedOutputPath(instanceID, pluginState.OutputFile)
+	content, err := fileutil.ReadAllText(outputPath)
+	if err != nil {
+		log.Errorf("failed to read the offloaded output of plugin %v from %v: %v", pluginState.Id, outputPath, err)
+		return
+	}
+	if err = json.Unmarshal([]byte(content), &pluginState.Result.Output); err != nil {
+		log.Errorf("failed to parse the offloaded output of plugin %v from %v: %v", pluginState.Id, outputPath, err)
+		return
+	}
+	pluginState.OutputFile = ""
+}
+
+// rehydratePluginOutputs reads the offloaded plugin outputs of the document state back into their results
+func rehydratePluginOutputs(log log.T, instanceID string, docState *model.DocumentState) {
+	for index := range docState.InstancePluginsInformation {
+		rehydratePluginOutput(log, instanceID, &docState.InstancePluginsInformation[index])
+	}
+}
+
+// removeOffloadedOutput deletes an offloaded plugin output
+func removeOffloadedOutput(log log.T, instanceID, outputFile string) {
+	outputPath := offloadedOutputPath(instanceID, outputFile)
+	if err := fileutil.DeleteFile(outputPath); err != nil {
+		log.Debugf("Error deleting offloaded output %v: %v", outputPath, err)
+	}
+}
+
+// removeOffloadedOutputs deletes the offloaded plugin outputs of the document
+func removeOffloadedOutputs(log log.T, documentID, instanceID string) {
+	outputDir := offloadedOutputPath(instanceID, documentID)
+	if !fileutil.Exists(outputDir) {
+		return
+	}
+	if err := fileutil.DeleteDirectory(outputDir); err != nil {
+		log.Debugf("Error deleting offloaded outputs %v: %v", outputDir, err)
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestOffloadThreshold(threshold int) func() {
+	origThreshold := pluginOutputOffloadThreshold
+	pluginOutputOffloadThreshold = func() int { return threshold }
+	return func() { pluginOutputOffloadThreshold = origThreshold }
+}
+
+func TestPersistDataOffloadsLargePluginOutput(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestOffloadThreshold(64)()
+	largeOutput := strings.Repeat("x", 1024)
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Id: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: largeOutput}},
+		{Id: "plugin2", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "small"}},
+	}
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	// the large output is left out of the state file, the caller keeps it
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.NoError(t, err)
+	assert.NotContains(t, string(content), largeOutput)
+	assert.Contains(t, string(content), "small")
+	assert.Equal(t, largeOutput, docState.InstancePluginsInformation[0].Result.Output)
+	persisted, _ := getDocState(testLog, docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent), testInstanceID)
+	assert.Nil(t, persisted.InstancePluginsInformation[0].Result.Output)
+	assert.NotEmpty(t, persisted.InstancePluginsInformation[0].OutputFile)
+	assert.Empty(t, persisted.InstancePluginsInformation[1].OutputFile)
+
+	// the output is read back transparently
+	docState = GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.Equal(t, largeOutput, docState.InstancePluginsInformation[0].Result.Output)
+	assert.Empty(t, docState.InstancePluginsInformation[0].OutputFile)
+	assert.Equal(t, "small", docState.InstancePluginsInformation[1].Result.Output)
+
+	RemoveData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.False(t, fileutil.Exists(offloadedOutputPath(testInstanceID, testDocumentID)))
+}
+
+func TestPersistPluginStateOffloadsLargePluginOutput(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestOffloadThreshold(64)()
+	largeOutput := strings.Repeat("y", 1024)
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.InstancePluginsInformation = []model.PluginState{{Id: "plugin1"}}
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	pluginState := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: largeOutput}}
+	PersistPluginState(testLog, pluginState, "plugin1", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	persisted, _ := getDocState(testLog, docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent), testInstanceID)
+	assert.Nil(t, persisted.InstancePluginsInformation[0].Result.Output)
+	outputFile := persisted.InstancePluginsInformation[0].OutputFile
+	assert.True(t, fileutil.Exists(offloadedOutputPath(testInstanceID, outputFile)))
+
+	rehydrated := GetPluginState(testLog, "plugin1", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	if assert.NotNil(t, rehydrated) {
+		assert.Equal(t, largeOutput, rehydrated.Result.Output)
+		assert.Equal(t, contracts.ResultStatusSuccess, rehydrated.Result.Status)
+	}
+
+	// a smaller output replacing the offloaded one is kept in the state and the offloaded file is deleted
+	rehydrated.Result.Output = "done"
+	PersistPluginState(testLog, *rehydrated, "plugin1", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	persisted, _ = getDocState(testLog, docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent), testInstanceID)
+	assert.Equal(t, "done", persisted.InstancePluginsInformation[0].Result.Output)
+	assert.Empty(t, persisted.InstancePluginsInformation[0].OutputFile)
+	assert.False(t, fileutil.Exists(offloadedOutputPath(testInstanceID, outputFile)))
+}
+
+func TestPersistDataKeepsOutputsWhenOffloadingDisabled(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestOffloadThreshold(0)()
+	largeOutput := strings.Repeat("z", 1024)
+	docState := model.DocumentState{}
+	docState.InstancePluginsInformation = []model.PluginState{{Id: "plugin1", Result: contracts.PluginResult{Output: largeOutput}}}
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	persisted, _ := getDocState(testLog, docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent), testInstanceID)
+	assert.Equal(t, largeOutput, persisted.InstancePluginsInformation[0].Result.Output)
+	assert.Empty(t, persisted.InstancePluginsInformation[0].OutputFile)
+}
@@ -0,0 +1,157 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// OutputMode selects how GetFinalResultWithOutput returns the plugin outputs saved in the orchestration dirs
+type OutputMode int
+
+const (
+	// InlineOutput copies the outputs into the plugin results, up to the maximum inline output size
+	InlineOutput OutputMode = iota
+	// LazyOutput returns readers of the orchestration files instead of loading the outputs in memory
+	LazyOutput
+)
+
+const (
+	stdoutFileName = "stdout"
+	stderrFileName = "stderr"
+)
+
+// PluginOutputReaders gives access to the stdout and stderr a plugin saved in its orchestration dir
+type PluginOutputReaders struct {
+	Stdout io.ReadCloser
+	Stderr io.ReadCloser
+}
+
+// outputFilesReader reads a sequence of files as a single stream, each file is opened only when the reading reaches it
+type outputFilesReader struct {
+	paths   []string
+	current *os.File
+}
+
+// Read reads from the current file, moving to the next one once it's exhausted
+func (r *outputFilesReader) Read(p []byte) (n int, err error) {
+	for {
+		if r.current == nil {
+			if len(r.paths) == 0 {
+				return 0, io.EOF
+			}
+			if r.current, err = os.Open(r.paths[0]); err != nil {
+				return 0, err
+			}
+			r.paths = r.paths[1:]
+		}
+		n, err = r.current.Read(p)
+		if err != io.EOF {
+			return
+		}
+		r.current.Close()
+		r.current = nil
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close closes the file being read and skips the remaining ones
+func (r *outputFilesReader) Close() (err error) {
+	r.paths = nil
+	if r.current != nil {
+		err = r.current.Close()
+		r.current = nil
+	}
+	return
+}
+
+// GetFinalResultWithOutput rebuilds the document result like GetFinalResult, along with the plugin outputs saved in the orchestration dirs.
+// With InlineOutput the outputs are copied into the plugin results, cut at maxInlineOutputBytes, and no reader is returned.
+// With LazyOutput the plugin results are left as persisted and the outputs are returned as readers keyed by plugin id, callers must close them.
+func GetFinalResultWithOutput(log log.T, documentID, instanceID string, mode OutputMode, maxInlineOutputBytes int) (result contracts.DocumentResult, readers map[string]PluginOutputReaders) {
+	result = GetFinalResult(log, documentID, instanceID)
+	docState := GetDocumentInterimState(log, documentID, instanceID, FindTerminalLocationFolder(documentID, instanceID))
+
+	if mode == LazyOutput {
+		readers = make(map[string]PluginOutputReaders)
+	}
+	for _, pluginState := range docState.InstancePluginsInformation {
+		orchestrationDirectory := pluginState.Configuration.OrchestrationDirectory
+		stdout := &outputFilesReader{paths: findOutputFiles(log, orchestrationDirectory, stdoutFileName)}
+		stderr := &outputFilesReader{paths: findOutputFiles(log, orchestrationDirectory, stderrFileName)}
+		if mode == LazyOutput {
+			readers[pluginState.Id] = PluginOutputReaders{Stdout: stdout, Stderr: stderr}
+			continue
+		}
+
+		// keep the persisted outputs of the plugins that didn't save any file
+		pluginResult := result.PluginResults[pluginState.Id]
+		truncated := false
+		if len(stdout.paths) > 0 {
+			pluginResult.StandardOutput, truncated = readInlineOutput(log, stdout, maxInlineOutputBytes)
+		}
+		if len(stderr.paths) > 0 {
+			stderrTruncated := false
+			pluginResult.StandardError, stderrTruncated = readInlineOutput(log, stderr, maxInlineOutputBytes)
+			truncated = truncated || stderrTruncated
+		}
+		if truncated {
+			pluginResult.OutputTruncated = true
+			pluginResult.OutputTruncatedAt = maxInlineOutputBytes
+		}
+	}
+	return
+}
+
+// findOutputFiles returns the output files with the given name under the orchestration dir of a plugin, in lexical order
+func findOutputFiles(log log.T, orchestrationDirectory, fileName string) (paths []string) {
+	if orchestrationDirectory == "" {
+		return
+	}
+	err := filepath.Walk(orchestrationDirectory, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && info.Name() == fileName {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		log.Debugf("Failed to list the output files under %v: %v", orchestrationDirectory, err)
+	}
+	return
+}
+
+// readInlineOutput reads at most maxBytes of the output and reports whether there was more
+func readInlineOutput(log log.T, reader io.ReadCloser, maxBytes int) (output string, truncated bool) {
+	defer reader.Close()
+	content, err := ioutil.ReadAll(io.LimitReader(reader, int64(maxBytes)+1))
+	if err != nil {
+		log.Debugf("Failed to read plugin output: %v", err)
+	}
+	if len(content) > maxBytes {
+		return string(content[:maxBytes]), true
+	}
+	return string(content), false
+}
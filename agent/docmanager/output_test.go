@@ -0,0 +1,96 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// persistDocumentWithOutput persists a completed document whose plugin saved its outputs in two steps
+func persistDocumentWithOutput(t *testing.T) (stdout, stderr string) {
+	pluginDir := filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), testDocumentID, "aws:runScript")
+	steps := []struct {
+		dir    string
+		stdout string
+		stderr string
+	}{
+		{"0.aws:runScript", strings.Repeat("a", 20), "warning"},
+		{"1.aws:runScript", strings.Repeat("b", 20), ""},
+	}
+	for _, step := range steps {
+		stepDir := filepath.Join(pluginDir, step.dir)
+		assert.NoError(t, fileutil.MakeDirs(stepDir))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(stepDir, stdoutFileName), []byte(step.stdout), 0600))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(stepDir, stderrFileName), []byte(step.stderr), 0600))
+		stdout += step.stdout
+		stderr += step.stderr
+	}
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+	pluginState := model.PluginState{Id: "aws:runScript", Name: "aws:runScript"}
+	pluginState.Configuration.OrchestrationDirectory = pluginDir
+	pluginState.Result.Status = contracts.ResultStatusSuccess
+	docState.InstancePluginsInformation = []model.PluginState{pluginState}
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+	return
+}
+
+func TestGetFinalResultWithInlineOutput(t *testing.T) {
+	defer setTestDataStore(t)()
+	stdout, stderr := persistDocumentWithOutput(t)
+
+	result, readers := GetFinalResultWithOutput(testLog, testDocumentID, testInstanceID, InlineOutput, 30)
+
+	assert.Nil(t, readers)
+	assert.Equal(t, contracts.ResultStatusSuccess, result.Status)
+	pluginResult := result.PluginResults["aws:runScript"]
+	assert.Equal(t, stdout[:30], pluginResult.StandardOutput)
+	assert.Equal(t, stderr, pluginResult.StandardError)
+	assert.True(t, pluginResult.OutputTruncated)
+	assert.Equal(t, 30, pluginResult.OutputTruncatedAt)
+}
+
+func TestGetFinalResultWithLazyOutput(t *testing.T) {
+	defer setTestDataStore(t)()
+	stdout, stderr := persistDocumentWithOutput(t)
+
+	result, readers := GetFinalResultWithOutput(testLog, testDocumentID, testInstanceID, LazyOutput, 30)
+
+	pluginResult := result.PluginResults["aws:runScript"]
+	assert.Empty(t, pluginResult.StandardOutput)
+	assert.False(t, pluginResult.OutputTruncated)
+	if assert.Contains(t, readers, "aws:runScript") {
+		pluginReaders := readers["aws:runScript"]
+		content, err := ioutil.ReadAll(pluginReaders.Stdout)
+		assert.NoError(t, err)
+		assert.Equal(t, stdout, string(content))
+		content, err = ioutil.ReadAll(pluginReaders.Stderr)
+		assert.NoError(t, err)
+		assert.Equal(t, stderr, string(content))
+		assert.NoError(t, pluginReaders.Stdout.Close())
+		assert.NoError(t, pluginReaders.Stderr.Close())
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// panicMessagesFolderName is the document state folder holding the messages whose processing panicked
+const panicMessagesFolderName = "panicked"
+
+// PanicMessage is a message whose processing panicked, kept along with the panic to reproduce the crash
+type PanicMessage struct {
+	MessageID     string
+	QuarantinedAt time.Time
+	// Panic is the value the processing panicked with
+	Panic string
+	// Stack is the stack of the goroutine that panicked
+	Stack string
+	// Message is the message as received, scrubbed of its credentials by the caller
+	Message json.RawMessage
+}
+
+// QuarantinePanicMessage keeps the message whose processing panicked in the panicked folder of the instance,
+// the caller scrubs the message of its credentials beforehand. It returns the path the message was persisted to.
+func QuarantinePanicMessage(log log.T, instanceID string, panicMessage PanicMessage) (string, error) {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return "", err
+	}
+	if panicMessage.QuarantinedAt.IsZero() {
+		panicMessage.QuarantinedAt = time.Now()
+	}
+	panicDir := DocumentStateDir(instanceID, panicMessagesFolderName)
+	if err := fileutil.MakeDirs(panicDir); err != nil {
+		return "", fmt.Errorf("failed to create the panicked messages folder: %v", err)
+	}
+	content, err := jsonutil.Marshal(panicMessage)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal the message %v: %v", panicMessage.MessageID, err)
+	}
+	// the message id comes from the message that panicked, it isn't trusted to name the file
+	messagePath := filepath.Join(panicDir, fmt.Sprintf("%v.json", panicMessage.QuarantinedAt.UnixNano()))
+	if _, err = fileutil.WriteIntoFileWithPermissions(messagePath, content, os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		return "", fmt.Errorf("failed to persist the message %v: %v", panicMessage.MessageID, err)
+	}
+	log.Warnf("quarantined the message %v whose processing panicked to %v", panicMessage.MessageID, messagePath)
+	return messagePath, nil
+}
+
+// ListPanicMessages returns the messages of the instance whose processing panicked, the oldest first
+func ListPanicMessages(log log.T, instanceID string) ([]PanicMessage, error) {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(DocumentStateDir(instanceID, panicMessagesFolderName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var panicMessages []PanicMessage
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".json") {
+			continue
+		}
+		var panicMessage PanicMessage
+		if err := jsonutil.UnmarshalFile(filepath.Join(DocumentStateDir(instanceID, panicMessagesFolderName), file.Name()), &panicMessage); err != nil {
+			log.Warnf("skipping the unreadable panicked message %v: %v", file.Name(), err)
+			continue
+		}
+		panicMessages = append(panicMessages, panicMessage)
+	}
+	sort.SliceStable(panicMessages, func(i, j int) bool {
+		return panicMessages[i].QuarantinedAt.Before(panicMessages[j].QuarantinedAt)
+	})
+	return panicMessages, nil
+}
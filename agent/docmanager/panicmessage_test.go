@@ -0,0 +1,53 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQuarantineAndListPanicMessages(t *testing.T) {
+	defer setTestDataStore(t)()
+	first := PanicMessage{MessageID: "message1", QuarantinedAt: time.Now().Add(-time.Minute), Panic: "boom", Stack: "goroutine 1", Message: []byte(`{"MessageId":"message1"}`)}
+	second := PanicMessage{MessageID: "message2", Panic: "bang", Message: []byte(`{"MessageId":"message2"}`)}
+
+	_, err := QuarantinePanicMessage(testLog, testInstanceID, second)
+	assert.NoError(t, err)
+	_, err = QuarantinePanicMessage(testLog, testInstanceID, first)
+	assert.NoError(t, err)
+
+	panicMessages, err := ListPanicMessages(testLog, testInstanceID)
+	assert.NoError(t, err)
+	if assert.Len(t, panicMessages, 2) {
+		assert.Equal(t, "message1", panicMessages[0].MessageID)
+		assert.Equal(t, "boom", panicMessages[0].Panic)
+		assert.Equal(t, "goroutine 1", panicMessages[0].Stack)
+		assert.JSONEq(t, `{"MessageId":"message1"}`, string(panicMessages[0].Message))
+		assert.Equal(t, "message2", panicMessages[1].MessageID)
+		assert.False(t, panicMessages[1].QuarantinedAt.IsZero())
+	}
+}
+
+func TestListPanicMessagesNoneQuarantined(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	panicMessages, err := ListPanicMessages(testLog, testInstanceID)
+
+	assert.NoError(t, err)
+	assert.Empty(t, panicMessages)
+}
@@ -0,0 +1,51 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// orchestrationPartitionLayout is the layout of the date partitions of the orchestration root dir
+const orchestrationPartitionLayout = "2006/01/02"
+
+// PartitionedOrchestrationDir returns the date partition of the orchestration root dir for the given time,
+// the orchestration dirs created at that time go under <root>/<yyyy>/<mm>/<dd>
+func PartitionedOrchestrationDir(orchestrationRootDir string, t time.Time) string {
+	return filepath.Join(orchestrationRootDir, filepath.FromSlash(t.UTC().Format(orchestrationPartitionLayout)))
+}
+
+// removeEmptyPartitionDirs removes the date partitions holding the deleted orchestration dir once they're empty,
+// nothing is removed if the orchestration dir isn't in a date partition
+func removeEmptyPartitionDirs(log log.T, orchestrationDirFullPath string) {
+	dayDir := filepath.Dir(filepath.Clean(orchestrationDirFullPath))
+	monthDir := filepath.Dir(dayDir)
+	yearDir := filepath.Dir(monthDir)
+	partition := filepath.ToSlash(filepath.Join(filepath.Base(yearDir), filepath.Base(monthDir), filepath.Base(dayDir)))
+	if _, err := time.Parse(orchestrationPartitionLayout, partition); err != nil {
+		return
+	}
+	for _, partitionDir := range []string{dayDir, monthDir, yearDir} {
+		// a partition still holding other orchestration dirs fails to be removed
+		if err := os.Remove(partitionDir); err != nil {
+			return
+		}
+		log.Debugf("removed empty orchestration partition %v", partitionDir)
+	}
+}
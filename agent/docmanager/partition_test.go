@@ -0,0 +1,76 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPartitionedOrchestrationDir(t *testing.T) {
+	received := time.Date(2017, time.March, 4, 23, 30, 0, 0, time.FixedZone("east", 3600))
+	assert.Equal(t, filepath.Join("root", "2017", "03", "04"), PartitionedOrchestrationDir("root", received))
+}
+
+func TestDeleteOldDocumentFolderLogsTraversesPartitions(t *testing.T) {
+	defer setTestDataStore(t)()
+	orchestrationRootDirName := "awsrunCommand"
+	orchestrationRootDir := orchestrationDir(testInstanceID, orchestrationRootDirName)
+	monthDir := filepath.Join(orchestrationRootDir, "2017", "03")
+	oldDir := filepath.Join(PartitionedOrchestrationDir(orchestrationRootDir, time.Date(2017, time.March, 4, 0, 0, 0, 0, time.UTC)), "command1")
+	recentDir := filepath.Join(PartitionedOrchestrationDir(orchestrationRootDir, time.Date(2017, time.March, 5, 0, 0, 0, 0, time.UTC)), "command2")
+	flatDir := filepath.Join(orchestrationRootDir, "command3")
+	for _, dir := range []string{oldDir, recentDir, flatDir} {
+		assert.NoError(t, fileutil.MakeDirs(filepath.Join(dir, "awsrunShellScript")))
+	}
+	persistCommandDocument("command1", "command1", oldDir, appconfig.DefaultLocationOfCompleted)
+	persistCommandDocument("command2", "command2", recentDir, appconfig.DefaultLocationOfCompleted)
+	persistCommandDocument("command3", "command3", flatDir, appconfig.DefaultLocationOfCompleted)
+	age := func(documentID string) {
+		modTime := time.Now().Add(-48 * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+	cleanup := func() {
+		DeleteOldDocumentFolderLogs(testLog, testInstanceID, orchestrationRootDirName, 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun,
+			func(fileName string) bool { return true },
+			func(fileName string) string { return fileName })
+	}
+	age("command1")
+	age("command3")
+
+	cleanup()
+
+	// the day partition of command1 is empty and removed, the month partition still holds command2
+	assert.False(t, fileutil.Exists(oldDir))
+	assert.False(t, fileutil.Exists(filepath.Dir(oldDir)))
+	assert.True(t, fileutil.Exists(recentDir))
+	assert.False(t, fileutil.Exists(flatDir))
+	assert.True(t, fileutil.Exists(orchestrationRootDir))
+
+	age("command2")
+
+	cleanup()
+
+	assert.False(t, fileutil.Exists(docStateFileName("command2", testInstanceID, appconfig.DefaultLocationOfCompleted)))
+	assert.False(t, fileutil.Exists(monthDir))
+	assert.False(t, fileutil.Exists(filepath.Join(orchestrationRootDir, "2017")))
+	assert.True(t, fileutil.Exists(orchestrationRootDir))
+}
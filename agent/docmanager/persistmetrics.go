@@ -0,0 +1,38 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import "time"
+
+// DocManagerMetrics receives the outcome of the writes of the document states and of the data store cleanups,
+// it must be safe for concurrent use.
+type DocManagerMetrics interface {
+	// RecordPersist is called after each write of a document state with the time it took and why it failed, nil if it didn't
+	RecordPersist(duration time.Duration, err error)
+	// RecordCleanup is called at the end of each sweep of DeleteOldDocumentFolderLogs with the count of documents deleted
+	RecordCleanup(deleted int)
+}
+
+// DefaultMetrics receives the outcome of the writes and cleanups of the data store, it records nothing by default
+var DefaultMetrics DocManagerMetrics = noopDocManagerMetrics{}
+
+// noopDocManagerMetrics records nothing
+type noopDocManagerMetrics struct{}
+
+// RecordPersist does nothing
+func (noopDocManagerMetrics) RecordPersist(duration time.Duration, err error) {}
+
+// RecordCleanup does nothing
+func (noopDocManagerMetrics) RecordCleanup(deleted int) {}
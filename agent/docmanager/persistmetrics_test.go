@@ -0,0 +1,88 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeDocManagerMetrics records the callbacks it receives
+type fakeDocManagerMetrics struct {
+	persists     int
+	persistFails int
+	cleanups     []int
+	m            sync.Mutex
+}
+
+func (f *fakeDocManagerMetrics) RecordPersist(duration time.Duration, err error) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.persists++
+	if err != nil {
+		f.persistFails++
+	}
+}
+
+func (f *fakeDocManagerMetrics) RecordCleanup(deleted int) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	f.cleanups = append(f.cleanups, deleted)
+}
+
+func setTestDocManagerMetrics() (*fakeDocManagerMetrics, func()) {
+	origMetrics := DefaultMetrics
+	fake := &fakeDocManagerMetrics{}
+	DefaultMetrics = fake
+	return fake, func() { DefaultMetrics = origMetrics }
+}
+
+func TestPersistAndCleanupCallMetricsHook(t *testing.T) {
+	defer setTestDataStore(t)()
+	fake, restore := setTestDocManagerMetrics()
+	defer restore()
+
+	for _, documentID := range []string{"documentRecent", "documentOld1", "documentOld2"} {
+		assert.NoError(t, PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, model.DocumentState{}))
+	}
+	// a channel can't be marshalled
+	assert.Error(t, PersistData(testLog, "documentInvalid", testInstanceID, appconfig.DefaultLocationOfCompleted, make(chan int)))
+	assert.Equal(t, 4, fake.persists)
+	assert.Equal(t, 1, fake.persistFails)
+
+	// setDocState reports through the same hook
+	pluginState := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess}}
+	PersistPluginState(testLog, pluginState, "plugin1", "documentRecent", testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, 5, fake.persists)
+	assert.Equal(t, 1, fake.persistFails)
+
+	modTime := time.Now().Add(-48 * time.Hour)
+	for _, documentID := range []string{"documentOld1", "documentOld2"} {
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+	}
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, "awsrunCommand", 24, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun,
+		func(fileName string) bool { return true },
+		func(fileName string) string { return fileName })
+
+	assert.Equal(t, []int{2}, fake.cleanups)
+}
@@ -0,0 +1,159 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// pluginStateDebounce returns how long the in progress plugin state updates of a document are held back, 0 persists them right away
+var pluginStateDebounce = func() time.Duration {
+	config, err := appconfig.Config(false)
+	if err != nil || config.Ssm.PluginStateDebounceMillis <= 0 {
+		return 0
+	}
+	return time.Duration(config.Ssm.PluginStateDebounceMillis) * time.Millisecond
+}
+
+// pluginStateUpdate is a plugin state to persist in the state of its document
+type pluginStateUpdate struct {
+	pluginID    string
+	pluginState model.PluginState
+}
+
+// pluginStateBatch holds back the plugin state updates of a document, the last update of each plugin wins
+type pluginStateBatch struct {
+	log            log.T
+	commandID      string
+	instanceID     string
+	locationFolder string
+	updates        []pluginStateUpdate
+	timer          *time.Timer
+}
+
+// set records the update of the plugin, replacing the one held back for it if any
+func (b *pluginStateBatch) set(update pluginStateUpdate) {
+	for index := range b.updates {
+		if b.updates[index].pluginID == update.pluginID {
+			b.updates[index] = update
+			return
+		}
+	}
+	b.updates = append(b.updates, update)
+}
+
+// pluginStateBatcher holds back the plugin state updates of the documents so that the updates of a document
+// within the debounce window are persisted in a single write
+type pluginStateBatcher struct {
+	batches map[string]*pluginStateBatch
+	m       sync.Mutex
+}
+
+var pluginStateBatches = pluginStateBatcher{batches: make(map[string]*pluginStateBatch)}
+
+// batchKey returns the key of the batch of the document
+func batchKey(commandID, instanceID string) string {
+	return filepath.Join(instanceID, commandID)
+}
+
+// add holds back the update of the plugin state until the window is over, a terminal update is persisted right away
+// along with the updates held back
+func (b *pluginStateBatcher) add(log log.T, update pluginStateUpdate, commandID, instanceID, locationFolder string, window time.Duration) {
+	key := batchKey(commandID, instanceID)
+	b.m.Lock()
+	batch, found := b.batches[key]
+	if found && batch.locationFolder != locationFolder {
+		// the document moved since, the updates held back are persisted in the folder they were made for first
+		b.m.Unlock()
+		b.flush(commandID, instanceID)
+		b.m.Lock()
+		batch, found = b.batches[key]
+	}
+	if !found {
+		batch = &pluginStateBatch{log: log, commandID: commandID, instanceID: instanceID, locationFolder: locationFolder}
+		b.batches[key] = batch
+	}
+	batch.log = log
+	batch.set(update)
+	terminal := !isInProgressPluginStatus(update.pluginState.Result.Status)
+	if !terminal && batch.timer == nil {
+		batch.timer = time.AfterFunc(window, func() { b.flush(commandID, instanceID) })
+	}
+	b.m.Unlock()
+	if terminal {
+		b.flush(commandID, instanceID)
+	}
+}
+
+// flush persists the plugin state updates of the document held back, if any, in a single write
+func (b *pluginStateBatcher) flush(commandID, instanceID string) {
+	key := batchKey(commandID, instanceID)
+	b.m.Lock()
+	_, found := b.batches[key]
+	b.m.Unlock()
+	if !found {
+		return
+	}
+	// the batch is taken once the document is locked, so that the batches of the document are persisted in the order they were made
+	docMutex := lockDocument(instanceID, commandID)
+	defer unlockDocument(instanceID, commandID, docMutex)
+	b.m.Lock()
+	batch, found := b.batches[key]
+	if found {
+		delete(b.batches, key)
+		if batch.timer != nil {
+			batch.timer.Stop()
+		}
+	}
+	b.m.Unlock()
+	if found {
+		persistPluginStates(batch.log, batch.updates, batch.commandID, batch.instanceID, batch.locationFolder)
+	}
+}
+
+// flushAll persists the plugin state updates held back of all the documents
+func (b *pluginStateBatcher) flushAll() {
+	b.m.Lock()
+	batches := make([]*pluginStateBatch, 0, len(b.batches))
+	for _, batch := range b.batches {
+		batches = append(batches, batch)
+	}
+	b.m.Unlock()
+	for _, batch := range batches {
+		b.flush(batch.commandID, batch.instanceID)
+	}
+}
+
+// isInProgressPluginStatus returns true if the plugin with the status hasn't completed yet
+func isInProgressPluginStatus(status contracts.ResultStatus) bool {
+	return status == "" || status == contracts.ResultStatusNotStarted || status == contracts.ResultStatusInProgress
+}
+
+// flushPluginStates persists the plugin state updates of the document held back, before the document is read, written or moved
+func flushPluginStates(commandID, instanceID string) {
+	pluginStateBatches.flush(commandID, instanceID)
+}
+
+// FlushAllPluginStates persists the plugin state updates held back of all the documents, so that none is lost when the agent stops
+func FlushAllPluginStates() {
+	pluginStateBatches.flushAll()
+}
@@ -0,0 +1,137 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+func setTestPluginStateDebounce(window time.Duration) func() {
+	origDebounce := pluginStateDebounce
+	pluginStateDebounce = func() time.Duration { return window }
+	return func() { pluginStateDebounce = origDebounce }
+}
+
+func persistTestPlugins(t testing.TB, nPlugins int) {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	for i := 0; i < nPlugins; i++ {
+		docState.InstancePluginsInformation = append(docState.InstancePluginsInformation, model.PluginState{Id: fmt.Sprintf("plugin%v", i)})
+	}
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState))
+}
+
+func readTestPluginState(pluginIndex int) model.PluginState {
+	persisted, _ := readDocState(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	return persisted.InstancePluginsInformation[pluginIndex]
+}
+
+func TestPersistPluginStateBatchesInProgressUpdates(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestPluginStateDebounce(time.Hour)()
+	persistTestPlugins(t, 2)
+
+	for i := 0; i < 10; i++ {
+		pluginState := model.PluginState{Id: "plugin0", Result: contracts.PluginResult{Status: contracts.ResultStatusInProgress, Output: fmt.Sprintf("step %v", i)}}
+		PersistPluginState(testLog, pluginState, "plugin0", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	}
+
+	// the updates are held back
+	assert.Nil(t, readTestPluginState(0).Result.Output)
+
+	// the terminal update of another plugin is persisted right away along with them
+	pluginState := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "done"}}
+	PersistPluginState(testLog, pluginState, "plugin1", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	assert.Equal(t, contracts.ResultStatusInProgress, readTestPluginState(0).Result.Status)
+	assert.Equal(t, "step 9", readTestPluginState(0).Result.Output)
+	assert.Equal(t, contracts.ResultStatusSuccess, readTestPluginState(1).Result.Status)
+	assert.Empty(t, pluginStateBatches.batches)
+}
+
+func TestPersistPluginStateFlushesAfterWindow(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestPluginStateDebounce(10 * time.Millisecond)()
+	persistTestPlugins(t, 1)
+
+	for i := 0; i < 5; i++ {
+		pluginState := model.PluginState{Id: "plugin0", Result: contracts.PluginResult{Status: contracts.ResultStatusInProgress, Output: fmt.Sprintf("step %v", i)}}
+		PersistPluginState(testLog, pluginState, "plugin0", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	}
+
+	// the updates held back are persisted once the window is over
+	assert.Empty(t, readTestPluginState(0).Result.Output)
+	for deadline := time.Now().Add(time.Second); readTestPluginState(0).Result.Output == nil && time.Now().Before(deadline); {
+		time.Sleep(5 * time.Millisecond)
+	}
+	assert.Equal(t, "step 4", readTestPluginState(0).Result.Output)
+}
+
+func TestMoveDocumentStateFlushesHeldBackPluginStates(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestPluginStateDebounce(time.Hour)()
+	persistTestPlugins(t, 1)
+
+	pluginState := model.PluginState{Id: "plugin0", Result: contracts.PluginResult{Status: contracts.ResultStatusInProgress, Output: "last"}}
+	PersistPluginState(testLog, pluginState, "plugin0", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	persisted, _ := readDocState(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+	assert.Equal(t, "last", persisted.InstancePluginsInformation[0].Result.Output)
+	assert.Empty(t, pluginStateBatches.batches)
+}
+
+func TestFlushAllPluginStates(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestPluginStateDebounce(time.Hour)()
+	persistTestPlugins(t, 1)
+
+	pluginState := model.PluginState{Id: "plugin0", Result: contracts.PluginResult{Status: contracts.ResultStatusInProgress, Output: "last"}}
+	PersistPluginState(testLog, pluginState, "plugin0", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.Nil(t, readTestPluginState(0).Result.Output)
+
+	FlushAllPluginStates()
+
+	assert.Equal(t, "last", readTestPluginState(0).Result.Output)
+	assert.Empty(t, pluginStateBatches.batches)
+}
+
+func benchmarkPersistPluginState(b *testing.B, window time.Duration) {
+	defer setTestDataStore(b)()
+	defer setTestPluginStateDebounce(window)()
+	persistTestPlugins(b, 50)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		pluginState := model.PluginState{Id: "plugin0", Result: contracts.PluginResult{Status: contracts.ResultStatusInProgress, Output: fmt.Sprintf("step %v", i)}}
+		PersistPluginState(testLog, pluginState, "plugin0", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	}
+	flushPluginStates(testDocumentID, testInstanceID)
+}
+
+func BenchmarkPersistPluginState(b *testing.B) {
+	benchmarkPersistPluginState(b, 0)
+}
+
+func BenchmarkPersistPluginStateDebounced(b *testing.B) {
+	benchmarkPersistPluginState(b, time.Second)
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// rawMessagesFolderName is the document state folder holding the message each command was received in, as <command id>.json
+const rawMessagesFolderName = "messages"
+
+// rawMessageFileName returns the path of the message the command was received in
+func rawMessageFileName(commandID, instanceID string) string {
+	return filepath.Join(DocumentStateDir(instanceID, rawMessagesFolderName), commandID+".json")
+}
+
+// PersistRawMessage keeps the message the command was received in next to its document state,
+// the caller scrubs the message of its credentials beforehand
+func PersistRawMessage(log log.T, commandID, instanceID string, content []byte) error {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+	messagePath := rawMessageFileName(commandID, instanceID)
+	if err := fileutil.MakeDirs(filepath.Dir(messagePath)); err != nil {
+		return fmt.Errorf("failed to create the messages folder of %v: %v", commandID, err)
+	}
+	if _, err := fileutil.WriteIntoFileWithPermissions(messagePath, string(content), os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		return fmt.Errorf("failed to persist the message of %v: %v", commandID, err)
+	}
+	log.Debugf("persisted the message of %v to %v", commandID, messagePath)
+	return nil
+}
+
+// GetRawMessage returns the message the command was received in, as persisted by PersistRawMessage
+func GetRawMessage(commandID, instanceID string) ([]byte, error) {
+	if err := ValidateInstanceID(instanceID); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(rawMessageFileName(commandID, instanceID))
+}
+
+// removeRawMessage deletes the message of the deleted document
+func removeRawMessage(log log.T, commandID, instanceID string) {
+	messagePath := rawMessageFileName(commandID, instanceID)
+	if !fileutil.Exists(messagePath) {
+		return
+	}
+	if err := fileutil.DeleteFile(messagePath); err != nil {
+		log.Debugf("Error deleting message %v: %v", messagePath, err)
+	}
+}
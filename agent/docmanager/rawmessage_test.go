@@ -0,0 +1,55 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPersistRawMessage(t *testing.T) {
+	defer setTestDataStore(t)()
+	message := []byte(`{"MessageId":"aws.ssm.` + testDocumentID + `.` + testInstanceID + `","Payload":"{\"password\":\"****\"}"}`)
+
+	assert.NoError(t, PersistRawMessage(testLog, testDocumentID, testInstanceID, message))
+
+	persisted, err := GetRawMessage(testDocumentID, testInstanceID)
+	assert.NoError(t, err)
+	assert.Equal(t, message, persisted)
+}
+
+func TestGetRawMessageNotPersisted(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	_, err := GetRawMessage(testDocumentID, testInstanceID)
+
+	assert.Error(t, err)
+}
+
+func TestRawMessageRemovedWithDocumentState(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+	assert.NoError(t, PersistRawMessage(testLog, testDocumentID, testInstanceID, []byte(`{}`)))
+
+	RemoveData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+
+	assert.False(t, fileutil.Exists(rawMessageFileName(testDocumentID, testInstanceID)))
+}
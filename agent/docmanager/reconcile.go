@@ -0,0 +1,163 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// moveIntermediateSuffix is appended to the name of a document state while a move rewrites it
+const moveIntermediateSuffix = ".tmp"
+
+// reconciledFolders lists the state folders a document moves through, the most advanced first
+var reconciledFolders = []string{
+	appconfig.DefaultLocationOfCompleted,
+	appconfig.DefaultLocationOfFailed,
+	appconfig.DefaultLocationOfCurrent,
+	appconfig.DefaultLocationOfPending,
+}
+
+// ReconcileDocumentStates repairs the document states of the instance left behind by a move interrupted by a crash.
+// The intermediate files of a move are completed, or dropped if the move went through,
+// and a document found in several state folders is kept in the most advanced folder its recorded status agrees with.
+// It must run before the documents are processed.
+func ReconcileDocumentStates(log log.T, instanceID string) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+
+	copies := make(map[string][]string)
+	for _, locationFolder := range reconciledFolders {
+		files, err := store.List(DocumentStateDir(instanceID, locationFolder))
+		if err != nil {
+			log.Debugf("skip reconciling the documents of %v: %v", locationFolder, err)
+			continue
+		}
+		for _, file := range files {
+			documentID := documentIDOfStateFile(file)
+			if strings.HasSuffix(documentID, moveIntermediateSuffix) {
+				documentID = strings.TrimSuffix(documentID, moveIntermediateSuffix)
+				if !completeInterruptedMove(log, documentID, instanceID, locationFolder) {
+					continue
+				}
+			}
+			copies[documentID] = append(copies[documentID], locationFolder)
+		}
+	}
+
+	for documentID, locationFolders := range copies {
+		if len(locationFolders) > 1 {
+			keepCanonicalCopy(log, documentID, instanceID, locationFolders)
+		}
+	}
+}
+
+// completeInterruptedMove turns the intermediate file of the document into its state if the move didn't get to it,
+// returns whether the state was recovered from the intermediate file
+func completeInterruptedMove(log log.T, documentID, instanceID, locationFolder string) bool {
+	absoluteFileName := docStateFileName(documentID, instanceID, locationFolder)
+	intermediateFileName := absoluteFileName + moveIntermediateSuffix
+	if docStateExists(absoluteFileName) {
+		log.Infof("dropping the intermediate state of document %v in %v, its move completed", documentID, locationFolder)
+		if err := store.Delete(intermediateFileName); err != nil {
+			log.Debugf("Error deleting file %v: %v", intermediateFileName, err)
+		}
+		return false
+	}
+	content, err := store.Get(intermediateFileName)
+	if err == nil {
+		content, err = openDocState(content)
+	}
+	if err != nil || !json.Valid(content) {
+		log.Infof("dropping the incomplete intermediate state of document %v in %v", documentID, locationFolder)
+		if err = store.Delete(intermediateFileName); err != nil {
+			log.Debugf("Error deleting file %v: %v", intermediateFileName, err)
+		}
+		return false
+	}
+	log.Infof("completing the interrupted move of document %v to %v", documentID, locationFolder)
+	if err = store.Move(intermediateFileName, absoluteFileName); err != nil {
+		log.Errorf("failed to complete the interrupted move of document %v to %v: %v", documentID, locationFolder, err)
+		return false
+	}
+	return true
+}
+
+// keepCanonicalCopy deletes the copies of the document but the one in the most advanced folder its recorded status agrees with,
+// a terminal folder only holds the documents with a terminal status
+func keepCanonicalCopy(log log.T, documentID, instanceID string, locationFolders []string) {
+	docMutex := lockDocument(instanceID, documentID)
+	defer unlockDocument(instanceID, documentID, docMutex)
+
+	canonical := ""
+	for _, locationFolder := range reconciledFolders {
+		if !containsString(locationFolders, locationFolder) {
+			continue
+		}
+		var docState model.DocumentState
+		content, err := getDocStateContent(docStateFileName(documentID, instanceID, locationFolder))
+		if err == nil {
+			err = json.Unmarshal(content, &docState)
+		}
+		if err != nil {
+			log.Debugf("the copy of document %v in %v is unreadable: %v", documentID, locationFolder, err)
+			continue
+		}
+		if !isTerminalLocationFolder(locationFolder) || isTerminalStatus(docState.DocumentInformation.DocumentStatus) {
+			canonical = locationFolder
+			break
+		}
+	}
+	if canonical == "" {
+		log.Warnf("none of the copies of document %v in %v is readable, leaving them as is", documentID, locationFolders)
+		return
+	}
+
+	log.Infof("document %v is found in %v, keeping its copy in %v", documentID, locationFolders, canonical)
+	for _, locationFolder := range locationFolders {
+		if locationFolder == canonical {
+			continue
+		}
+		absoluteFileName := docStateFileName(documentID, instanceID, locationFolder)
+		if err := deleteDocState(absoluteFileName); err != nil {
+			log.Errorf("failed to delete the copy of document %v in %v: %v", documentID, locationFolder, err)
+		}
+	}
+}
+
+// isTerminalStatus checks if the document status is final
+func isTerminalStatus(status contracts.ResultStatus) bool {
+	switch status {
+	case "", contracts.ResultStatusNotStarted, contracts.ResultStatusInProgress, contracts.ResultStatusSuccessAndReboot, contracts.ResultStatusPassedAndReboot:
+		return false
+	}
+	return true
+}
+
+// containsString checks if the value is one of the values
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// isRerunnablePluginStatus returns true if the plugin of a completed document with the status failed and runs again on a rerun
+func isRerunnablePluginStatus(status contracts.ResultStatus) bool {
+	return status == contracts.ResultStatusFailed || status == contracts.ResultStatusTimedOut
+}
+
+// ResetFailedPlugins moves the completed document back to the Pending folder with its failed plugins reset to NotStarted,
+// the results of the other plugins are kept. The returned state is ready to be submitted again, its failed plugins then run
+// and their new results are merged with the kept ones. An error is returned if the document hasn't completed or has no failed plugin.
+func ResetFailedPlugins(log log.T, documentID, instanceID string) (model.DocumentState, error) {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return model.DocumentState{}, err
+	}
+
+	docMutex := lockDocument(instanceID, documentID)
+	defer unlockDocument(instanceID, documentID, docMutex)
+
+	terminalFileName := ""
+	for _, locationFolder := range terminalLocationFolders {
+		if fileName := docStateFileName(documentID, instanceID, locationFolder); docStateExists(fileName) {
+			terminalFileName = fileName
+			break
+		}
+	}
+	if terminalFileName == "" {
+		return model.DocumentState{}, fmt.Errorf("document %v has not completed", documentID)
+	}
+	docState, err := getDocStateForUpdate(log, terminalFileName, instanceID)
+	if err != nil {
+		return model.DocumentState{}, err
+	}
+	// the kept results are persisted again with the document, the outputs of the failed plugins are replaced once they run again
+	rehydratePluginOutputs(log, instanceID, &docState)
+	reset := 0
+	for index := range docState.InstancePluginsInformation {
+		pluginState := &docState.InstancePluginsInformation[index]
+		if !isRerunnablePluginStatus(pluginState.Result.Status) {
+			continue
+		}
+		log.Infof("plugin %v of document %v failed with status %v, it will run again", pluginState.Id, documentID, pluginState.Result.Status)
+		pluginState.Result = contracts.PluginResult{PluginName: pluginState.Name, Status: contracts.ResultStatusNotStarted}
+		pluginState.OutputFile = ""
+		reset++
+	}
+	if reset == 0 {
+		return model.DocumentState{}, fmt.Errorf("document %v has no failed plugin to run again", documentID)
+	}
+	docInfo := &docState.DocumentInformation
+	docInfo.DocumentStatus = contracts.ResultStatusInProgress
+	docInfo.DocumentTraceOutput = ""
+	docInfo.LastError = ""
+	docInfo.ResultHash = ""
+	docInfo.ExecutedOffline = false
+	docInfo.ResultAcknowledged = false
+
+	// the document is pending before its completed state is removed, so that a crash in between doesn't lose it
+	setDocState(log, docState, docStateFileName(documentID, instanceID, appconfig.DefaultLocationOfPending), appconfig.DefaultLocationOfPending)
+	if !docStateExists(docStateFileName(documentID, instanceID, appconfig.DefaultLocationOfPending)) {
+		return model.DocumentState{}, fmt.Errorf("failed to move document %v back to %v", documentID, appconfig.DefaultLocationOfPending)
+	}
+	if err = deleteDocState(terminalFileName); err != nil {
+		log.Errorf("failed to remove the completed state %v of the document run again: %v", terminalFileName, err)
+	}
+	removeSignature(log, terminalFileName)
+	removeSummary(log, terminalFileName)
+	return docState, nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// mixedResultsDocState returns the state of a document whose second and fourth plugins failed
+func mixedResultsDocState() model.DocumentState {
+	docState := completedDocState()
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	docState.DocumentInformation.LastError = "second failed"
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Id: "first", Name: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "first done"}},
+		{Id: "second", Name: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusFailed, Code: 1, Output: "second failed"}},
+		{Id: "third", Name: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusSkipped, Output: "third skipped"}},
+		{Id: "fourth", Name: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusTimedOut, Code: 1}},
+	}
+	return docState
+}
+
+func TestResetFailedPlugins(t *testing.T) {
+	defer setTestDataStore(t)()
+	completed := mixedResultsDocState()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfFailed, completed)
+
+	docState, err := ResetFailedPlugins(testLog, testDocumentID, testInstanceID)
+
+	assert.NoError(t, err)
+	assert.Equal(t, contracts.ResultStatusInProgress, docState.DocumentInformation.DocumentStatus)
+	assert.Empty(t, docState.DocumentInformation.LastError)
+	plugins := docState.InstancePluginsInformation
+	// the results of the plugins that didn't fail are kept
+	assert.Equal(t, completed.InstancePluginsInformation[0], plugins[0])
+	assert.Equal(t, completed.InstancePluginsInformation[2], plugins[2])
+	for _, index := range []int{1, 3} {
+		assert.Equal(t, contracts.PluginResult{PluginName: "aws:runShellScript", Status: contracts.ResultStatusNotStarted}, plugins[index].Result)
+	}
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfFailed)))
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending))
+}
+
+func TestResetFailedPluginsOfSucceededDocument(t *testing.T) {
+	defer setTestDataStore(t)()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, completedDocState())
+
+	_, err := ResetFailedPlugins(testLog, testDocumentID, testInstanceID)
+
+	assert.Error(t, err)
+	assert.True(t, docStateExists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)))
+	assert.False(t, docStateExists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending)))
+}
+
+func TestResetFailedPluginsOfRunningDocument(t *testing.T) {
+	defer setTestDataStore(t)()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, mixedResultsDocState())
+
+	_, err := ResetFailedPlugins(testLog, testDocumentID, testInstanceID)
+
+	assert.Error(t, err)
+	assert.True(t, docStateExists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+}
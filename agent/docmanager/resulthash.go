@@ -0,0 +1,123 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// resultHashEntry is the part of the result of a plugin the result hash of a document covers,
+// the timestamps and the S3 destinations are left out so that identical runs hash the same
+type resultHashEntry struct {
+	PluginID       string
+	Status         contracts.ResultStatus
+	Code           int
+	Output         interface{}
+	StandardOutput string
+	StandardError  string
+}
+
+// ComputeResultHash returns the hex encoded sha256 of the final status of the document and of the normalized results of its plugins,
+// the plugins are sorted by id so that the hash doesn't depend on the order they completed in
+func ComputeResultHash(docState model.DocumentState) (string, error) {
+	entries := make([]resultHashEntry, 0, len(docState.InstancePluginsInformation))
+	for _, pluginState := range docState.InstancePluginsInformation {
+		pluginID := pluginState.Id
+		if pluginID == "" {
+			pluginID = pluginState.Name
+		}
+		output := pluginState.Result.Output
+		if text, ok := output.(string); ok {
+			output = normalizeResultOutput(text)
+		}
+		entries = append(entries, resultHashEntry{
+			PluginID:       pluginID,
+			Status:         pluginState.Result.Status,
+			Code:           pluginState.Result.Code,
+			Output:         output,
+			StandardOutput: normalizeResultOutput(pluginState.Result.StandardOutput),
+			StandardError:  normalizeResultOutput(pluginState.Result.StandardError),
+		})
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].PluginID < entries[j].PluginID
+	})
+	// json sorts the keys of the map outputs, the content is the same whatever the order they were built in
+	content, err := json.Marshal(struct {
+		DocumentStatus contracts.ResultStatus
+		Plugins        []resultHashEntry
+	}{docState.DocumentInformation.DocumentStatus, entries})
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// normalizeResultOutput makes the line endings of the output platform independent and drops its trailing white spaces
+func normalizeResultOutput(output string) string {
+	return strings.TrimRight(strings.Replace(output, "\r\n", "\n", -1), " \t\r\n")
+}
+
+// SetDocumentResultHash computes the result hash of the document in the given folder and records it in its document information
+func SetDocumentResultHash(log log.T, documentID, instanceID, locationFolder string) error {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return err
+	}
+
+	absoluteFileName := docStateFileName(documentID, instanceID, locationFolder)
+
+	docMutex := lockDocument(instanceID, documentID)
+	defer unlockDocument(instanceID, documentID, docMutex)
+
+	if !docStateExists(absoluteFileName) {
+		return fmt.Errorf("document %v not found in %v", documentID, locationFolder)
+	}
+	docState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if err != nil {
+		return err
+	}
+	// hash the offloaded outputs without bringing them back into the persisted state
+	hashed := docState
+	hashed.InstancePluginsInformation = append([]model.PluginState(nil), docState.InstancePluginsInformation...)
+	rehydratePluginOutputs(log, instanceID, &hashed)
+	resultHash, err := ComputeResultHash(hashed)
+	if err != nil {
+		return err
+	}
+	docState.DocumentInformation.ResultHash = resultHash
+	setDocState(log, docState, absoluteFileName, locationFolder)
+	return nil
+}
+
+// GetDocumentResultHash returns the result hash recorded when the document completed, empty if the document isn't found
+// in the terminal folders or completed before the hash was recorded
+func GetDocumentResultHash(log log.T, documentID, instanceID string) string {
+	for _, locationFolder := range terminalLocationFolders {
+		if docStateExists(docStateFileName(documentID, instanceID, locationFolder)) {
+			return GetDocumentInfo(log, documentID, instanceID, locationFolder).ResultHash
+		}
+	}
+	return ""
+}
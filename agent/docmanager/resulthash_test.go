@@ -0,0 +1,98 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// newHashedDocument returns a completed document with the given plugin results
+func newHashedDocument(plugins ...model.PluginState) model.DocumentState {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+	docState.InstancePluginsInformation = plugins
+	return docState
+}
+
+func TestComputeResultHashIsDeterministic(t *testing.T) {
+	plugin1 := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "line1\r\nline2\r\n", StartDateTime: time.Now()}}
+	plugin2 := model.PluginState{Id: "plugin2", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: map[string]interface{}{"a": 1, "b": "two"}}}
+	otherPlugin1 := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "line1\nline2", StartDateTime: time.Now().Add(time.Hour)}}
+	otherPlugin2 := model.PluginState{Id: "plugin2", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: map[string]interface{}{"b": "two", "a": 1}}}
+
+	hash, err := ComputeResultHash(newHashedDocument(plugin1, plugin2))
+	assert.NoError(t, err)
+	assert.Len(t, hash, 64)
+	// the plugins completed in a different order, at a different time and on a platform with other line endings
+	otherHash, err := ComputeResultHash(newHashedDocument(otherPlugin2, otherPlugin1))
+	assert.NoError(t, err)
+	assert.Equal(t, hash, otherHash)
+}
+
+func TestComputeResultHashChangesWithResult(t *testing.T) {
+	plugin := model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "done"}}
+	hash, err := ComputeResultHash(newHashedDocument(plugin))
+	assert.NoError(t, err)
+
+	changedOutput := plugin
+	changedOutput.Result.Output = "done again"
+	changedStatus := plugin
+	changedStatus.Result.Status = contracts.ResultStatusFailed
+	changedCode := plugin
+	changedCode.Result.Code = 1
+	renamed := plugin
+	renamed.Id = "plugin2"
+	for _, changed := range []model.PluginState{changedOutput, changedStatus, changedCode, renamed} {
+		otherHash, err := ComputeResultHash(newHashedDocument(changed))
+		assert.NoError(t, err)
+		assert.NotEqual(t, hash, otherHash)
+	}
+
+	failedDocument := newHashedDocument(plugin)
+	failedDocument.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+	otherHash, err := ComputeResultHash(failedDocument)
+	assert.NoError(t, err)
+	assert.NotEqual(t, hash, otherHash)
+}
+
+func TestSetDocumentResultHash(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestOffloadThreshold(64)()
+	largeOutput := strings.Repeat("x", 1024)
+	docState := newHashedDocument(model.PluginState{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: largeOutput}})
+	expected, err := ComputeResultHash(docState)
+	assert.NoError(t, err)
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	assert.NoError(t, SetDocumentResultHash(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	// the offloaded output is hashed and stays offloaded
+	assert.Equal(t, expected, GetDocumentResultHash(testLog, testDocumentID, testInstanceID))
+	persisted, _ := getDocState(testLog, docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted), testInstanceID)
+	assert.NotEmpty(t, persisted.InstancePluginsInformation[0].OutputFile)
+
+	assert.Empty(t, GetDocumentResultHash(testLog, "unknown", testInstanceID))
+	assert.Error(t, SetDocumentResultHash(testLog, "unknown", testInstanceID, appconfig.DefaultLocationOfCurrent))
+	deleteLock(testInstanceID, "unknown")
+}
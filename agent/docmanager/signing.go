@@ -0,0 +1,174 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// signaturesFolderName is the folder, next to the state folders, holding the signature of each document state
+const signaturesFolderName = "signatures"
+
+// SigningKeyProvider returns the key the persisted document states are signed with.
+type SigningKeyProvider func() ([]byte, error)
+
+// StateSigningKey provides the key of the HMAC-SHA256 signing the persisted document states,
+// nil disables the signing
+var StateSigningKey SigningKeyProvider
+
+// stateChecksumsEnabled returns true if the checksum of the document states is recorded and verified even if they aren't signed
+var stateChecksumsEnabled = func() bool {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return false
+	}
+	return config.Ssm.VerifyStateChecksums
+}
+
+// stateSignature is the sidecar of a signed document state, or of a state whose checksum only is recorded
+type stateSignature struct {
+	// Checksum is the hex encoded SHA-256 of the state, it detects accidental corruption
+	Checksum string
+	// Signature is the hex encoded HMAC-SHA256 of the state, it detects deliberate changes, empty if the state isn't signed
+	Signature string `json:",omitempty"`
+}
+
+// TamperError reports a document state that was changed without the signing key,
+// the state is still consistent with its checksum, or its signature is missing
+type TamperError struct {
+	Path string
+}
+
+func (e *TamperError) Error() string {
+	return fmt.Sprintf("document state %v doesn't match its signature, it may have been tampered with", e.Path)
+}
+
+// ChecksumError reports a document state whose content changed since it was written, e.g. a corrupted disk
+type ChecksumError struct {
+	Path string
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("document state %v doesn't match its checksum", e.Path)
+}
+
+// signatureFileName returns the path of the signature of the given document state,
+// the signature of a document is kept in the same place whatever the state folder the document moves to
+func signatureFileName(absoluteFileName string) string {
+	stateDir := filepath.Dir(filepath.Dir(absoluteFileName))
+	return filepath.Join(stateDir, signaturesFolderName, filepath.Base(absoluteFileName))
+}
+
+// checksumContent returns the hex encoded SHA-256 of the content
+func checksumContent(content []byte) string {
+	checksum := sha256.Sum256(content)
+	return hex.EncodeToString(checksum[:])
+}
+
+// signContent returns the checksum and the signature of the content
+func signContent(key, content []byte) stateSignature {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(content)
+	return stateSignature{
+		Checksum:  checksumContent(content),
+		Signature: hex.EncodeToString(mac.Sum(nil)),
+	}
+}
+
+// signDocState writes the signature of the document state content just persisted if the signing is enabled,
+// or its checksum only if the checksums are enabled
+func signDocState(log log.T, absoluteFileName, content string) {
+	sidecar := stateSignature{Checksum: checksumContent([]byte(content))}
+	if StateSigningKey != nil {
+		key, err := StateSigningKey()
+		if err != nil {
+			log.Errorf("failed to get the key to sign %v: %v", absoluteFileName, err)
+			return
+		}
+		sidecar = signContent(key, []byte(content))
+	} else if !stateChecksumsEnabled() {
+		return
+	}
+	signaturePath := signatureFileName(absoluteFileName)
+	if err := fileutil.MakeDirs(filepath.Dir(signaturePath)); err != nil {
+		log.Errorf("failed to create the signatures folder of %v: %v", absoluteFileName, err)
+		return
+	}
+	signature, err := jsonutil.Marshal(sidecar)
+	if err != nil {
+		log.Errorf("failed to marshal the signature of %v: %v", absoluteFileName, err)
+		return
+	}
+	if _, err = fileutil.WriteIntoFileWithPermissions(signaturePath, signature, os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		log.Errorf("failed to sign %v: %v", absoluteFileName, err)
+	}
+}
+
+// verifyDocState checks the content read from the document state against its signature if the signing is enabled,
+// or against its checksum if the checksums are enabled, a state persisted without its checksum is taken as is.
+// It returns a ChecksumError if the content got corrupted and a TamperError if it was changed without the signing key.
+func verifyDocState(absoluteFileName string, content []byte) error {
+	if StateSigningKey == nil {
+		if !stateChecksumsEnabled() {
+			return nil
+		}
+		var recorded stateSignature
+		if err := jsonutil.UnmarshalFile(signatureFileName(absoluteFileName), &recorded); err != nil || recorded.Checksum == "" {
+			return nil
+		}
+		if checksumContent(content) != recorded.Checksum {
+			return &ChecksumError{Path: absoluteFileName}
+		}
+		return nil
+	}
+	key, err := StateSigningKey()
+	if err != nil {
+		return fmt.Errorf("failed to get the key to verify %v: %v", absoluteFileName, err)
+	}
+	var recorded stateSignature
+	if err = jsonutil.UnmarshalFile(signatureFileName(absoluteFileName), &recorded); err != nil {
+		return &TamperError{Path: absoluteFileName}
+	}
+	expected := signContent(key, content)
+	if expected.Checksum != recorded.Checksum {
+		// a deliberate change would come with a matching checksum, the state was corrupted
+		return &ChecksumError{Path: absoluteFileName}
+	}
+	if !hmac.Equal([]byte(expected.Signature), []byte(recorded.Signature)) {
+		return &TamperError{Path: absoluteFileName}
+	}
+	return nil
+}
+
+// removeSignature deletes the signature of the deleted document state
+func removeSignature(log log.T, absoluteFileName string) {
+	signaturePath := signatureFileName(absoluteFileName)
+	if !fileutil.Exists(signaturePath) {
+		return
+	}
+	if err := fileutil.DeleteFile(signaturePath); err != nil {
+		log.Debugf("Error deleting signature %v: %v", signaturePath, err)
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// persistSignedDocState persists a document state signed with a test key, returns its path
+func persistSignedDocState(t *testing.T) string {
+	StateSigningKey = func() ([]byte, error) { return []byte("test signing key"), nil }
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	return docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+}
+
+func TestReadSignedDocState(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func() { StateSigningKey = nil }()
+	fileName := persistSignedDocState(t)
+
+	docState, err := readDocState(fileName)
+
+	assert.NoError(t, err)
+	assert.Equal(t, testDocumentID, docState.DocumentInformation.DocumentID)
+	assert.True(t, fileutil.Exists(signatureFileName(fileName)))
+}
+
+func TestReadCorruptedDocState(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func() { StateSigningKey = nil }()
+	fileName := persistSignedDocState(t)
+	content, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	corrupted := strings.Replace(string(content), string(contracts.ResultStatusInProgress), string(contracts.ResultStatusSuccess), 1)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(corrupted), 0600))
+
+	_, err = readDocState(fileName)
+
+	assert.IsType(t, &ChecksumError{}, err)
+	assert.Empty(t, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent).DocumentInformation.DocumentID)
+}
+
+func TestReadTamperedDocState(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func() { StateSigningKey = nil }()
+	fileName := persistSignedDocState(t)
+	content, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	tampered := strings.Replace(string(content), string(contracts.ResultStatusInProgress), string(contracts.ResultStatusSuccess), 1)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(tampered), 0600))
+	// whoever changed the state can recompute its checksum but not its signature without the key
+	var signature stateSignature
+	assert.NoError(t, jsonutil.UnmarshalFile(signatureFileName(fileName), &signature))
+	signature.Checksum = signContent([]byte("another key"), []byte(tampered)).Checksum
+	sidecar, err := jsonutil.Marshal(signature)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(signatureFileName(fileName), []byte(sidecar), 0600))
+
+	_, err = readDocState(fileName)
+
+	assert.IsType(t, &TamperError{}, err)
+}
+
+func TestReadUnsignedDocState(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func() { StateSigningKey = nil }()
+	fileName := persistSignedDocState(t)
+	assert.NoError(t, os.Remove(signatureFileName(fileName)))
+
+	_, err := readDocState(fileName)
+	assert.IsType(t, &TamperError{}, err)
+
+	// states aren't verified with the signing disabled
+	StateSigningKey = nil
+	_, err = readDocState(fileName)
+	assert.NoError(t, err)
+}
+
+func TestSignatureFollowsDocumentState(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer func() { StateSigningKey = nil }()
+	fileName := persistSignedDocState(t)
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+	_, err := readDocState(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+	assert.NoError(t, err)
+
+	RemoveData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.False(t, fileutil.Exists(signatureFileName(fileName)))
+}
+
+// setTestStateChecksums sets whether the checksums of the unsigned states are verified, returns the function restoring the setting
+func setTestStateChecksums(enabled bool) func() {
+	origStateChecksumsEnabled := stateChecksumsEnabled
+	stateChecksumsEnabled = func() bool { return enabled }
+	return func() { stateChecksumsEnabled = origStateChecksumsEnabled }
+}
+
+// flipByte persists a document state and flips a byte of its document name, the state still parses
+func flipByte(t *testing.T) string {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentName = "AWS-RunShellScript"
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	content, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	i := strings.Index(string(content), "RunShellScript")
+	content[i] ^= 0x20
+	assert.NoError(t, ioutil.WriteFile(fileName, content, 0600))
+	return fileName
+}
+
+func TestReadDocStateWithFlippedByte(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestStateChecksums(true)()
+	fileName := flipByte(t)
+
+	_, err := readDocState(fileName)
+	assert.IsType(t, &ChecksumError{}, err)
+
+	_, err = GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	if assert.IsType(t, &CorruptStateError{}, err) {
+		assert.NotEmpty(t, err.(*CorruptStateError).QuarantinePath)
+		assert.True(t, fileutil.Exists(err.(*CorruptStateError).QuarantinePath))
+	}
+	assert.False(t, fileutil.Exists(fileName))
+}
+
+func TestReadDocStateWithFlippedByteUnverified(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestStateChecksums(false)()
+	fileName := flipByte(t)
+
+	docState, err := readDocState(fileName)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AWS-runShellScript", docState.DocumentInformation.DocumentName)
+	assert.False(t, fileutil.Exists(signatureFileName(fileName)))
+}
+
+func TestReadDocStatePersistedBeforeChecksumsEnabled(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestStateChecksums(false)()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	stateChecksumsEnabled = func() bool { return true }
+
+	_, err := readDocState(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+
+	assert.NoError(t, err)
+}
@@ -0,0 +1,202 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
+)
+
+// sizeCapPreservedDocuments is the number of most recent terminal documents whose state is never purged to honor the size cap,
+// only their orchestration output is. The state of a recent document is what keeps a redelivered message from running again.
+var sizeCapPreservedDocuments = 100
+
+// terminalDocument is a document state found in a terminal folder
+type terminalDocument struct {
+	documentID     string
+	locationFolder string
+	modTime        time.Time
+}
+
+// FolderUsage returns the size in bytes of the files of the data store of the instance,
+// i.e. the document states along with the orchestration output of the documents
+func FolderUsage(instanceID string) (int64, error) {
+	if err := ValidateDataStorePath(); err != nil {
+		return 0, err
+	}
+	if err := ValidateInstanceID(instanceID); err != nil {
+		return 0, err
+	}
+	return fileutil.GetPathSize(filepath.Join(dataStorePath, instanceID))
+}
+
+// EnforceDataStoreSizeCap purges the oldest terminal documents along with their orchestration dirs until the data store of the instance
+// uses no more than maxBytes. The state of the most recent documents is kept, their orchestration dirs are purged last.
+// It returns the usage of the data store once purged, which is still above maxBytes if nothing more could be purged.
+func EnforceDataStoreSizeCap(log log.T, instanceID string, maxBytes int64) int64 {
+	usage, err := FolderUsage(instanceID)
+	if err != nil {
+		log.Errorf("failed to compute the size of the data store: %v", err)
+		return usage
+	}
+	if usage <= maxBytes {
+		return usage
+	}
+	log.Infof("the data store uses %v bytes, above its %v bytes cap, purging the oldest documents", usage, maxBytes)
+
+	documents := listTerminalDocuments(log, instanceID)
+	// oldest first
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].modTime.Before(documents[j].modTime)
+	})
+	preservedFrom := len(documents) - sizeCapPreservedDocuments
+	owners := collectOrchestrationDirOwners(log, instanceID)
+
+	for index, document := range documents {
+		if usage <= maxBytes {
+			break
+		}
+		usage -= purgeDocument(log, instanceID, document, owners, index < preservedFrom, "")
+	}
+	if usage > maxBytes {
+		log.Warnf("the data store still uses %v bytes once purged, above its %v bytes cap", usage, maxBytes)
+	}
+	return usage
+}
+
+// EnforceDocumentCountLimit keeps the maxDocuments most recently modified documents of the terminal folders and deletes the older ones
+// along with their orchestration dirs, regardless of their age. The orchestration dir of a document that doesn't record it is looked up
+// under orchestrationRootDirName. It returns the number of documents deleted.
+func EnforceDocumentCountLimit(log log.T, instanceID, orchestrationRootDirName string, maxDocuments int) (deleted int) {
+	if checkDataStorePath(log, instanceID) != nil {
+		return
+	}
+	if maxDocuments < 0 {
+		maxDocuments = 0
+	}
+	documents := listTerminalDocuments(log, instanceID)
+	if len(documents) <= maxDocuments {
+		return
+	}
+	log.Infof("%v documents are retained, above the %v documents limit, deleting the oldest ones", len(documents), maxDocuments)
+
+	// newest first
+	sort.Slice(documents, func(i, j int) bool {
+		return documents[i].modTime.After(documents[j].modTime)
+	})
+	owners := collectOrchestrationDirOwners(log, instanceID)
+	orchestrationRootDir := orchestrationDir(instanceID, orchestrationRootDirName)
+	for _, document := range documents[maxDocuments:] {
+		purgeDocument(log, instanceID, document, owners, true, orchestrationRootDir)
+		if !docStateExists(docStateFileName(document.documentID, instanceID, document.locationFolder)) {
+			deleted++
+		}
+	}
+	return
+}
+
+// listTerminalDocuments returns the documents of the terminal folders of the instance
+func listTerminalDocuments(log log.T, instanceID string) (documents []terminalDocument) {
+	for _, locationFolder := range terminalLocationFolders {
+		stateDir := DocumentStateDir(instanceID, locationFolder)
+		files, err := store.List(stateDir)
+		if err != nil {
+			log.Debugf("failed to list the documents of %v: %v", locationFolder, err)
+			continue
+		}
+		for _, file := range files {
+			if strings.HasSuffix(file, moveIntermediateSuffix) {
+				continue
+			}
+			info, err := store.Stat(filepath.Join(stateDir, file))
+			if err != nil {
+				continue
+			}
+			documents = append(documents, terminalDocument{documentID: documentIDOfStateFile(file), locationFolder: locationFolder, modTime: info.ModTime})
+		}
+	}
+	return
+}
+
+// purgeDocument deletes the orchestration dirs of the terminal document, and its state as well if deleteState is set,
+// it returns the number of bytes freed. The orchestration dir of a document that doesn't record it is derived from orchestrationRootDir, if set.
+func purgeDocument(log log.T, instanceID string, document terminalDocument, owners orchestrationDirOwners, deleteState bool, orchestrationRootDir string) (freed int64) {
+	deleted := false
+	defer func() {
+		if deleted {
+			deleteLock(instanceID, document.documentID)
+		}
+	}()
+	docMutex := lockDocument(instanceID, document.documentID)
+	defer unlockDocument(instanceID, document.documentID, docMutex)
+
+	absoluteFileName := docStateFileName(document.documentID, instanceID, document.locationFolder)
+	docState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if isCorruptState(err) {
+		log.Warnf("not purging document %v: %v", document.documentID, err)
+		return
+	}
+	orchestrationDirs := documentOrchestrationDirs(docState)
+	if len(orchestrationDirs) == 0 && orchestrationRootDir != "" {
+		orchestrationDirs = []string{filepath.Join(orchestrationRootDir, document.documentID)}
+	}
+	for _, orchestrationDirFullPath := range orchestrationDirs {
+		if !fileutil.Exists(orchestrationDirFullPath) {
+			continue
+		}
+		if err := owners.collision(orchestrationDirFullPath, document.documentID, owners.commandID(orchestrationDirFullPath, document.documentID)); err != nil {
+			log.Warnf("keeping the orchestration dir of document %v: %v", document.documentID, err)
+			continue
+		}
+		size, _ := fileutil.GetPathSize(orchestrationDirFullPath)
+		log.Debugf("Attempting Deletion of folder : %v", orchestrationDirFullPath)
+		if err := deleteOrchestrationDir(log, orchestrationDirFullPath); err != nil {
+			log.Debugf("Error deleting dir %v, recording it for a later pass: %v", orchestrationDirFullPath, err)
+			recordLeftover(log, instanceID, orchestrationDirFullPath)
+			continue
+		}
+		freed += size
+	}
+	if !deleteState {
+		return
+	}
+
+	info, _ := statDocState(absoluteFileName)
+	size := info.Size
+	if offloaded, err := fileutil.GetPathSize(offloadedOutputPath(instanceID, document.documentID)); err == nil {
+		size += offloaded
+	}
+	log.Debugf("Attempting Deletion of file : %v", absoluteFileName)
+	if err := deleteDocState(absoluteFileName); err != nil {
+		log.Debugf("Error deleting file %v: %v", absoluteFileName, err)
+		metrics.DefaultSink.IncrCounter(metrics.CleanupFailedDeletions, 1)
+		return
+	}
+	removeClaim(log, document.documentID, instanceID)
+	removeSignature(log, absoluteFileName)
+	removeSummary(log, absoluteFileName)
+	removeOffloadedOutputs(log, document.documentID, instanceID)
+	removeRawMessage(log, document.documentID, instanceID)
+	owners.remove(document.documentID)
+	metrics.DefaultSink.IncrCounter(metrics.CleanupDeletedDocuments, 1)
+	deleted = true
+	return freed + size
+}
@@ -0,0 +1,145 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps persist documents state to disk
+package docmanager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// seedSizeCapDocuments persists count completed documents, each with a 10KB orchestration output, the first one the oldest
+func seedSizeCapDocuments(t *testing.T, count int) (documentIDs []string) {
+	for i := 0; i < count; i++ {
+		documentID := fmt.Sprintf("document%v", i)
+		orchestrationDir := filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), documentID)
+		assert.NoError(t, fileutil.MakeDirs(orchestrationDir))
+		_, err := fileutil.WriteIntoFileWithPermissions(filepath.Join(orchestrationDir, "stdout"), strings.Repeat("o", 10*1024), os.FileMode(int(appconfig.ReadWriteAccess)))
+		assert.NoError(t, err)
+
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentID = documentID
+		docState.DocumentInformation.CommandID = documentID
+		docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+		docState.DocumentInformation.OrchestrationDirectory = orchestrationDir
+		PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+		modTime := time.Now().Add(time.Duration(i-count) * time.Hour)
+		assert.NoError(t, os.Chtimes(docStateFileName(documentID, testInstanceID, appconfig.DefaultLocationOfCompleted), modTime, modTime))
+		documentIDs = append(documentIDs, documentID)
+	}
+	return
+}
+
+func setTestSizeCapPreservedDocuments(preserved int) func() {
+	origPreserved := sizeCapPreservedDocuments
+	sizeCapPreservedDocuments = preserved
+	return func() { sizeCapPreservedDocuments = origPreserved }
+}
+
+func TestEnforceDataStoreSizeCapPurgesOldestDocuments(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestSizeCapPreservedDocuments(1)()
+	documentIDs := seedSizeCapDocuments(t, 5)
+	usage, err := FolderUsage(testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, usage > 50*1024)
+
+	maxBytes := int64(32 * 1024)
+	purgedUsage := EnforceDataStoreSizeCap(testLog, testInstanceID, maxBytes)
+
+	usage, err = FolderUsage(testInstanceID)
+	assert.NoError(t, err)
+	assert.True(t, usage <= maxBytes, "usage %v is above the cap", usage)
+	assert.True(t, purgedUsage <= maxBytes)
+	// the oldest documents are purged, the newest ones are kept
+	assert.False(t, IsDocumentCompleted(documentIDs[0], testInstanceID))
+	assert.False(t, IsDocumentCompleted(documentIDs[1], testInstanceID))
+	assert.True(t, IsDocumentCompleted(documentIDs[3], testInstanceID))
+	assert.True(t, IsDocumentCompleted(documentIDs[4], testInstanceID))
+	assert.True(t, fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), documentIDs[4])))
+}
+
+func TestEnforceDataStoreSizeCapPreservesRecentDocumentStates(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestSizeCapPreservedDocuments(2)()
+	documentIDs := seedSizeCapDocuments(t, 3)
+
+	// the cap can't be honored without purging every document
+	EnforceDataStoreSizeCap(testLog, testInstanceID, 1)
+
+	assert.False(t, IsDocumentCompleted(documentIDs[0], testInstanceID))
+	for _, documentID := range documentIDs[1:] {
+		// the state of the recent documents is kept, their output is purged
+		assert.True(t, IsDocumentCompleted(documentID, testInstanceID))
+		assert.False(t, fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), documentID)))
+	}
+}
+
+func TestEnforceDataStoreSizeCapUnderTheCap(t *testing.T) {
+	defer setTestDataStore(t)()
+	documentIDs := seedSizeCapDocuments(t, 2)
+
+	EnforceDataStoreSizeCap(testLog, testInstanceID, 1024*1024)
+
+	for _, documentID := range documentIDs {
+		assert.True(t, IsDocumentCompleted(documentID, testInstanceID))
+	}
+}
+
+func TestEnforceDocumentCountLimitKeepsMostRecentDocuments(t *testing.T) {
+	defer setTestDataStore(t)()
+	documentIDs := seedSizeCapDocuments(t, 5)
+
+	deleted := EnforceDocumentCountLimit(testLog, testInstanceID, "awsrunCommand", 2)
+
+	assert.Equal(t, 3, deleted)
+	for _, documentID := range documentIDs[:3] {
+		assert.False(t, IsDocumentCompleted(documentID, testInstanceID))
+		assert.False(t, fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), documentID)))
+	}
+	for _, documentID := range documentIDs[3:] {
+		assert.True(t, IsDocumentCompleted(documentID, testInstanceID))
+		assert.True(t, fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), documentID)))
+	}
+
+	// under the limit nothing is deleted
+	assert.Equal(t, 0, EnforceDocumentCountLimit(testLog, testInstanceID, "awsrunCommand", 2))
+}
+
+func TestEnforceDocumentCountLimitDerivesUnrecordedOrchestrationDir(t *testing.T) {
+	defer setTestDataStore(t)()
+	documentIDs := seedSizeCapDocuments(t, 2)
+	docStatePath := docStateFileName(documentIDs[0], testInstanceID, appconfig.DefaultLocationOfCompleted)
+	modTime, _ := fileutil.GetFileModificationTime(docStatePath)
+	docState := GetDocumentInterimState(testLog, documentIDs[0], testInstanceID, appconfig.DefaultLocationOfCompleted)
+	docState.DocumentInformation.OrchestrationDirectory = ""
+	PersistData(testLog, documentIDs[0], testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+	assert.NoError(t, os.Chtimes(docStatePath, modTime, modTime))
+
+	assert.Equal(t, 1, EnforceDocumentCountLimit(testLog, testInstanceID, "awsrunCommand", 1))
+
+	assert.False(t, IsDocumentCompleted(documentIDs[0], testInstanceID))
+	assert.False(t, fileutil.Exists(filepath.Join(orchestrationDir(testInstanceID, "awsrunCommand"), documentIDs[0])))
+	assert.True(t, IsDocumentCompleted(documentIDs[1], testInstanceID))
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+)
+
+// compressedStateSuffix ends the file name of a gzipped document state, the states without it are plain json
+const compressedStateSuffix = ".gz"
+
+// compressCompletedStates returns true if the document states are gzipped once they're moved to the completed folder
+var compressCompletedStates = func() bool {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return false
+	}
+	return config.Ssm.CompressCompletedStates
+}
+
+// compressesStates returns true if the document states of the folder are persisted gzipped
+func compressesStates(locationFolder string) bool {
+	return locationFolder == appconfig.DefaultLocationOfCompleted && compressCompletedStates()
+}
+
+// documentIDOfStateFile returns the id of the document whose state is persisted in the file, gzipped or not
+func documentIDOfStateFile(fileName string) string {
+	return strings.TrimSuffix(fileName, compressedStateSuffix)
+}
+
+// storedDocStateFileName returns the file the document state is persisted in, its gzipped file if it has no plain json file
+func storedDocStateFileName(absoluteFileName string) string {
+	if _, err := store.Stat(absoluteFileName); os.IsNotExist(err) {
+		if _, err = store.Stat(absoluteFileName + compressedStateSuffix); err == nil {
+			return absoluteFileName + compressedStateSuffix
+		}
+	}
+	return absoluteFileName
+}
+
+// getDocStateContent returns the json content of the document state, decrypted if it was encrypted and read from its gzipped file
+// if it has no plain json file.
+// A gzipped file that can't be read back is reported as a CorruptStateError.
+func getDocStateContent(absoluteFileName string) ([]byte, error) {
+	content, err := store.Get(absoluteFileName)
+	if !os.IsNotExist(err) {
+		if err != nil {
+			return nil, err
+		}
+		return openDocState(content)
+	}
+	compressed, compressedErr := store.Get(absoluteFileName + compressedStateSuffix)
+	if compressedErr != nil {
+		return nil, err
+	}
+	if compressed, err = openDocState(compressed); err != nil {
+		return nil, err
+	}
+	if content, err = (gzipCodec{}).Decompress(compressed); err != nil {
+		return nil, &CorruptStateError{Path: absoluteFileName + compressedStateSuffix, Err: err}
+	}
+	return content, nil
+}
+
+// putDocState persists the json content of the document state, gzipped if the folder compresses its states and then encrypted
+// if the states are, and drops the copy of the state persisted in the other format
+func putDocState(absoluteFileName, locationFolder string, content []byte) error {
+	docStateCache.invalidate(absoluteFileName)
+	storedFileName, staleFileName := absoluteFileName, absoluteFileName+compressedStateSuffix
+	if compressesStates(locationFolder) {
+		compressed, err := gzipCodec{}.Compress(content)
+		if err != nil {
+			return err
+		}
+		content = compressed
+		storedFileName, staleFileName = staleFileName, storedFileName
+	}
+	content, err := sealDocState(content)
+	if err != nil {
+		return err
+	}
+	if err := store.Put(storedFileName, content); err != nil {
+		return err
+	}
+	store.Delete(staleFileName)
+	return nil
+}
+
+// deleteDocState deletes the document state, gzipped or not, the error satisfies os.IsNotExist if there was none
+func deleteDocState(absoluteFileName string) error {
+	docStateCache.invalidate(absoluteFileName)
+	err := store.Delete(absoluteFileName)
+	if compressedErr := store.Delete(absoluteFileName + compressedStateSuffix); compressedErr == nil && os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// setTestCompressCompletedStates sets whether the completed states are gzipped, returns the function restoring the setting
+func setTestCompressCompletedStates(compress bool) func() {
+	origCompressCompletedStates := compressCompletedStates
+	compressCompletedStates = func() bool { return compress }
+	return func() { compressCompletedStates = origCompressCompletedStates }
+}
+
+// completedDocState returns the state of a document that ran one plugin
+func completedDocState() model.DocumentState {
+	docState := model.DocumentState{DocumentType: model.SendCommand}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentName = "AWS-RunShellScript"
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSuccess
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Id: "aws:runShellScript", Name: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "done"}},
+	}
+	return docState
+}
+
+func TestMoveDocumentStateCompressesCompletedStates(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestCompressCompletedStates(true)()
+	docState := completedDocState()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.False(t, fileutil.Exists(fileName))
+	compressed, err := ioutil.ReadFile(fileName + compressedStateSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, gzipCodec{}.Magic(), compressed[:2])
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+	assert.Equal(t, docState.DocumentInformation, GetDocumentInfo(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+	assert.Equal(t, appconfig.DefaultLocationOfCompleted, FindTerminalLocationFolder(testDocumentID, testInstanceID))
+	assert.Equal(t, map[string]string{testDocumentID: "AWS-RunShellScript"}, DocumentNames(testLog, testInstanceID, appconfig.DefaultLocationOfCompleted))
+}
+
+func TestCompressedStatesAreUpdatedAndRemoved(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestCompressCompletedStates(true)()
+	docState := completedDocState()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.True(t, fileutil.Exists(fileName+compressedStateSuffix))
+
+	amended := contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "amended"}
+	assert.NoError(t, AmendPluginResult(testLog, testDocumentID, testInstanceID, "aws:runShellScript", amended))
+	assert.False(t, fileutil.Exists(fileName))
+	amendedState := GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, "amended", amendedState.InstancePluginsInformation[0].Result.Output)
+
+	RemoveData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.False(t, fileutil.Exists(fileName+compressedStateSuffix))
+}
+
+func TestPlainCompletedStatesStillLoad(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := completedDocState()
+	// persisted before the compression was enabled
+	restore := setTestCompressCompletedStates(false)
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState)
+	restore()
+	defer setTestCompressCompletedStates(true)()
+
+	assert.True(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)))
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+}
+
+func TestDeleteOldDocumentFolderLogsDeletesCompressedStates(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestCompressCompletedStates(true)()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, completedDocState())
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.True(t, fileutil.Exists(fileName+compressedStateSuffix))
+
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, "awsrunCommand", 0, nil, appconfig.DefaultMaxDocumentLogDeletionsPerRun,
+		func(fileName string) bool { return fileName == testDocumentID },
+		func(fileName string) string { return fileName })
+
+	assert.False(t, fileutil.Exists(fileName+compressedStateSuffix))
+	assert.False(t, docStateExists(fileName))
+}
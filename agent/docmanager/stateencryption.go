@@ -0,0 +1,177 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+)
+
+// encryptedStateHeader starts the content of an encrypted document state, it's followed by the nonce and the sealed content.
+// The states without it are read as they are, so that the states persisted before the encryption was enabled still load.
+var encryptedStateHeader = []byte("SSM-ENCRYPTED-STATE-V1\n")
+
+// StateEncryptionKey provides the AES key, of 16, 24 or 32 bytes, the whole persisted document states are encrypted with,
+// nil uses the key configured with the Ssm.StateEncryptionKeyFile setting
+var StateEncryptionKey EncryptionKeyProvider
+
+// stateEncryptionKey returns the provider of the key the document states are encrypted with, nil if they're persisted in plain text
+var stateEncryptionKey = func() EncryptionKeyProvider {
+	if StateEncryptionKey != nil {
+		return StateEncryptionKey
+	}
+	config, err := appconfig.Config(false)
+	if err != nil || config.Ssm.StateEncryptionKeyFile == "" {
+		return nil
+	}
+	keyFile, kmsKeyID := config.Ssm.StateEncryptionKeyFile, config.Ssm.StateEncryptionKmsKeyID
+	return func() ([]byte, error) {
+		return configuredStateKey.get(keyFile, kmsKeyID)
+	}
+}
+
+// newKMSClient returns the client decrypting the key of the document states, assigned to a variable to allow unittest to override
+var newKMSClient = func() kmsiface.KMSAPI {
+	return kms.New(session.New(sdkutil.AwsConfig()))
+}
+
+// cachedStateKey keeps the configured key of the document states once read so that KMS is called once
+type cachedStateKey struct {
+	keyFile  string
+	kmsKeyID string
+	key      []byte
+	m        sync.Mutex
+}
+
+var configuredStateKey cachedStateKey
+
+// get returns the key of the file, decrypted by KMS if a KMS key is configured
+func (c *cachedStateKey) get(keyFile, kmsKeyID string) ([]byte, error) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.key != nil && c.keyFile == keyFile && c.kmsKeyID == kmsKeyID {
+		return c.key, nil
+	}
+	var key []byte
+	var err error
+	if kmsKeyID == "" {
+		key, err = readStateKey(keyFile)
+	} else {
+		key, err = kmsStateKey(keyFile, kmsKeyID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.keyFile, c.kmsKeyID, c.key = keyFile, kmsKeyID, key
+	return key, nil
+}
+
+// readStateKey reads the base64 encoded key of the file
+func readStateKey(keyFile string) ([]byte, error) {
+	content, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the document state key %v: %v", keyFile, err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(content)))
+	if err != nil {
+		return nil, fmt.Errorf("the document state key %v isn't base64 encoded: %v", keyFile, err)
+	}
+	return key, nil
+}
+
+// kmsStateKey decrypts the key of the file with KMS, a new key generated by KMS is written to the file if it doesn't exist yet
+func kmsStateKey(keyFile, kmsKeyID string) ([]byte, error) {
+	client := newKMSClient()
+	if encrypted, err := ioutil.ReadFile(keyFile); err == nil {
+		output, err := client.Decrypt(&kms.DecryptInput{CiphertextBlob: encrypted})
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt the document state key %v: %v", keyFile, err)
+		}
+		return output.Plaintext, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read the document state key %v: %v", keyFile, err)
+	}
+	output, err := client.GenerateDataKey(&kms.GenerateDataKeyInput{KeyId: aws.String(kmsKeyID), KeySpec: aws.String(kms.DataKeySpecAes256)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate the document state key with %v: %v", kmsKeyID, err)
+	}
+	if err = fileutil.MakeDirs(filepath.Dir(keyFile)); err != nil {
+		return nil, err
+	}
+	if _, err = fileutil.WriteIntoFileWithPermissions(keyFile, string(output.CiphertextBlob), os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		return nil, fmt.Errorf("failed to write the document state key %v: %v", keyFile, err)
+	}
+	return output.Plaintext, nil
+}
+
+// sealDocState encrypts the content of the document state about to be persisted if the states are encrypted
+func sealDocState(content []byte) ([]byte, error) {
+	provider := stateEncryptionKey()
+	if provider == nil {
+		return content, nil
+	}
+	aead, err := newStateCipher(provider)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed := make([]byte, 0, len(encryptedStateHeader)+len(nonce)+len(content)+aead.Overhead())
+	sealed = append(append(sealed, encryptedStateHeader...), nonce...)
+	return aead.Seal(sealed, nonce, content, encryptedStateHeader), nil
+}
+
+// openDocState decrypts the content read from the document state if it's encrypted, a plain state is returned as is
+func openDocState(content []byte) ([]byte, error) {
+	if !bytes.HasPrefix(content, encryptedStateHeader) {
+		return content, nil
+	}
+	provider := stateEncryptionKey()
+	if provider == nil {
+		return nil, errors.New("the document state is encrypted but no key is configured to decrypt it")
+	}
+	aead, err := newStateCipher(provider)
+	if err != nil {
+		return nil, err
+	}
+	sealed := content[len(encryptedStateHeader):]
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("the encrypted document state is too short")
+	}
+	plaintext, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], encryptedStateHeader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt the document state: %v", err)
+	}
+	return plaintext, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/aws-sdk-go/service/kms"
+	"github.com/aws/aws-sdk-go/service/kms/kmsiface"
+	"github.com/stretchr/testify/assert"
+)
+
+var testStateKey = []byte("fedcba9876543210fedcba9876543210")
+
+// setTestStateEncryption encrypts the document states with the test key, returns the function restoring the plain text states
+func setTestStateEncryption() func() {
+	StateEncryptionKey = func() ([]byte, error) { return testStateKey, nil }
+	return func() { StateEncryptionKey = nil }
+}
+
+// kmsMock wraps the state key into a fake ciphertext blob
+type kmsMock struct {
+	kmsiface.KMSAPI
+	generated int
+}
+
+func (m *kmsMock) GenerateDataKey(input *kms.GenerateDataKeyInput) (*kms.GenerateDataKeyOutput, error) {
+	m.generated++
+	return &kms.GenerateDataKeyOutput{Plaintext: testStateKey, CiphertextBlob: append([]byte("wrapped:"), testStateKey...)}, nil
+}
+
+func (m *kmsMock) Decrypt(input *kms.DecryptInput) (*kms.DecryptOutput, error) {
+	return &kms.DecryptOutput{Plaintext: input.CiphertextBlob[len("wrapped:"):]}, nil
+}
+
+func TestPersistDataEncryptsTheState(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestStateEncryption()()
+	docState := sensitiveDocState()
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.NoError(t, err)
+	assert.Equal(t, encryptedStateHeader, content[:len(encryptedStateHeader)])
+	assert.NotContains(t, string(content), "s3cr3t")
+	assert.NotContains(t, string(content), "AWS-RunShellScript")
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestEncryptedStatesAreCompressedOnceCompleted(t *testing.T) {
+	defer setTestDataStore(t)()
+	defer setTestStateEncryption()()
+	defer setTestCompressCompletedStates(true)()
+	docState := completedDocState()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted) + compressedStateSuffix)
+	assert.NoError(t, err)
+	assert.Equal(t, encryptedStateHeader, content[:len(encryptedStateHeader)])
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted))
+}
+
+func TestReadUnencryptedStatesWithKey(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := sensitiveDocState()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	defer setTestStateEncryption()()
+
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestReadEncryptedStateWithoutKey(t *testing.T) {
+	defer setTestDataStore(t)()
+	restore := setTestStateEncryption()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, sensitiveDocState())
+	restore()
+
+	_, err := GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	assert.Error(t, err)
+	_, corrupt := err.(*CorruptStateError)
+	assert.False(t, corrupt)
+}
+
+func TestOpenDocStateRejectsTamperedContent(t *testing.T) {
+	defer setTestStateEncryption()()
+	sealed, err := sealDocState([]byte(`{"DocumentType":"SendCommand"}`))
+	assert.NoError(t, err)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = openDocState(sealed)
+
+	assert.Error(t, err)
+}
+
+func TestReadLocalStateKey(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statekey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "state.key")
+	assert.NoError(t, ioutil.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(testStateKey)+"\n"), 0600))
+	cache := cachedStateKey{}
+
+	key, err := cache.get(keyFile, "")
+
+	assert.NoError(t, err)
+	assert.Equal(t, testStateKey, key)
+}
+
+func TestKmsStateKeyIsGeneratedOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "statekey")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	keyFile := filepath.Join(dir, "keys", "state.key")
+	mock := &kmsMock{}
+	origNewKMSClient := newKMSClient
+	newKMSClient = func() kmsiface.KMSAPI { return mock }
+	defer func() { newKMSClient = origNewKMSClient }()
+
+	key, err := (&cachedStateKey{}).get(keyFile, "alias/ssm")
+	assert.NoError(t, err)
+	assert.Equal(t, testStateKey, key)
+	wrapped, err := ioutil.ReadFile(keyFile)
+	assert.NoError(t, err)
+	assert.NotEqual(t, testStateKey, wrapped)
+
+	key, err = (&cachedStateKey{}).get(keyFile, "alias/ssm")
+	assert.NoError(t, err)
+	assert.Equal(t, testStateKey, key)
+	assert.Equal(t, 1, mock.generated)
+}
@@ -0,0 +1,106 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+)
+
+// CurrentStateSchemaVersion is the version of the layout the document states are persisted in,
+// the states persisted before the version was recorded are version 1
+const CurrentStateSchemaVersion = 2
+
+// versionedDocState is the layout a document state is persisted in, the state along with the version of the layout
+type versionedDocState struct {
+	StateSchemaVersion int
+	model.DocumentState
+}
+
+// UnsupportedStateVersionError reports a document state persisted by a newer agent in a layout this agent doesn't know,
+// it's left as is rather than read with missing data
+type UnsupportedStateVersionError struct {
+	Path    string
+	Version int
+}
+
+func (e *UnsupportedStateVersionError) Error() string {
+	return fmt.Sprintf("document state %v has schema version %v, this agent reads up to version %v", e.Path, e.Version, CurrentStateSchemaVersion)
+}
+
+// stateMigrations upgrades the json layout of the document states of a version to the next one, indexed by the version they upgrade from
+var stateMigrations = map[int]func(state map[string]interface{}) error{
+	1: migrateDocStateFromV1,
+}
+
+// migrateDocStateFromV1 records the plugin count of the states of version 1 persisted before it was,
+// from the plugins they hold, so that they're checked for completeness from then on
+func migrateDocStateFromV1(state map[string]interface{}) error {
+	info, ok := state["DocumentInformation"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("DocumentInformation is missing")
+	}
+	if count, ok := info["PluginCount"].(float64); ok && count > 0 {
+		return nil
+	}
+	plugins, _ := state["InstancePluginsInformation"].([]interface{})
+	if len(plugins) > 0 {
+		info["PluginCount"] = len(plugins)
+	}
+	return nil
+}
+
+// stateSchemaVersion returns the version of the layout of the json content of a document state
+func stateSchemaVersion(content []byte) (int, error) {
+	var header struct {
+		StateSchemaVersion int
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return 0, err
+	}
+	if header.StateSchemaVersion == 0 {
+		return 1, nil
+	}
+	return header.StateSchemaVersion, nil
+}
+
+// migrateDocState upgrades the json content of a document state to the current layout.
+// The upgrade is done in memory only, the state is persisted in the current layout by the next write of the document.
+// An UnsupportedStateVersionError is returned for a state of a version newer than the current one.
+func migrateDocState(fileName string, content []byte) ([]byte, error) {
+	version, err := stateSchemaVersion(content)
+	if err != nil {
+		return nil, &CorruptStateError{Path: fileName, Err: err}
+	}
+	if version > CurrentStateSchemaVersion {
+		return nil, &UnsupportedStateVersionError{Path: fileName, Version: version}
+	}
+	if version == CurrentStateSchemaVersion {
+		return content, nil
+	}
+	var state map[string]interface{}
+	if err = json.Unmarshal(content, &state); err != nil {
+		return nil, &CorruptStateError{Path: fileName, Err: err}
+	}
+	for ; version < CurrentStateSchemaVersion; version++ {
+		if err = stateMigrations[version](state); err != nil {
+			return nil, &CorruptStateError{Path: fileName, Err: fmt.Errorf("failed to migrate from schema version %v: %v", version, err)}
+		}
+	}
+	state["StateSchemaVersion"] = CurrentStateSchemaVersion
+	return json.Marshal(state)
+}
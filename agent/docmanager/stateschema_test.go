@@ -0,0 +1,124 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// legacyDocState is a document state of schema version 1, persisted before the version and the plugin count were recorded
+const legacyDocState = `{
+	"DocumentInformation": {"DocumentID": "` + testDocumentID + `", "DocumentName": "AWS-RunShellScript", "DocumentStatus": "InProgress"},
+	"DocumentType": "SendCommand",
+	"SchemaVersion": "2.2",
+	"InstancePluginsInformation": [
+		{"Id": "first", "Name": "aws:runShellScript"},
+		{"Id": "second", "Name": "aws:runShellScript"}
+	]
+}`
+
+func TestPersistDataRecordsTheStateSchemaVersion(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := completedDocState()
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+
+	content, err := ioutil.ReadFile(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+	assert.NoError(t, err)
+	version, err := stateSchemaVersion(content)
+	assert.NoError(t, err)
+	assert.Equal(t, CurrentStateSchemaVersion, version)
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestGetDocStateMigratesV1States(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(legacyDocState), 0600))
+
+	docState, err := GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	assert.NoError(t, err)
+	assert.Equal(t, "AWS-RunShellScript", docState.DocumentInformation.DocumentName)
+	assert.Equal(t, "2.2", docState.SchemaVersion)
+	assert.Equal(t, 2, docState.DocumentInformation.PluginCount)
+	// the read leaves the state as is, the next write of the document persists it in the current version
+	content, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, legacyDocState, string(content))
+
+	PersistDocumentInfo(testLog, docState.DocumentInformation, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	content, err = ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	version, err := stateSchemaVersion(content)
+	assert.NoError(t, err)
+	assert.Equal(t, CurrentStateSchemaVersion, version)
+	assert.Equal(t, docState, GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent))
+}
+
+func TestCleanupDeletesOldV1States(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	legacy := strings.Replace(legacyDocState, `"InProgress"`, `"Success"`, 1)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(legacy), 0600))
+	old := time.Now().Add(-48 * time.Hour)
+	assert.NoError(t, os.Chtimes(fileName, old, old))
+
+	// reading the state for its retention doesn't rewrite it, so its retention isn't started over
+	DeleteOldDocumentFolderLogs(testLog, testInstanceID, appconfig.DefaultDocumentRootDirName, 24, nil, 100,
+		func(string) bool { return true }, func(fileName string) string { return fileName })
+
+	assert.False(t, fileutil.Exists(fileName))
+}
+
+func TestGetDocStateKeepsThePluginCountOfV1States(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	legacy := strings.Replace(legacyDocState, `"DocumentStatus": "InProgress"`, `"DocumentStatus": "InProgress", "PluginCount": 3`, 1)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(legacy), 0600))
+
+	docState := GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	assert.Equal(t, 3, docState.DocumentInformation.PluginCount)
+}
+
+func TestGetDocStateRejectsNewerStates(t *testing.T) {
+	defer setTestDataStore(t)()
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	newer := strings.Replace(legacyDocState, `"DocumentType"`, `"StateSchemaVersion": 99, "DocumentType"`, 1)
+	assert.NoError(t, ioutil.WriteFile(fileName, []byte(newer), 0600))
+
+	_, err := GetDocumentInterimStateE(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+
+	assert.Error(t, err)
+	versionErr, ok := err.(*UnsupportedStateVersionError)
+	assert.True(t, ok)
+	if ok {
+		assert.Equal(t, 99, versionErr.Version)
+	}
+	// the state is left as is for the agent that persisted it
+	content, err := ioutil.ReadFile(fileName)
+	assert.NoError(t, err)
+	assert.Equal(t, newer, string(content))
+	assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCorrupt)))
+}
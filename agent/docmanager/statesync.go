@@ -0,0 +1,84 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+)
+
+// fileSyncer flushes the document states written by FileDocumentStore to stable storage
+type fileSyncer interface {
+	// SyncFile flushes the content of the file
+	SyncFile(absoluteFileName string) error
+	// SyncDir flushes the entries of the dir, e.g. a file renamed into it
+	SyncDir(dir string) error
+}
+
+// stateSyncer flushes the document states when FsyncDocumentState is set, assigned to a variable to allow unittest to override
+var stateSyncer fileSyncer = fsStateSyncer{}
+
+// fsyncDocumentState returns true if the document states are flushed to stable storage before their writes return
+var fsyncDocumentState = func() bool {
+	config, err := appconfig.Config(false)
+	if err != nil {
+		return false
+	}
+	return config.Ssm.FsyncDocumentState
+}
+
+// fsStateSyncer fsyncs the files and dirs of the local filesystem
+type fsStateSyncer struct{}
+
+// SyncFile fsyncs the file
+func (fsStateSyncer) SyncFile(absoluteFileName string) error {
+	return syncPath(absoluteFileName)
+}
+
+// SyncDir fsyncs the dir, Windows can't open a dir for it and flushes the renames on its own
+func (fsStateSyncer) SyncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	return syncPath(dir)
+}
+
+// syncPath opens the file or dir and fsyncs it
+func syncPath(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// syncMove flushes the renamed state and the dirs whose entries the rename changed
+func syncMove(absoluteSource, absoluteDestination string) error {
+	if err := stateSyncer.SyncFile(absoluteDestination); err != nil {
+		return err
+	}
+	destinationDir := filepath.Dir(absoluteDestination)
+	if err := stateSyncer.SyncDir(destinationDir); err != nil {
+		return err
+	}
+	if sourceDir := filepath.Dir(absoluteSource); sourceDir != destinationDir {
+		return stateSyncer.SyncDir(sourceDir)
+	}
+	return nil
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/stretchr/testify/assert"
+)
+
+// syncRecorder records the files and dirs flushed
+type syncRecorder struct {
+	files []string
+	dirs  []string
+	m     sync.Mutex
+}
+
+func (r *syncRecorder) SyncFile(absoluteFileName string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.files = append(r.files, absoluteFileName)
+	return fsStateSyncer{}.SyncFile(absoluteFileName)
+}
+
+func (r *syncRecorder) SyncDir(dir string) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.dirs = append(r.dirs, dir)
+	return fsStateSyncer{}.SyncDir(dir)
+}
+
+// setTestFsyncDocumentState sets whether the document states are flushed, recording the flushes, returns the function restoring them
+func setTestFsyncDocumentState(fsync bool, recorder *syncRecorder) func() {
+	origFsyncDocumentState, origStateSyncer := fsyncDocumentState, stateSyncer
+	fsyncDocumentState = func() bool { return fsync }
+	stateSyncer = recorder
+	return func() {
+		fsyncDocumentState, stateSyncer = origFsyncDocumentState, origStateSyncer
+	}
+}
+
+func TestPersistDataFsyncsTheState(t *testing.T) {
+	defer setTestDataStore(t)()
+	recorder := &syncRecorder{}
+	defer setTestFsyncDocumentState(true, recorder)()
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, completedDocState())
+
+	fileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	assert.Contains(t, recorder.files, fileName)
+}
+
+func TestMoveDocumentStateFsyncsBothFolders(t *testing.T) {
+	defer setTestDataStore(t)()
+	recorder := &syncRecorder{}
+	defer setTestFsyncDocumentState(true, recorder)()
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, completedDocState())
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	completedFileName := docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Contains(t, recorder.files, completedFileName)
+	assert.Contains(t, recorder.dirs, filepath.Dir(completedFileName))
+	assert.Contains(t, recorder.dirs, filepath.Dir(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)))
+}
+
+func TestPersistDataDoesNotFsyncByDefault(t *testing.T) {
+	defer setTestDataStore(t)()
+	recorder := &syncRecorder{}
+	defer setTestFsyncDocumentState(false, recorder)()
+
+	PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, completedDocState())
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	assert.Empty(t, recorder.files)
+	assert.Empty(t, recorder.dirs)
+}
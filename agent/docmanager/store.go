@@ -0,0 +1,150 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+)
+
+// DocumentStore persists the document states, each state is keyed by its absolute file name under the data store path.
+// The claim markers of the documents are kept in the store as well. The sidecars of the states, e.g. their signatures
+// and summaries, and the orchestration dirs stay on the local filesystem.
+type DocumentStore interface {
+	// Get returns the content of the state, an error satisfying os.IsNotExist if there is none
+	Get(absoluteFileName string) ([]byte, error)
+	// Stat returns the size and modification time of the state without reading it, an error satisfying os.IsNotExist if there is none
+	Stat(absoluteFileName string) (DocumentStateInfo, error)
+	// Put creates or overwrites the state
+	Put(absoluteFileName string, content []byte) error
+	// Create creates the state atomically, an error satisfying os.IsExist if there is one already
+	Create(absoluteFileName string, content []byte) error
+	// Move renames the state, overwriting the destination
+	Move(absoluteSource, absoluteDestination string) error
+	// Delete removes the state, an error satisfying os.IsNotExist if there is none
+	Delete(absoluteFileName string) error
+	// List returns the names of the states of the dir, an error satisfying os.IsNotExist if the dir doesn't exist
+	List(dir string) ([]string, error)
+}
+
+// DocumentStateInfo describes a persisted document state, the cleanups go by its modification time
+type DocumentStateInfo struct {
+	Size    int64
+	ModTime time.Time
+}
+
+// store is where the document states are persisted, the local filesystem unless replaced with SetDocumentStore
+var store DocumentStore = FileDocumentStore{}
+
+// SetDocumentStore replaces the store of the document states, it has to be called before any document is processed
+func SetDocumentStore(documentStore DocumentStore) {
+	store = documentStore
+}
+
+// FileDocumentStore persists the document states as files of the local filesystem
+type FileDocumentStore struct{}
+
+// Get reads the state file
+func (FileDocumentStore) Get(absoluteFileName string) ([]byte, error) {
+	return ioutil.ReadFile(absoluteFileName)
+}
+
+// Stat returns the size and modification time of the state file
+func (FileDocumentStore) Stat(absoluteFileName string) (DocumentStateInfo, error) {
+	info, err := os.Stat(absoluteFileName)
+	if err != nil {
+		return DocumentStateInfo{}, err
+	}
+	if info.IsDir() {
+		return DocumentStateInfo{}, &os.PathError{Op: "stat", Path: absoluteFileName, Err: os.ErrNotExist}
+	}
+	return DocumentStateInfo{Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Put writes the state file
+func (FileDocumentStore) Put(absoluteFileName string, content []byte) error {
+	if _, err := fileutil.WriteIntoFileWithPermissions(absoluteFileName, string(content), os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		return err
+	}
+	if fsyncDocumentState() {
+		return stateSyncer.SyncFile(absoluteFileName)
+	}
+	return nil
+}
+
+// Create writes the state file unless it exists
+func (FileDocumentStore) Create(absoluteFileName string, content []byte) error {
+	file, err := os.OpenFile(absoluteFileName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, os.FileMode(int(appconfig.ReadWriteAccess)))
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Move renames the state file
+func (FileDocumentStore) Move(absoluteSource, absoluteDestination string) error {
+	if _, err := fileutil.MoveAndRenameFile(filepath.Dir(absoluteSource), filepath.Base(absoluteSource), filepath.Dir(absoluteDestination), filepath.Base(absoluteDestination)); err != nil {
+		return err
+	}
+	if fsyncDocumentState() {
+		return syncMove(absoluteSource, absoluteDestination)
+	}
+	return nil
+}
+
+// Delete removes the state file
+func (FileDocumentStore) Delete(absoluteFileName string) error {
+	return fileutil.DeleteFile(absoluteFileName)
+}
+
+// List returns the names of the files of the dir
+func (FileDocumentStore) List(dir string) (names []string, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		if !file.IsDir() {
+			names = append(names, file.Name())
+		}
+	}
+	return names, nil
+}
+
+// docStateExists checks the store holds the state, gzipped or not
+func docStateExists(absoluteFileName string) bool {
+	_, err := statDocState(absoluteFileName)
+	return err == nil
+}
+
+// statDocState returns the size and modification time of the state, of its gzipped file if it has no plain json file
+func statDocState(absoluteFileName string) (DocumentStateInfo, error) {
+	info, err := store.Stat(absoluteFileName)
+	if os.IsNotExist(err) {
+		if compressedInfo, compressedErr := store.Stat(absoluteFileName + compressedStateSuffix); compressedErr == nil {
+			return compressedInfo, nil
+		}
+	}
+	return info, err
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// memoryDocumentStore keeps the document states in memory
+type memoryDocumentStore struct {
+	states   map[string][]byte
+	modTimes map[string]time.Time
+	m        sync.Mutex
+}
+
+func newMemoryDocumentStore() *memoryDocumentStore {
+	return &memoryDocumentStore{states: make(map[string][]byte), modTimes: make(map[string]time.Time)}
+}
+
+func (s *memoryDocumentStore) Get(absoluteFileName string) ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	content, found := s.states[absoluteFileName]
+	if !found {
+		return nil, &os.PathError{Op: "get", Path: absoluteFileName, Err: os.ErrNotExist}
+	}
+	return content, nil
+}
+
+func (s *memoryDocumentStore) Stat(absoluteFileName string) (DocumentStateInfo, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	content, found := s.states[absoluteFileName]
+	if !found {
+		return DocumentStateInfo{}, &os.PathError{Op: "stat", Path: absoluteFileName, Err: os.ErrNotExist}
+	}
+	return DocumentStateInfo{Size: int64(len(content)), ModTime: s.modTimes[absoluteFileName]}, nil
+}
+
+func (s *memoryDocumentStore) Put(absoluteFileName string, content []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.states[absoluteFileName] = append([]byte(nil), content...)
+	s.modTimes[absoluteFileName] = time.Now()
+	return nil
+}
+
+func (s *memoryDocumentStore) Create(absoluteFileName string, content []byte) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, found := s.states[absoluteFileName]; found {
+		return &os.PathError{Op: "create", Path: absoluteFileName, Err: os.ErrExist}
+	}
+	s.states[absoluteFileName] = append([]byte(nil), content...)
+	s.modTimes[absoluteFileName] = time.Now()
+	return nil
+}
+
+func (s *memoryDocumentStore) Move(absoluteSource, absoluteDestination string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	content, found := s.states[absoluteSource]
+	if !found {
+		return &os.PathError{Op: "move", Path: absoluteSource, Err: os.ErrNotExist}
+	}
+	delete(s.states, absoluteSource)
+	s.states[absoluteDestination] = content
+	s.modTimes[absoluteDestination] = s.modTimes[absoluteSource]
+	delete(s.modTimes, absoluteSource)
+	return nil
+}
+
+func (s *memoryDocumentStore) Delete(absoluteFileName string) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if _, found := s.states[absoluteFileName]; !found {
+		return &os.PathError{Op: "delete", Path: absoluteFileName, Err: os.ErrNotExist}
+	}
+	delete(s.states, absoluteFileName)
+	delete(s.modTimes, absoluteFileName)
+	return nil
+}
+
+func (s *memoryDocumentStore) List(dir string) (names []string, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	for absoluteFileName := range s.states {
+		if filepath.Dir(absoluteFileName) == filepath.Clean(dir) {
+			names = append(names, filepath.Base(absoluteFileName))
+		}
+	}
+	return names, nil
+}
+
+func setTestDocumentStore(documentStore DocumentStore) func() {
+	origStore := store
+	SetDocumentStore(documentStore)
+	return func() { SetDocumentStore(origStore) }
+}
+
+func TestDocumentStoreReplacesTheFilesystem(t *testing.T) {
+	defer setTestDataStore(t)()
+	memoryStore := newMemoryDocumentStore()
+	defer setTestDocumentStore(memoryStore)()
+
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = testDocumentID
+	docState.DocumentInformation.DocumentName = "AWS-RunShellScript"
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusInProgress
+	docState.InstancePluginsInformation = []model.PluginState{{Id: "plugin1", Name: "aws:runShellScript"}}
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending, docState))
+	assert.True(t, IsDocumentCurrentlyExecuting(testDocumentID, testInstanceID))
+
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCurrent)
+	PersistPluginState(testLog, model.PluginState{Id: "plugin1", Name: "aws:runShellScript", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess}},
+		"plugin1", testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	docInfo := GetDocumentInfo(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	docInfo.DocumentStatus = contracts.ResultStatusSuccess
+	PersistDocumentInfo(testLog, docInfo, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent)
+	MoveDocumentState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCompleted)
+
+	loaded := GetDocumentInterimState(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, contracts.ResultStatusSuccess, loaded.DocumentInformation.DocumentStatus)
+	assert.Equal(t, contracts.ResultStatusSuccess, loaded.InstancePluginsInformation[0].Result.Status)
+	assert.False(t, IsDocumentCurrentlyExecuting(testDocumentID, testInstanceID))
+	assert.Equal(t, appconfig.DefaultLocationOfCompleted, FindTerminalLocationFolder(testDocumentID, testInstanceID))
+	assert.Equal(t, map[string]string{testDocumentID: "AWS-RunShellScript"}, DocumentNames(testLog, testInstanceID, appconfig.DefaultLocationOfCompleted))
+
+	// the states went to the store only
+	assert.Len(t, memoryStore.states, 1)
+	for _, locationFolder := range stateFolders {
+		assert.False(t, fileutil.Exists(docStateFileName(testDocumentID, testInstanceID, locationFolder)))
+	}
+
+	RemoveData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Empty(t, memoryStore.states)
+}
+
+func TestDocumentStoreIsCleanedUpReconciledAndMigrated(t *testing.T) {
+	defer setTestDataStore(t)()
+	memoryStore := newMemoryDocumentStore()
+	defer setTestDocumentStore(memoryStore)()
+	docState := completedDocState()
+	docState.DocumentInformation.InstanceID = testInstanceID
+	assert.NoError(t, PersistData(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted, docState))
+
+	// the state of the store is migrated along with the files of the instance
+	newInstanceID := "i-0123456789abcdef0"
+	assert.NoError(t, MigrateInstanceData(testLog, testInstanceID, newInstanceID))
+	fileName := docStateFileName(testDocumentID, newInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.Equal(t, newInstanceID, GetDocumentInfo(testLog, testDocumentID, newInstanceID, appconfig.DefaultLocationOfCompleted).InstanceID)
+	assert.False(t, docStateExists(docStateFileName(testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)))
+
+	// an interrupted move of the store is completed
+	assert.NoError(t, memoryStore.Move(fileName, fileName+moveIntermediateSuffix))
+	ReconcileDocumentStates(testLog, newInstanceID)
+	assert.True(t, docStateExists(fileName))
+
+	// the old state of the store is cleaned up
+	memoryStore.modTimes[fileName] = time.Now().Add(-48 * time.Hour)
+	DeleteOldDocumentFolderLogs(testLog, newInstanceID, appconfig.DefaultDocumentRootDirName, 24, nil, 100,
+		func(string) bool { return true }, func(fileName string) string { return fileName })
+	assert.Empty(t, memoryStore.states)
+}
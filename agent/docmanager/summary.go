@@ -0,0 +1,160 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+)
+
+// summariesFolderName is the folder, next to the state folders, holding the summary of each completed document
+const summariesFolderName = "summaries"
+
+// DocumentSummary is the compact summary of a completed document, it's written when the document moves to its terminal folder
+// so the tooling can list the completed documents without reading their full state
+type DocumentSummary struct {
+	DocumentID     string
+	CommandID      string `json:",omitempty"`
+	AssociationID  string `json:",omitempty"`
+	DocumentName   string
+	DocumentStatus contracts.ResultStatus
+	// LocationFolder is the terminal folder holding the state of the document
+	LocationFolder string
+	CreatedDate    string
+	// StartDateTime and EndDateTime span the executions of the plugins, empty if no plugin ran
+	StartDateTime string `json:",omitempty"`
+	EndDateTime   string `json:",omitempty"`
+	CompletedDate string
+	PluginCount   int
+	LastError     string `json:",omitempty"`
+}
+
+// summaryFileName returns the path of the summary of the given document state,
+// the summaries of all the terminal folders are kept together
+func summaryFileName(absoluteFileName string) string {
+	stateDir := filepath.Dir(filepath.Dir(absoluteFileName))
+	return filepath.Join(stateDir, summariesFolderName, filepath.Base(absoluteFileName))
+}
+
+// summarizeDocState returns the summary of the document state persisted in the given terminal folder
+func summarizeDocState(docState model.DocumentState, locationFolder string, completed time.Time) DocumentSummary {
+	docInfo := docState.DocumentInformation
+	summary := DocumentSummary{
+		DocumentID:     docInfo.DocumentID,
+		CommandID:      docInfo.CommandID,
+		AssociationID:  docInfo.AssociationID,
+		DocumentName:   docInfo.DocumentName,
+		DocumentStatus: docInfo.DocumentStatus,
+		LocationFolder: locationFolder,
+		CreatedDate:    docInfo.CreatedDate,
+		CompletedDate:  times.ToIso8601UTC(completed),
+		PluginCount:    len(docState.InstancePluginsInformation),
+		LastError:      docInfo.LastError,
+	}
+	var start, end time.Time
+	for _, pluginState := range docState.InstancePluginsInformation {
+		result := pluginState.Result
+		if !result.StartDateTime.IsZero() && (start.IsZero() || result.StartDateTime.Before(start)) {
+			start = result.StartDateTime
+		}
+		if result.EndDateTime.After(end) {
+			end = result.EndDateTime
+		}
+	}
+	if !start.IsZero() {
+		summary.StartDateTime = times.ToIso8601UTC(start)
+	}
+	if !end.IsZero() {
+		summary.EndDateTime = times.ToIso8601UTC(end)
+	}
+	return summary
+}
+
+// writeDocumentSummary writes the summary of the document state just moved to the given terminal folder,
+// the caller holds the lock of the document
+func writeDocumentSummary(log log.T, absoluteFileName, instanceID, locationFolder string) {
+	docState, err := getDocStateForUpdate(log, absoluteFileName, instanceID)
+	if err != nil {
+		log.Warnf("not summarizing %v: %v", absoluteFileName, err)
+		return
+	}
+	summaryPath := summaryFileName(absoluteFileName)
+	if err = fileutil.MakeDirs(filepath.Dir(summaryPath)); err != nil {
+		log.Errorf("failed to create the summaries folder of %v: %v", absoluteFileName, err)
+		return
+	}
+	summary, err := jsonutil.Marshal(summarizeDocState(docState, locationFolder, time.Now()))
+	if err != nil {
+		log.Errorf("failed to marshal the summary of %v: %v", absoluteFileName, err)
+		return
+	}
+	if _, err = fileutil.WriteIntoFileWithPermissions(summaryPath, summary, os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		log.Errorf("failed to write the summary of %v: %v", absoluteFileName, err)
+	}
+}
+
+// removeSummary deletes the summary of the deleted document state
+func removeSummary(log log.T, absoluteFileName string) {
+	summaryPath := summaryFileName(absoluteFileName)
+	if !fileutil.Exists(summaryPath) {
+		return
+	}
+	if err := fileutil.DeleteFile(summaryPath); err != nil {
+		log.Debugf("Error deleting summary %v: %v", summaryPath, err)
+	}
+}
+
+// ReadDocumentSummaries returns the summaries of the completed documents of the instance, the most recently completed first.
+// The summaries that can't be read are skipped.
+func ReadDocumentSummaries(log log.T, instanceID string) ([]DocumentSummary, error) {
+	if err := checkDataStorePath(log, instanceID); err != nil {
+		return nil, err
+	}
+	summariesDir := filepath.Join(filepath.Dir(DocumentStateDir(instanceID, appconfig.DefaultLocationOfCompleted)), summariesFolderName)
+	files, err := ioutil.ReadDir(summariesDir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	summaries := make([]DocumentSummary, 0, len(files))
+	for _, f := range files {
+		var summary DocumentSummary
+		if err = jsonutil.UnmarshalFile(filepath.Join(summariesDir, f.Name()), &summary); err != nil {
+			log.Debugf("skipping the summary %v: %v", f.Name(), err)
+			continue
+		}
+		summaries = append(summaries, summary)
+	}
+	sort.SliceStable(summaries, func(i, j int) bool {
+		if summaries[i].CompletedDate != summaries[j].CompletedDate {
+			return summaries[i].CompletedDate > summaries[j].CompletedDate
+		}
+		return summaries[i].DocumentID < summaries[j].DocumentID
+	})
+	return summaries, nil
+}
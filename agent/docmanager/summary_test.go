@@ -0,0 +1,104 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package docmanager helps maintain command documents
+package docmanager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+	"github.com/stretchr/testify/assert"
+)
+
+// completeTestDocument persists a document with two plugins in the Current folder and moves it to the given terminal folder
+func completeTestDocument(documentID string, status contracts.ResultStatus, terminalFolder string) model.DocumentState {
+	start := time.Date(2017, 3, 1, 10, 0, 0, 0, time.UTC)
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = documentID
+	docState.DocumentInformation.CommandID = documentID
+	docState.DocumentInformation.DocumentName = "AWS-RunShellScript"
+	docState.DocumentInformation.DocumentStatus = status
+	docState.DocumentInformation.CreatedDate = "2017-03-01T09:59:00.000Z"
+	docState.InstancePluginsInformation = []model.PluginState{
+		{Id: "plugin1", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, StartDateTime: start, EndDateTime: start.Add(time.Minute)}},
+		{Id: "plugin2", Result: contracts.PluginResult{Status: status, StartDateTime: start.Add(time.Minute), EndDateTime: start.Add(3 * time.Minute)}},
+	}
+	PersistData(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCurrent, docState)
+	MoveDocumentState(testLog, documentID, testInstanceID, appconfig.DefaultLocationOfCurrent, terminalFolder)
+	return docState
+}
+
+func TestMoveToTerminalFolderWritesSummary(t *testing.T) {
+	defer setTestDataStore(t)()
+	docState := completeTestDocument(testDocumentID, contracts.ResultStatusSuccess, appconfig.DefaultLocationOfCompleted)
+
+	summaries, err := ReadDocumentSummaries(testLog, testInstanceID)
+
+	assert.NoError(t, err)
+	if assert.Len(t, summaries, 1) {
+		summary := summaries[0]
+		docInfo := GetDocumentInfo(testLog, testDocumentID, testInstanceID, appconfig.DefaultLocationOfCompleted)
+		assert.Equal(t, docInfo.DocumentID, summary.DocumentID)
+		assert.Equal(t, docInfo.CommandID, summary.CommandID)
+		assert.Equal(t, docInfo.DocumentName, summary.DocumentName)
+		assert.Equal(t, docInfo.DocumentStatus, summary.DocumentStatus)
+		assert.Equal(t, docInfo.CreatedDate, summary.CreatedDate)
+		assert.Equal(t, appconfig.DefaultLocationOfCompleted, summary.LocationFolder)
+		assert.Equal(t, len(docState.InstancePluginsInformation), summary.PluginCount)
+		assert.Equal(t, "2017-03-01T10:00:00.000Z", summary.StartDateTime)
+		assert.Equal(t, "2017-03-01T10:03:00.000Z", summary.EndDateTime)
+		assert.NotEmpty(t, summary.CompletedDate)
+	}
+}
+
+func TestDocumentSummariesAreSortedAndRemovedWithTheirState(t *testing.T) {
+	defer setTestDataStore(t)()
+	completeTestDocument("olderDocument", contracts.ResultStatusSuccess, appconfig.DefaultLocationOfCompleted)
+	time.Sleep(10 * time.Millisecond)
+	completeTestDocument("newerDocument", contracts.ResultStatusFailed, appconfig.DefaultLocationOfFailed)
+	// the documents still running aren't summarized
+	PersistData(testLog, "runningDocument", testInstanceID, appconfig.DefaultLocationOfCurrent, model.DocumentState{})
+
+	summaries, err := ReadDocumentSummaries(testLog, testInstanceID)
+	assert.NoError(t, err)
+	if assert.Len(t, summaries, 2) {
+		assert.Equal(t, "newerDocument", summaries[0].DocumentID)
+		assert.Equal(t, contracts.ResultStatusFailed, summaries[0].DocumentStatus)
+		assert.Equal(t, appconfig.DefaultLocationOfFailed, summaries[0].LocationFolder)
+		assert.Equal(t, "olderDocument", summaries[1].DocumentID)
+		assert.True(t, times.ParseIso8601UTC(summaries[0].CompletedDate).After(times.ParseIso8601UTC(summaries[1].CompletedDate)))
+	}
+
+	RemoveData(testLog, "olderDocument", testInstanceID, appconfig.DefaultLocationOfCompleted)
+	assert.False(t, fileutil.Exists(summaryFileName(docStateFileName("olderDocument", testInstanceID, appconfig.DefaultLocationOfCompleted))))
+	summaries, err = ReadDocumentSummaries(testLog, testInstanceID)
+	assert.NoError(t, err)
+	if assert.Len(t, summaries, 1) {
+		assert.Equal(t, "newerDocument", summaries[0].DocumentID)
+	}
+}
+
+func TestReadDocumentSummariesWithoutCompletedDocuments(t *testing.T) {
+	defer setTestDataStore(t)()
+
+	summaries, err := ReadDocumentSummaries(testLog, testInstanceID)
+
+	assert.NoError(t, err)
+	assert.Empty(t, summaries)
+}
@@ -26,12 +26,20 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/updateutil"
 
 	"fmt"
+	"path/filepath"
+	"strings"
 )
 
 const (
 	preconditionSchemaVersion string = "2.2"
+
+	// workingDirectoryPrefix names the default working directory of a document under its orchestration directory
+	workingDirectoryPrefix = "workingDirectory-"
 )
 
+// workingDirectoryNameReplacer turns a message id into a valid directory name
+var workingDirectoryNameReplacer = strings.NewReplacer("/", "_", "\\", "_", ":", "_")
+
 type DocumentParserInfo struct {
 	OrchestrationDir  string
 	S3Bucket          string
@@ -53,15 +61,26 @@ func InitializeDocState(log log.T,
 	docState.SchemaVersion = docContent.SchemaVersion
 	docState.DocumentType = documentType
 	docState.DocumentInformation = docInfo
+	docState.DocumentInformation.OrchestrationDirectory = parserInfo.OrchestrationDir
+	if parserInfo.DefaultWorkingDir == "" && parserInfo.OrchestrationDir != "" {
+		parserInfo.DefaultWorkingDir = documentWorkingDir(parserInfo.OrchestrationDir, parserInfo.MessageId)
+	}
 
 	pluginInfo, err := ParseDocument(log, docContent, parserInfo, params)
 	if err != nil {
 		return
 	}
 	docState.InstancePluginsInformation = pluginInfo
+	docState.DocumentInformation.PluginCount = len(pluginInfo)
 	return docState, nil
 }
 
+// documentWorkingDir returns the default working directory of the plugins of a document. It's under the orchestration directory
+// so that it's cleaned up along with it, and named after the message so that documents sharing an orchestration directory don't share it.
+func documentWorkingDir(orchestrationDir, messageID string) string {
+	return filepath.Join(orchestrationDir, workingDirectoryPrefix+workingDirectoryNameReplacer.Replace(messageID))
+}
+
 // ParseDocument is a method used to parse documents that are not received by any service (MDS or State manager)
 func ParseDocument(log log.T,
 	docContent *contracts.DocumentContent,
@@ -187,6 +206,7 @@ func parsePluginStateForV20Schema(
 			Preconditions:           instancePluginConfig.Preconditions,
 			IsPreconditionEnabled:   isPreconditionEnabled,
 			DefaultWorkingDirectory: defaultWorkingDir,
+			DependsOn:               instancePluginConfig.DependsOn,
 		}
 
 		var plugin docModel.PluginState
@@ -112,6 +112,30 @@ func TestParseDocument_ValidMainSteps(t *testing.T) {
 	assert.Equal(t, testWorkingDir, pluginInfoTest.Configuration.DefaultWorkingDirectory)
 }
 
+func TestParseDocumentKeepsStepDependencies(t *testing.T) {
+	testParserInfo := DocumentParserInfo{
+		OrchestrationDir: testOrchDir,
+		MessageId:        testMessageID,
+		DocumentId:       testDocumentID,
+	}
+	var testDocContent contracts.DocumentContent
+	err := json.Unmarshal([]byte(`{
+		"schemaVersion": "2.2",
+		"mainSteps": [
+			{"action": "aws:runShellScript", "name": "first", "inputs": {"runCommand": ["echo first"]}},
+			{"action": "aws:runShellScript", "name": "second", "dependsOn": ["first"], "inputs": {"runCommand": ["echo second"]}}
+		]
+	}`), &testDocContent)
+	assert.NoError(t, err)
+
+	pluginsInfo, err := ParseDocument(log.NewMockLog(), &testDocContent, testParserInfo, nil)
+
+	assert.NoError(t, err)
+	assert.Len(t, pluginsInfo, 2)
+	assert.Empty(t, pluginsInfo[0].Configuration.DependsOn)
+	assert.Equal(t, []string{"first"}, pluginsInfo[1].Configuration.DependsOn)
+}
+
 func TestInitializeDocState_Valid(t *testing.T) {
 	mockLog := log.NewMockLog()
 
@@ -139,6 +163,9 @@ func TestInitializeDocState_Valid(t *testing.T) {
 	assert.Equal(t, model.SendCommand, docState.DocumentType)
 	assert.Equal(t, "1.2", docState.SchemaVersion)
 	assert.Equal(t, 1, len(pluginInfo))
+	assert.Equal(t, 1, docState.DocumentInformation.PluginCount)
+	assert.Equal(t, testOrchDir, docState.DocumentInformation.OrchestrationDirectory)
+	assert.Equal(t, docState.DocumentInformation.OrchestrationDirectory, filepath.Dir(pluginInfo[0].Configuration.OrchestrationDirectory))
 	assert.Equal(t, filepath.Join(testOrchDir, "awsrunShellScript"), pluginInfo[0].Configuration.OrchestrationDirectory)
 	assert.Equal(t, testS3Bucket, pluginInfo[0].Configuration.OutputS3BucketName)
 	assert.Equal(t, filepath.Join(testS3Prefix, "awsrunShellScript"), pluginInfo[0].Configuration.OutputS3KeyPrefix)
@@ -147,6 +174,31 @@ func TestInitializeDocState_Valid(t *testing.T) {
 	assert.Equal(t, testWorkingDir, pluginInfo[0].Configuration.DefaultWorkingDirectory)
 }
 
+func TestInitializeDocStateIsolatesWorkingDirectories(t *testing.T) {
+	var testDocContent contracts.DocumentContent
+	assert.NoError(t, json.Unmarshal(loadFile(t, "../runcommand/mds/testdata/validcommand12.json"), &testDocContent))
+
+	// two parts of the same command share the orchestration dir of the command
+	workingDirs := make(map[string]bool)
+	for _, messageID := range []string{"aws.ssm.part1.command.instance", "aws.ssm.part2.command.instance"} {
+		parserInfo := DocumentParserInfo{
+			OrchestrationDir: testOrchDir,
+			MessageId:        messageID,
+			DocumentId:       testDocumentID,
+		}
+		docState, err := InitializeDocState(log.NewMockLog(), model.SendCommand, &testDocContent, model.DocumentInfo{}, parserInfo, nil)
+		assert.NoError(t, err)
+
+		for _, pluginState := range docState.InstancePluginsInformation {
+			workingDir := pluginState.Configuration.DefaultWorkingDirectory
+			assert.Equal(t, testOrchDir, filepath.Dir(workingDir), "the working dir is cleaned up with the orchestration dir")
+			assert.Contains(t, workingDir, messageID)
+			workingDirs[workingDir] = true
+		}
+	}
+	assert.Len(t, workingDirs, 2)
+}
+
 func TestParseDocument_EmptyDocContent(t *testing.T) {
 	mockLog := log.NewMockLog()
 	testParserInfo := DocumentParserInfo{
@@ -280,6 +280,20 @@ func ReadDir(location string) ([]os.FileInfo, error) {
 	return ioutil.ReadDir(location)
 }
 
+// GetPathSize returns the size in bytes of the given file, or of all the files under the given directory
+func GetPathSize(path string) (size int64, err error) {
+	err = filepath.Walk(path, func(walkPath string, info os.FileInfo, walkErr error) error {
+		if walkErr != nil {
+			return walkErr
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	return
+}
+
 // isUnderDir determines if a given path is in or under a given parent directory (after accounting for path traversal)
 func isUnderDir(childPath, parentDirPath string) bool {
 	return strings.HasPrefix(filepath.Clean(childPath)+string(filepath.Separator), filepath.Clean(parentDirPath)+string(filepath.Separator))
@@ -15,6 +15,7 @@ package fileutil
 
 import (
 	"fmt"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
@@ -209,6 +210,26 @@ func TestMoveFile(t *testing.T) {
 	fs = osFS{}
 }
 
+func TestGetPathSize(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pathsize")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "subdir"), 0700))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "file"), []byte("12345"), 0600))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "subdir", "file"), []byte("123"), 0600))
+
+	size, err := GetPathSize(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(8), size)
+
+	size, err = GetPathSize(filepath.Join(dir, "file"))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(5), size)
+
+	_, err = GetPathSize(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
 func TestUnderDir(t *testing.T) {
 	// Remove one or more directory levels
 	assert.True(t, isUnderDir(`~/foo/bar/../`, `~/foo`))
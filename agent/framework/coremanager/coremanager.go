@@ -23,6 +23,7 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/framework/coremodules"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer"
@@ -116,13 +117,19 @@ func initializeBookkeepingLocations(log logger.T, instanceID string) bool {
 
 	//TODO: initializations for all state tracking folders of core modules should be moved inside the corresponding core modules.
 
-	//Create folders pending, current, completed, corrupt under the location DefaultLogDirPath/<instanceId>
+	if err := docmanager.ValidateDataStorePath(); err != nil {
+		log.Errorf("Invalid location for internal state management. %v", err)
+		return false
+	}
+
+	//Create folders pending, current, completed, failed, corrupt under the location DefaultLogDirPath/<instanceId>
 	log.Info("Initializing bookkeeping folders")
 	initStatus := true
 	folders := []string{
 		appconfig.DefaultLocationOfPending,
 		appconfig.DefaultLocationOfCurrent,
 		appconfig.DefaultLocationOfCompleted,
+		appconfig.DefaultLocationOfFailed,
 		appconfig.DefaultLocationOfCorrupt}
 
 	for _, folder := range folders {
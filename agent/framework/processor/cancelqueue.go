@@ -0,0 +1,83 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+)
+
+// cancelQueueSize is how many cancel commands can wait for a cancel worker, the ones coming in once it's full are dropped
+const cancelQueueSize = 1000
+
+// cancelQueue hands the cancel commands over to the cancel command pool from its own go routine,
+// so a burst of cancel commands waiting for a cancel worker never blocks the caller
+type cancelQueue struct {
+	queue      chan model.DocumentState
+	stopSignal chan struct{}
+	done       chan struct{}
+	stopped    bool
+	m          sync.Mutex
+}
+
+// push queues up the cancel command, the first push starts submitting the queued commands with the given function.
+// Returns false if the command is dropped because the queue is full or stopped.
+func (q *cancelQueue) push(docState model.DocumentState, submit func(docState model.DocumentState)) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.stopped {
+		return false
+	}
+	if q.queue == nil {
+		q.queue = make(chan model.DocumentState, cancelQueueSize)
+		q.stopSignal = make(chan struct{})
+		q.done = make(chan struct{})
+		go q.dispatch(submit)
+	}
+	select {
+	case q.queue <- docState:
+		return true
+	default:
+		return false
+	}
+}
+
+// dispatch submits the queued cancel commands until the queue is stopped
+func (q *cancelQueue) dispatch(submit func(docState model.DocumentState)) {
+	defer close(q.done)
+	for {
+		select {
+		case <-q.stopSignal:
+			return
+		case docState := <-q.queue:
+			submit(docState)
+		}
+	}
+}
+
+// stop drops the cancel commands still queued and waits for the one being submitted, if any
+func (q *cancelQueue) stop() {
+	q.m.Lock()
+	if q.stopped || q.queue == nil {
+		q.stopped = true
+		q.m.Unlock()
+		return
+	}
+	q.stopped = true
+	close(q.stopSignal)
+	q.m.Unlock()
+	<-q.done
+}
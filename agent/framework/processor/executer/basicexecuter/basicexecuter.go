@@ -47,9 +47,21 @@ func run(context context.T,
 	docStore executer.DocumentStore,
 	resChan chan contracts.DocumentResult,
 	cancelFlag task.CancelFlag) {
+	//status channel for plugins update
+	statusChan := make(chan contracts.PluginResult)
+	var wg sync.WaitGroup
+	isStatusChanClosed, isResChanClosed := false, false
 	defer func() {
 		if msg := recover(); msg != nil {
 			context.Log().Errorf("Executer run panic: %v", msg)
+			//the receiver drains the channel till it's closed, flush the pending plugin updates and never leave it hanging
+			if !isStatusChanClosed {
+				close(statusChan)
+			}
+			wg.Wait()
+			if !isResChanClosed {
+				close(resChan)
+			}
 		}
 	}()
 	docState := docStore.Load()
@@ -59,9 +71,6 @@ func run(context context.T,
 	nPlugins := len(docState.InstancePluginsInformation)
 	documentName := docState.DocumentInformation.DocumentName
 	documentVersion := docState.DocumentInformation.DocumentVersion
-	//status channel for plugins update
-	statusChan := make(chan contracts.PluginResult)
-	var wg sync.WaitGroup
 	wg.Add(1)
 	//The go-routine to listen to individual plugin update
 	go func() {
@@ -91,6 +100,7 @@ func run(context context.T,
 	}()
 
 	outputs := pluginRunner(context, docState.InstancePluginsInformation, statusChan, cancelFlag)
+	isStatusChanClosed = true
 	close(statusChan)
 	//make sure the launched go routine has finshed before sending the final response
 	wg.Wait()
@@ -113,6 +123,7 @@ func run(context context.T,
 	// persist the docState object
 	docStore.Save(docState)
 	//sender close the channel
+	isResChanClosed = true
 	close(resChan)
 }
 
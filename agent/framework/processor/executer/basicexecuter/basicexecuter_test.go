@@ -144,3 +144,39 @@ func testBasicExecuter(t *testing.T, testCase TestCase) {
 	dataStoreMock.AssertExpectations(t)
 
 }
+
+// TestBasicExecuterPluginRunnerPanic makes sure the response channel is closed, after the updates sent so far, when the plugin runner panics
+func TestBasicExecuterPluginRunnerPanic(t *testing.T) {
+	pluginState := docModel.PluginState{
+		Name: "aws:runScript",
+		Id:   "aws:runScript",
+	}
+	docState := docModel.DocumentState{
+		DocumentInformation:        docModel.DocumentInfo{MessageID: "MessageID"},
+		DocumentType:               "SendCommand",
+		InstancePluginsInformation: []docModel.PluginState{pluginState, pluginState},
+	}
+	result := contracts.PluginResult{
+		PluginName: "aws:runScript",
+		Status:     contracts.ResultStatusSuccess,
+	}
+	dataStoreMock := executermock.MockDocumentStore{}
+	dataStoreMock.On("Load").Return(docState)
+	pluginRunner = func(context context.T,
+		plugins []docModel.PluginState,
+		resChan chan contracts.PluginResult,
+		cancelFlag task.CancelFlag) map[string]*contracts.PluginResult {
+		resChan <- result
+		panic("plugin runner failure")
+	}
+
+	e := NewBasicExecuter(context.NewMockDefault())
+	resChan := e.Run(task.NewChanneledCancelFlag(), &dataStoreMock)
+	var received []contracts.DocumentResult
+	for res := range resChan {
+		received = append(received, res)
+	}
+	if assert.Len(t, received, 1) {
+		assert.Equal(t, "aws:runScript", received[0].LastPlugin)
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
+)
+
+// DocumentFailureAlert is called when the documents of the given name failed the given number of times within the window
+type DocumentFailureAlert func(documentName string, failures int, window time.Duration)
+
+// OnRepeatedDocumentFailures is invoked when the failures of the documents of a name reach Mds.DocumentFailureAlertThreshold
+// within Mds.DocumentFailureAlertWindowMinutes, nil only reports the alert to the log and the metrics
+var OnRepeatedDocumentFailures DocumentFailureAlert
+
+// documentFailures tracks the recent failures of the documents run by all the processors of the agent
+var documentFailures failureTracker
+
+// failureTracker records when the documents of each name failed
+type failureTracker struct {
+	failures map[string][]time.Time
+	m        sync.Mutex
+}
+
+// record adds a failure of the documents of the name, it returns the number of failures within the window
+// if they reached the threshold, the failures are then forgotten so that the next alert takes as many new failures
+func (f *failureTracker) record(documentName string, failedAt time.Time, threshold int, window time.Duration) (failures int, alert bool) {
+	f.m.Lock()
+	defer f.m.Unlock()
+	if f.failures == nil {
+		f.failures = make(map[string][]time.Time)
+	}
+	recent := f.failures[documentName][:0]
+	for _, t := range f.failures[documentName] {
+		if failedAt.Sub(t) < window {
+			recent = append(recent, t)
+		}
+	}
+	recent = append(recent, failedAt)
+	if len(recent) < threshold {
+		f.failures[documentName] = recent
+		return len(recent), false
+	}
+	delete(f.failures, documentName)
+	return len(recent), true
+}
+
+// recordDocumentStatus records the final status of the document, and raises the alert if the documents of its name failed too often
+func recordDocumentStatus(context context.T, documentName string, status contracts.ResultStatus) {
+	config := context.AppConfig().Mds
+	if config.DocumentFailureAlertThreshold <= 0 {
+		return
+	}
+	if status != contracts.ResultStatusFailed && status != contracts.ResultStatusTimedOut {
+		return
+	}
+	window := time.Duration(config.DocumentFailureAlertWindowMinutes) * time.Minute
+	failures, alert := documentFailures.record(documentName, time.Now(), config.DocumentFailureAlertThreshold, window)
+	if !alert {
+		return
+	}
+	context.Log().Errorf("documents %v failed %v times in the last %v", documentName, failures, window)
+	metrics.DefaultSink.IncrCounter(metrics.RepeatedDocumentFailures, 1)
+	if OnRepeatedDocumentFailures != nil {
+		OnRepeatedDocumentFailures(documentName, failures, window)
+	}
+}
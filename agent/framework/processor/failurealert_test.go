@@ -0,0 +1,105 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer"
+	executermocks "github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/mock"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestFailureTrackerAlertsAtThresholdWithinWindow(t *testing.T) {
+	var f failureTracker
+	start := time.Now()
+	window := 10 * time.Minute
+
+	// the failure out of the window is forgotten
+	f.record("AWS-RunPatchBaseline", start, 3, window)
+	failures, alert := f.record("AWS-RunPatchBaseline", start.Add(window), 3, window)
+	assert.Equal(t, 1, failures)
+	assert.False(t, alert)
+	failures, alert = f.record("AWS-RunPatchBaseline", start.Add(window+time.Minute), 3, window)
+	assert.Equal(t, 2, failures)
+	assert.False(t, alert)
+	// the failures of another name are counted apart
+	_, alert = f.record("AWS-RunShellScript", start.Add(window+time.Minute), 3, window)
+	assert.False(t, alert)
+
+	failures, alert = f.record("AWS-RunPatchBaseline", start.Add(window+2*time.Minute), 3, window)
+	assert.Equal(t, 3, failures)
+	assert.True(t, alert)
+
+	// the next alert takes as many new failures
+	_, alert = f.record("AWS-RunPatchBaseline", start.Add(window+3*time.Minute), 3, window)
+	assert.False(t, alert)
+}
+
+func TestProcessCommandAlertsOnRepeatedFailures(t *testing.T) {
+	defer func() {
+		OnRepeatedDocumentFailures = nil
+		documentFailures = failureTracker{}
+	}()
+	type alert struct {
+		documentName string
+		failures     int
+		window       time.Duration
+	}
+	var alerts []alert
+	OnRepeatedDocumentFailures = func(documentName string, failures int, window time.Duration) {
+		alerts = append(alerts, alert{documentName, failures, window})
+	}
+	config := appconfig.DefaultConfig()
+	config.Mds.DocumentFailureAlertThreshold = 3
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	run := func(documentName string, status contracts.ResultStatus) {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = "messageID"
+		docState.DocumentInformation.InstanceID = "instanceID"
+		docState.DocumentInformation.DocumentID = "documentID"
+		docState.DocumentInformation.DocumentName = documentName
+		executerMock := executermocks.NewMockExecuter()
+		statusChan := make(chan contracts.DocumentResult, 1)
+		cancelFlag := task.NewChanneledCancelFlag()
+		executerMock.On("Run", cancelFlag, mock.AnythingOfType("*executer.DocumentFileStore")).Return(statusChan)
+		creator := func(ctx context.T) executer.Executer {
+			return executerMock
+		}
+		statusChan <- contracts.DocumentResult{Status: status}
+		close(statusChan)
+		processCommand(ctx, creator, cancelFlag, make(chan contracts.DocumentResult, 1), &docState)
+	}
+
+	run("AWS-RunPatchBaseline", contracts.ResultStatusFailed)
+	run("AWS-RunPatchBaseline", contracts.ResultStatusSuccess)
+	run("AWS-RunShellScript", contracts.ResultStatusFailed)
+	run("AWS-RunPatchBaseline", contracts.ResultStatusTimedOut)
+	assert.Empty(t, alerts)
+
+	run("AWS-RunPatchBaseline", contracts.ResultStatusFailed)
+
+	assert.Equal(t, []alert{{"AWS-RunPatchBaseline", 3, time.Hour}}, alerts)
+}
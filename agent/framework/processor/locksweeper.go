@@ -0,0 +1,76 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// documentLockSweepInterval is how often the locks of the documents no longer in any state folder are released
+var documentLockSweepInterval = 10 * time.Minute
+
+// Assign docmanager functions to global variables to allow unittest to override
+var releaseDocumentLock = docmanager.ReleaseDocumentLock
+var sweepDocumentLocks = docmanager.SweepDocumentLocks
+
+// lockSweeper periodically releases the document locks left behind by the documents whose processing was cut short,
+// the locks of the documents reaching their final folder are released right away
+type lockSweeper struct {
+	stopChan chan bool
+	m        sync.Mutex
+}
+
+// start sweeps the document locks every interval until the sweeper is stopped
+func (s *lockSweeper) start(log log.T, interval time.Duration) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.stopChan != nil {
+		return
+	}
+	s.stopChan = make(chan bool)
+	go func(stopChan chan bool) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+				sweepDocumentLocks(log)
+			}
+		}
+	}(s.stopChan)
+}
+
+// stop stops sweeping the document locks
+func (s *lockSweeper) stop() {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.stopChan == nil {
+		return
+	}
+	close(s.stopChan)
+	s.stopChan = nil
+}
+
+// finalizeDocumentState moves the state of the document whose processing is over to its final folder and releases its lock
+func finalizeDocumentState(log log.T, documentID, instanceID, srcLocationFolder, dstLocationFolder string) {
+	docmanager.MoveDocumentState(log, documentID, instanceID, srcLocationFolder, dstLocationFolder)
+	releaseDocumentLock(instanceID, documentID)
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLockSweeperSweepsUntilStopped(t *testing.T) {
+	var sweeps int32
+	sweepDocumentLocks = func(log log.T) int {
+		atomic.AddInt32(&sweeps, 1)
+		return 0
+	}
+	defer func() { sweepDocumentLocks = docmanager.SweepDocumentLocks }()
+	var sweeper lockSweeper
+
+	sweeper.start(log.NewMockLog(), 10*time.Millisecond)
+	time.Sleep(55 * time.Millisecond)
+	sweeper.stop()
+	swept := atomic.LoadInt32(&sweeps)
+	time.Sleep(30 * time.Millisecond)
+
+	assert.True(t, swept >= 2, "swept %v times", swept)
+	assert.Equal(t, swept, atomic.LoadInt32(&sweeps))
+	// stopping twice is harmless
+	sweeper.stop()
+}
+
+func TestProcessCancelCommandReleasesDocumentLock(t *testing.T) {
+	var released []string
+	releaseDocumentLock = func(instanceID, documentID string) bool {
+		released = append(released, instanceID+"/"+documentID)
+		return true
+	}
+	defer func() { releaseDocumentLock = docmanager.ReleaseDocumentLock }()
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock := new(task.MockedPool)
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = "cancelDocumentID"
+	docState.DocumentInformation.InstanceID = "instanceID"
+	docState.CancelInformation.CancelMessageID = "messageID"
+	sendCommandPoolMock.On("CancelWithReason", "messageID", task.CancelReasonUserRequested).Return(false)
+
+	processCancelCommand(ctx, sendCommandPoolMock, &docState)
+
+	assert.Equal(t, []string{"instanceID/cancelDocumentID"}, released)
+}
@@ -3,6 +3,7 @@ package processormock
 import (
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
 	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor"
 	"github.com/stretchr/testify/mock"
 )
 
@@ -29,3 +30,27 @@ func (m *MockedProcessor) Cancel(docState model.DocumentState) {
 	m.Called(docState)
 	return
 }
+
+func (m *MockedProcessor) ForceComplete(commandID, instanceID string, status contracts.ResultStatus, reason string) error {
+	args := m.Called(commandID, instanceID, status, reason)
+	return args.Error(0)
+}
+
+func (m *MockedProcessor) QueueComposition() map[string]processor.DocumentCounts {
+	args := m.Called()
+	return args.Get(0).(map[string]processor.DocumentCounts)
+}
+
+func (m *MockedProcessor) InFlightMessageIDs() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockedProcessor) AllowReprocess(commandID string) {
+	m.Called(commandID)
+}
+
+func (m *MockedProcessor) RerunFailedPlugins(commandID, instanceID string) error {
+	args := m.Called(commandID, instanceID)
+	return args.Error(0)
+}
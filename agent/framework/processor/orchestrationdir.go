@@ -0,0 +1,65 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+)
+
+// Assign fileutil functions to global variables to allow unittest to override
+var makeOrchestrationDir = fileutil.MakeDirsWithExecuteAccess
+
+// orchestrationDirLimiter bounds how many orchestration directories are created at once across the processors of the agent
+type orchestrationDirLimiter struct {
+	slots chan bool
+	m     sync.Mutex
+}
+
+var orchestrationDirs orchestrationDirLimiter
+
+// acquire waits for one of limit slots to be free and takes it, the returned function frees it
+func (l *orchestrationDirLimiter) acquire(limit int) (release func()) {
+	l.m.Lock()
+	// the slots taken under a former limit are freed into the channel they were taken from
+	if l.slots == nil || cap(l.slots) != limit {
+		l.slots = make(chan bool, limit)
+	}
+	slots := l.slots
+	l.m.Unlock()
+	slots <- true
+	return func() { <-slots }
+}
+
+// createOrchestrationDir creates the orchestration directory of the document ahead of its plugins,
+// at most OrchestrationDirCreationLimit directories are created at once. The plugins create it on their own if it fails.
+func createOrchestrationDir(context context.T, orchestrationDir string) {
+	if orchestrationDir == "" {
+		return
+	}
+	log := context.Log()
+	limit := context.AppConfig().Mds.OrchestrationDirCreationLimit
+	if limit <= 0 {
+		limit = appconfig.DefaultOrchestrationDirCreationLimit
+	}
+	release := orchestrationDirs.acquire(limit)
+	defer release()
+	if err := makeOrchestrationDir(orchestrationDir); err != nil {
+		log.Warnf("failed to create the orchestration directory %v: %v", orchestrationDir, err)
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// concurrencyRecorder records the most calls running at once
+type concurrencyRecorder struct {
+	running    int
+	maxRunning int
+	created    []string
+	m          sync.Mutex
+}
+
+func (r *concurrencyRecorder) makeDir(dir string) error {
+	r.m.Lock()
+	r.running++
+	if r.running > r.maxRunning {
+		r.maxRunning = r.running
+	}
+	r.created = append(r.created, dir)
+	r.m.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	r.m.Lock()
+	r.running--
+	r.m.Unlock()
+	return nil
+}
+
+func TestCreateOrchestrationDirBurstStaysWithinLimit(t *testing.T) {
+	defer func() { makeOrchestrationDir = fileutil.MakeDirsWithExecuteAccess }()
+	for _, limit := range []int{1, 3} {
+		recorder := &concurrencyRecorder{}
+		makeOrchestrationDir = recorder.makeDir
+		config := appconfig.DefaultConfig()
+		config.Mds.OrchestrationDirCreationLimit = limit
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				createOrchestrationDir(ctx, fmt.Sprintf("orchestration/command%v", i))
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Len(t, recorder.created, 20)
+		assert.True(t, recorder.maxRunning <= limit, "%v directories created at once, limit %v", recorder.maxRunning, limit)
+		assert.True(t, recorder.maxRunning > 0)
+	}
+}
+
+func TestCreateOrchestrationDirSkipsUnknownDir(t *testing.T) {
+	recorder := &concurrencyRecorder{}
+	makeOrchestrationDir = recorder.makeDir
+	defer func() { makeOrchestrationDir = fileutil.MakeDirsWithExecuteAccess }()
+
+	createOrchestrationDir(context.NewMockDefault(), "")
+
+	assert.Empty(t, recorder.created)
+}
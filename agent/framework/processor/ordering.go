@@ -0,0 +1,158 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// messageOrdering processes the sequenced messages of a command in the order of their sequence numbers, starting at 1:
+// a message is held back until its predecessor is processed, or until it has waited for the timeout
+type messageOrdering struct {
+	commands map[string]*commandMessages
+	m        sync.Mutex
+}
+
+// commandMessages tracks the sequenced messages of a command
+type commandMessages struct {
+	// next is the sequence number of the message processed once its predecessor is done
+	next int
+	// processing counts the messages of the command handed over and not done yet
+	processing int
+	buffered   map[int]*bufferedMessage
+}
+
+// bufferedMessage is a message held back until its turn or until its timer fires
+type bufferedMessage struct {
+	docState model.DocumentState
+	timer    *time.Timer
+}
+
+// push processes the message right away if it's its turn, otherwise it's buffered until its predecessor is processed or the timeout elapses
+func (o *messageOrdering) push(log log.T, docState model.DocumentState, timeout time.Duration, process submitFunc) {
+	commandID := docState.DocumentInformation.CommandID
+	sequenceNumber := docState.DocumentInformation.SequenceNumber
+	o.m.Lock()
+	if o.commands == nil {
+		o.commands = make(map[string]*commandMessages)
+	}
+	command, found := o.commands[commandID]
+	if !found {
+		command = &commandMessages{next: 1, buffered: make(map[int]*bufferedMessage)}
+		o.commands[commandID] = command
+	}
+	if _, found := command.buffered[sequenceNumber]; found {
+		o.m.Unlock()
+		log.Infof("message %v of command %v is already waiting for its turn, skipping it", sequenceNumber, commandID)
+		return
+	}
+	if sequenceNumber > command.next {
+		log.Infof("message %v of command %v arrived before message %v, holding it back", sequenceNumber, commandID, command.next)
+		command.buffered[sequenceNumber] = &bufferedMessage{
+			docState: docState,
+			timer: time.AfterFunc(timeout, func() {
+				o.expire(log, commandID, sequenceNumber, process)
+			}),
+		}
+		o.m.Unlock()
+		return
+	}
+	command.processing++
+	o.m.Unlock()
+	process(docState, o.doneFunc(commandID, sequenceNumber, process))
+}
+
+// expire processes the message, which waited too long for its predecessors, along with the buffered messages preceding it
+func (o *messageOrdering) expire(log log.T, commandID string, sequenceNumber int, process submitFunc) {
+	o.m.Lock()
+	command, found := o.commands[commandID]
+	if !found || command.buffered[sequenceNumber] == nil {
+		o.m.Unlock()
+		return
+	}
+	var expired []int
+	for buffered := range command.buffered {
+		if buffered <= sequenceNumber {
+			expired = append(expired, buffered)
+		}
+	}
+	sort.Ints(expired)
+	var docStates []model.DocumentState
+	for _, buffered := range expired {
+		command.buffered[buffered].timer.Stop()
+		docStates = append(docStates, command.buffered[buffered].docState)
+		delete(command.buffered, buffered)
+	}
+	command.processing += len(docStates)
+	missing := command.next
+	o.m.Unlock()
+	log.Warnf("message %v of command %v timed out waiting for message %v, processing it anyway", sequenceNumber, commandID, missing)
+	for i, docState := range docStates {
+		process(docState, o.doneFunc(commandID, expired[i], process))
+	}
+}
+
+// doneFunc returns the function called once the message is processed, it hands the next message of the command over if it's buffered.
+// The next message is processed from its own go routine as done is called from the worker of the pool the message was submitted to.
+func (o *messageOrdering) doneFunc(commandID string, sequenceNumber int, process submitFunc) func() {
+	return func() {
+		o.m.Lock()
+		command, found := o.commands[commandID]
+		if !found {
+			//the ordering was stopped
+			o.m.Unlock()
+			return
+		}
+		command.processing--
+		if sequenceNumber >= command.next {
+			command.next = sequenceNumber + 1
+		}
+		next, found := command.buffered[command.next]
+		if !found {
+			//forget the command once it has nothing left, a message arriving late is then processed right away or after the timeout
+			if command.processing == 0 && len(command.buffered) == 0 {
+				delete(o.commands, commandID)
+			}
+			o.m.Unlock()
+			return
+		}
+		next.timer.Stop()
+		delete(command.buffered, command.next)
+		command.processing++
+		nextSequenceNumber := command.next
+		o.m.Unlock()
+		go process(next.docState, o.doneFunc(commandID, nextSequenceNumber, process))
+	}
+}
+
+// stop drops the buffered messages, it returns how many were dropped
+func (o *messageOrdering) stop() int {
+	o.m.Lock()
+	defer o.m.Unlock()
+	dropped := 0
+	for _, command := range o.commands {
+		for _, buffered := range command.buffered {
+			buffered.timer.Stop()
+			dropped++
+		}
+	}
+	o.commands = nil
+	return dropped
+}
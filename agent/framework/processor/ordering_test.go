@@ -0,0 +1,102 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/stretchr/testify/assert"
+)
+
+// sequencedMessage returns the message of the command with the given sequence number
+func sequencedMessage(commandID string, sequenceNumber int) model.DocumentState {
+	docState := model.DocumentState{}
+	docState.DocumentInformation.CommandID = commandID
+	docState.DocumentInformation.DocumentID = fmt.Sprintf("%v.%v", commandID, sequenceNumber)
+	docState.DocumentInformation.SequenceNumber = sequenceNumber
+	return docState
+}
+
+// waitForSubmissions waits up to a second for the submitter to get n documents
+func waitForSubmissions(submitter *recordingSubmitter, n int) {
+	deadline := time.Now().Add(time.Second)
+	for submitter.count() < n && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestMessageOrderingProcessesMessagesInSequence(t *testing.T) {
+	submitter := &recordingSubmitter{runTime: 10 * time.Millisecond}
+	var o messageOrdering
+	for _, sequenceNumber := range []int{3, 1, 4, 2} {
+		o.push(log.NewMockLog(), sequencedMessage("command", sequenceNumber), time.Minute, submitter.submit)
+	}
+
+	waitForSubmissions(submitter, 4)
+	submitter.m.Lock()
+	defer submitter.m.Unlock()
+	assert.Equal(t, []string{"command.1", "command.2", "command.3", "command.4"}, submitter.submitted)
+	// each message waits for its predecessor to be processed
+	assert.Equal(t, 1, submitter.maxRunning)
+}
+
+func TestMessageOrderingKeepsCommandsApart(t *testing.T) {
+	submitter := &recordingSubmitter{runTime: 10 * time.Millisecond}
+	var o messageOrdering
+	o.push(log.NewMockLog(), sequencedMessage("command1", 2), time.Minute, submitter.submit)
+	o.push(log.NewMockLog(), sequencedMessage("command2", 1), time.Minute, submitter.submit)
+	o.push(log.NewMockLog(), sequencedMessage("command1", 1), time.Minute, submitter.submit)
+
+	waitForSubmissions(submitter, 3)
+	submitter.m.Lock()
+	defer submitter.m.Unlock()
+	assert.Equal(t, []string{"command2.1", "command1.1", "command1.2"}, submitter.submitted)
+}
+
+func TestMessageOrderingProcessesBufferedMessagesAfterTimeout(t *testing.T) {
+	submitter := &recordingSubmitter{runTime: 10 * time.Millisecond}
+	var o messageOrdering
+	timeout := 100 * time.Millisecond
+	o.push(log.NewMockLog(), sequencedMessage("command", 3), timeout, submitter.submit)
+	o.push(log.NewMockLog(), sequencedMessage("command", 2), timeout, submitter.submit)
+	time.Sleep(timeout / 2)
+	assert.Equal(t, 0, submitter.count())
+
+	// message 1 never arrives, the buffered messages are processed in order once they time out
+	waitForSubmissions(submitter, 2)
+	submitter.m.Lock()
+	assert.Equal(t, []string{"command.2", "command.3"}, submitter.submitted)
+	submitter.m.Unlock()
+
+	// a message arriving late isn't held back
+	o.push(log.NewMockLog(), sequencedMessage("command", 1), time.Minute, submitter.submit)
+	assert.Equal(t, 3, submitter.count())
+}
+
+func TestMessageOrderingStopDropsBufferedMessages(t *testing.T) {
+	submitter := &recordingSubmitter{runTime: 10 * time.Millisecond}
+	var o messageOrdering
+	timeout := 50 * time.Millisecond
+	o.push(log.NewMockLog(), sequencedMessage("command", 2), timeout, submitter.submit)
+	o.push(log.NewMockLog(), sequencedMessage("command", 3), timeout, submitter.submit)
+
+	assert.Equal(t, 2, o.stop())
+	time.Sleep(2 * timeout)
+	assert.Equal(t, 0, submitter.count())
+}
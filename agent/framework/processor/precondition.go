@@ -0,0 +1,86 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"fmt"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+)
+
+var lookupCompleted = docmanager.LookupCompleted
+
+// unmetPrecondition checks that the precondition command of the document succeeded, it returns why the document
+// has to be skipped, or an empty string if it can run
+func unmetPrecondition(context context.T, docInfo model.DocumentInfo) string {
+	commandID := docInfo.PreconditionCommandID
+	if commandID == "" {
+		return ""
+	}
+	log := context.Log()
+	precondition, found := lookupCompleted(log, commandID, docInfo.InstanceID)
+	if !found {
+		if context.AppConfig().Mds.PreconditionNotFoundAction == appconfig.PreconditionNotFoundActionRun {
+			log.Infof("precondition command %v of document %v not found, running the document anyway", commandID, docInfo.DocumentID)
+			return ""
+		}
+		return fmt.Sprintf("precondition command %v not found", commandID)
+	}
+	if !precondition.DocumentStatus.IsSuccess() {
+		return fmt.Sprintf("precondition command %v completed with status %v", commandID, precondition.DocumentStatus)
+	}
+	return ""
+}
+
+// skipDocument completes the document as Skipped without running any of its plugins, and reports it
+func skipDocument(context context.T, resChan chan contracts.DocumentResult, docState *model.DocumentState, reason string) {
+	log := context.Log()
+	documentID := docState.DocumentInformation.DocumentID
+	instanceID := docState.DocumentInformation.InstanceID
+	log.Infof("skipping document %v, %v", documentID, reason)
+
+	res := contracts.DocumentResult{
+		MessageID:       docState.DocumentInformation.MessageID,
+		AssociationID:   docState.DocumentInformation.AssociationID,
+		DocumentName:    docState.DocumentInformation.DocumentName,
+		DocumentVersion: docState.DocumentInformation.DocumentVersion,
+		PluginResults:   make(map[string]*contracts.PluginResult),
+		Status:          contracts.ResultStatusSkipped,
+		NPlugins:        len(docState.InstancePluginsInformation),
+	}
+	for i := range docState.InstancePluginsInformation {
+		plugin := &docState.InstancePluginsInformation[i]
+		plugin.Result = contracts.PluginResult{
+			PluginName: plugin.Name,
+			Status:     contracts.ResultStatusSkipped,
+			Output:     reason,
+		}
+		result := plugin.Result
+		res.PluginResults[plugin.Id] = &result
+	}
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusSkipped
+	docState.DocumentInformation.DocumentTraceOutput = reason
+	if err := docmanager.PersistData(log, documentID, instanceID, appconfig.DefaultLocationOfPending, *docState); err != nil {
+		log.Errorf("failed to persist the skipped document %v: %v", documentID, err)
+	}
+	resChan <- res
+
+	terminalFolder := docmanager.TerminalLocationFolder(contracts.ResultStatusSkipped, context.AppConfig().Mds.SeparateFailedDocuments)
+	finalizeDocumentState(log, documentID, instanceID, appconfig.DefaultLocationOfPending, terminalFolder)
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer"
+	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/mock"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/task"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProcessCommandChecksPrecondition(t *testing.T) {
+	defer func() { lookupCompleted = docmanager.LookupCompleted }()
+	testCases := []struct {
+		name               string
+		preconditionStatus contracts.ResultStatus
+		notFoundAction     string
+		runs               bool
+	}{
+		{"satisfied", contracts.ResultStatusSuccess, appconfig.PreconditionNotFoundActionSkip, true},
+		{"unsatisfied", contracts.ResultStatusFailed, appconfig.PreconditionNotFoundActionSkip, false},
+		{"not found skipped", "", appconfig.PreconditionNotFoundActionSkip, false},
+		{"not found run", "", appconfig.PreconditionNotFoundActionRun, true},
+	}
+	for _, testCase := range testCases {
+		lookupCompleted = func(log log.T, documentID, instanceID string) (model.DocumentInfo, bool) {
+			assert.Equal(t, "priorCommandID", documentID)
+			if testCase.preconditionStatus == "" {
+				return model.DocumentInfo{}, false
+			}
+			return model.DocumentInfo{DocumentID: documentID, DocumentStatus: testCase.preconditionStatus}, true
+		}
+		config := appconfig.DefaultConfig()
+		config.Mds.PreconditionNotFoundAction = testCase.notFoundAction
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = "messageID"
+		docState.DocumentInformation.InstanceID = "instanceID"
+		docState.DocumentInformation.DocumentID = "documentID"
+		docState.DocumentInformation.PreconditionCommandID = "priorCommandID"
+		docState.InstancePluginsInformation = []model.PluginState{{Id: "plugin1", Name: "aws:runShellScript"}}
+		executerMock := executermocks.NewMockExecuter()
+		statusChan := make(chan contracts.DocumentResult, 1)
+		statusChan <- contracts.DocumentResult{Status: contracts.ResultStatusSuccess}
+		close(statusChan)
+		cancelFlag := task.NewChanneledCancelFlag()
+		if testCase.runs {
+			executerMock.On("Run", cancelFlag, mock.AnythingOfType("*executer.DocumentFileStore")).Return(statusChan)
+		}
+		creator := func(ctx context.T) executer.Executer {
+			return executerMock
+		}
+		resChan := make(chan contracts.DocumentResult, 1)
+
+		processCommand(ctx, creator, cancelFlag, resChan, &docState)
+
+		executerMock.AssertExpectations(t)
+		if assert.Len(t, resChan, 1, testCase.name) {
+			res := <-resChan
+			if testCase.runs {
+				assert.Equal(t, contracts.ResultStatusSuccess, res.Status, testCase.name)
+			} else {
+				assert.Equal(t, contracts.ResultStatusSkipped, res.Status, testCase.name)
+				assert.Equal(t, contracts.ResultStatusSkipped, res.PluginResults["plugin1"].Status, testCase.name)
+				assert.Equal(t, contracts.ResultStatusSkipped, docState.DocumentInformation.DocumentStatus, testCase.name)
+			}
+		}
+	}
+}
@@ -18,7 +18,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
@@ -29,6 +31,8 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/basicexecuter"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
 	"github.com/aws/amazon-ssm-agent/agent/platform"
 	"github.com/aws/amazon-ssm-agent/agent/rebooter"
 	"github.com/aws/amazon-ssm-agent/agent/task"
@@ -37,6 +41,24 @@ import (
 
 type ExecuterCreator func(ctx context.T) executer.Executer
 
+// Assign docmanager functions to global variables to allow unittest to override
+var claimDocument = docmanager.ClaimDocument
+var isDocumentCompleted = docmanager.IsDocumentCompleted
+var findExecutingDuplicate = docmanager.FindExecutingDuplicate
+var reclaimCompletedDocument = docmanager.ReclaimCompletedDocument
+var getFinalResult = docmanager.GetFinalResult
+var forceCompleteDocument = docmanager.ForceCompleteDocument
+var setDocumentLastError = docmanager.SetDocumentLastError
+var setDocumentResultHash = docmanager.SetDocumentResultHash
+var resetInterruptedPlugins = docmanager.ResetInterruptedPlugins
+var resetFailedPlugins = docmanager.ResetFailedPlugins
+var ensureStateFolders = docmanager.EnsureStateFolders
+var reconcileDocumentStates = docmanager.ReconcileDocumentStates
+var reconcileForeignDocumentStates = docmanager.ReconcileForeignDocumentStates
+var documentNames = docmanager.DocumentNames
+var flushAllPluginStates = docmanager.FlushAllPluginStates
+var getInstanceID = platform.InstanceID
+
 const (
 
 	// hardstopTimeout is the time before the processor will be shutdown during a hardstop
@@ -52,10 +74,26 @@ type Processor interface {
 	Submit(docState model.DocumentState)
 	//cancel process the cancel document, with no return value since the command is already tracked in a different thread
 	Cancel(docState model.DocumentState)
+	//ForceComplete moves a document stuck in progress to its terminal state with the given status and reason
+	ForceComplete(commandID, instanceID string, status contracts.ResultStatus, reason string) error
+	//QueueComposition returns how many documents of each name are pending or running
+	QueueComposition() map[string]DocumentCounts
+	//InFlightMessageIDs returns the ids of the messages whose documents are submitted to the pools and haven't completed yet
+	InFlightMessageIDs() []string
+	//AllowReprocess makes the next delivery of the command execute it again even though it has completed
+	AllowReprocess(commandID string)
+	//RerunFailedPlugins executes the failed plugins of the completed command again, keeping the results of the others
+	RerunFailedPlugins(commandID, instanceID string) error
 	//TODO do we need to implement CancelAll?
 	//CancelAll()
 }
 
+// DocumentCounts counts the documents of a name by state
+type DocumentCounts struct {
+	Pending int
+	Running int
+}
+
 type EngineProcessor struct {
 	context           context.T
 	executerCreator   ExecuterCreator
@@ -64,6 +102,13 @@ type EngineProcessor struct {
 	//TODO this should be abstract as the Processor's domain
 	supportedDocTypes []model.DocumentType
 	resChan           chan contracts.DocumentResult
+	documents         documentTracker
+	cancels           cancelQueue
+	resumer           resumer
+	reprocess         reprocessOverrides
+	ordering          messageOrdering
+	locks             lockSweeper
+	resends           resultResender
 }
 
 //TODO worker pool should be triggered in the Start() function
@@ -89,6 +134,11 @@ func NewEngineProcessor(ctx context.T, commandWorkerLimit int, cancelWorkerLimit
 	}
 }
 
+// InFlightDocuments returns how many documents are queued up or running across the processors of the agent
+func InFlightDocuments() int {
+	return int(atomic.LoadInt64(&inFlightDocuments))
+}
+
 func (p *EngineProcessor) Start() (resChan chan contracts.DocumentResult, err error) {
 	context := p.context
 	if context == nil {
@@ -96,20 +146,103 @@ func (p *EngineProcessor) Start() (resChan chan contracts.DocumentResult, err er
 	}
 	log := context.Log()
 	//process the older jobs from Current & Pending folder
-	instanceID, err := platform.InstanceID()
+	instanceID, err := p.resolveInstanceID()
 	if err != nil {
-		log.Errorf("no instanceID provided, %v", err)
-		return
+		log.Errorf("unable to start processing documents, %v", err)
+		return nil, err
+	}
+	//make sure the documents can be persisted before accepting any
+	if err = ensureStateFolders(log, instanceID); err != nil {
+		log.Errorf("unable to start processing documents, %v", err)
+		return nil, err
 	}
+	//repair the documents a crash left in the middle of a move before resuming them
+	reconcileDocumentStates(log, instanceID)
+	//take care of the documents carried over from another instance before they're looked up under this one
+	config := context.AppConfig()
+	reconcileForeignDocumentStates(log, instanceID, config.Mds.ForeignDocumentStateAction)
 	resChan = p.resChan
 	//prioritie the ongoing document first
-	p.processInProgressDocuments(instanceID)
+	resumable := p.processInProgressDocuments(instanceID)
 	//deal with the pending jobs that haven't picked up by worker yet
-	p.processPendingDocuments(instanceID)
+	resumable = append(resumable, p.processPendingDocuments(instanceID)...)
+	p.resumer.start(p.submit, resumable, config.Mds.ResumeConcurrencyLimit, time.Duration(config.Mds.ResumeIntervalMillis)*time.Millisecond)
+	p.locks.start(log, documentLockSweepInterval)
 	return
 }
 
+// resolveInstanceID returns the id the document states of the instance are persisted under,
+// the configured fallback id is used if the instance id lookup fails
+func (p *EngineProcessor) resolveInstanceID() (string, error) {
+	return docmanager.ResolveInstanceID(getInstanceID, p.context.AppConfig().Agent.FallbackInstanceID)
+}
+
+// Submit claims the document before queuing it up, a document that has been claimed already is never executed twice:
+// if it has completed its final result is sent again, unless AllowReprocess was called for its command, otherwise the redelivery is dropped.
+// With the Sequence message ordering strategy, a sequenced message is held back until the preceding messages of its command are processed.
 func (p *EngineProcessor) Submit(docState model.DocumentState) {
+	config := p.context.AppConfig()
+	if config.Mds.MessageOrderingStrategy == appconfig.MessageOrderingStrategySequence && docState.DocumentInformation.SequenceNumber > 0 {
+		timeout := time.Duration(config.Mds.MessageOrderingTimeoutSeconds) * time.Second
+		p.ordering.push(p.context.Log(), docState, timeout, p.claimAndSubmit)
+		return
+	}
+	p.claimAndSubmit(docState, nil)
+}
+
+// claimAndSubmit claims the document and queues it up, done, if any, is called once the document is over or if it isn't submitted
+func (p *EngineProcessor) claimAndSubmit(docState model.DocumentState, done func()) {
+	if !p.claim(docState) {
+		if done != nil {
+			done()
+		}
+		return
+	}
+	p.submit(docState, done)
+}
+
+// claim returns whether the document is to be executed
+func (p *EngineProcessor) claim(docState model.DocumentState) bool {
+	log := p.context.Log()
+	documentID := docState.DocumentInformation.DocumentID
+	instanceID := docState.DocumentInformation.InstanceID
+	// the duplicates of another document, according to docmanager.DocumentDedupKey, are dropped before they're claimed
+	if duplicateID, found := findExecutingDuplicate(log, docState); found && duplicateID != documentID {
+		log.Infof("document %v duplicates document %v already being executed, skipping it", documentID, duplicateID)
+		return false
+	}
+	claimed, err := claimDocument(log, documentID, instanceID)
+	if err != nil {
+		log.Errorf("failed to claim document %v, submitting it anyway: %v", documentID, err)
+	} else if !claimed {
+		if !isDocumentCompleted(documentID, instanceID) {
+			log.Infof("document %v is already being executed, skipping it", documentID)
+			return false
+		}
+		if !p.reprocess.consume(docState.DocumentInformation.CommandID) {
+			log.Infof("document %v has already been executed, resending its result", documentID)
+			p.resends.send(log, p.resChan, getFinalResult(log, documentID, instanceID))
+			return false
+		}
+		if claimed, err = reclaimCompletedDocument(log, documentID, instanceID); err != nil || !claimed {
+			log.Errorf("failed to reclaim document %v for reprocessing, skipping it: %v", documentID, err)
+			return false
+		}
+		log.Infof("document %v has already been executed, reprocessing it as requested", documentID)
+	}
+	return true
+}
+
+// AllowReprocess records a one-shot override of the completed check of Submit for the command,
+// so that its next delivery is executed again instead of resending its result
+func (p *EngineProcessor) AllowReprocess(commandID string) {
+	p.context.Log().Infof("command %v will be reprocessed on its next delivery", commandID)
+	p.reprocess.add(commandID)
+}
+
+// submit queues up the document in the send command pool, the documents resumed from a previous run
+// are already claimed so they're submitted here directly. done, if any, is called once the document is over or failed to be submitted.
+func (p *EngineProcessor) submit(docState model.DocumentState, done func()) {
 	log := p.context.Log()
 	//TODO this is a hack, in future jobID should be managed by Processing engine itself, instead of inferring from job's internal field
 	var jobID string
@@ -118,20 +251,38 @@ func (p *EngineProcessor) Submit(docState model.DocumentState) {
 	} else {
 		jobID = docState.DocumentInformation.MessageID
 	}
+	//cancel the older document this one replaces before it gets a chance to run
+	if supersededCommandID := docState.DocumentInformation.SupersedesCommandID; supersededCommandID != "" {
+		p.supersede(supersededCommandID, docState.DocumentInformation.CommandID)
+	}
 	//queue up the pending document
-	docmanager.PersistData(log, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, appconfig.DefaultLocationOfPending, docState)
+	if err := docmanager.PersistData(log, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, appconfig.DefaultLocationOfPending, docState); err != nil {
+		log.Errorf("document %v won't be resumed after an agent restart: %v", docState.DocumentInformation.DocumentID, err)
+	}
+	p.documents.add(jobID, docState)
 	err := p.sendCommandPool.Submit(log, jobID, func(cancelFlag task.CancelFlag) {
+		if done != nil {
+			defer done()
+		}
+		p.documents.markStarted(jobID)
 		processCommand(
 			p.context,
 			p.executerCreator,
 			cancelFlag,
 			p.resChan,
 			&docState)
+		if tracked, found := p.documents.remove(jobID); found && tracked.supersededBy != "" {
+			markSuperseded(p.context, &docState, tracked.supersededBy, docmanager.FindTerminalLocationFolder(docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID))
+		}
 	})
 	if err != nil {
+		if done != nil {
+			done()
+		}
+		p.documents.remove(jobID)
 		log.Error("Document Submission failed", err)
 		//move the fail-to-submit document to corrupt folder
-		docmanager.MoveDocumentState(log, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCorrupt)
+		finalizeDocumentState(log, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, appconfig.DefaultLocationOfPending, appconfig.DefaultLocationOfCorrupt)
 		return
 	}
 	return
@@ -147,9 +298,27 @@ func (p *EngineProcessor) Cancel(docState model.DocumentState) {
 		jobID = docState.DocumentInformation.MessageID
 	}
 	//queue up the pending document
-	docmanager.PersistData(log, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, appconfig.DefaultLocationOfPending, docState)
+	if err := docmanager.PersistData(log, docState.DocumentInformation.DocumentID, docState.DocumentInformation.InstanceID, appconfig.DefaultLocationOfPending, docState); err != nil {
+		log.Errorf("cancel command %v won't be resumed after an agent restart: %v", jobID, err)
+	}
+	//the cancel workers bound how many cancel commands are processed at once, the ones waiting for a worker
+	//are queued up so they don't hold up the send commands
+	if !p.cancels.push(docState, p.submitCancel) {
+		log.Errorf("CancelCommand %v dropped, too many cancel commands are waiting to be processed", jobID)
+	}
+}
+
+// submitCancel submits the cancel command to the cancel command pool, it blocks until a cancel worker is available
+func (p *EngineProcessor) submitCancel(docState model.DocumentState) {
+	log := p.context.Log()
+	jobID := docState.DocumentInformation.MessageID
+	if docState.IsAssociation() {
+		jobID = docState.DocumentInformation.AssociationID
+	}
 	err := p.cancelCommandPool.Submit(log, jobID, func(cancelFlag task.CancelFlag) {
 		processCancelCommand(p.context, p.sendCommandPool, &docState)
+		//a job cancelled before it started will never run, so it won't untrack itself
+		p.documents.removeIfNotStarted(docState.CancelInformation.CancelMessageID)
 	})
 	if err != nil {
 		log.Error("CancelCommand failed", err)
@@ -157,6 +326,142 @@ func (p *EngineProcessor) Cancel(docState model.DocumentState) {
 	}
 }
 
+// ForceComplete marks the document of the given command, stuck in the Current folder, with the given terminal status and reason,
+// and moves it to its terminal folder. A document that is still running is left untouched.
+func (p *EngineProcessor) ForceComplete(commandID, instanceID string, status contracts.ResultStatus, reason string) error {
+	log := p.context.Log()
+	switch status {
+	case "", contracts.ResultStatusNotStarted, contracts.ResultStatusInProgress:
+		return fmt.Errorf("status %v is not a terminal status", status)
+	}
+	if jobID, started, found := p.documents.find(commandID); found {
+		if started {
+			return fmt.Errorf("command %v is still running", commandID)
+		}
+		//the queued job is no longer needed, drop it from the pool
+		p.sendCommandPool.Cancel(jobID)
+		p.documents.remove(jobID)
+	}
+	terminalFolder := docmanager.TerminalLocationFolder(status, p.context.AppConfig().Mds.SeparateFailedDocuments)
+	if err := forceCompleteDocument(log, commandID, instanceID, status, reason, terminalFolder); err != nil {
+		return err
+	}
+	log.Infof("command %v is force completed with status %v, reason: %v", commandID, status, reason)
+	return nil
+}
+
+// RerunFailedPlugins moves the completed document of the given command back to the Pending folder with its failed plugins reset
+// and submits it again, only the failed plugins run and their new results are merged with the kept ones into a fresh completed state.
+// The document keeps its claim, so a redelivery of the command while it runs again is dropped.
+func (p *EngineProcessor) RerunFailedPlugins(commandID, instanceID string) error {
+	log := p.context.Log()
+	if _, _, found := p.documents.find(commandID); found {
+		return fmt.Errorf("command %v is still running", commandID)
+	}
+	docState, err := resetFailedPlugins(log, commandID, instanceID)
+	if err != nil {
+		return err
+	}
+	log.Infof("running the failed plugins of command %v again", commandID)
+	p.submit(docState, nil)
+	return nil
+}
+
+// InFlightMessageIDs returns the sorted ids of the messages whose documents are queued or running in the pools,
+// the associations are keyed by their association id in the send command pool and are resolved to the message id of their run
+func (p *EngineProcessor) InFlightMessageIDs() []string {
+	messageIDs := make(map[string]bool)
+	for _, jobID := range p.sendCommandPool.JobIDs() {
+		if messageID, found := p.documents.messageID(jobID); found {
+			messageIDs[messageID] = true
+		} else {
+			messageIDs[jobID] = true
+		}
+	}
+	for _, jobID := range p.cancelCommandPool.JobIDs() {
+		messageIDs[jobID] = true
+	}
+	sorted := make([]string, 0, len(messageIDs))
+	for messageID := range messageIDs {
+		sorted = append(sorted, messageID)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// QueueComposition returns how many documents of each name are pending or running in the send command pool,
+// along with the documents persisted in the Pending and Current folders that haven't been submitted yet
+func (p *EngineProcessor) QueueComposition() map[string]DocumentCounts {
+	log := p.context.Log()
+	composition := make(map[string]DocumentCounts)
+	counted := make(map[string]bool)
+	for _, tracked := range p.documents.list() {
+		documentName := tracked.docState.DocumentInformation.DocumentName
+		counts := composition[documentName]
+		if tracked.started {
+			counts.Running++
+		} else {
+			counts.Pending++
+		}
+		composition[documentName] = counts
+		counted[tracked.docState.DocumentInformation.DocumentID] = true
+	}
+
+	instanceID, err := p.resolveInstanceID()
+	if err != nil {
+		log.Debugf("skip counting the persisted documents, no instanceID provided, %v", err)
+		return composition
+	}
+	for _, locationFolder := range []string{appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfPending} {
+		for documentID, documentName := range documentNames(log, instanceID, locationFolder) {
+			if counted[documentID] {
+				continue
+			}
+			counts := composition[documentName]
+			if locationFolder == appconfig.DefaultLocationOfCurrent {
+				counts.Running++
+			} else {
+				counts.Pending++
+			}
+			composition[documentName] = counts
+			counted[documentID] = true
+		}
+	}
+	return composition
+}
+
+// supersede cancels the queued or running document of the given command, and marks it as superseded by newCommandID
+func (p *EngineProcessor) supersede(commandID, newCommandID string) {
+	log := p.context.Log()
+	jobID, started, found := p.documents.markSuperseded(commandID, newCommandID)
+	if !found {
+		log.Debugf("command %v superseded by %v is neither pending nor in progress", commandID, newCommandID)
+		return
+	}
+	log.Infof("command %v is superseded by %v, cancelling it", commandID, newCommandID)
+	if found = p.sendCommandPool.CancelWithReason(jobID, task.CancelReasonSuperseded); !found {
+		log.Debugf("Job with id %v not found (possibly completed)", jobID)
+		return
+	}
+	if started {
+		//the running job marks its own state as superseded once the executer returns
+		return
+	}
+	//the job will never be picked up by a worker, finalize the pending document here
+	tracked, found := p.documents.remove(jobID)
+	if !found {
+		return
+	}
+	docState := tracked.docState
+	docState.DocumentInformation.DocumentStatus = contracts.ResultStatusCancelled
+	markSuperseded(p.context, &docState, newCommandID, appconfig.DefaultLocationOfPending)
+	finalizeDocumentState(log,
+		docState.DocumentInformation.DocumentID,
+		docState.DocumentInformation.InstanceID,
+		appconfig.DefaultLocationOfPending,
+		appconfig.DefaultLocationOfCompleted)
+}
+
 //Stop set the cancel flags of all the running jobs, which are to be captured by the command worker and shutdown gracefully
 func (p *EngineProcessor) Stop(stopType contracts.StopType) {
 	var waitTimeout time.Duration
@@ -167,30 +472,52 @@ func (p *EngineProcessor) Stop(stopType contracts.StopType) {
 		waitTimeout = hardStopTimeout
 	}
 
+	// no more document is resumed once the pools shut down
+	p.resumer.stop(waitTimeout)
+	p.locks.stop()
+	// neither are the messages waiting for their turn
+	if dropped := p.ordering.stop(); dropped > 0 {
+		p.context.Log().Warnf("dropped %v messages waiting for their predecessors", dropped)
+	}
+
+	// the plugin states held back are persisted before the pools shut down, the agent may be killed before they're done
+	flushAllPluginStates()
+
 	var wg sync.WaitGroup
 
-	// shutdown the send command pool in a separate go routine
+	// shutdown the send command pool in a separate go routine, on a soft stop the protected documents are given longer to complete
+	config := p.context.AppConfig()
+	protectedTimeout := time.Duration(config.Mds.ProtectedDocumentsStopTimeoutSeconds) * time.Second
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		if stopType == contracts.StopTypeSoftStop && len(config.Mds.ProtectedDocuments) > 0 && protectedTimeout > 0 {
+			p.sendCommandPool.ShutdownAndWaitProtected(waitTimeout, protectedTimeout, p.isProtectedJob)
+			return
+		}
 		p.sendCommandPool.ShutdownAndWait(waitTimeout)
 	}()
 
-	// shutdown the cancel command pool in a separate go routine
+	// shutdown the cancel command pool in a separate go routine, once no more cancel command is submitted to it
 	wg.Add(1)
 	go func() {
 		defer wg.Done()
+		p.cancels.stop()
 		p.cancelCommandPool.ShutdownAndWait(waitTimeout)
 	}()
 
 	// wait for everything to shutdown
 	wg.Wait()
+	// and so are the ones the jobs made while shutting down
+	flushAllPluginStates()
+	p.resends.stop()
 	// close the receiver channel only after we're sure all the ongoing jobs are stopped and no sender is on this channel
 	close(p.resChan)
 }
 
 //TODO remove the direct file dependency once we encapsulate docmanager package
-func (p *EngineProcessor) processPendingDocuments(instanceID string) {
+// processPendingDocuments returns the supported documents of the Pending folder, to be resumed
+func (p *EngineProcessor) processPendingDocuments(instanceID string) (resumable []resumableDocument) {
 	log := p.context.Log()
 	files := []os.FileInfo{}
 	var err error
@@ -213,18 +540,26 @@ func (p *EngineProcessor) processPendingDocuments(instanceID string) {
 	for _, f := range files {
 		log.Debugf("Processing an older document - %v", f.Name())
 		//inspect document state
-		docState := docmanager.GetDocumentInterimState(log, f.Name(), instanceID, appconfig.DefaultLocationOfPending)
+		docState, err := docmanager.GetDocumentInterimStateE(log, f.Name(), instanceID, appconfig.DefaultLocationOfPending)
+		if skipUnreadableDocument(log, docState, err, f.Name(), instanceID, appconfig.DefaultLocationOfPending) {
+			continue
+		}
+		if quarantineIncompleteDocument(log, docState, f.Name(), instanceID, appconfig.DefaultLocationOfPending) {
+			continue
+		}
 
 		if p.isSupportedDocumentType(docState.DocumentType) {
 			log.Debugf("processor processing pending document %v", docState.DocumentInformation.DocumentID)
-			p.Submit(docState)
+			resumable = append(resumable, resumableDocument{docState: docState, modTime: f.ModTime()})
 		}
 
 	}
+	return
 }
 
-// ProcessInProgressDocuments processes InProgress documents that have been persisted in current folder
-func (p *EngineProcessor) processInProgressDocuments(instanceID string) {
+// ProcessInProgressDocuments processes InProgress documents that have been persisted in current folder,
+// it returns the supported ones to be resumed
+func (p *EngineProcessor) processInProgressDocuments(instanceID string) (resumable []resumableDocument) {
 	log := p.context.Log()
 	config := p.context.AppConfig()
 	var err error
@@ -248,25 +583,70 @@ func (p *EngineProcessor) processInProgressDocuments(instanceID string) {
 		log.Debugf("processing previously unexecuted document - %v", f.Name())
 
 		//inspect document state
-		docState := docmanager.GetDocumentInterimState(log, f.Name(), instanceID, appconfig.DefaultLocationOfCurrent)
+		docState, err := docmanager.GetDocumentInterimStateE(log, f.Name(), instanceID, appconfig.DefaultLocationOfCurrent)
+		if skipUnreadableDocument(log, docState, err, f.Name(), instanceID, appconfig.DefaultLocationOfCurrent) {
+			continue
+		}
 
 		retryLimit := config.Mds.CommandRetryLimit
 		if docState.DocumentInformation.RunCount >= retryLimit {
-			docmanager.MoveDocumentState(log, f.Name(), instanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCorrupt)
+			finalizeDocumentState(log, f.Name(), instanceID, appconfig.DefaultLocationOfCurrent, appconfig.DefaultLocationOfCorrupt)
+			continue
+		}
+		if quarantineIncompleteDocument(log, docState, f.Name(), instanceID, appconfig.DefaultLocationOfCurrent) {
 			continue
 		}
 
 		// increment the command run count
 		docState.DocumentInformation.RunCount++
 
-		docmanager.PersistData(log, docState.DocumentInformation.DocumentID, instanceID, appconfig.DefaultLocationOfCurrent, docState)
+		if err := docmanager.PersistData(log, docState.DocumentInformation.DocumentID, instanceID, appconfig.DefaultLocationOfCurrent, docState); err != nil {
+			log.Errorf("failed to record the run count of document %v: %v", docState.DocumentInformation.DocumentID, err)
+		}
 
 		if p.isSupportedDocumentType(docState.DocumentType) {
 			log.Debugf("processor processing in-progress document %v", docState.DocumentInformation.DocumentID)
 			//Submit the work to Job Pool so that we don't block for processing of new messages
-			p.Submit(docState)
+			resumable = append(resumable, resumableDocument{docState: docState, modTime: f.ModTime(), inProgress: true})
 		}
 	}
+	return
+}
+
+// skipUnreadableDocument returns whether the state of the document couldn't be read, a corrupt state is moved
+// to the corrupt folder by docmanager, it must not be processed as an empty document.
+// A state persisted by a newer agent in a schema version this agent doesn't know fails the document, it's moved to the corrupt folder as is.
+func skipUnreadableDocument(log log.T, docState model.DocumentState, err error, fileName, instanceID, locationFolder string) bool {
+	if _, unsupported := err.(*docmanager.UnsupportedStateVersionError); unsupported {
+		log.Errorf("failing document %v, its state can't be read by this agent: %v", fileName, err)
+		finalizeDocumentState(log, fileName, instanceID, locationFolder, appconfig.DefaultLocationOfCorrupt)
+		return true
+	}
+	switch {
+	case os.IsNotExist(err):
+		log.Debugf("skipping document %v, its state was removed since the folder was listed", fileName)
+	case err != nil:
+		log.Errorf("skipping document %v, its state couldn't be read: %v", fileName, err)
+	case docState.DocumentInformation.DocumentID == "":
+		log.Errorf("skipping document %v, its state is empty", fileName)
+	default:
+		return false
+	}
+	return true
+}
+
+// quarantineIncompleteDocument moves the document whose state lost some of its steps to the corrupt folder instead of resuming it,
+// returns whether the document was quarantined. The missing steps can't be rebuilt from the orchestration output,
+// which only holds what the steps printed, and resuming the document would silently skip them.
+func quarantineIncompleteDocument(log log.T, docState model.DocumentState, fileName, instanceID, locationFolder string) bool {
+	err := docmanager.ValidateDocumentCompleteness(docState)
+	if err == nil {
+		return false
+	}
+	log.Errorf("not resuming document %v: %v", fileName, err)
+	setDocumentLastError(log, fileName, instanceID, locationFolder, err.Error())
+	finalizeDocumentState(log, fileName, instanceID, locationFolder, appconfig.DefaultLocationOfCorrupt)
+	return true
 }
 
 func (p *EngineProcessor) isSupportedDocumentType(documentType model.DocumentType) bool {
@@ -280,12 +660,18 @@ func (p *EngineProcessor) isSupportedDocumentType(documentType model.DocumentTyp
 
 func processCommand(context context.T, executerCreator ExecuterCreator, cancelFlag task.CancelFlag, resChan chan contracts.DocumentResult, docState *model.DocumentState) {
 	log := context.Log()
+	start := time.Now()
+	if reason := unmetPrecondition(context, docState.DocumentInformation); reason != "" {
+		skipDocument(context, resChan, docState, reason)
+		return
+	}
 	//persist the current running document
 	docmanager.MoveDocumentState(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfPending,
 		appconfig.DefaultLocationOfCurrent)
+	createOrchestrationDir(context, docState.DocumentInformation.OrchestrationDirectory)
 	log.Debug("Running executer...")
 	documentID := docState.DocumentInformation.DocumentID
 	instanceID := docState.DocumentInformation.InstanceID
@@ -298,7 +684,22 @@ func processCommand(context context.T, executerCreator ExecuterCreator, cancelFl
 	)
 	// Listen for reboot
 	isReboot := false
+	finalStatus := contracts.ResultStatusSuccess
+	lastError := ""
+	//keep track of the stream so that an executer exiting before the document level response can be detected
+	var lastRes *contracts.DocumentResult
+	isTerminated := false
+	//a protected document shut down before it completes is resumed after the restart, its results aren't final then
+	protected := isProtectedDocument(log, context.AppConfig().Mds.ProtectedDocuments, docState.DocumentInformation.DocumentName)
+	var interrupted []string
 	for res := range statusChan {
+		if protected && cancelFlag.ShutDown() {
+			if res.LastPlugin != "" {
+				interrupted = append(interrupted, res.LastPlugin)
+			}
+			continue
+		}
+		lastRes = &res
 		if res.LastPlugin == "" {
 			log.Infof("sending document: %v complete response", documentID)
 		} else {
@@ -308,24 +709,116 @@ func processCommand(context context.T, executerCreator ExecuterCreator, cancelFl
 		//hand off the message to Service
 		resChan <- res
 		isReboot = res.Status == contracts.ResultStatusSuccessAndReboot
+		if res.LastPlugin == "" {
+			finalStatus = res.Status
+			isTerminated = true
+		}
+	}
+	if protected && !isTerminated && cancelFlag.ShutDown() {
+		log.Infof("protected document %v was shut down before it completed, it will be resumed", messageID)
+		if err := resetInterruptedPlugins(log, documentID, instanceID, interrupted); err != nil {
+			log.Errorf("failed to keep document %v for resuming: %v", documentID, err)
+		}
+		return
 	}
 	//TODO since there's a bug in UpdatePlugin that returns InProgress even if the document is completed, we cannot use InProgress to judge here, we need to fix the bug by the time out-of-proc is done
 	// Shutdown/reboot detection
 	if isReboot {
-		log.Infof("document %v requested reboot, need to resume", messageID)
-		rebooter.RequestPendingReboot(context.Log())
-		return
+		rebootDocState := docStore.Load()
+		rebootDocState.DocumentInformation.RebootCount++
+		docState.DocumentInformation.RebootCount = rebootDocState.DocumentInformation.RebootCount
+		if rebootLimit := context.AppConfig().Mds.RebootResumeLimit; rebootLimit > 0 && rebootDocState.DocumentInformation.RebootCount > rebootLimit {
+			//the document keeps asking for a reboot, fail it instead of resuming it forever
+			reason := fmt.Sprintf("document requested a reboot more than %v times, failing it", rebootLimit)
+			log.Errorf("document %v %v", messageID, reason)
+			rebootDocState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+			rebootDocState.DocumentInformation.DocumentTraceOutput = reason
+			docStore.Save(rebootDocState)
+			resChan <- abnormalTerminationResult(docState, lastRes)
+			finalStatus = contracts.ResultStatusFailed
+			lastError = reason
+		} else {
+			docStore.Save(rebootDocState)
+			log.Infof("document %v requested reboot, need to resume", messageID)
+			rebooter.RequestPendingReboot(context.Log())
+			return
+		}
 	}
 
-	//persist : commands execution in completed folder (terminal state folder)
-	log.Debugf("execution of %v is over. Moving interimState file from Current to Completed folder", messageID)
+	if !isTerminated {
+		log.Errorf("executer of document %v closed its channel without reporting the document status, marking it failed", messageID)
+		resChan <- abnormalTerminationResult(docState, lastRes)
+		finalDocState := docStore.Load()
+		finalDocState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
+		docStore.Save(finalDocState)
+		finalStatus = contracts.ResultStatusFailed
+		lastError = "the document stopped without reporting its status"
+	}
 
-	docmanager.MoveDocumentState(log,
+	//summarize why the document failed so that it can be reported without going through its plugin results
+	if finalStatus == contracts.ResultStatusFailed || finalStatus == contracts.ResultStatusTimedOut {
+		if lastError == "" && lastRes != nil {
+			lastError = docmanager.SummarizePluginFailure(lastRes.PluginResults)
+		}
+		if lastError == "" {
+			lastError = fmt.Sprintf("document completed with status %v", finalStatus)
+		}
+		setDocumentLastError(log, documentID, instanceID, appconfig.DefaultLocationOfCurrent, lastError)
+	}
+	recordDocumentStatus(context, docState.DocumentInformation.DocumentName, finalStatus)
+
+	if err := setDocumentResultHash(log, documentID, instanceID, appconfig.DefaultLocationOfCurrent); err != nil {
+		log.Debugf("failed to record the result hash of document %v: %v", documentID, err)
+	}
+
+	//persist : commands execution in completed or failed folder (terminal state folder)
+	terminalFolder := docmanager.TerminalLocationFolder(finalStatus, context.AppConfig().Mds.SeparateFailedDocuments)
+	log.Debugf("execution of %v is over. Moving interimState file from Current to %v folder", messageID, terminalFolder)
+
+	finalizeDocumentState(log,
 		documentID,
 		instanceID,
 		appconfig.DefaultLocationOfCurrent,
-		appconfig.DefaultLocationOfCompleted)
+		terminalFolder)
+	metrics.DefaultSink.Observe(metrics.DocumentProcessingSeconds, time.Since(start).Seconds())
+}
+
+// abnormalTerminationResult builds the failed document level response of a document that couldn't run to completion,
+// e.g. whose executer exited without sending one, it carries over the plugin results of the last update received
+func abnormalTerminationResult(docState *model.DocumentState, lastRes *contracts.DocumentResult) contracts.DocumentResult {
+	docInfo := docState.DocumentInformation
+	res := contracts.DocumentResult{
+		MessageID:       docInfo.MessageID,
+		AssociationID:   docInfo.AssociationID,
+		DocumentName:    docInfo.DocumentName,
+		DocumentVersion: docInfo.DocumentVersion,
+		NPlugins:        len(docState.InstancePluginsInformation),
+	}
+	if lastRes != nil {
+		res.PluginResults = lastRes.PluginResults
+	}
+	res.Status = contracts.ResultStatusFailed
+	res.LastPlugin = ""
+	return res
+}
 
+// markSuperseded records in the persisted document state that the document was replaced by newCommandID
+func markSuperseded(context context.T, docState *model.DocumentState, newCommandID, locationFolder string) {
+	log := context.Log()
+	documentID := docState.DocumentInformation.DocumentID
+	instanceID := docState.DocumentInformation.InstanceID
+	docState.DocumentInformation.SupersededBy = newCommandID
+
+	docInfo := docmanager.GetDocumentInfo(log, documentID, instanceID, locationFolder)
+	if docInfo.DocumentID == "" {
+		log.Debugf("document %v not found in %v, skip marking it as superseded", documentID, locationFolder)
+		return
+	}
+	if docInfo.DocumentStatus == "" || docInfo.DocumentStatus == contracts.ResultStatusInProgress {
+		docInfo.DocumentStatus = contracts.ResultStatusCancelled
+	}
+	docInfo.SupersededBy = newCommandID
+	docmanager.PersistDocumentInfo(log, docInfo, documentID, instanceID, locationFolder)
 }
 
 //TODO CancelCommand is currently treated as a special type of Command by the Processor, but in general Cancel operation should be seen as a probe to existing commands
@@ -335,7 +828,7 @@ func processCancelCommand(context context.T, sendCommandPool task.Pool, docState
 
 	log.Debugf("Canceling job with id %v...", docState.CancelInformation.CancelMessageID)
 
-	if found := sendCommandPool.Cancel(docState.CancelInformation.CancelMessageID); !found {
+	if found := sendCommandPool.CancelWithReason(docState.CancelInformation.CancelMessageID, task.CancelReasonUserRequested); !found {
 		log.Debugf("Job with id %v not found (possibly completed)", docState.CancelInformation.CancelMessageID)
 		docState.CancelInformation.DebugInfo = fmt.Sprintf("Command %v couldn't be cancelled", docState.CancelInformation.CancelCommandID)
 		docState.DocumentInformation.DocumentStatus = contracts.ResultStatusFailed
@@ -345,15 +838,17 @@ func processCancelCommand(context context.T, sendCommandPool task.Pool, docState
 	}
 
 	//persist the final status of cancel-message in current folder
-	docmanager.PersistData(log,
+	if err := docmanager.PersistData(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
-		appconfig.DefaultLocationOfCurrent, docState)
+		appconfig.DefaultLocationOfCurrent, docState); err != nil {
+		log.Errorf("failed to persist the final status of cancel command %v: %v", docState.CancelInformation.CancelMessageID, err)
+	}
 
 	//persist : commands execution in completed folder (terminal state folder)
 	log.Debugf("Execution of %v is over. Moving interimState file from Current to Completed folder", docState.DocumentInformation.MessageID)
 
-	docmanager.MoveDocumentState(log,
+	finalizeDocumentState(log,
 		docState.DocumentInformation.DocumentID,
 		docState.DocumentInformation.InstanceID,
 		appconfig.DefaultLocationOfCurrent,
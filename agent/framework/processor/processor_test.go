@@ -15,16 +15,24 @@
 package processor
 
 import (
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"fmt"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
 	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer"
 	"github.com/aws/amazon-ssm-agent/agent/framework/processor/executer/mock"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/platform"
 	"github.com/aws/amazon-ssm-agent/agent/task"
+	"github.com/aws/amazon-ssm-agent/agent/times"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -49,6 +57,479 @@ func TestEngineProcessor_Submit(t *testing.T) {
 	sendCommandPoolMock.AssertExpectations(t)
 }
 
+func TestEngineProcessor_SubmitSupersedesPendingDocument(t *testing.T) {
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "oldMessageID", mock.Anything).Return(nil)
+	sendCommandPoolMock.On("Submit", ctx.Log(), "newMessageID", mock.Anything).Return(nil)
+	sendCommandPoolMock.On("CancelWithReason", "oldMessageID", task.CancelReasonSuperseded).Return(true)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+	}
+	oldDocState := model.DocumentState{}
+	oldDocState.DocumentInformation.MessageID = "oldMessageID"
+	oldDocState.DocumentInformation.CommandID = "oldCommandID"
+	processor.Submit(oldDocState)
+
+	newDocState := model.DocumentState{}
+	newDocState.DocumentInformation.MessageID = "newMessageID"
+	newDocState.DocumentInformation.CommandID = "newCommandID"
+	newDocState.DocumentInformation.SupersedesCommandID = "oldCommandID"
+	processor.Submit(newDocState)
+
+	sendCommandPoolMock.AssertExpectations(t)
+	// the pending document is finalized right away since its job will never start
+	_, found := processor.documents.remove("oldMessageID")
+	assert.False(t, found)
+	_, found = processor.documents.remove("newMessageID")
+	assert.True(t, found)
+}
+
+func TestEngineProcessor_SubmitSupersedesRunningDocument(t *testing.T) {
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "oldMessageID", mock.Anything).Return(nil)
+	sendCommandPoolMock.On("Submit", ctx.Log(), "newMessageID", mock.Anything).Return(nil)
+	sendCommandPoolMock.On("CancelWithReason", "oldMessageID", task.CancelReasonSuperseded).Return(true)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+	}
+	oldDocState := model.DocumentState{}
+	oldDocState.DocumentInformation.MessageID = "oldMessageID"
+	oldDocState.DocumentInformation.CommandID = "oldCommandID"
+	processor.Submit(oldDocState)
+	processor.documents.markStarted("oldMessageID")
+
+	newDocState := model.DocumentState{}
+	newDocState.DocumentInformation.MessageID = "newMessageID"
+	newDocState.DocumentInformation.CommandID = "newCommandID"
+	newDocState.DocumentInformation.SupersedesCommandID = "oldCommandID"
+	processor.Submit(newDocState)
+
+	sendCommandPoolMock.AssertExpectations(t)
+	// the running job is cancelled and left to mark itself superseded when it exits
+	tracked, found := processor.documents.remove("oldMessageID")
+	assert.True(t, found)
+	assert.Equal(t, "newCommandID", tracked.supersededBy)
+}
+
+func TestEngineProcessor_QueueComposition(t *testing.T) {
+	defer stubClaimDocument(false)()
+	getInstanceID = func() (string, error) { return "instanceID", nil }
+	documentNames = func(log log.T, instanceID, locationFolder string) map[string]string {
+		switch locationFolder {
+		case appconfig.DefaultLocationOfPending:
+			// the submitted documents are persisted in Pending as well
+			return map[string]string{"command1": "AWS-RunShellScript", "command4": "AWS-RunPowerShellScript"}
+		case appconfig.DefaultLocationOfCurrent:
+			return map[string]string{"command5": "AWS-RunShellScript"}
+		}
+		return nil
+	}
+	defer func() {
+		getInstanceID = platform.InstanceID
+		documentNames = docmanager.DocumentNames
+	}()
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), mock.Anything, mock.Anything).Return(nil)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+	}
+	for i, documentName := range []string{"AWS-RunShellScript", "AWS-RunShellScript", "AWS-ApplyPatchBaseline"} {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = fmt.Sprintf("message%v", i+1)
+		docState.DocumentInformation.DocumentID = fmt.Sprintf("command%v", i+1)
+		docState.DocumentInformation.DocumentName = documentName
+		processor.Submit(docState)
+	}
+	processor.documents.markStarted("message2")
+
+	assert.Equal(t, map[string]DocumentCounts{
+		"AWS-RunShellScript":      {Pending: 1, Running: 2},
+		"AWS-ApplyPatchBaseline":  {Pending: 1},
+		"AWS-RunPowerShellScript": {Pending: 1},
+	}, processor.QueueComposition())
+}
+
+// blockingExecuter completes its documents once released
+type blockingExecuter struct {
+	release chan bool
+}
+
+func (e blockingExecuter) Run(cancelFlag task.CancelFlag, docStore executer.DocumentStore) chan contracts.DocumentResult {
+	statusChan := make(chan contracts.DocumentResult, 1)
+	go func() {
+		<-e.release
+		statusChan <- contracts.DocumentResult{Status: contracts.ResultStatusSuccess}
+		close(statusChan)
+	}()
+	return statusChan
+}
+
+func TestEngineProcessor_InFlightMessageIDs(t *testing.T) {
+	defer stubClaimDocument(false)()
+	ctx := context.NewMockDefault()
+	release := make(chan bool)
+	processor := EngineProcessor{
+		executerCreator: func(ctx context.T) executer.Executer {
+			return blockingExecuter{release: release}
+		},
+		sendCommandPool:   task.NewPool(ctx.Log(), 3, time.Second, times.DefaultClock),
+		cancelCommandPool: task.NewPool(ctx.Log(), 1, time.Second, times.DefaultClock),
+		resChan:           make(chan contracts.DocumentResult, 10),
+		context:           ctx,
+	}
+	defer processor.sendCommandPool.ShutdownAndWait(time.Second)
+	defer processor.cancelCommandPool.ShutdownAndWait(time.Second)
+	assert.Empty(t, processor.InFlightMessageIDs())
+
+	for _, messageID := range []string{"aws.ssm.command2.instanceID", "aws.ssm.command1.instanceID"} {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = messageID
+		docState.DocumentInformation.DocumentID = messageID
+		processor.Submit(docState)
+	}
+	// associations are keyed by their association id in the pool
+	association := model.DocumentState{DocumentType: model.Association}
+	association.DocumentInformation.AssociationID = "associationID"
+	association.DocumentInformation.DocumentID = "associationID.runID"
+	association.DocumentInformation.MessageID = "aws.ssm.associationID.instanceID"
+	processor.Submit(association)
+
+	assert.Equal(t, []string{
+		"aws.ssm.associationID.instanceID",
+		"aws.ssm.command1.instanceID",
+		"aws.ssm.command2.instanceID",
+	}, processor.InFlightMessageIDs())
+
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for len(processor.InFlightMessageIDs()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Empty(t, processor.InFlightMessageIDs())
+}
+
+// stubClaimDocument replaces the persisted claim markers with an in-memory set, returns a function restoring them
+func stubClaimDocument(completed bool) func() {
+	var m sync.Mutex
+	claimed := make(map[string]bool)
+	origClaimDocument, origIsDocumentCompleted, origGetFinalResult := claimDocument, isDocumentCompleted, getFinalResult
+	origReclaimCompletedDocument := reclaimCompletedDocument
+	claimDocument = func(log log.T, documentID, instanceID string) (bool, error) {
+		m.Lock()
+		defer m.Unlock()
+		if claimed[documentID] {
+			return false, nil
+		}
+		claimed[documentID] = true
+		return true, nil
+	}
+	isDocumentCompleted = func(documentID, instanceID string) bool {
+		return completed
+	}
+	getFinalResult = func(log log.T, documentID, instanceID string) contracts.DocumentResult {
+		return contracts.DocumentResult{MessageID: "messageID", Status: contracts.ResultStatusSuccess}
+	}
+	reclaimCompletedDocument = func(log log.T, documentID, instanceID string) (bool, error) {
+		m.Lock()
+		defer m.Unlock()
+		claimed[documentID] = true
+		return completed, nil
+	}
+	return func() {
+		claimDocument, isDocumentCompleted, getFinalResult = origClaimDocument, origIsDocumentCompleted, origGetFinalResult
+		reclaimCompletedDocument = origReclaimCompletedDocument
+	}
+}
+
+// countingPool counts the jobs submitted to it, unlike task.MockedPool it doesn't format the logger it's passed,
+// which races with the concurrent calls of the logger mock. The cancels are handed to cancelWithReason.
+type countingPool struct {
+	task.Pool
+	submits          int32
+	cancelWithReason func(jobID string, reason task.CancelReason) bool
+}
+
+func (p *countingPool) Submit(log log.T, jobID string, job task.Job) error {
+	atomic.AddInt32(&p.submits, 1)
+	return nil
+}
+
+func (p *countingPool) CancelWithReason(jobID string, reason task.CancelReason) bool {
+	return p.cancelWithReason(jobID, reason)
+}
+
+func TestEngineProcessor_SubmitSameDocumentConcurrently(t *testing.T) {
+	defer stubClaimDocument(false)()
+	sendCommandPool := &countingPool{}
+	ctx := context.NewMockDefault()
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPool,
+		context:         ctx,
+	}
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.DocumentID = "documentID"
+
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			processor.Submit(docState)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&sendCommandPool.submits))
+}
+
+func TestEngineProcessor_SubmitSkipsDuplicateDocuments(t *testing.T) {
+	defer stubClaimDocument(false)()
+	defer func(orig func(log.T, model.DocumentState) (string, bool)) { findExecutingDuplicate = orig }(findExecutingDuplicate)
+	findExecutingDuplicate = func(log log.T, docState model.DocumentState) (string, bool) {
+		if docState.DocumentInformation.DocumentID == "duplicateDocumentID" {
+			return "documentID", true
+		}
+		return "", false
+	}
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "messageID", mock.Anything).Return(nil).Once()
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+	}
+	for _, documentID := range []string{"documentID", "duplicateDocumentID"} {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = "messageID"
+		docState.DocumentInformation.DocumentID = documentID
+		processor.Submit(docState)
+	}
+
+	sendCommandPoolMock.AssertNumberOfCalls(t, "Submit", 1)
+}
+
+func TestEngineProcessor_SubmitHoldsBackOutOfOrderMessages(t *testing.T) {
+	defer stubClaimDocument(false)()
+	sendCommandPoolMock := new(task.MockedPool)
+	config := appconfig.DefaultConfig()
+	config.Mds.MessageOrderingStrategy = appconfig.MessageOrderingStrategySequence
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	var submitted []string
+	sendCommandPoolMock.On("Submit", mock.Anything, mock.Anything, mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		submitted = append(submitted, args.String(1))
+	})
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+	}
+	for _, sequenceNumber := range []int{2, 1} {
+		docState := sequencedMessage("commandID", sequenceNumber)
+		docState.DocumentInformation.MessageID = fmt.Sprintf("messageID%v", sequenceNumber)
+		processor.Submit(docState)
+	}
+	unsequenced := model.DocumentState{}
+	unsequenced.DocumentInformation.MessageID = "unsequencedMessageID"
+	unsequenced.DocumentInformation.CommandID = "commandID"
+	processor.Submit(unsequenced)
+
+	// message 2 waits for message 1 to complete, the unsequenced message is submitted as it arrives
+	assert.Equal(t, []string{"messageID1", "unsequencedMessageID"}, submitted)
+	assert.Equal(t, 1, processor.ordering.stop())
+}
+
+func TestEngineProcessor_SubmitCompletedDocumentResendsResult(t *testing.T) {
+	defer stubClaimDocument(true)()
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "messageID", mock.Anything).Return(nil).Once()
+	resChan := make(chan contracts.DocumentResult, 1)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+		resChan:         resChan,
+	}
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.DocumentID = "documentID"
+	processor.Submit(docState)
+	processor.Submit(docState)
+
+	sendCommandPoolMock.AssertNumberOfCalls(t, "Submit", 1)
+	res := <-resChan
+	assert.Equal(t, "messageID", res.MessageID)
+	assert.Equal(t, contracts.ResultStatusSuccess, res.Status)
+}
+
+func TestEngineProcessor_SubmitCompletedDocumentDoesNotWaitForTheResult(t *testing.T) {
+	defer stubClaimDocument(true)()
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "messageID", mock.Anything).Return(nil).Once()
+	// nobody consumes the results yet
+	resChan := make(chan contracts.DocumentResult)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+		resChan:         resChan,
+	}
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.DocumentID = "documentID"
+	processor.Submit(docState)
+
+	submitted := make(chan bool)
+	go func() {
+		processor.Submit(docState)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "the redelivery waited for its result to be consumed")
+	}
+	res := <-resChan
+	assert.Equal(t, "messageID", res.MessageID)
+}
+
+func TestEngineProcessor_SubmitCompletedDocumentAfterStop(t *testing.T) {
+	defer stubClaimDocument(true)()
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "messageID", mock.Anything).Return(nil).Once()
+	resChan := make(chan contracts.DocumentResult)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+		resChan:         resChan,
+	}
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.DocumentID = "documentID"
+	processor.Submit(docState)
+	// a result resent before the stop is dropped, not left blocking the stop
+	processor.Submit(docState)
+
+	// as Stop does once the pools are shut down
+	processor.resends.stop()
+	close(resChan)
+
+	// a redelivery once the result channel is closed doesn't send on it
+	assert.NotPanics(t, func() { processor.Submit(docState) })
+	_, open := <-resChan
+	assert.False(t, open)
+}
+
+func TestEngineProcessor_AllowReprocessCompletedDocument(t *testing.T) {
+	defer stubClaimDocument(true)()
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "messageID", mock.Anything).Return(nil)
+	resChan := make(chan contracts.DocumentResult, 2)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+		resChan:         resChan,
+	}
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.DocumentID = "commandID"
+	docState.DocumentInformation.CommandID = "commandID"
+	processor.Submit(docState)
+	sendCommandPoolMock.AssertNumberOfCalls(t, "Submit", 1)
+
+	// the override is for the next delivery only
+	processor.AllowReprocess("commandID")
+	processor.Submit(docState)
+	processor.Submit(docState)
+
+	sendCommandPoolMock.AssertNumberOfCalls(t, "Submit", 2)
+	// the results are resent in the background
+	processor.resends.wg.Wait()
+	assert.Len(t, resChan, 1)
+	res := <-resChan
+	assert.Equal(t, contracts.ResultStatusSuccess, res.Status)
+}
+
+func TestEngineProcessor_AllowReprocessOtherCommand(t *testing.T) {
+	defer stubClaimDocument(true)()
+	sendCommandPoolMock := new(task.MockedPool)
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock.On("Submit", ctx.Log(), "messageID", mock.Anything).Return(nil)
+	resChan := make(chan contracts.DocumentResult, 1)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+		resChan:         resChan,
+	}
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.DocumentID = "commandID"
+	docState.DocumentInformation.CommandID = "commandID"
+	processor.Submit(docState)
+
+	processor.AllowReprocess("otherCommandID")
+	processor.Submit(docState)
+
+	sendCommandPoolMock.AssertNumberOfCalls(t, "Submit", 1)
+	// the results are resent in the background
+	processor.resends.wg.Wait()
+	assert.Len(t, resChan, 1)
+}
+
+func TestEngineProcessor_ForceCompleteStuckDocument(t *testing.T) {
+	origForceCompleteDocument := forceCompleteDocument
+	defer func() { forceCompleteDocument = origForceCompleteDocument }()
+	var forcedFolder string
+	forceCompleteDocument = func(log log.T, documentID, instanceID string, status contracts.ResultStatus, reason, terminalFolder string) error {
+		assert.Equal(t, "commandID", documentID)
+		assert.Equal(t, contracts.ResultStatusFailed, status)
+		assert.Equal(t, "worker died", reason)
+		forcedFolder = terminalFolder
+		return nil
+	}
+	processor := EngineProcessor{
+		context: context.NewMockDefault(),
+	}
+
+	err := processor.ForceComplete("commandID", "instanceID", contracts.ResultStatusFailed, "worker died")
+	assert.NoError(t, err)
+	assert.Equal(t, appconfig.DefaultLocationOfCompleted, forcedFolder)
+
+	// only terminal statuses are accepted
+	err = processor.ForceComplete("commandID", "instanceID", contracts.ResultStatusInProgress, "worker died")
+	assert.Error(t, err)
+}
+
+func TestEngineProcessor_ForceCompleteRunningDocument(t *testing.T) {
+	origForceCompleteDocument := forceCompleteDocument
+	defer func() { forceCompleteDocument = origForceCompleteDocument }()
+	forceCompleteDocument = func(log log.T, documentID, instanceID string, status contracts.ResultStatus, reason, terminalFolder string) error {
+		assert.Fail(t, "a running document must not be force completed")
+		return nil
+	}
+	processor := EngineProcessor{
+		context: context.NewMockDefault(),
+	}
+	docState := model.DocumentState{}
+	docState.DocumentInformation.CommandID = "commandID"
+	processor.documents.add("messageID", docState)
+	processor.documents.markStarted("messageID")
+
+	err := processor.ForceComplete("commandID", "instanceID", contracts.ResultStatusFailed, "worker died")
+	assert.Error(t, err)
+	_, found := processor.documents.remove("messageID")
+	assert.True(t, found)
+}
+
 func TestEngineProcessor_Cancel(t *testing.T) {
 	cancelCommandPoolMock := new(task.MockedPool)
 	ctx := context.NewMockDefault()
@@ -56,7 +537,10 @@ func TestEngineProcessor_Cancel(t *testing.T) {
 	creator := func(ctx context.T) executer.Executer {
 		return executerMock
 	}
-	cancelCommandPoolMock.On("Submit", ctx.Log(), "cancelMessageID", mock.Anything).Return(nil)
+	submitted := make(chan bool)
+	cancelCommandPoolMock.On("Submit", ctx.Log(), "cancelMessageID", mock.Anything).Return(nil).Run(func(args mock.Arguments) {
+		close(submitted)
+	})
 	processor := EngineProcessor{
 		executerCreator:   creator,
 		cancelCommandPool: cancelCommandPoolMock,
@@ -65,9 +549,84 @@ func TestEngineProcessor_Cancel(t *testing.T) {
 	docState := model.DocumentState{}
 	docState.DocumentInformation.MessageID = "cancelMessageID"
 	processor.Cancel(docState)
+	// the cancel command is submitted to the pool in the background
+	select {
+	case <-submitted:
+	case <-time.After(time.Second):
+	}
 	cancelCommandPoolMock.AssertExpectations(t)
 }
 
+func TestEngineProcessor_CancelBurst(t *testing.T) {
+	const cancelWorkersLimit = 2
+	const burst = 10
+	ctx := context.NewMockDefault()
+	var m sync.Mutex
+	running, maxRunning, canceled := 0, 0, 0
+	release := make(chan bool)
+	// every cancel holds its worker until released
+	sendCommandPool := &countingPool{cancelWithReason: func(jobID string, reason task.CancelReason) bool {
+		assert.Equal(t, task.CancelReasonUserRequested, reason)
+		m.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		m.Unlock()
+		<-release
+		m.Lock()
+		running--
+		canceled++
+		m.Unlock()
+		return true
+	}}
+	processor := EngineProcessor{
+		sendCommandPool:   sendCommandPool,
+		cancelCommandPool: task.NewPool(ctx.Log(), cancelWorkersLimit, time.Second, times.DefaultClock),
+		context:           ctx,
+	}
+
+	for i := 0; i < burst; i++ {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = fmt.Sprintf("cancelMessageID%v", i)
+		docState.CancelInformation.CancelMessageID = fmt.Sprintf("messageID%v", i)
+		processor.Cancel(docState)
+	}
+
+	// the send commands are still processed while all the cancel workers are busy
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	submitted := make(chan bool)
+	go func() {
+		processor.Submit(docState)
+		close(submitted)
+	}()
+	select {
+	case <-submitted:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "send command blocked by the cancel commands")
+	}
+
+	// the cancel workers all pick up a cancel before any is released
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		m.Lock()
+		busy := running
+		m.Unlock()
+		if busy == cancelWorkersLimit {
+			break
+		}
+	}
+	for i := 0; i < burst; i++ {
+		release <- true
+	}
+	m.Lock()
+	assert.Equal(t, cancelWorkersLimit, maxRunning)
+	m.Unlock()
+	processor.cancels.stop()
+	processor.cancelCommandPool.ShutdownAndWait(time.Second)
+	assert.Equal(t, burst, canceled)
+}
+
 func TestEngineProcessor_Stop(t *testing.T) {
 	sendCommandPoolMock := new(task.MockedPool)
 	cancelCommandPoolMock := new(task.MockedPool)
@@ -86,6 +645,183 @@ func TestEngineProcessor_Stop(t *testing.T) {
 	cancelCommandPoolMock.AssertExpectations(t)
 }
 
+func TestEngineProcessor_StopFlushesPluginStatesBeforeThePoolsShutDown(t *testing.T) {
+	flushes := 0
+	defer func(orig func()) { flushAllPluginStates = orig }(flushAllPluginStates)
+	flushAllPluginStates = func() { flushes++ }
+	sendCommandPoolMock := new(task.MockedPool)
+	cancelCommandPoolMock := new(task.MockedPool)
+	processor := EngineProcessor{
+		sendCommandPool:   sendCommandPoolMock,
+		cancelCommandPool: cancelCommandPoolMock,
+		context:           context.NewMockDefault(),
+		resChan:           make(chan contracts.DocumentResult),
+	}
+	flushedBeforeShutdown := false
+	sendCommandPoolMock.On("ShutdownAndWait", mock.AnythingOfType("time.Duration")).Return(true).Run(func(mock.Arguments) {
+		flushedBeforeShutdown = flushes == 1
+	})
+	cancelCommandPoolMock.On("ShutdownAndWait", mock.AnythingOfType("time.Duration")).Return(true)
+
+	processor.Stop(contracts.StopTypeSoftStop)
+
+	assert.True(t, flushedBeforeShutdown)
+	// the updates the jobs made while shutting down are persisted as well
+	assert.Equal(t, 2, flushes)
+}
+
+func TestEngineProcessor_StopLetsProtectedDocumentsFinish(t *testing.T) {
+	sendCommandPoolMock := new(task.MockedPool)
+	cancelCommandPoolMock := new(task.MockedPool)
+	config := appconfig.DefaultConfig()
+	config.Mds.ProtectedDocuments = []string{"AWS-RunPatchBaseline*"}
+	config.Mds.ProtectedDocumentsStopTimeoutSeconds = 600
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	processor := EngineProcessor{
+		sendCommandPool:   sendCommandPoolMock,
+		cancelCommandPool: cancelCommandPoolMock,
+		context:           ctx,
+		resChan:           make(chan contracts.DocumentResult),
+	}
+	for jobID, documentName := range map[string]string{"patch": "AWS-RunPatchBaseline", "script": "AWS-RunShellScript", "queued": "AWS-RunPatchBaselineAssociation"} {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.DocumentName = documentName
+		processor.documents.add(jobID, docState)
+	}
+	processor.documents.markStarted("patch")
+	processor.documents.markStarted("script")
+	var protected func(jobID string) bool
+	sendCommandPoolMock.On("ShutdownAndWaitProtected", time.Duration(config.Mds.StopTimeoutMillis)*time.Millisecond, 600*time.Second, mock.Anything).Return(true).Run(func(args mock.Arguments) {
+		protected = args.Get(2).(func(jobID string) bool)
+	})
+	cancelCommandPoolMock.On("ShutdownAndWait", mock.AnythingOfType("time.Duration")).Return(true)
+
+	processor.Stop(contracts.StopTypeSoftStop)
+
+	sendCommandPoolMock.AssertExpectations(t)
+	cancelCommandPoolMock.AssertExpectations(t)
+	assert.True(t, protected("patch"))
+	assert.False(t, protected("script"))
+	// only the running documents are waited for
+	assert.False(t, protected("queued"))
+	assert.False(t, protected("unknown"))
+}
+
+func TestEngineProcessor_HardStopDoesNotWaitForProtectedDocuments(t *testing.T) {
+	sendCommandPoolMock := new(task.MockedPool)
+	cancelCommandPoolMock := new(task.MockedPool)
+	config := appconfig.DefaultConfig()
+	config.Mds.ProtectedDocuments = []string{"*"}
+	config.Mds.ProtectedDocumentsStopTimeoutSeconds = 600
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	processor := EngineProcessor{
+		sendCommandPool:   sendCommandPoolMock,
+		cancelCommandPool: cancelCommandPoolMock,
+		context:           ctx,
+		resChan:           make(chan contracts.DocumentResult),
+	}
+	sendCommandPoolMock.On("ShutdownAndWait", hardStopTimeout).Return(true)
+	cancelCommandPoolMock.On("ShutdownAndWait", hardStopTimeout).Return(true)
+
+	processor.Stop(contracts.StopTypeHardStop)
+
+	sendCommandPoolMock.AssertExpectations(t)
+	sendCommandPoolMock.AssertNotCalled(t, "ShutdownAndWaitProtected", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestProcessCommandKeepsShutDownProtectedDocumentForResume(t *testing.T) {
+	var reset map[string][]string
+	resetInterruptedPlugins = func(log log.T, documentID, instanceID string, pluginNames []string) error {
+		reset[documentID] = pluginNames
+		return nil
+	}
+	defer func() { resetInterruptedPlugins = docmanager.ResetInterruptedPlugins }()
+	config := appconfig.DefaultConfig()
+	config.Mds.ProtectedDocuments = []string{"AWS-RunPatchBaseline"}
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+
+	for _, documentName := range []string{"AWS-RunPatchBaseline", "AWS-RunShellScript"} {
+		reset = make(map[string][]string)
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = "messageID"
+		docState.DocumentInformation.InstanceID = "instanceID"
+		docState.DocumentInformation.DocumentID = documentName
+		docState.DocumentInformation.DocumentName = documentName
+		executerMock := executermocks.NewMockExecuter()
+		statusChan := make(chan contracts.DocumentResult, 2)
+		cancelFlag := task.NewChanneledCancelFlag()
+		cancelFlag.Set(task.ShutDown)
+		executerMock.On("Run", cancelFlag, mock.AnythingOfType("*executer.DocumentFileStore")).Return(statusChan)
+		creator := func(ctx context.T) executer.Executer {
+			return executerMock
+		}
+		statusChan <- contracts.DocumentResult{LastPlugin: "aws:runPatchBaseline", Status: contracts.ResultStatusFailed}
+		statusChan <- contracts.DocumentResult{Status: contracts.ResultStatusFailed}
+		close(statusChan)
+		resChan := make(chan contracts.DocumentResult, 2)
+
+		processCommand(ctx, creator, cancelFlag, resChan, &docState)
+
+		if documentName == "AWS-RunPatchBaseline" {
+			// the protected document sends no result, it's resumed from the interrupted plugin after the restart
+			assert.Len(t, resChan, 0)
+			assert.Equal(t, map[string][]string{documentName: {"aws:runPatchBaseline"}}, reset)
+		} else {
+			assert.Len(t, resChan, 2)
+			assert.Empty(t, reset)
+		}
+	}
+}
+
+func TestEngineProcessor_StartResolvesInstanceID(t *testing.T) {
+	getInstanceID = func() (string, error) { return "", fmt.Errorf("metadata unreachable") }
+	var preparedInstanceID string
+	ensureStateFolders = func(log log.T, instanceID string) error {
+		preparedInstanceID = instanceID
+		return nil
+	}
+	reconcileDocumentStates = func(log log.T, instanceID string) {}
+	var foreignAction string
+	reconcileForeignDocumentStates = func(log log.T, instanceID, action string) []docmanager.ForeignDocumentState {
+		foreignAction = action
+		return nil
+	}
+	defer func() {
+		getInstanceID = platform.InstanceID
+		ensureStateFolders = docmanager.EnsureStateFolders
+		reconcileDocumentStates = docmanager.ReconcileDocumentStates
+		reconcileForeignDocumentStates = docmanager.ReconcileForeignDocumentStates
+	}()
+	newProcessor := func(fallbackInstanceID string) EngineProcessor {
+		config := appconfig.DefaultConfig()
+		config.Agent.FallbackInstanceID = fallbackInstanceID
+		config.Mds.ForeignDocumentStateAction = appconfig.ForeignDocumentStateActionQuarantine
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+		return EngineProcessor{context: ctx, resChan: make(chan contracts.DocumentResult)}
+	}
+
+	processor := newProcessor("")
+	_, err := processor.Start()
+
+	assert.Error(t, err)
+	assert.Empty(t, preparedInstanceID)
+
+	processor = newProcessor("mi-fallback")
+	_, err = processor.Start()
+
+	assert.NoError(t, err)
+	assert.Equal(t, "mi-fallback", preparedInstanceID)
+	assert.Equal(t, appconfig.ForeignDocumentStateActionQuarantine, foreignAction)
+}
+
 //TODO add Shut test
 func TestProcessCommand(t *testing.T) {
 	ctx := context.NewMockDefault()
@@ -115,6 +851,14 @@ func TestProcessCommand(t *testing.T) {
 			res2 := <-resChan
 			assert.Equal(t, res, res2)
 		}
+		//send the document level response
+		res := contracts.DocumentResult{
+			LastPlugin: "",
+			Status:     contracts.ResultStatusSuccess,
+		}
+		statusChan <- res
+		res2 := <-resChan
+		assert.Equal(t, res, res2)
 		close(statusChan)
 	}()
 	processCommand(ctx, creator, cancelFlag, resChan, &docState)
@@ -125,14 +869,238 @@ func TestProcessCommand(t *testing.T) {
 
 }
 
+func TestProcessCommandRecordsLastErrorOfFailedDocument(t *testing.T) {
+	lastErrors := make(map[string]string)
+	setDocumentLastError = func(log log.T, documentID, instanceID, locationFolder, lastError string) {
+		assert.Equal(t, appconfig.DefaultLocationOfCurrent, locationFolder)
+		lastErrors[documentID] = lastError
+	}
+	defer func() { setDocumentLastError = docmanager.SetDocumentLastError }()
+	ctx := context.NewMockDefault()
+
+	for _, status := range []contracts.ResultStatus{contracts.ResultStatusSuccess, contracts.ResultStatusFailed} {
+		docState := model.DocumentState{}
+		docState.DocumentInformation.MessageID = "messageID"
+		docState.DocumentInformation.InstanceID = "instanceID"
+		docState.DocumentInformation.DocumentID = string(status)
+		executerMock := executermocks.NewMockExecuter()
+		statusChan := make(chan contracts.DocumentResult, 1)
+		cancelFlag := task.NewChanneledCancelFlag()
+		executerMock.On("Run", cancelFlag, mock.AnythingOfType("*executer.DocumentFileStore")).Return(statusChan)
+		creator := func(ctx context.T) executer.Executer {
+			return executerMock
+		}
+		statusChan <- contracts.DocumentResult{
+			Status: status,
+			PluginResults: map[string]*contracts.PluginResult{
+				"plugin0": {Status: status, Error: fmt.Errorf("exit status 1")},
+			},
+		}
+		close(statusChan)
+		resChan := make(chan contracts.DocumentResult, 1)
+		processCommand(ctx, creator, cancelFlag, resChan, &docState)
+	}
+
+	assert.NotContains(t, lastErrors, string(contracts.ResultStatusSuccess))
+	assert.Equal(t, "step plugin0 Failed: exit status 1", lastErrors[string(contracts.ResultStatusFailed)])
+}
+
+func TestProcessCommandRecordsResultHash(t *testing.T) {
+	var hashed []string
+	setDocumentResultHash = func(log log.T, documentID, instanceID, locationFolder string) error {
+		assert.Equal(t, appconfig.DefaultLocationOfCurrent, locationFolder)
+		hashed = append(hashed, documentID)
+		return nil
+	}
+	defer func() { setDocumentResultHash = docmanager.SetDocumentResultHash }()
+	ctx := context.NewMockDefault()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.InstanceID = "instanceID"
+	docState.DocumentInformation.DocumentID = "documentID"
+	executerMock := executermocks.NewMockExecuter()
+	statusChan := make(chan contracts.DocumentResult, 1)
+	cancelFlag := task.NewChanneledCancelFlag()
+	executerMock.On("Run", cancelFlag, mock.AnythingOfType("*executer.DocumentFileStore")).Return(statusChan)
+	creator := func(ctx context.T) executer.Executer {
+		return executerMock
+	}
+	statusChan <- contracts.DocumentResult{Status: contracts.ResultStatusSuccess}
+	close(statusChan)
+
+	processCommand(ctx, creator, cancelFlag, make(chan contracts.DocumentResult, 1), &docState)
+
+	assert.Equal(t, []string{"documentID"}, hashed)
+}
+
+func TestProcessCommandExecuterClosesWithoutTerminalStatus(t *testing.T) {
+	ctx := context.NewMockDefault()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.InstanceID = "instanceID"
+	docState.DocumentInformation.DocumentID = "documentID"
+	docState.InstancePluginsInformation = []model.PluginState{{Id: "plugin0"}, {Id: "plugin1"}}
+	executerMock := executermocks.NewMockExecuter()
+	resChan := make(chan contracts.DocumentResult)
+	statusChan := make(chan contracts.DocumentResult)
+	cancelFlag := task.NewChanneledCancelFlag()
+	executerMock.On("Run", cancelFlag, mock.AnythingOfType("*executer.DocumentFileStore")).Return(statusChan)
+	creator := func(ctx context.T) executer.Executer {
+		return executerMock
+	}
+	pluginResults := map[string]*contracts.PluginResult{
+		"plugin0": {PluginName: "plugin0", Status: contracts.ResultStatusSuccess},
+	}
+	go func() {
+		//emit a partial stream, then close the channel before the document level response
+		statusChan <- contracts.DocumentResult{
+			LastPlugin:    "plugin0",
+			Status:        contracts.ResultStatusInProgress,
+			PluginResults: pluginResults,
+		}
+		close(statusChan)
+	}()
+	var received []contracts.DocumentResult
+	done := make(chan bool)
+	go func() {
+		for res := range resChan {
+			received = append(received, res)
+		}
+		done <- true
+	}()
+	processCommand(ctx, creator, cancelFlag, resChan, &docState)
+	close(resChan)
+	<-done
+	executerMock.AssertExpectations(t)
+	if assert.Len(t, received, 2) {
+		assert.Equal(t, "plugin0", received[0].LastPlugin)
+		final := received[1]
+		assert.Equal(t, "", final.LastPlugin)
+		assert.Equal(t, contracts.ResultStatusFailed, final.Status)
+		assert.Equal(t, "messageID", final.MessageID)
+		assert.Equal(t, 2, final.NPlugins)
+		assert.Equal(t, pluginResults, final.PluginResults)
+	}
+}
+
+// rebootingExecuter is an executer whose document requests a reboot on every run
+type rebootingExecuter struct{}
+
+func (rebootingExecuter) Run(cancelFlag task.CancelFlag, docStore executer.DocumentStore) chan contracts.DocumentResult {
+	statusChan := make(chan contracts.DocumentResult, 1)
+	statusChan <- contracts.DocumentResult{
+		LastPlugin: "",
+		Status:     contracts.ResultStatusSuccessAndReboot,
+	}
+	close(statusChan)
+	return statusChan
+}
+
+func TestProcessCommandFailsDocumentRebootingBeyondLimit(t *testing.T) {
+	ctx := new(context.Mock)
+	config := appconfig.SsmagentConfig{}
+	config.Mds.RebootResumeLimit = 3
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	docState := model.DocumentState{}
+	docState.DocumentInformation.MessageID = "messageID"
+	docState.DocumentInformation.InstanceID = "instanceID"
+	docState.DocumentInformation.DocumentID = "documentID"
+	creator := func(ctx context.T) executer.Executer {
+		return rebootingExecuter{}
+	}
+
+	runs := 0
+	var final contracts.DocumentResult
+	// resume the document after every reboot until it stops asking for one
+	for final.Status != contracts.ResultStatusFailed && runs < 10 {
+		runs++
+		resChan := make(chan contracts.DocumentResult, 2)
+		processCommand(ctx, creator, task.NewChanneledCancelFlag(), resChan, &docState)
+		close(resChan)
+		for res := range resChan {
+			final = res
+		}
+	}
+
+	assert.Equal(t, 4, runs)
+	assert.Equal(t, 4, docState.DocumentInformation.RebootCount)
+	assert.Equal(t, contracts.ResultStatusFailed, final.Status)
+	assert.Equal(t, "", final.LastPlugin)
+	assert.Equal(t, "messageID", final.MessageID)
+}
+
 func TestProcessCancelCommand_Success(t *testing.T) {
 	ctx := context.NewMockDefault()
 	sendCommandPoolMock := new(task.MockedPool)
 	docState := model.DocumentState{}
 	docState.CancelInformation.CancelMessageID = "messageID"
-	sendCommandPoolMock.On("Cancel", "messageID").Return(true)
+	sendCommandPoolMock.On("CancelWithReason", "messageID", task.CancelReasonUserRequested).Return(true)
 	processCancelCommand(ctx, sendCommandPoolMock, &docState)
 	sendCommandPoolMock.AssertExpectations(t)
 	assert.Equal(t, docState.DocumentInformation.DocumentStatus, contracts.ResultStatusSuccess)
 
 }
+
+func TestSkipUnreadableDocumentFailsNewerStates(t *testing.T) {
+	var released []string
+	releaseDocumentLock = func(instanceID, documentID string) bool {
+		released = append(released, documentID)
+		return true
+	}
+	defer func() { releaseDocumentLock = docmanager.ReleaseDocumentLock }()
+	err := &docmanager.UnsupportedStateVersionError{Path: "documentID", Version: docmanager.CurrentStateSchemaVersion + 1}
+
+	skipped := skipUnreadableDocument(log.NewMockLog(), model.DocumentState{}, err, "documentID", "instanceID", appconfig.DefaultLocationOfCurrent)
+
+	assert.True(t, skipped)
+	// the document is moved to the corrupt folder, its lock released
+	assert.Equal(t, []string{"documentID"}, released)
+}
+
+func TestEngineProcessor_RerunFailedPlugins(t *testing.T) {
+	origResetFailedPlugins := resetFailedPlugins
+	defer func() { resetFailedPlugins = origResetFailedPlugins }()
+	docState := model.DocumentState{}
+	docState.DocumentInformation.DocumentID = "commandID"
+	docState.DocumentInformation.CommandID = "commandID"
+	docState.DocumentInformation.MessageID = "messageID"
+	resetFailedPlugins = func(log log.T, documentID, instanceID string) (model.DocumentState, error) {
+		assert.Equal(t, "commandID", documentID)
+		assert.Equal(t, "instanceID", instanceID)
+		return docState, nil
+	}
+	ctx := context.NewMockDefault()
+	sendCommandPoolMock := new(task.MockedPool)
+	sendCommandPoolMock.On("Submit", ctx.Log(), "messageID", mock.Anything).Return(nil)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         ctx,
+	}
+
+	err := processor.RerunFailedPlugins("commandID", "instanceID")
+
+	assert.NoError(t, err)
+	sendCommandPoolMock.AssertExpectations(t)
+	// the document is running again, it can't be rerun until it completes
+	err = processor.RerunFailedPlugins("commandID", "instanceID")
+	assert.Error(t, err)
+}
+
+func TestEngineProcessor_RerunFailedPluginsOfDocumentWithoutFailures(t *testing.T) {
+	origResetFailedPlugins := resetFailedPlugins
+	defer func() { resetFailedPlugins = origResetFailedPlugins }()
+	resetFailedPlugins = func(log log.T, documentID, instanceID string) (model.DocumentState, error) {
+		return model.DocumentState{}, fmt.Errorf("document %v has no failed plugin to run again", documentID)
+	}
+	sendCommandPoolMock := new(task.MockedPool)
+	processor := EngineProcessor{
+		sendCommandPool: sendCommandPoolMock,
+		context:         context.NewMockDefault(),
+	}
+
+	err := processor.RerunFailedPlugins("commandID", "instanceID")
+
+	assert.Error(t, err)
+	sendCommandPoolMock.AssertNotCalled(t, "Submit", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,46 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"path"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// isProtectedDocument returns whether the document name matches one of the protected document patterns,
+// a protected document keeps running while the agent stops and resumes after the restart if it's shut down
+func isProtectedDocument(log log.T, patterns []string, documentName string) bool {
+	for _, pattern := range patterns {
+		matched, err := path.Match(pattern, documentName)
+		if err != nil {
+			log.Debugf("Invalid protected document pattern %v: %v", pattern, err)
+			continue
+		}
+		if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isProtectedJob returns whether the job of the send command pool runs a protected document
+func (p *EngineProcessor) isProtectedJob(jobID string) bool {
+	tracked, found := p.documents.get(jobID)
+	if !found || !tracked.started {
+		return false
+	}
+	return isProtectedDocument(p.context.Log(), p.context.AppConfig().Mds.ProtectedDocuments, tracked.docState.DocumentInformation.DocumentName)
+}
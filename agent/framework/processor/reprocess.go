@@ -0,0 +1,46 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync"
+)
+
+// reprocessOverrides holds the commands whose next delivery is executed again even though they have completed
+type reprocessOverrides struct {
+	commandIDs map[string]bool
+	m          sync.Mutex
+}
+
+// add records the override for the command
+func (r *reprocessOverrides) add(commandID string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.commandIDs == nil {
+		r.commandIDs = make(map[string]bool)
+	}
+	r.commandIDs[commandID] = true
+}
+
+// consume removes the override of the command, it returns whether there was one
+func (r *reprocessOverrides) consume(commandID string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if !r.commandIDs[commandID] {
+		return false
+	}
+	delete(r.commandIDs, commandID)
+	return true
+}
@@ -0,0 +1,67 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// resultResender sends the final results of the documents already executed again, off the submission path so that
+// a redelivery doesn't wait for the results to be consumed. The results still waiting once it's stopped are dropped,
+// the processor closes its result channel only after the resender is stopped.
+type resultResender struct {
+	stopChan chan bool
+	stopped  bool
+	wg       sync.WaitGroup
+	m        sync.Mutex
+}
+
+// send sends the result to resChan in the background, unless the resender is stopped
+func (r *resultResender) send(log log.T, resChan chan contracts.DocumentResult, result contracts.DocumentResult) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.stopped {
+		log.Debugf("not resending the result of %v, the processor is stopped", result.MessageID)
+		return
+	}
+	if r.stopChan == nil {
+		r.stopChan = make(chan bool)
+	}
+	r.wg.Add(1)
+	go func(stopChan chan bool) {
+		defer r.wg.Done()
+		select {
+		case resChan <- result:
+		case <-stopChan:
+			log.Debugf("dropped the result of %v resent as the processor stopped", result.MessageID)
+		}
+	}(r.stopChan)
+}
+
+// stop drops the results not sent yet and waits for the senders to return, no result is sent once it returns
+func (r *resultResender) stop() {
+	r.m.Lock()
+	if !r.stopped {
+		r.stopped = true
+		if r.stopChan != nil {
+			close(r.stopChan)
+		}
+	}
+	r.m.Unlock()
+	r.wg.Wait()
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+)
+
+// resumableDocument is a document left over by the previous run of the agent
+type resumableDocument struct {
+	docState model.DocumentState
+	modTime  time.Time
+	// inProgress is set for the documents found in the Current folder
+	inProgress bool
+}
+
+// submitFunc queues up a document, done is called once the document is over
+type submitFunc func(docState model.DocumentState, done func())
+
+// resumer paces the submission of the documents left over by the previous run, so that a long outage
+// doesn't flood the host with all the documents it left behind at once
+type resumer struct {
+	stopChan chan bool
+	done     chan bool
+	m        sync.Mutex
+}
+
+// start submits the documents, the in-progress ones first and then the most recent ones,
+// at most limit of them run at once and the others are submitted interval apart as the running ones complete.
+// A limit of 0 submits all the documents at once.
+func (r *resumer) start(submit submitFunc, documents []resumableDocument, limit int, interval time.Duration) {
+	// keep the in-progress documents ahead of the pending ones, the most recent first in each group
+	sort.SliceStable(documents, func(i, j int) bool {
+		if documents[i].inProgress != documents[j].inProgress {
+			return documents[i].inProgress
+		}
+		return documents[i].modTime.After(documents[j].modTime)
+	})
+	if limit <= 0 || len(documents) <= limit {
+		for _, document := range documents {
+			submit(document.docState, nil)
+		}
+		return
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.stopChan = make(chan bool)
+	r.done = make(chan bool)
+	slots := make(chan bool, limit)
+	release := func() { <-slots }
+	for _, document := range documents[:limit] {
+		slots <- true
+		submit(document.docState, release)
+	}
+	go func(stopChan, done chan bool, documents []resumableDocument) {
+		defer close(done)
+		for _, document := range documents {
+			select {
+			case <-stopChan:
+				return
+			case <-time.After(interval):
+			}
+			select {
+			case <-stopChan:
+				return
+			case slots <- true:
+			}
+			submit(document.docState, release)
+		}
+	}(r.stopChan, r.done, documents[limit:])
+}
+
+// stop stops submitting the documents not resumed yet and waits up to timeout for an ongoing submission to complete
+func (r *resumer) stop(timeout time.Duration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.stopChan == nil {
+		return
+	}
+	close(r.stopChan)
+	select {
+	case <-r.done:
+	case <-time.After(timeout):
+	}
+	r.stopChan = nil
+}
@@ -0,0 +1,118 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/stretchr/testify/assert"
+)
+
+// recordingSubmitter runs each submitted document for a while, and records the order and the time of the submissions
+// along with the most documents running at once
+type recordingSubmitter struct {
+	runTime     time.Duration
+	submitted   []string
+	submittedAt []time.Time
+	running     int
+	maxRunning  int
+	m           sync.Mutex
+}
+
+func (r *recordingSubmitter) submit(docState model.DocumentState, done func()) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.submitted = append(r.submitted, docState.DocumentInformation.DocumentID)
+	r.submittedAt = append(r.submittedAt, time.Now())
+	r.running++
+	if r.running > r.maxRunning {
+		r.maxRunning = r.running
+	}
+	go func() {
+		time.Sleep(r.runTime)
+		r.m.Lock()
+		r.running--
+		r.m.Unlock()
+		if done != nil {
+			done()
+		}
+	}()
+}
+
+func (r *recordingSubmitter) count() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return len(r.submitted)
+}
+
+// resumableDocuments returns n pending documents, document0 is the oldest
+func resumableDocuments(n int) []resumableDocument {
+	documents := make([]resumableDocument, n)
+	start := time.Now().Add(-time.Hour)
+	for i := range documents {
+		documents[i].docState.DocumentInformation.DocumentID = fmt.Sprintf("document%v", i)
+		documents[i].modTime = start.Add(time.Duration(i) * time.Minute)
+	}
+	return documents
+}
+
+func TestResumerHonorsConcurrencyLimitAndPacing(t *testing.T) {
+	submitter := &recordingSubmitter{runTime: 30 * time.Millisecond}
+	interval := 20 * time.Millisecond
+	documents := resumableDocuments(10)
+	documents[0].inProgress = true
+	var r resumer
+
+	r.start(submitter.submit, documents, 3, interval)
+	defer r.stop(time.Second)
+
+	// the first documents are submitted right away
+	assert.Equal(t, 3, submitter.count())
+	deadline := time.Now().Add(5 * time.Second)
+	for submitter.count() < len(documents) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	submitter.m.Lock()
+	defer submitter.m.Unlock()
+	assert.Equal(t, []string{"document0", "document9", "document8", "document7", "document6", "document5", "document4", "document3", "document2", "document1"}, submitter.submitted)
+	assert.True(t, submitter.maxRunning <= 3, "%v documents ran at once", submitter.maxRunning)
+	for i := 4; i < len(submitter.submittedAt); i++ {
+		assert.True(t, submitter.submittedAt[i].Sub(submitter.submittedAt[i-1]) >= interval, "document %v wasn't paced", i)
+	}
+}
+
+func TestResumerWithoutLimitSubmitsAllDocuments(t *testing.T) {
+	submitter := &recordingSubmitter{}
+	var r resumer
+
+	r.start(submitter.submit, resumableDocuments(10), 0, time.Hour)
+
+	assert.Equal(t, 10, submitter.count())
+}
+
+func TestResumerStop(t *testing.T) {
+	submitter := &recordingSubmitter{}
+	var r resumer
+	r.start(submitter.submit, resumableDocuments(10), 2, time.Hour)
+
+	r.stop(time.Second)
+
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, submitter.count())
+}
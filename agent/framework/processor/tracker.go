@@ -0,0 +1,142 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package processor defines the document processing unit interface
+package processor
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
+)
+
+// trackedDocument is the in-memory record of a document submitted to the send command pool
+type trackedDocument struct {
+	docState     model.DocumentState
+	started      bool
+	supersededBy string
+}
+
+// inFlightDocuments counts the documents tracked by all the processors of the agent
+var inFlightDocuments int64
+
+// documentTracker keeps track of the documents that are queued or running in the send command pool, keyed by job id
+type documentTracker struct {
+	documents map[string]*trackedDocument
+	m         sync.Mutex
+}
+
+// add starts tracking the document submitted with the given job id
+func (t *documentTracker) add(jobID string, docState model.DocumentState) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if t.documents == nil {
+		t.documents = make(map[string]*trackedDocument)
+	}
+	if _, found := t.documents[jobID]; !found {
+		metrics.DefaultSink.SetGauge(metrics.InFlightDocuments, float64(atomic.AddInt64(&inFlightDocuments, 1)))
+	}
+	t.documents[jobID] = &trackedDocument{docState: docState}
+}
+
+// remove stops tracking the given job and returns its last record
+func (t *documentTracker) remove(jobID string) (doc trackedDocument, found bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	tracked, found := t.documents[jobID]
+	if !found {
+		return
+	}
+	delete(t.documents, jobID)
+	metrics.DefaultSink.SetGauge(metrics.InFlightDocuments, float64(atomic.AddInt64(&inFlightDocuments, -1)))
+	return *tracked, true
+}
+
+// removeIfNotStarted stops tracking the given job unless a worker has already picked it up
+func (t *documentTracker) removeIfNotStarted(jobID string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if tracked, found := t.documents[jobID]; found && !tracked.started {
+		delete(t.documents, jobID)
+		metrics.DefaultSink.SetGauge(metrics.InFlightDocuments, float64(atomic.AddInt64(&inFlightDocuments, -1)))
+	}
+}
+
+// markStarted records that the worker has picked up the given job
+func (t *documentTracker) markStarted(jobID string) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	if tracked, found := t.documents[jobID]; found {
+		tracked.started = true
+	}
+}
+
+// markSuperseded flags the document with the given command id as superseded by newCommandID,
+// returns the job id of the document and whether it has been picked up by a worker yet
+func (t *documentTracker) markSuperseded(commandID, newCommandID string) (jobID string, started bool, found bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for id, tracked := range t.documents {
+		if tracked.docState.DocumentInformation.CommandID == commandID {
+			tracked.supersededBy = newCommandID
+			return id, tracked.started, true
+		}
+	}
+	return
+}
+
+// find returns the job id of the document with the given command id and whether it has been picked up by a worker yet
+func (t *documentTracker) find(commandID string) (jobID string, started bool, found bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for id, tracked := range t.documents {
+		if tracked.docState.DocumentInformation.CommandID == commandID {
+			return id, tracked.started, true
+		}
+	}
+	return
+}
+
+// get returns the record of the document submitted with the given job id
+func (t *documentTracker) get(jobID string) (doc trackedDocument, found bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	tracked, found := t.documents[jobID]
+	if !found {
+		return
+	}
+	return *tracked, true
+}
+
+// messageID returns the message id of the document submitted with the given job id
+func (t *documentTracker) messageID(jobID string) (messageID string, found bool) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	tracked, found := t.documents[jobID]
+	if !found {
+		return
+	}
+	return tracked.docState.DocumentInformation.MessageID, true
+}
+
+// list returns a copy of the records of the tracked documents
+func (t *documentTracker) list() (docs []trackedDocument) {
+	t.m.Lock()
+	defer t.m.Unlock()
+	for _, tracked := range t.documents {
+		docs = append(docs, *tracked)
+	}
+	return
+}
@@ -22,16 +22,19 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
 	docModel "github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/platform"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 )
 
 const (
-	executeStep string = "execute"
-	skipStep    string = "skip"
-	failStep    string = "fail"
+	executeStep     string = "execute"
+	skipStep        string = "skip"
+	failStep        string = "fail"
+	unsupportedStep string = "unsupported"
 )
 
 // T is the interface type for plugins.
@@ -39,6 +42,15 @@ type T interface {
 	Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag) contracts.PluginResult
 }
 
+// ProgressFunc records how far, in percent, the execution of a plugin went
+type ProgressFunc func(percent int)
+
+// ProgressReporter is implemented by the plugins able to report the progress of their execution.
+// The progress must be reported before ExecuteWithProgress returns.
+type ProgressReporter interface {
+	ExecuteWithProgress(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag, progress ProgressFunc) contracts.PluginResult
+}
+
 // PluginRegistry stores a set of plugins (both worker and long running plugins), indexed by ID.
 type PluginRegistry map[string]T
 
@@ -64,6 +76,8 @@ var allPlugins = map[string]struct{}{
 
 // Assign method to global variables to allow unittest to override
 var isSupportedPlugin = IsPluginSupportedForCurrentPlatform
+var persistPluginProgress = PersistPluginProgress
+var persistPluginCancelReason = PersistPluginCancelReason
 
 //TODO remove executionID and creation date
 // RunPlugins executes a set of plugins. The plugin configurations are given in a map with pluginId as key.
@@ -79,6 +93,14 @@ func RunPlugins(
 
 	pluginOutputs = make(map[string]*contracts.PluginResult)
 
+	policy := context.AppConfig().Ssm.UnsupportedPluginPolicy
+	statusPolicy := context.AppConfig().Ssm.UnrecognizedPluginStatusPolicy
+	dependencyPolicy := context.AppConfig().Ssm.StepDependencyFailurePolicy
+	unsupportedMessage := ""
+	if policy == appconfig.UnsupportedPluginPolicyFailDocument {
+		unsupportedMessage = findUnsupportedStep(context.Log(), plugins, pluginRegistry)
+	}
+
 	for _, pluginState := range plugins {
 		pluginID := pluginState.Id     // the identifier of the plugin
 		pluginName := pluginState.Name // the name of the plugin
@@ -138,25 +160,63 @@ func RunPlugins(
 			pluginHandlerFound,
 			configuration.IsPreconditionEnabled,
 			configuration.Preconditions)
+		if unsupportedMessage != "" {
+			// none of the steps run when the document references an unsupported plugin
+			operation = failStep
+			logMessage = fmt.Sprintf("Document references a plugin not supported by this agent, none of its steps is executed. %s", unsupportedMessage)
+		}
+		if operation == executeStep {
+			if unmet := unmetStepDependency(pluginID, configuration.DependsOn, pluginOutputs); unmet != "" {
+				operation, logMessage = skipStep, unmet
+				if dependencyPolicy == appconfig.StepDependencyFailurePolicyFailFast {
+					operation = failStep
+				}
+			}
+		}
 
 		switch operation {
 		case executeStep:
 			context.Log().Infof("%s is a supported plugin", pluginName)
-			r = runPlugin(context, p, pluginName, configuration, cancelFlag)
+			ensureWorkingDirectory(context.Log(), configuration.DefaultWorkingDirectory)
+			progress := newProgressFunc(context, pluginID, configuration, pluginOutputs[pluginID], resChan)
+			r = runPlugin(context, p, pluginName, configuration, cancelFlag, progress)
+			r.MarkOutputTruncation()
+			r = validatePluginStatus(context.Log(), pluginID, r, statusPolicy)
 			pluginOutputs[pluginID].Code = r.Code
 			pluginOutputs[pluginID].Status = r.Status
 			pluginOutputs[pluginID].Error = r.Error
 			pluginOutputs[pluginID].Output = r.Output
 			pluginOutputs[pluginID].StandardOutput = r.StandardOutput
 			pluginOutputs[pluginID].StandardError = r.StandardError
+			pluginOutputs[pluginID].OutputTruncated = r.OutputTruncated
+			pluginOutputs[pluginID].OutputTruncatedAt = r.OutputTruncatedAt
+			if cancelFlag != nil && cancelFlag.Canceled() {
+				if reason := task.CancelReasonOf(cancelFlag); reason != "" {
+					context.Log().Infof("plugin %v was canceled, reason: %v", pluginName, reason)
+					pluginOutputs[pluginID].CancelReason = string(reason)
+					persistPluginCancelReason(context.Log(), pluginID, configuration, reason)
+				}
+			}
 
 		case skipStep:
 			context.Log().Info(logMessage)
 			pluginOutputs[pluginID].Status = contracts.ResultStatusSkipped
 			pluginOutputs[pluginID].Code = 0
 			pluginOutputs[pluginID].Output = logMessage
+		case unsupportedStep:
+			if policy == appconfig.UnsupportedPluginPolicyMarkUnsupported {
+				context.Log().Warn(logMessage)
+				pluginOutputs[pluginID].Status = contracts.ResultStatusUnsupportedPlugin
+				pluginOutputs[pluginID].Code = 0
+				pluginOutputs[pluginID].Output = logMessage
+				break
+			}
+			err := fmt.Errorf("%v", logMessage)
+			pluginOutputs[pluginID].Status = contracts.ResultStatusFailed
+			pluginOutputs[pluginID].Error = err
+			context.Log().Error(err)
 		case failStep:
-			err := fmt.Errorf(logMessage)
+			err := fmt.Errorf("%v", logMessage)
 			pluginOutputs[pluginID].Status = contracts.ResultStatusFailed
 			pluginOutputs[pluginID].Error = err
 			context.Log().Error(err)
@@ -183,12 +243,97 @@ func RunPlugins(
 	return
 }
 
+// ensureWorkingDirectory creates the default working directory of the plugin if it doesn't exist yet
+func ensureWorkingDirectory(log log.T, workingDir string) {
+	if workingDir == "" || fileutil.Exists(workingDir) {
+		return
+	}
+	if err := fileutil.MakeDirs(workingDir); err != nil {
+		log.Errorf("failed to create the working directory %v: %v", workingDir, err)
+	}
+}
+
+// findUnsupportedStep returns why the first step yet to run whose plugin isn't supported can't run, empty if all are supported
+func findUnsupportedStep(log log.T, plugins []docModel.PluginState, pluginRegistry PluginRegistry) string {
+	for _, pluginState := range plugins {
+		switch pluginState.Result.Status {
+		case "", contracts.ResultStatusNotStarted, contracts.ResultStatusInProgress, contracts.ResultStatusSuccessAndReboot:
+		default:
+			continue
+		}
+		_, pluginHandlerFound := pluginRegistry[pluginState.Name]
+		isKnown, isSupported, _ := isSupportedPlugin(log, pluginState.Name)
+		operation, logMessage := getStepExecutionOperation(
+			log,
+			pluginState.Name,
+			pluginState.Id,
+			isKnown,
+			isSupported,
+			pluginHandlerFound,
+			pluginState.Configuration.IsPreconditionEnabled,
+			pluginState.Configuration.Preconditions)
+		if operation == unsupportedStep {
+			return logMessage
+		}
+	}
+	return ""
+}
+
+// unmetStepDependency returns why the step can't run because of a step it depends on, empty if they all succeeded.
+// The steps run in the order they're declared, so a dependency declared after the step is never met.
+func unmetStepDependency(pluginID string, dependsOn []string, pluginOutputs map[string]*contracts.PluginResult) string {
+	for _, dependency := range dependsOn {
+		output, found := pluginOutputs[dependency]
+		if !found {
+			return fmt.Sprintf("Step %s depends on step %s which doesn't run before it. Step name: %s", pluginID, dependency, pluginID)
+		}
+		if output.Status != contracts.ResultStatusSuccess {
+			return fmt.Sprintf("Step %s depends on step %s which didn't succeed, its status is %s. Step name: %s", pluginID, dependency, output.Status, pluginID)
+		}
+	}
+	return ""
+}
+
+// validatePluginStatus fails the result of a plugin reporting a status the agent doesn't know so that it doesn't reach the document state.
+// The result is kept with a note of the unknown status when coerced, or replaced when rejected.
+func validatePluginStatus(log log.T, pluginID string, res contracts.PluginResult, statusPolicy string) contracts.PluginResult {
+	// a plugin leaving its status unset is handled by the aggregation of the document status
+	if res.Status == "" || res.Status.IsRecognized() {
+		return res
+	}
+	note := fmt.Sprintf("plugin %v reported an unknown status %q", pluginID, res.Status)
+	log.Errorf("%v, failing the step", note)
+	if statusPolicy == appconfig.UnrecognizedPluginStatusPolicyReject {
+		err := fmt.Errorf("%v, its result is discarded", note)
+		return contracts.PluginResult{
+			PluginName:    res.PluginName,
+			Status:        contracts.ResultStatusFailed,
+			Code:          1,
+			Error:         err,
+			Output:        err.Error(),
+			StartDateTime: res.StartDateTime,
+			EndDateTime:   res.EndDateTime,
+		}
+	}
+	res.Status = contracts.ResultStatusFailed
+	if res.Code == 0 {
+		res.Code = 1
+	}
+	if res.Output == nil || res.Output == "" {
+		res.Output = note
+	} else {
+		res.Output = fmt.Sprintf("%v\n%v", res.Output, note)
+	}
+	return res
+}
+
 func runPlugin(
 	context context.T,
 	p T,
 	pluginID string,
 	config contracts.Configuration,
-	cancelFlag task.CancelFlag) (res contracts.PluginResult) {
+	cancelFlag task.CancelFlag,
+	progress ProgressFunc) (res contracts.PluginResult) {
 	// create a new context that includes plugin ID
 	context = context.With("[pluginID=" + pluginID + "]")
 
@@ -204,10 +349,58 @@ func runPlugin(
 		}
 	}()
 	log.Debugf("Running %s", pluginID)
+	if reporter, ok := p.(ProgressReporter); ok {
+		return reporter.ExecuteWithProgress(context, config, cancelFlag, progress)
+	}
 	return p.Execute(context, config, cancelFlag)
 }
 
-// Checks plugin compatibility and step precondition and returns if it should be executed, skipped or failed
+// newProgressFunc returns the function a plugin reports its progress with,
+// the progress is persisted in the plugin state and sent as an InProgress update of the plugin
+func newProgressFunc(context context.T, pluginID string, config contracts.Configuration, pluginOutput *contracts.PluginResult, resChan chan contracts.PluginResult) ProgressFunc {
+	return func(percent int) {
+		if percent < 0 {
+			percent = 0
+		} else if percent > 100 {
+			percent = 100
+		}
+		pluginOutput.Progress = percent
+		update := *pluginOutput
+		update.Status = contracts.ResultStatusInProgress
+		persistPluginProgress(context.Log(), pluginID, config, percent)
+		resChan <- update
+	}
+}
+
+// PersistPluginProgress records the progress of the plugin in its state persisted in the current folder
+func PersistPluginProgress(log log.T, pluginID string, config contracts.Configuration, percent int) {
+	updatePersistedPluginResult(log, pluginID, config, func(result *contracts.PluginResult) {
+		result.Progress = percent
+	})
+}
+
+// PersistPluginCancelReason records why the plugin was canceled in its state persisted in the current folder
+func PersistPluginCancelReason(log log.T, pluginID string, config contracts.Configuration, reason task.CancelReason) {
+	updatePersistedPluginResult(log, pluginID, config, func(result *contracts.PluginResult) {
+		result.CancelReason = string(reason)
+	})
+}
+
+// updatePersistedPluginResult applies the update to the plugin result persisted in the current folder
+func updatePersistedPluginResult(log log.T, pluginID string, config contracts.Configuration, update func(result *contracts.PluginResult)) {
+	messageIDSplit := strings.Split(config.MessageId, ".")
+	instanceID := messageIDSplit[len(messageIDSplit)-1]
+
+	pluginState := docmanager.GetPluginState(log, pluginID, config.BookKeepingFileName, instanceID, appconfig.DefaultLocationOfCurrent)
+	if pluginState == nil {
+		log.Debugf("failed to find plugin state with id %v, skip updating its result", pluginID)
+		return
+	}
+	update(&pluginState.Result)
+	docmanager.PersistPluginState(log, *pluginState, pluginID, config.BookKeepingFileName, instanceID, appconfig.DefaultLocationOfCurrent)
+}
+
+// Checks plugin compatibility and step precondition and returns if it should be executed, skipped, failed or handled as unsupported
 func getStepExecutionOperation(
 	log log.T,
 	pluginName string,
@@ -225,12 +418,12 @@ func getStepExecutionOperation(
 	if !isPreconditionEnabled {
 		// 1.x or 2.0 document
 		if !isKnown {
-			return failStep, fmt.Sprintf(
+			return unsupportedStep, fmt.Sprintf(
 				"Plugin with name %s is not supported by this version of ssm agent, please update to latest version. Step name: %s",
 				pluginName,
 				pluginId)
 		} else if !isSupported {
-			return failStep, fmt.Sprintf(
+			return unsupportedStep, fmt.Sprintf(
 				"Plugin with name %s is not supported in current platform. Step name: %s",
 				pluginName,
 				pluginId)
@@ -240,7 +433,7 @@ func getStepExecutionOperation(
 				"Precondition is not supported for document schema version prior to 2.2. Step name: %s",
 				pluginId)
 		} else if !isPluginHandlerFound {
-			return failStep, fmt.Sprintf(
+			return unsupportedStep, fmt.Sprintf(
 				"Plugin with name %s not found. Step name: %s",
 				pluginName,
 				pluginId)
@@ -254,7 +447,7 @@ func getStepExecutionOperation(
 
 			// precondition is not present - if pluginFound executeStep, else skipStep
 			if !isKnown {
-				return failStep, fmt.Sprintf(
+				return unsupportedStep, fmt.Sprintf(
 					"Plugin with name %s is not supported by this version of ssm agent, please update to latest version. Step name: %s",
 					pluginName,
 					pluginId)
@@ -271,7 +464,7 @@ func getStepExecutionOperation(
 			isAllowed, unrecognizedPreconditionList := evaluatePreconditions(log, preconditions)
 
 			if isAllowed && !isKnown {
-				return failStep, fmt.Sprintf(
+				return unsupportedStep, fmt.Sprintf(
 					"Plugin with name %s is not supported by this version of ssm agent, please update to latest version. Step name: %s",
 					pluginName,
 					pluginId)
@@ -16,12 +16,19 @@ package runpluginutil
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
 	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
 	"github.com/aws/amazon-ssm-agent/agent/task"
 	"github.com/stretchr/testify/assert"
@@ -1391,3 +1398,445 @@ func TestRunPluginsWithUnknownPlugin(t *testing.T) {
 
 	assert.Equal(t, pluginResults, outputs)
 }
+
+// TestRunPluginsWithTruncatedOutput tests that output cut by the size cap is flagged in the plugin result
+func TestRunPluginsWithTruncatedOutput(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	var cancelFlag task.CancelFlag = task.NewChanneledCancelFlag()
+	ctx := context.NewMockDefault()
+
+	longOutput := strings.Repeat("a", contracts.MaximumPluginOutputSize*2)
+	plugin := new(PluginMock)
+	config := contracts.Configuration{
+		PluginID: testPlugin1,
+	}
+	plugin.On("Execute", ctx, config, cancelFlag).Return(contracts.PluginResult{
+		Output: contracts.TruncateOutput(longOutput, "", contracts.MaximumPluginOutputSize),
+		Status: contracts.ResultStatusSuccess,
+	})
+	pluginStates := []model.PluginState{
+		{
+			Name:          testPlugin1,
+			Id:            testPlugin1,
+			Configuration: config,
+		},
+	}
+
+	ch := make(chan contracts.PluginResult, len(pluginStates))
+	outputs := RunPlugins(ctx, pluginStates, PluginRegistry{testPlugin1: plugin}, ch, cancelFlag)
+	close(ch)
+
+	plugin.AssertExpectations(t)
+	assert.True(t, outputs[testPlugin1].OutputTruncated)
+	assert.True(t, outputs[testPlugin1].OutputTruncatedAt > 0)
+	assert.True(t, outputs[testPlugin1].OutputTruncatedAt < contracts.MaximumPluginOutputSize)
+	update := <-ch
+	assert.True(t, update.OutputTruncated)
+}
+
+// progressPlugin reports the given progress steps before completing
+type progressPlugin struct {
+	steps []int
+}
+
+func (p progressPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag) contracts.PluginResult {
+	return contracts.PluginResult{Status: contracts.ResultStatusSuccess}
+}
+
+func (p progressPlugin) ExecuteWithProgress(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag, progress ProgressFunc) contracts.PluginResult {
+	for _, step := range p.steps {
+		progress(step)
+	}
+	return contracts.PluginResult{Status: contracts.ResultStatusSuccess}
+}
+
+// TestRunPluginsWithProgress tests that the progress reported by a plugin is persisted and forwarded as InProgress updates
+func TestRunPluginsWithProgress(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	var persisted []int
+	persistPluginProgress = func(log log.T, pluginID string, config contracts.Configuration, percent int) {
+		assert.Equal(t, testPlugin1, pluginID)
+		persisted = append(persisted, percent)
+	}
+	defer func() { persistPluginProgress = PersistPluginProgress }()
+	var cancelFlag task.CancelFlag = task.NewChanneledCancelFlag()
+	ctx := context.NewMockDefault()
+
+	pluginStates := []model.PluginState{
+		{Name: testPlugin1, Id: testPlugin1},
+		{Name: testPlugin2, Id: testPlugin2},
+	}
+	pluginRegistry := PluginRegistry{
+		testPlugin1: progressPlugin{steps: []int{25, 50, 150}},
+		testPlugin2: progressPlugin{},
+	}
+
+	ch := make(chan contracts.PluginResult, 10)
+	outputs := RunPlugins(ctx, pluginStates, pluginRegistry, ch, cancelFlag)
+	close(ch)
+
+	assert.Equal(t, []int{25, 50, 100}, persisted)
+	var updates []contracts.PluginResult
+	for update := range ch {
+		updates = append(updates, update)
+	}
+	if assert.Len(t, updates, 5) {
+		for i, expected := range []int{25, 50, 100} {
+			assert.Equal(t, testPlugin1, updates[i].PluginName)
+			assert.Equal(t, contracts.ResultStatusInProgress, updates[i].Status)
+			assert.Equal(t, expected, updates[i].Progress)
+		}
+		assert.Equal(t, contracts.ResultStatusSuccess, updates[3].Status)
+		assert.Equal(t, 100, updates[3].Progress)
+		// the plugin not reporting any progress is unaffected
+		assert.Equal(t, testPlugin2, updates[4].PluginName)
+		assert.Equal(t, 0, updates[4].Progress)
+	}
+	assert.Equal(t, 100, outputs[testPlugin1].Progress)
+
+	_, _, runtimeStatuses := docmanager.DocumentResultAggregator(ctx.Log(), testPlugin1, outputs)
+	assert.Equal(t, 100, runtimeStatuses[testPlugin1].Progress)
+}
+
+// cancelingPlugin cancels the document with the given reason while it runs
+type cancelingPlugin struct {
+	reason task.CancelReason
+}
+
+func (p cancelingPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag) contracts.PluginResult {
+	cancelFlag.(*task.ChanneledCancelFlag).SetWithReason(task.Canceled, p.reason)
+	return contracts.PluginResult{Status: contracts.ResultStatusCancelled}
+}
+
+// TestRunPluginsWithCancelReason tests that the reason of the cancellation is recorded in the results of the canceled plugins
+func TestRunPluginsWithCancelReason(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	persisted := make(map[string]task.CancelReason)
+	persistPluginCancelReason = func(log log.T, pluginID string, config contracts.Configuration, reason task.CancelReason) {
+		persisted[pluginID] = reason
+	}
+	defer func() { persistPluginCancelReason = PersistPluginCancelReason }()
+	ctx := context.NewMockDefault()
+
+	for _, reason := range []task.CancelReason{task.CancelReasonUserRequested, task.CancelReasonSuperseded, task.CancelReasonTimeout} {
+		persisted = make(map[string]task.CancelReason)
+		cancelFlag := task.NewChanneledCancelFlag()
+		pluginStates := []model.PluginState{
+			{Name: testPlugin1, Id: testPlugin1},
+			{Name: testPlugin2, Id: testPlugin2},
+		}
+		pluginRegistry := PluginRegistry{
+			testPlugin1: cancelingPlugin{reason: reason},
+			testPlugin2: progressPlugin{},
+		}
+
+		ch := make(chan contracts.PluginResult, 10)
+		outputs := RunPlugins(ctx, pluginStates, pluginRegistry, ch, cancelFlag)
+		close(ch)
+
+		assert.Equal(t, task.CancelReasonOf(cancelFlag), reason)
+		for _, pluginID := range []string{testPlugin1, testPlugin2} {
+			assert.Equal(t, string(reason), outputs[pluginID].CancelReason, pluginID)
+			assert.Equal(t, reason, persisted[pluginID], pluginID)
+		}
+		for update := range ch {
+			assert.Equal(t, string(reason), update.CancelReason)
+		}
+	}
+}
+
+// TestRunPluginsWithoutCancelReason tests that plugins of a document that isn't canceled have no cancel reason
+func TestRunPluginsWithoutCancelReason(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	persistPluginCancelReason = func(log log.T, pluginID string, config contracts.Configuration, reason task.CancelReason) {
+		assert.Fail(t, "no cancel reason is expected", pluginID)
+	}
+	defer func() { persistPluginCancelReason = PersistPluginCancelReason }()
+	ctx := context.NewMockDefault()
+
+	pluginStates := []model.PluginState{{Name: testPlugin1, Id: testPlugin1}}
+	pluginRegistry := PluginRegistry{testPlugin1: progressPlugin{}}
+	ch := make(chan contracts.PluginResult, 10)
+	outputs := RunPlugins(ctx, pluginStates, pluginRegistry, ch, task.NewChanneledCancelFlag())
+	close(ch)
+
+	assert.Empty(t, outputs[testPlugin1].CancelReason)
+}
+
+// TestRunPluginsWithUnsupportedPluginPolicy tests the steps of a document referencing an unknown plugin under each policy
+func TestRunPluginsWithUnsupportedPluginPolicy(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	unsupportedMessage := fmt.Sprintf(
+		"Plugin with name %s is not supported by this version of ssm agent, please update to latest version. Step name: %s",
+		testUnknownPlugin,
+		testUnknownPlugin)
+
+	testCases := []struct {
+		policy         string
+		expectedStatus map[string]contracts.ResultStatus
+	}{
+		{
+			policy: appconfig.UnsupportedPluginPolicyFailStep,
+			expectedStatus: map[string]contracts.ResultStatus{
+				testPlugin1:       contracts.ResultStatusSuccess,
+				testUnknownPlugin: contracts.ResultStatusFailed,
+				testPlugin2:       contracts.ResultStatusSuccess,
+			},
+		},
+		{
+			policy: appconfig.UnsupportedPluginPolicyMarkUnsupported,
+			expectedStatus: map[string]contracts.ResultStatus{
+				testPlugin1:       contracts.ResultStatusSuccess,
+				testUnknownPlugin: contracts.ResultStatusUnsupportedPlugin,
+				testPlugin2:       contracts.ResultStatusSuccess,
+			},
+		},
+		{
+			policy: appconfig.UnsupportedPluginPolicyFailDocument,
+			expectedStatus: map[string]contracts.ResultStatus{
+				testPlugin1:       contracts.ResultStatusFailed,
+				testUnknownPlugin: contracts.ResultStatusFailed,
+				testPlugin2:       contracts.ResultStatusFailed,
+			},
+		},
+	}
+
+	for _, testCase := range testCases {
+		config := appconfig.SsmagentConfig{}
+		config.Ssm.UnsupportedPluginPolicy = testCase.policy
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+		ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+
+		pluginStates := []model.PluginState{
+			{Name: testPlugin1, Id: testPlugin1},
+			{Name: testUnknownPlugin, Id: testUnknownPlugin},
+			{Name: testPlugin2, Id: testPlugin2},
+		}
+		pluginRegistry := PluginRegistry{
+			testPlugin1: progressPlugin{},
+			testPlugin2: progressPlugin{},
+		}
+		ch := make(chan contracts.PluginResult, 10)
+		outputs := RunPlugins(ctx, pluginStates, pluginRegistry, ch, task.NewChanneledCancelFlag())
+		close(ch)
+
+		for pluginID, status := range testCase.expectedStatus {
+			assert.Equal(t, status, outputs[pluginID].Status, "%v: %v", testCase.policy, pluginID)
+		}
+		unknownOutput := outputs[testUnknownPlugin]
+		switch testCase.policy {
+		case appconfig.UnsupportedPluginPolicyMarkUnsupported:
+			assert.Equal(t, unsupportedMessage, unknownOutput.Output)
+			assert.Nil(t, unknownOutput.Error)
+		case appconfig.UnsupportedPluginPolicyFailStep:
+			assert.EqualError(t, unknownOutput.Error, unsupportedMessage)
+		case appconfig.UnsupportedPluginPolicyFailDocument:
+			for _, output := range outputs {
+				assert.Contains(t, output.Error.Error(), unsupportedMessage)
+			}
+		}
+
+		updates := 0
+		for range ch {
+			updates++
+		}
+		assert.Equal(t, 3, updates, testCase.policy)
+	}
+}
+
+// TestDocumentStatusWithUnsupportedPlugin tests that the steps marked as UnsupportedPlugin don't fail the document
+func TestDocumentStatusWithUnsupportedPlugin(t *testing.T) {
+	outputs := map[string]*contracts.PluginResult{
+		testPlugin1:       {Status: contracts.ResultStatusSuccess},
+		testUnknownPlugin: {Status: contracts.ResultStatusUnsupportedPlugin},
+	}
+	status, _, _ := docmanager.DocumentResultAggregator(log.NewMockLog(), "", outputs)
+	assert.Equal(t, contracts.ResultStatusSuccess, status)
+}
+
+// garbageStatusPlugin completes with a status the agent doesn't know
+type garbageStatusPlugin struct{}
+
+func (p garbageStatusPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag) contracts.PluginResult {
+	return contracts.PluginResult{Status: "Exploded", Output: "plugin output", StandardOutput: "stdout"}
+}
+
+// TestRunPluginsWithUnrecognizedPluginStatus tests that an unknown plugin status is coerced or rejected according to the policy
+func TestRunPluginsWithUnrecognizedPluginStatus(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+
+	for _, policy := range []string{appconfig.UnrecognizedPluginStatusPolicyCoerce, appconfig.UnrecognizedPluginStatusPolicyReject} {
+		config := appconfig.SsmagentConfig{}
+		config.Ssm.UnrecognizedPluginStatusPolicy = policy
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+		ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+
+		pluginStates := []model.PluginState{
+			{Name: testPlugin1, Id: testPlugin1},
+			{Name: testPlugin2, Id: testPlugin2},
+		}
+		pluginRegistry := PluginRegistry{
+			testPlugin1: garbageStatusPlugin{},
+			testPlugin2: progressPlugin{},
+		}
+		ch := make(chan contracts.PluginResult, 10)
+		outputs := RunPlugins(ctx, pluginStates, pluginRegistry, ch, task.NewChanneledCancelFlag())
+		close(ch)
+
+		garbage := outputs[testPlugin1]
+		assert.Equal(t, contracts.ResultStatusFailed, garbage.Status, policy)
+		assert.NotEqual(t, 0, garbage.Code, policy)
+		assert.Contains(t, fmt.Sprint(garbage.Output), `unknown status "Exploded"`, policy)
+		switch policy {
+		case appconfig.UnrecognizedPluginStatusPolicyCoerce:
+			assert.Contains(t, garbage.Output, "plugin output")
+			assert.Equal(t, "stdout", garbage.StandardOutput)
+			assert.Nil(t, garbage.Error)
+		case appconfig.UnrecognizedPluginStatusPolicyReject:
+			assert.NotContains(t, garbage.Output, "plugin output")
+			assert.Empty(t, garbage.StandardOutput)
+			assert.Error(t, garbage.Error)
+		}
+		// the other steps are unaffected
+		assert.Equal(t, contracts.ResultStatusSuccess, outputs[testPlugin2].Status, policy)
+		for update := range ch {
+			assert.True(t, update.Status.IsRecognized(), policy)
+		}
+	}
+}
+
+// TestRunPluginsCreatesWorkingDirectory tests that the default working directory of a plugin exists when it runs
+func TestRunPluginsCreatesWorkingDirectory(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	dir, err := ioutil.TempDir("", "runpluginutil")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	workingDir := filepath.Join(dir, "orchestration", "workingDirectory-messageID")
+	ctx := context.NewMockDefault()
+
+	pluginState := model.PluginState{Name: testPlugin1, Id: testPlugin1}
+	pluginState.Configuration.DefaultWorkingDirectory = workingDir
+	ch := make(chan contracts.PluginResult, 10)
+	outputs := RunPlugins(ctx, []model.PluginState{pluginState}, PluginRegistry{testPlugin1: progressPlugin{}}, ch, task.NewChanneledCancelFlag())
+	close(ch)
+
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs[testPlugin1].Status)
+	assert.True(t, fileutil.IsDirectory(workingDir))
+}
+
+// failedPlugin completes with a failure
+type failedPlugin struct{}
+
+func (p failedPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag) contracts.PluginResult {
+	return contracts.PluginResult{Status: contracts.ResultStatusFailed, Code: 1, Output: "failed"}
+}
+
+// TestRunPluginsWithFailedDependency tests that a step depending on a failed step is skipped or failed according to the policy
+func TestRunPluginsWithFailedDependency(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+
+	testCases := map[string]contracts.ResultStatus{
+		appconfig.StepDependencyFailurePolicySkip:     contracts.ResultStatusSkipped,
+		appconfig.StepDependencyFailurePolicyFailFast: contracts.ResultStatusFailed,
+	}
+	for policy, expectedStatus := range testCases {
+		config := appconfig.SsmagentConfig{}
+		config.Ssm.StepDependencyFailurePolicy = policy
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+		ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+
+		stepA := model.PluginState{Name: testPlugin1, Id: "stepA"}
+		stepB := model.PluginState{Name: testPlugin2, Id: "stepB"}
+		stepB.Configuration.DependsOn = []string{"stepA"}
+		stepC := model.PluginState{Name: testPlugin2, Id: "stepC"}
+		pluginRegistry := PluginRegistry{
+			testPlugin1: failedPlugin{},
+			testPlugin2: progressPlugin{},
+		}
+		ch := make(chan contracts.PluginResult, 10)
+		outputs := RunPlugins(ctx, []model.PluginState{stepA, stepB, stepC}, pluginRegistry, ch, task.NewChanneledCancelFlag())
+		close(ch)
+
+		assert.Equal(t, contracts.ResultStatusFailed, outputs["stepA"].Status, policy)
+		assert.Equal(t, expectedStatus, outputs["stepB"].Status, policy)
+		assert.Contains(t, fmt.Sprint(outputs["stepB"].Output, outputs["stepB"].Error), "depends on step stepA", policy)
+		// the steps not depending on the failed step still run
+		assert.Equal(t, contracts.ResultStatusSuccess, outputs["stepC"].Status, policy)
+		updates := 0
+		for range ch {
+			updates++
+		}
+		assert.Equal(t, 3, updates, policy)
+	}
+}
+
+// TestRunPluginsWithSucceededDependency tests that a step runs once the steps it depends on succeeded
+func TestRunPluginsWithSucceededDependency(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	ctx := context.NewMockDefault()
+
+	stepA := model.PluginState{Name: testPlugin1, Id: "stepA"}
+	stepB := model.PluginState{Name: testPlugin1, Id: "stepB"}
+	stepB.Configuration.DependsOn = []string{"stepA"}
+	stepC := model.PluginState{Name: testPlugin1, Id: "stepC"}
+	stepC.Configuration.DependsOn = []string{"stepD"}
+	stepD := model.PluginState{Name: testPlugin1, Id: "stepD"}
+	ch := make(chan contracts.PluginResult, 10)
+	outputs := RunPlugins(ctx, []model.PluginState{stepA, stepB, stepC, stepD}, PluginRegistry{testPlugin1: progressPlugin{}}, ch, task.NewChanneledCancelFlag())
+	close(ch)
+
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs["stepB"].Status)
+	// a step can't depend on a step declared after it
+	assert.Equal(t, contracts.ResultStatusSkipped, outputs["stepC"].Status)
+	assert.Contains(t, fmt.Sprint(outputs["stepC"].Output), "doesn't run before it")
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs["stepD"].Status)
+}
+
+// countingPlugin succeeds and counts its executions
+type countingPlugin struct {
+	executions *int
+}
+
+func (p countingPlugin) Execute(context context.T, config contracts.Configuration, cancelFlag task.CancelFlag) contracts.PluginResult {
+	*p.executions++
+	return contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "rerun"}
+}
+
+// TestRunPluginsWithResetFailedPlugins tests that only the plugins reset by a rerun execute, their results merged with the kept ones
+func TestRunPluginsWithResetFailedPlugins(t *testing.T) {
+	setIsSupportedMock()
+	defer restoreIsSupported()
+	executions := 0
+	pluginStates := []model.PluginState{
+		{Name: testPlugin1, Id: "first", Result: contracts.PluginResult{Status: contracts.ResultStatusSuccess, Output: "first done"}},
+		{Name: testPlugin1, Id: "second", Result: contracts.PluginResult{Status: contracts.ResultStatusNotStarted}},
+		{Name: testPlugin1, Id: "third", Result: contracts.PluginResult{Status: contracts.ResultStatusSkipped, Output: "third skipped"}},
+	}
+	ch := make(chan contracts.PluginResult, 10)
+	outputs := RunPlugins(context.NewMockDefault(), pluginStates, PluginRegistry{testPlugin1: countingPlugin{&executions}}, ch, task.NewChanneledCancelFlag())
+	close(ch)
+
+	assert.Equal(t, 1, executions)
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs["first"].Status)
+	assert.Equal(t, "first done", outputs["first"].Output)
+	assert.Equal(t, contracts.ResultStatusSuccess, outputs["second"].Status)
+	assert.Equal(t, "rerun", outputs["second"].Output)
+	assert.Equal(t, contracts.ResultStatusSkipped, outputs["third"].Status)
+	status, _, _ := docmanager.DocumentResultAggregator(log.NewMockLog(), "", outputs)
+	assert.Equal(t, contracts.ResultStatusSuccess, status)
+}
@@ -29,6 +29,13 @@ func Indent(jsonStr string) string {
 	return string(dst.Bytes())
 }
 
+// Compact removes the insignificant white spaces of a json string.
+func Compact(jsonStr string) string {
+	var dst bytes.Buffer
+	json.Compact(&dst, []byte(jsonStr))
+	return string(dst.Bytes())
+}
+
 // Remarshal marshals an object to Json then parses it back to another object.
 // This is useful for example when we want to go from map[string]interface{}
 // to a more specific struct type or if we want a deep copy of the object.
@@ -31,12 +31,14 @@ func NewMockLog() *Mock {
 	log.On("Flush").Return()
 	log.On("Debug", mock.Anything).Return()
 	log.On("Error", mock.Anything).Return(nil)
+	log.On("Warn", mock.Anything).Return(nil)
 	log.On("Trace", mock.Anything).Return()
 	log.On("Info", mock.Anything).Return()
 	log.On("Debugf", mock.Anything, mock.Anything).Return()
 	log.On("Errorf", mock.Anything, mock.Anything).Return(nil)
 	log.On("Tracef", mock.Anything, mock.Anything).Return()
 	log.On("Infof", mock.Anything, mock.Anything).Return()
+	log.On("Warnf", mock.Anything, mock.Anything).Return(nil)
 	return log
 }
 
@@ -0,0 +1,70 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package metrics defines the sink the agent reports its operational metrics to
+package metrics
+
+// names of the metrics reported by the agent
+const (
+	// DocumentPersistSuccess counts the document states persisted
+	DocumentPersistSuccess = "ssm_agent_document_persist_success_total"
+	// DocumentPersistFailure counts the document states that failed to persist
+	DocumentPersistFailure = "ssm_agent_document_persist_failure_total"
+	// CleanupDeletedDocuments counts the documents deleted by the data store cleanup
+	CleanupDeletedDocuments = "ssm_agent_cleanup_deleted_documents_total"
+	// CleanupFailedDeletions counts the documents the data store cleanup failed to delete
+	CleanupFailedDeletions = "ssm_agent_cleanup_failed_deletions_total"
+	// CleanupSweeps counts the data store cleanups that went through all the terminal documents
+	CleanupSweeps = "ssm_agent_cleanup_sweeps_total"
+	// CleanupExaminedDocuments counts the documents examined by the data store cleanup
+	CleanupExaminedDocuments = "ssm_agent_cleanup_examined_documents_total"
+	// CleanupSkippedDocuments counts the documents examined by the data store cleanup and kept
+	CleanupSkippedDocuments = "ssm_agent_cleanup_skipped_documents_total"
+	// CleanupFreedBytes counts the bytes freed by the data store cleanup
+	CleanupFreedBytes = "ssm_agent_cleanup_freed_bytes_total"
+	// CleanupSweepSeconds is the time taken by a data store cleanup
+	CleanupSweepSeconds = "ssm_agent_cleanup_sweep_seconds"
+	// InFlightDocuments is the number of documents queued or running in the processors
+	InFlightDocuments = "ssm_agent_in_flight_documents"
+	// DocumentProcessingSeconds is the time taken to run a document, from its start to its move to a terminal folder
+	DocumentProcessingSeconds = "ssm_agent_document_processing_seconds"
+	// RepeatedDocumentFailures counts the alerts raised on the documents of a name failing repeatedly
+	RepeatedDocumentFailures = "ssm_agent_repeated_document_failures_total"
+	// ExpiredMessages counts the messages left unacknowledged because their processing outlasted their visibility
+	ExpiredMessages = "ssm_agent_expired_messages_total"
+)
+
+// Sink receives the metrics of the agent, it must be safe for concurrent use.
+type Sink interface {
+	// IncrCounter adds delta to the counter
+	IncrCounter(name string, delta float64)
+	// SetGauge sets the gauge to value
+	SetGauge(name string, value float64)
+	// Observe adds value to the histogram
+	Observe(name string, value float64)
+}
+
+// DefaultSink receives the metrics reported by the agent, it records nothing by default
+var DefaultSink Sink = noopSink{}
+
+// noopSink is a sink recording nothing
+type noopSink struct{}
+
+// IncrCounter does nothing
+func (noopSink) IncrCounter(name string, delta float64) {}
+
+// SetGauge does nothing
+func (noopSink) SetGauge(name string, value float64) {}
+
+// Observe does nothing
+func (noopSink) Observe(name string, value float64) {}
@@ -0,0 +1,158 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package metrics defines the sink the agent reports its operational metrics to
+package metrics
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// prometheusContentType is the content type of the Prometheus text exposition format
+const prometheusContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefaultBuckets are the upper bounds, in seconds, of the buckets of the histograms
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 900, 3600}
+
+// histogram is a cumulative histogram in the Prometheus sense
+type histogram struct {
+	// counts holds the number of observations in each bucket, not cumulated
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// PrometheusSink keeps the metrics in memory and renders them in the Prometheus text exposition format.
+type PrometheusSink struct {
+	buckets    []float64
+	counters   map[string]float64
+	gauges     map[string]float64
+	histograms map[string]*histogram
+	m          sync.Mutex
+}
+
+// NewPrometheusSink returns an empty sink whose histograms use the given bucket upper bounds, DefaultBuckets if none
+func NewPrometheusSink(buckets ...float64) *PrometheusSink {
+	if len(buckets) == 0 {
+		buckets = DefaultBuckets
+	}
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	return &PrometheusSink{
+		buckets:    sorted,
+		counters:   make(map[string]float64),
+		gauges:     make(map[string]float64),
+		histograms: make(map[string]*histogram),
+	}
+}
+
+// IncrCounter adds delta to the counter
+func (p *PrometheusSink) IncrCounter(name string, delta float64) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.counters[name] += delta
+}
+
+// SetGauge sets the gauge to value
+func (p *PrometheusSink) SetGauge(name string, value float64) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	p.gauges[name] = value
+}
+
+// Observe adds value to the histogram
+func (p *PrometheusSink) Observe(name string, value float64) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	h, found := p.histograms[name]
+	if !found {
+		h = &histogram{counts: make([]uint64, len(p.buckets))}
+		p.histograms[name] = h
+	}
+	for i, upperBound := range p.buckets {
+		if value <= upperBound {
+			h.counts[i]++
+			break
+		}
+	}
+	h.sum += value
+	h.count++
+}
+
+// Render returns the metrics in the Prometheus text exposition format, sorted by name
+func (p *PrometheusSink) Render() []byte {
+	p.m.Lock()
+	defer p.m.Unlock()
+	var buf bytes.Buffer
+	for _, name := range sortedNames(p.counters) {
+		fmt.Fprintf(&buf, "# TYPE %v counter\n%v %v\n", name, name, formatValue(p.counters[name]))
+	}
+	for _, name := range sortedNames(p.gauges) {
+		fmt.Fprintf(&buf, "# TYPE %v gauge\n%v %v\n", name, name, formatValue(p.gauges[name]))
+	}
+	histogramNames := make([]string, 0, len(p.histograms))
+	for name := range p.histograms {
+		histogramNames = append(histogramNames, name)
+	}
+	sort.Strings(histogramNames)
+	for _, name := range histogramNames {
+		h := p.histograms[name]
+		fmt.Fprintf(&buf, "# TYPE %v histogram\n", name)
+		var cumulated uint64
+		for i, upperBound := range p.buckets {
+			cumulated += h.counts[i]
+			fmt.Fprintf(&buf, "%v_bucket{le=\"%v\"} %v\n", name, formatValue(upperBound), cumulated)
+		}
+		fmt.Fprintf(&buf, "%v_bucket{le=\"+Inf\"} %v\n", name, h.count)
+		fmt.Fprintf(&buf, "%v_sum %v\n", name, formatValue(h.sum))
+		fmt.Fprintf(&buf, "%v_count %v\n", name, h.count)
+	}
+	return buf.Bytes()
+}
+
+// Handler returns the http handler serving the metrics to a Prometheus scraper
+func (p *PrometheusSink) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", prometheusContentType)
+		w.Write(p.Render())
+	})
+}
+
+// sortedNames returns the names of the metrics in alphabetical order
+func sortedNames(values map[string]float64) []string {
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// formatValue formats the value the way the Prometheus text format expects it
+func formatValue(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package metrics defines the sink the agent reports its operational metrics to
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusSinkRendersMetrics(t *testing.T) {
+	sink := NewPrometheusSink(0.1, 1)
+	sink.IncrCounter(DocumentPersistSuccess, 1)
+	sink.IncrCounter(DocumentPersistSuccess, 2)
+	sink.IncrCounter(DocumentPersistFailure, 1)
+	sink.SetGauge(InFlightDocuments, 5)
+	sink.SetGauge(InFlightDocuments, 4)
+	sink.Observe(DocumentProcessingSeconds, 0.05)
+	sink.Observe(DocumentProcessingSeconds, 0.5)
+	sink.Observe(DocumentProcessingSeconds, 2)
+
+	rendered := string(sink.Render())
+
+	expected := []string{
+		"# TYPE ssm_agent_document_persist_success_total counter",
+		"ssm_agent_document_persist_success_total 3",
+		"ssm_agent_document_persist_failure_total 1",
+		"# TYPE ssm_agent_in_flight_documents gauge",
+		"ssm_agent_in_flight_documents 4",
+		"# TYPE ssm_agent_document_processing_seconds histogram",
+		`ssm_agent_document_processing_seconds_bucket{le="0.1"} 1`,
+		`ssm_agent_document_processing_seconds_bucket{le="1"} 2`,
+		`ssm_agent_document_processing_seconds_bucket{le="+Inf"} 3`,
+		"ssm_agent_document_processing_seconds_sum 2.55",
+		"ssm_agent_document_processing_seconds_count 3",
+	}
+	lines := strings.Split(rendered, "\n")
+	for _, line := range expected {
+		assert.Contains(t, lines, line)
+	}
+}
+
+func TestPrometheusSinkHandler(t *testing.T) {
+	sink := NewPrometheusSink()
+	sink.IncrCounter(CleanupDeletedDocuments, 2)
+	recorder := httptest.NewRecorder()
+
+	sink.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+
+	assert.Equal(t, prometheusContentType, recorder.Header().Get("Content-Type"))
+	body, err := ioutil.ReadAll(recorder.Body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(body), "ssm_agent_cleanup_deleted_documents_total 2\n")
+}
@@ -18,8 +18,10 @@ import (
 	"io"
 	"io/ioutil"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"errors"
 
@@ -38,6 +40,7 @@ const (
 	defaultExecutionTimeoutInSeconds = 3600
 	maxExecutionTimeoutInSeconds     = 172800
 	minExecutionTimeoutInSeconds     = 5
+	redactedValue                    = "****"
 )
 
 // UploadOutputToS3BucketExecuter is a function that can upload outputs to S3 bucket.
@@ -69,15 +72,28 @@ type DefaultPlugin struct {
 
 	// OutputTruncatedSuffix is an optional suffix that is inserted at the end of the truncated stdout/stderr.
 	OutputTruncatedSuffix string
+
+	// OutputFlushInterval is the interval of the intermediate uploads of the output to S3 while the plugin runs, 0 disables them.
+	OutputFlushInterval time.Duration
+
+	// OutputRedactionPatterns are the patterns redacted from the output before it is returned or uploaded.
+	OutputRedactionPatterns []appconfig.OutputRedactionPattern
 }
 
 // PluginConfig is used for initializing plugins with default values
 type PluginConfig struct {
-	StdoutFileName        string
-	StderrFileName        string
-	MaxStdoutLength       int
-	MaxStderrLength       int
-	OutputTruncatedSuffix string
+	StdoutFileName          string
+	StderrFileName          string
+	MaxStdoutLength         int
+	MaxStderrLength         int
+	OutputTruncatedSuffix   string
+	OutputFlushInterval     time.Duration
+	OutputRedactionPatterns []appconfig.OutputRedactionPattern
+}
+
+// s3Upload uploads the local file to the given S3 bucket and key, assigned to a global variable to allow unittest to override
+var s3Upload = func(log log.T, bucketName string, bucketKey string, contentPath string) error {
+	return s3util.NewAmazonS3Util(log, bucketName).S3Upload(log, bucketName, bucketKey, contentPath)
 }
 
 // StringPrefix returns the beginning part of a string, truncated to the given limit.
@@ -139,7 +155,7 @@ func (p *DefaultPlugin) UploadOutputToS3Bucket(log log.T, pluginID string, orche
 			if Stdout != "" {
 				localPath := filepath.Join(orchestrationDir, p.StdoutFileName)
 				s3Key := fileutil.BuildS3Path(outputS3KeyPrefix, pluginID, p.StdoutFileName)
-				if err := s3util.NewAmazonS3Util(log, outputS3BucketName).S3Upload(log, outputS3BucketName, s3Key, localPath); err != nil && p.UploadToS3Sync {
+				if err := s3Upload(log, outputS3BucketName, s3Key, localPath); err != nil && p.UploadToS3Sync {
 					// if we are in synchronous mode, we can also return the error
 					uploadOutputToS3BucketErrors = append(uploadOutputToS3BucketErrors, err.Error())
 				}
@@ -147,7 +163,7 @@ func (p *DefaultPlugin) UploadOutputToS3Bucket(log log.T, pluginID string, orche
 			if Stderr != "" {
 				localPath := filepath.Join(orchestrationDir, p.StderrFileName)
 				s3Key := fileutil.BuildS3Path(outputS3KeyPrefix, pluginID, p.StderrFileName)
-				if err := s3util.NewAmazonS3Util(log, outputS3BucketName).S3Upload(log, outputS3BucketName, s3Key, localPath); err != nil && p.UploadToS3Sync {
+				if err := s3Upload(log, outputS3BucketName, s3Key, localPath); err != nil && p.UploadToS3Sync {
 					// if we are in synchronous mode, we can also return the error
 					uploadOutputToS3BucketErrors = append(uploadOutputToS3BucketErrors, err.Error())
 				}
@@ -165,6 +181,113 @@ func (p *DefaultPlugin) UploadOutputToS3Bucket(log log.T, pluginID string, orche
 	return uploadOutputToS3BucketErrors
 }
 
+// StartOutputFlush periodically uploads the output accumulated so far in the orchestration dir to S3 while the plugin runs.
+// The returned function stops the flushes, it must be called before the final upload so that the complete output is uploaded last.
+// No flush happens when redaction patterns are configured since the output is only redacted once the plugin completes.
+func (p *DefaultPlugin) StartOutputFlush(log log.T, pluginID string, orchestrationDir string, outputS3BucketName string, outputS3KeyPrefix string) (stop func()) {
+	if outputS3BucketName == "" || p.OutputFlushInterval <= 0 || len(p.OutputRedactionPatterns) > 0 {
+		return func() {}
+	}
+	stopChan := make(chan bool)
+	doneChan := make(chan bool)
+	go func() {
+		defer close(doneChan)
+		ticker := time.NewTicker(p.OutputFlushInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stopChan:
+				return
+			case <-ticker.C:
+				p.flushOutput(log, pluginID, orchestrationDir, outputS3BucketName, outputS3KeyPrefix)
+			}
+		}
+	}()
+	return func() {
+		close(stopChan)
+		<-doneChan
+	}
+}
+
+// flushOutput uploads the non empty output files of the orchestration dir to S3
+func (p *DefaultPlugin) flushOutput(log log.T, pluginID string, orchestrationDir string, outputS3BucketName string, outputS3KeyPrefix string) {
+	for _, fileName := range []string{p.StdoutFileName, p.StderrFileName} {
+		localPath := filepath.Join(orchestrationDir, fileName)
+		if size, err := fileutil.GetPathSize(localPath); err != nil || size == 0 {
+			continue
+		}
+		s3Key := fileutil.BuildS3Path(outputS3KeyPrefix, pluginID, fileName)
+		if err := s3Upload(log, outputS3BucketName, s3Key, localPath); err != nil {
+			log.Debugf("intermediate upload of %v failed: %v", localPath, err)
+		}
+	}
+}
+
+// RedactOutput replaces the matches of the redaction patterns of the plugin in the output files of the orchestration dir
+// and in the given output, before they are uploaded or persisted, and records in the output that it was redacted.
+func (p *DefaultPlugin) RedactOutput(log log.T, pluginName string, orchestrationDir string, out *contracts.PluginOutput) {
+	patterns := RedactionPatterns(log, p.OutputRedactionPatterns, pluginName)
+	if len(patterns) == 0 {
+		return
+	}
+	for _, fileName := range []string{p.StdoutFileName, p.StderrFileName} {
+		localPath := filepath.Join(orchestrationDir, fileName)
+		if !fileutil.Exists(localPath) {
+			continue
+		}
+		content, err := ioutil.ReadFile(localPath)
+		if err != nil {
+			log.Errorf("failed to read %v for redaction: %v", localPath, err)
+			continue
+		}
+		if redacted, ok := Redact(string(content), patterns); ok {
+			if err = ioutil.WriteFile(localPath, []byte(redacted), appconfig.ReadWriteAccess); err != nil {
+				log.Errorf("failed to write the redacted output to %v: %v", localPath, err)
+				continue
+			}
+			out.Redacted = true
+		}
+	}
+	var ok bool
+	if out.Stdout, ok = Redact(out.Stdout, patterns); ok {
+		out.Redacted = true
+	}
+	if out.Stderr, ok = Redact(out.Stderr, patterns); ok {
+		out.Redacted = true
+	}
+	if out.Redacted {
+		log.Infof("redacted the output of %v", pluginName)
+	}
+}
+
+// RedactionPatterns compiles the redaction patterns that apply to the plugin, the invalid patterns are skipped
+func RedactionPatterns(log log.T, patterns []appconfig.OutputRedactionPattern, pluginName string) (compiled []*regexp.Regexp) {
+	for _, pattern := range patterns {
+		if pattern.PluginName != "" && pattern.PluginName != pluginName {
+			continue
+		}
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil {
+			log.Warnf("ignoring invalid output redaction pattern %v: %v", pattern.Pattern, err)
+			continue
+		}
+		compiled = append(compiled, re)
+	}
+	return
+}
+
+// Redact replaces the matches of the patterns in the text, and returns whether anything was replaced
+func Redact(text string, patterns []*regexp.Regexp) (string, bool) {
+	redacted := false
+	for _, re := range patterns {
+		if re.MatchString(text) {
+			text = re.ReplaceAllLiteralString(text, redactedValue)
+			redacted = true
+		}
+	}
+	return text, redacted
+}
+
 // CreateScriptFile creates a script containing the given commands.
 func CreateScriptFile(log log.T, scriptPath string, runCommand []string, byteOrderMark fileutil.ByteOrderMark) (err error) {
 	// write source commands to file
@@ -193,12 +316,32 @@ func DownloadFileFromSource(log log.T, source string, sourceHash string, sourceH
 // DefaultPluginConfig returns the default values for the plugin
 func DefaultPluginConfig() PluginConfig {
 	return PluginConfig{
-		StdoutFileName:        "stdout",
-		StderrFileName:        "stderr",
-		MaxStdoutLength:       24000,
-		MaxStderrLength:       8000,
-		OutputTruncatedSuffix: "--output truncated--",
+		StdoutFileName:          "stdout",
+		StderrFileName:          "stderr",
+		MaxStdoutLength:         24000,
+		MaxStderrLength:         8000,
+		OutputTruncatedSuffix:   "--output truncated--",
+		OutputFlushInterval:     outputFlushInterval(),
+		OutputRedactionPatterns: outputRedactionPatterns(),
+	}
+}
+
+// outputFlushInterval returns the interval of the intermediate output uploads set in the agent configuration
+func outputFlushInterval() time.Duration {
+	appCfg, err := appconfig.Config(false)
+	if err != nil {
+		return 0
+	}
+	return time.Duration(appCfg.S3.OutputFlushIntervalSeconds) * time.Second
+}
+
+// outputRedactionPatterns returns the output redaction patterns set in the agent configuration
+func outputRedactionPatterns() []appconfig.OutputRedactionPattern {
+	appCfg, err := appconfig.Config(false)
+	if err != nil {
+		return nil
 	}
+	return appCfg.Ssm.OutputRedactionPatterns
 }
 
 // PersistPluginInformationToCurrent persists the plugin execution results
@@ -221,6 +364,7 @@ func PersistPluginInformationToCurrent(log log.T, pluginID string, config contra
 	}
 
 	//set plugin state's execution details
+	res.MarkOutputTruncation()
 	pluginState.Configuration = config
 	pluginState.Result = res
 
@@ -57,6 +57,8 @@ func (p *Plugin) AssignPluginConfigs(pluginConfig pluginutil.PluginConfig) {
 	p.MaxStdoutLength = pluginConfig.MaxStdoutLength
 	p.MaxStderrLength = pluginConfig.MaxStderrLength
 	p.OutputTruncatedSuffix = pluginConfig.OutputTruncatedSuffix
+	p.OutputFlushInterval = pluginConfig.OutputFlushInterval
+	p.OutputRedactionPatterns = pluginConfig.OutputRedactionPatterns
 	p.StdoutFileName = pluginConfig.StdoutFileName
 	p.StderrFileName = pluginConfig.StderrFileName
 	p.ExecuteUploadOutputToS3Bucket = pluginutil.UploadOutputToS3BucketExecuter(p.UploadOutputToS3Bucket)
@@ -100,6 +102,7 @@ func (p *Plugin) Execute(context context.T, config contracts.Configuration, canc
 	res.Output = out.String()
 	res.StandardOutput = pluginutil.StringPrefix(out.Stdout, p.MaxStdoutLength, p.OutputTruncatedSuffix)
 	res.StandardError = pluginutil.StringPrefix(out.Stderr, p.MaxStderrLength, p.OutputTruncatedSuffix)
+	res.OutputRedacted = out.Redacted
 
 	pluginutil.PersistPluginInformationToCurrent(log, config.PluginID, config, res)
 
@@ -160,8 +163,16 @@ func (p *Plugin) runCommands(log log.T, pluginID string, pluginInput RunScriptPl
 	commandName := p.ShellCommand
 	commandArguments := append(p.ShellArguments, scriptPath, appconfig.ExitCodeTrap)
 
+	// Upload the output accumulated so far to S3 while the commands run
+	s3PluginID := pluginInput.ID
+	if s3PluginID == "" {
+		s3PluginID = pluginID
+	}
+	stopOutputFlush := p.StartOutputFlush(log, s3PluginID, orchestrationDir, outputS3BucketName, outputS3KeyPrefix)
+
 	// Execute Command
 	stdout, stderr, exitCode, errs := p.CommandExecuter.Execute(log, workingDir, stdoutFilePath, stderrFilePath, cancelFlag, executionTimeout, commandName, commandArguments)
+	stopOutputFlush()
 
 	// Set output status
 	out.ExitCode = exitCode
@@ -186,12 +197,9 @@ func (p *Plugin) runCommands(log log.T, pluginID string, pluginInput RunScriptPl
 	} else {
 		out.AppendError(log, string(bytesErr))
 	}
+	p.RedactOutput(log, p.Name, orchestrationDir, &out)
 
 	// Upload output to S3
-	s3PluginID := pluginInput.ID
-	if s3PluginID == "" {
-		s3PluginID = pluginID
-	}
 	uploadOutputToS3BucketErrors := p.ExecuteUploadOutputToS3Bucket(log, s3PluginID, orchestrationDir, outputS3BucketName, outputS3KeyPrefix, false, "", out.Stdout, out.Stderr)
 	if len(uploadOutputToS3BucketErrors) > 0 {
 		log.Errorf("Unable to upload the logs: %s", uploadOutputToS3BucketErrors)
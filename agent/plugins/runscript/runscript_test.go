@@ -17,6 +17,8 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
+	"os"
 	"path/filepath"
 	"testing"
 
@@ -45,14 +47,28 @@ type TestCase struct {
 type CommandTester func(p *Plugin, mockCancelFlag *task.MockCancelFlag, mockExecuter *executers.MockCommandExecuter, mockS3Uploader *pluginutil.MockDefaultPlugin)
 
 const (
-	orchestrationDirectory = "OrchesDir"
-	s3BucketName           = "bucket"
-	s3KeyPrefix            = "key"
-	pluginID               = "aws:runScript1"
-	testInstanceID         = "i-12345678"
-	bucketRegionErrorMsg   = "AuthorizationHeaderMalformed: The authorization header is malformed; the region 'us-east-1' is wrong; expecting 'us-west-2' status code: 400, request id: []"
+	s3BucketName         = "bucket"
+	s3KeyPrefix          = "key"
+	pluginID             = "aws:runScript1"
+	testInstanceID       = "i-12345678"
+	bucketRegionErrorMsg = "AuthorizationHeaderMalformed: The authorization header is malformed; the region 'us-east-1' is wrong; expecting 'us-west-2' status code: 400, request id: []"
 )
 
+// orchestrationDirectory is a temp dir, the scripts the tests write are removed along with it
+var orchestrationDirectory string
+
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "OrchesDir")
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	orchestrationDirectory = dir
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
 var TestCases = []TestCase{
 	generateTestCaseOk("0"),
 	generateTestCaseOk("1"),
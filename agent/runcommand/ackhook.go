@@ -0,0 +1,27 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+)
+
+// AcknowledgeGate is called with the parsed document of a message right before the message is acked,
+// it returns an error to veto the ack, the message is then left in MDS for redelivery.
+type AcknowledgeGate func(docState *model.DocumentState) error
+
+// BeforeAcknowledge is invoked on every parsed message before it is acked,
+// nil allows every ack
+var BeforeAcknowledge AcknowledgeGate
@@ -0,0 +1,81 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// TestProcessMessageWithAllowedAck tests processMessage acks and schedules a message the gate allows
+func TestProcessMessageWithAllowedAck(t *testing.T) {
+	var gated *model.DocumentState
+	BeforeAcknowledge = func(docState *model.DocumentState) error {
+		gated = docState
+		return nil
+	}
+	defer func() { BeforeAcknowledge = nil }()
+	var fakeDocState = model.DocumentState{
+		DocumentType: model.SendCommand,
+	}
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	loadDocStateFromSendCommand = func(context context.T,
+		msg *ssmmds.Message,
+		messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.ProcessMock.On("Submit", fakeDocState).Return(nil)
+
+	svc.processMessage(&tc.Message)
+
+	assert.Equal(t, &fakeDocState, gated)
+	tc.MdsMock.AssertExpectations(t)
+	tc.ProcessMock.AssertExpectations(t)
+	assert.True(t, *tc.IsDocLevelResponseSent)
+}
+
+// TestProcessMessageWithVetoedAck tests processMessage leaves a message the gate vetoes for redelivery
+func TestProcessMessageWithVetoedAck(t *testing.T) {
+	BeforeAcknowledge = func(docState *model.DocumentState) error {
+		return errors.New("not now")
+	}
+	defer func() { BeforeAcknowledge = nil }()
+	var fakeDocState = model.DocumentState{
+		DocumentType: model.SendCommand,
+	}
+	fakeDocState.DocumentInformation.CommandID = "commandID"
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	loadDocStateFromSendCommand = func(context context.T,
+		msg *ssmmds.Message,
+		messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+
+	svc.processMessage(&tc.Message)
+
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.MdsMock.AssertNotCalled(t, "FailMessage", mock.Anything, mock.Anything, mock.Anything)
+	tc.ProcessMock.AssertNotCalled(t, "Submit", mock.Anything)
+	assert.False(t, *tc.IsDocLevelResponseSent)
+	assert.Empty(t, svc.correlator.lookup("commandID"))
+	assert.Empty(t, svc.tracing.traces)
+}
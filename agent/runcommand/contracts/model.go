@@ -23,12 +23,21 @@ type CancelPayload struct {
 
 // SendCommandPayload parallels the structure of a send command MDS message payload.
 type SendCommandPayload struct {
-	Parameters         map[string]interface{}    `json:"Parameters"`
-	DocumentContent    contracts.DocumentContent `json:"DocumentContent"`
-	CommandID          string                    `json:"CommandId"`
-	DocumentName       string                    `json:"DocumentName"`
-	OutputS3KeyPrefix  string                    `json:"OutputS3KeyPrefix"`
-	OutputS3BucketName string                    `json:"OutputS3BucketName"`
+	Parameters          map[string]interface{}    `json:"Parameters"`
+	DocumentContent     contracts.DocumentContent `json:"DocumentContent"`
+	CommandID           string                    `json:"CommandId"`
+	DocumentName        string                    `json:"DocumentName"`
+	OutputS3KeyPrefix   string                    `json:"OutputS3KeyPrefix"`
+	OutputS3BucketName  string                    `json:"OutputS3BucketName"`
+	SupersedesCommandID string                    `json:"SupersedesCommandId"`
+	// OrchestrationRetentionHours is how long the document asks its logs to be kept on the instance
+	OrchestrationRetentionHours int `json:"OrchestrationRetentionHours,omitempty"`
+	// SequenceNumber orders the messages of the command, starting at 1, 0 if the message isn't sequenced
+	SequenceNumber int `json:"SequenceNumber,omitempty"`
+	// PreconditionCommandID is the command that has to have succeeded on the instance for this one to run
+	PreconditionCommandID string `json:"PreconditionCommandId,omitempty"`
+	// SkipManagedInstanceRewrite opts the document out of the rewriting of the managed instance incompatible documents
+	SkipManagedInstanceRewrite bool `json:"SkipManagedInstanceRewrite,omitempty"`
 }
 
 // SendReplyPayload represents the json structure of a reply sent to MDS.
@@ -22,6 +22,7 @@ import (
 	asocitscheduler "github.com/aws/amazon-ssm-agent/agent/association/scheduler"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
 	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
 	mdsService "github.com/aws/amazon-ssm-agent/agent/runcommand/mds"
 	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
@@ -42,6 +43,7 @@ var once sync.Once
 
 var loadDocStateFromSendCommand = parseSendCommandMessage
 var loadDocStateFromCancelCommand = parseCancelCommandMessage
+var checkOrchestrationDirCollision = docmanager.CheckOrchestrationDirCollision
 
 // Name returns the module name
 func (s *RunCommandService) ModuleName() string {
@@ -59,6 +61,9 @@ func (s *RunCommandService) ModuleExecute(context context.T) (err error) {
 		return
 	}
 	go s.listenReply(resultChan)
+	if s.dropWatcher != nil {
+		go s.listenDropFolder()
+	}
 	log.Info("Starting message polling")
 	if s.messagePollJob, err = scheduler.Every(pollMessageFrequencyMinutes).Minutes().Run(s.loop); err != nil {
 		context.Log().Errorf("unable to schedule message poll job. %v", err)
@@ -107,42 +112,121 @@ func (s *RunCommandService) listenReply(resultChan chan contracts.DocumentResult
 	log := s.context.Log()
 	//processor guarantees to close this channel upon stop
 	for res := range resultChan {
+		s.tracing.resultReceived(res)
 		if res.LastPlugin != "" {
 			log.Infof("received plugin: %v result from Processor", res.LastPlugin)
 		} else {
 			log.Infof("command: %v complete", res.MessageID)
+			s.correlator.remove(res.MessageID)
+			if config := s.context.AppConfig(); config.Mds.LocalResultsDir != "" {
+				writeLocalResult(log, config.Mds.LocalResultsDir, config.Ssm.OutputRedactionPatterns, res)
+			}
 		}
 		s.sendResponse(res.MessageID, res)
 	}
 }
 
+// listenDropFolder polls the offline service as soon as a document dropped into the local command folder is completely written
+func (s *RunCommandService) listenDropFolder() {
+	log := s.context.Log()
+	for {
+		select {
+		case <-s.stopSignal:
+			return
+		case docName := <-s.dropWatcher.Ready():
+			log.Debugf("local command document %v dropped, polling", docName)
+			s.pollOnce()
+		}
+	}
+}
+
+// parsedMessage is a received message along with the document state parsed out of it
+type parsedMessage struct {
+	msg      *ssmmds.Message
+	context  context.T
+	docState *model.DocumentState
+	// err is why the document state couldn't be parsed
+	err error
+	// ignored is set for the messages failing their validation, nothing is done about them
+	ignored bool
+}
+
+// processMessage parses and handles the message, a message whose processing panics is quarantined and failed
 func (s *RunCommandService) processMessage(msg *ssmmds.Message) {
-	var (
-		docState *model.DocumentState
-		err      error
-	)
+	defer s.recoverMessagePanic(msg)
+	s.handleMessage(s.parseMessage(msg))
+}
 
+// parseMessage validates the message and parses the document state out of it, it doesn't depend on the other messages
+// received so the messages of a poll can be parsed concurrently
+func (s *RunCommandService) parseMessage(msg *ssmmds.Message) *parsedMessage {
 	// create separate logger that includes messageID with every log message
 	context := s.context.With("[messageID=" + *msg.MessageId + "]")
 	log := context.Log()
 	log.Debug("Processing message")
+	parsed := &parsedMessage{msg: msg, context: context}
 
-	if err = validate(msg); err != nil {
+	if err := validate(msg); err != nil {
 		log.Error("message not valid, ignoring: ", err)
+		parsed.ignored = true
+		return parsed
+	}
+
+	if VerifyMessage != nil {
+		if err := VerifyMessage(msg); err != nil {
+			log.Error("message failed verification, ignoring: ", err)
+			parsed.ignored = true
+			return parsed
+		}
+	}
+
+	if strings.HasPrefix(*msg.Topic, string(SendCommandTopicPrefix)) {
+		s.tracing.received(*msg.MessageId)
+		parsed.docState, parsed.err = loadDocStateFromSendCommand(context, msg, s.orchestrationRootDir)
+	} else if strings.HasPrefix(*msg.Topic, string(CancelCommandTopicPrefix)) {
+		parsed.docState, parsed.err = loadDocStateFromCancelCommand(context, msg, s.orchestrationRootDir)
+	} else {
+		parsed.err = fmt.Errorf("unexpected topic name %v", *msg.Topic)
+	}
+	return parsed
+}
+
+// handleMessage acknowledges the parsed message and hands its document over to the processor,
+// the messages of a poll are handled one after the other in the order they were received
+func (s *RunCommandService) handleMessage(parsed *parsedMessage) {
+	msg := parsed.msg
+	context := parsed.context
+	log := context.Log()
+	docState, err := parsed.docState, parsed.err
+	received, receiptFound := s.receipts.remove(*msg.MessageId)
+	if parsed.ignored {
 		return
 	}
 
 	if strings.HasPrefix(*msg.Topic, string(SendCommandTopicPrefix)) {
-		docState, err = loadDocStateFromSendCommand(context, msg, s.orchestrationRootDir)
+		if err == nil {
+			config := context.AppConfig()
+			refreshUnsupportedDocuments(log, config.Mds.UnsupportedDocumentsFile, config.Mds.UnsupportedDocumentsLimit)
+			docInfo := docState.DocumentInformation
+			if isUnsupportedDocument(docInfo.DocumentName) {
+				err = fmt.Errorf("document %v is not supported by this agent", docInfo.DocumentName)
+			} else {
+				// never let the outputs of two commands merge into the same orchestration dir
+				err = checkOrchestrationDirCollision(log, docInfo.InstanceID, docInfo.DocumentID, docInfo.CommandID, docInfo.OrchestrationDirectory)
+			}
+		}
 		if err != nil {
 			log.Error(err)
+			s.tracing.failed(*msg.MessageId, err)
 			s.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusFailed, err.Error())
 			return
 		}
-	} else if strings.HasPrefix(*msg.Topic, string(CancelCommandTopicPrefix)) {
-		docState, err = loadDocStateFromCancelCommand(context, msg, s.orchestrationRootDir)
-	} else {
-		err = fmt.Errorf("unexpected topic name %v", *msg.Topic)
+		//correlate the message with the other parts of the same command
+		if commandID := docState.DocumentInformation.CommandID; commandID != "" {
+			if related := s.correlator.add(commandID, *msg.MessageId); len(related) > 1 {
+				log.Infof("message is part of multi-part command %v, related messages %v", commandID, related)
+			}
+		}
 	}
 
 	if err != nil {
@@ -152,21 +236,42 @@ func (s *RunCommandService) processMessage(msg *ssmmds.Message) {
 		}
 		return
 	}
+	if receiptFound && isVisibilityExpired(log, context.AppConfig().Mds.MessageVisibilitySeconds, received) {
+		s.tracing.failed(*msg.MessageId, errVisibilityExpired)
+		s.correlator.remove(*msg.MessageId)
+		return
+	}
+	if BeforeAcknowledge != nil {
+		if err = BeforeAcknowledge(docState); err != nil {
+			log.Infof("ack vetoed, leaving the message for redelivery: %v", err)
+			s.tracing.failed(*msg.MessageId, err)
+			s.correlator.remove(*msg.MessageId)
+			return
+		}
+	}
 	if err = s.service.AcknowledgeMessage(log, *msg.MessageId); err != nil {
+		s.tracing.failed(*msg.MessageId, err)
 		sdkutil.HandleAwsError(log, err, s.processorStopPolicy)
 		return
 	}
 
 	log.Debugf("Ack done. Received message - messageId - %v", *msg.MessageId)
 
-	log.Debugf("Processing to send a reply to update the document status to InProgress")
+	// the documents received from the offline service run locally, there may be nobody to send InProgress to
+	if !isOfflineDocument(docState.DocumentType) || context.AppConfig().Mds.SendOfflineInProgressResponse {
+		log.Debugf("Processing to send a reply to update the document status to InProgress")
 
-	//TODO This function should be called in service when it submits the document to the engine
-	s.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusInProgress, "")
+		//TODO This function should be called in service when it submits the document to the engine
+		s.sendDocLevelResponse(*msg.MessageId, contracts.ResultStatusInProgress, "")
 
-	log.Debugf("SendReply done. Received message - messageId - %v", *msg.MessageId)
+		log.Debugf("SendReply done. Received message - messageId - %v", *msg.MessageId)
+	}
+	if config := context.AppConfig(); config.Mds.PersistRawMessages {
+		persistRawMessage(log, msg, docState, config.Ssm.OutputRedactionPatterns)
+	}
 	switch docState.DocumentType {
 	case model.SendCommand, model.SendCommandOffline:
+		s.tracing.scheduled(*msg.MessageId, docState.DocumentInformation.CommandID)
 		s.processor.Submit(*docState)
 	case model.CancelCommand, model.CancelCommandOffline:
 		s.processor.Cancel(*docState)
@@ -176,3 +281,8 @@ func (s *RunCommandService) processMessage(msg *ssmmds.Message) {
 	}
 
 }
+
+// isOfflineDocument checks if the document was received from the offline service
+func isOfflineDocument(documentType model.DocumentType) bool {
+	return documentType == model.SendCommandOffline || documentType == model.CancelCommandOffline
+}
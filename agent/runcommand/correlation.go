@@ -0,0 +1,79 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"sync"
+)
+
+// commandCorrelator groups the in-flight messages by their command id, a large command may be split across several messages
+type commandCorrelator struct {
+	messages map[string][]string
+	commands map[string]string
+	m        sync.Mutex
+}
+
+// add records the message under the given command id and returns the ids of all the messages of the command
+func (c *commandCorrelator) add(commandID, messageID string) []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.messages == nil {
+		c.messages = make(map[string][]string)
+		c.commands = make(map[string]string)
+	}
+	if _, found := c.commands[messageID]; !found {
+		c.commands[messageID] = commandID
+		c.messages[commandID] = append(c.messages[commandID], messageID)
+	}
+	return c.copyOf(commandID)
+}
+
+// remove forgets the message once it's been processed
+func (c *commandCorrelator) remove(messageID string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	commandID, found := c.commands[messageID]
+	if !found {
+		return
+	}
+	delete(c.commands, messageID)
+	messageIDs := c.messages[commandID]
+	for i, id := range messageIDs {
+		if id == messageID {
+			messageIDs = append(messageIDs[:i], messageIDs[i+1:]...)
+			break
+		}
+	}
+	if len(messageIDs) == 0 {
+		delete(c.messages, commandID)
+		return
+	}
+	c.messages[commandID] = messageIDs
+}
+
+// lookup returns the ids of the in-flight messages of the given command
+func (c *commandCorrelator) lookup(commandID string) []string {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.copyOf(commandID)
+}
+
+// copyOf returns a copy of the message ids of the command, the caller must hold the lock
+func (c *commandCorrelator) copyOf(commandID string) []string {
+	if len(c.messages[commandID]) == 0 {
+		return nil
+	}
+	return append([]string(nil), c.messages[commandID]...)
+}
@@ -0,0 +1,158 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package service is a wrapper for the SSM Message Delivery Service and Offline Command Service
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// DropFolderWatcher watches the local command folder and notifies the name of each document dropped into it
+// once the document is completely written, i.e. once its ready marker appears or its size stops changing for the settle duration.
+type DropFolderWatcher struct {
+	log     log.T
+	folder  string
+	settle  time.Duration
+	watcher *fsnotify.Watcher
+	ready   chan string
+	stop    chan bool
+	once    sync.Once
+	// tracked holds the documents waiting to be completely written, with the channel waking them up on a new event
+	tracked map[string]chan bool
+	m       sync.Mutex
+}
+
+// NewDropFolderWatcher starts watching the folder, it must be closed once no longer needed
+func NewDropFolderWatcher(log log.T, folder string, settle time.Duration) (*DropFolderWatcher, error) {
+	if err := fileutil.MakeDirs(folder); err != nil {
+		return nil, err
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	if err = watcher.Add(folder); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	w := &DropFolderWatcher{
+		log:     log,
+		folder:  folder,
+		settle:  settle,
+		watcher: watcher,
+		ready:   make(chan string),
+		stop:    make(chan bool),
+		tracked: make(map[string]chan bool),
+	}
+	go w.watch()
+	return w, nil
+}
+
+// Ready returns the channel the names of the completely written documents are sent to
+func (w *DropFolderWatcher) Ready() <-chan string {
+	return w.ready
+}
+
+// Close stops watching the folder
+func (w *DropFolderWatcher) Close() {
+	w.once.Do(func() {
+		close(w.stop)
+		w.watcher.Close()
+	})
+}
+
+// watch tracks the documents created or written in the folder
+func (w *DropFolderWatcher) watch() {
+	for {
+		select {
+		case <-w.stop:
+			return
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if event.Op&(fsnotify.Create|fsnotify.Write) == 0 {
+				continue
+			}
+			w.track(strings.TrimSuffix(filepath.Base(event.Name), ReadyMarkerSuffix))
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			w.log.Errorf("error watching the local command folder %v: %v", w.folder, err)
+		}
+	}
+}
+
+// track notifies the document once it's completely written, a document already tracked is checked again
+func (w *DropFolderWatcher) track(docName string) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if wake, found := w.tracked[docName]; found {
+		select {
+		case wake <- true:
+		default:
+		}
+		return
+	}
+	wake := make(chan bool, 1)
+	w.tracked[docName] = wake
+	go func() {
+		defer func() {
+			w.m.Lock()
+			defer w.m.Unlock()
+			delete(w.tracked, docName)
+		}()
+		if !w.waitUntilWritten(filepath.Join(w.folder, docName), wake) {
+			return
+		}
+		w.log.Debugf("local command document %v is ready", docName)
+		select {
+		case w.ready <- docName:
+		case <-w.stop:
+		}
+	}()
+}
+
+// waitUntilWritten waits for the ready marker of the document or for its size to stop changing,
+// returns false if the document is gone, isn't a file, or the watcher is closed
+func (w *DropFolderWatcher) waitUntilWritten(docPath string, wake <-chan bool) bool {
+	lastSize := int64(-1)
+	for {
+		info, err := os.Stat(docPath)
+		if err != nil || info.IsDir() {
+			return false
+		}
+		if fileutil.Exists(docPath+ReadyMarkerSuffix) || info.Size() == lastSize {
+			return true
+		}
+		lastSize = info.Size()
+		select {
+		case <-w.stop:
+			return false
+		case <-wake:
+			// the marker may have been created, the size is compared again after a full settle interval
+			lastSize = -1
+		case <-time.After(w.settle):
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package service is a wrapper for the SSM Message Delivery Service and Offline Command Service
+package service
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDropFolderWatcherNotifiesWrittenDocument(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dropwatcher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(folder)
+
+	watcher, err := NewDropFolderWatcher(logger, folder, 50*time.Millisecond)
+	assert.Nil(t, err)
+	defer watcher.Close()
+
+	docPath := filepath.Join(folder, "command.json")
+	assert.Nil(t, fileutil.WriteAllText(docPath, "{"))
+
+	select {
+	case docName := <-watcher.Ready():
+		assert.Equal(t, "command.json", docName)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "written document not notified")
+	}
+}
+
+func TestDropFolderWatcherNotifiesDocumentWithReadyMarker(t *testing.T) {
+	folder, err := ioutil.TempDir("", "dropwatcher")
+	assert.Nil(t, err)
+	defer os.RemoveAll(folder)
+
+	// the settle duration is long enough that only the ready marker can trigger the notification
+	watcher, err := NewDropFolderWatcher(logger, folder, time.Hour)
+	assert.Nil(t, err)
+	defer watcher.Close()
+
+	docPath := filepath.Join(folder, "command.json")
+	assert.Nil(t, fileutil.WriteAllText(docPath, "{}"))
+	assert.Nil(t, fileutil.WriteAllText(docPath+ReadyMarkerSuffix, ""))
+
+	select {
+	case docName := <-watcher.Ready():
+		assert.Equal(t, "command.json", docName)
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "document with ready marker not notified")
+	}
+}
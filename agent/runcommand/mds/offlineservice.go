@@ -16,8 +16,10 @@ package service
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 	"strings"
+	"sync"
 	"time"
 
 	"errors"
@@ -33,32 +35,55 @@ import (
 	"github.com/twinj/uuid"
 )
 
+const (
+	// ReadyMarkerSuffix is appended to the name of a document dropped into the local command folder to mark it completely written
+	ReadyMarkerSuffix = ".ready"
+	// submittedFolderName and invalidFolderName are the folders of a custom drop folder the documents are moved to once picked up
+	submittedFolderName = "submitted"
+	invalidFolderName   = "invalid"
+)
+
 type offlineService struct {
 	TopicPrefix         string
 	newCommandDir       string
 	submittedCommandDir string
 	invalidCommandDir   string
+	// settle is how long a document must go unmodified before it's picked up, unless its ready marker is present
+	settle time.Duration
+	m      sync.Mutex
 }
 
-// NewOfflineService initializes a service that looks for work in a local command folder
-func NewOfflineService(log log.T, topicPrefix string) (Service, error) {
+// NewOfflineService initializes a service that looks for work in a local command folder, the default one if dropFolder is empty.
+// A document is picked up once its ready marker is present or it went unmodified for the settle duration, so that a document
+// still being written is never parsed.
+func NewOfflineService(log log.T, topicPrefix, dropFolder string, settle time.Duration) (Service, error) {
 	uuid.SwitchFormat(uuid.CleanHyphen)
+	newCommandDir, submittedCommandDir, invalidCommandDir := appconfig.LocalCommandRoot, appconfig.LocalCommandRootSubmitted, appconfig.LocalCommandRootInvalid
+	if dropFolder != "" {
+		newCommandDir = dropFolder
+		submittedCommandDir = filepath.Join(dropFolder, submittedFolderName)
+		invalidCommandDir = filepath.Join(dropFolder, invalidFolderName)
+	}
 	// Create and harden local document folder if needed
-	err := fileutil.MakeDirs(appconfig.LocalCommandRoot)
+	err := fileutil.MakeDirs(newCommandDir)
 	if err != nil {
-		log.Errorf("Failed to create local command directory %v : %v", appconfig.LocalCommandRoot, err.Error())
+		log.Errorf("Failed to create local command directory %v : %v", newCommandDir, err.Error())
 		return nil, err
 	}
 	return &offlineService{
 		TopicPrefix:         topicPrefix,
-		newCommandDir:       appconfig.LocalCommandRoot,
-		submittedCommandDir: appconfig.LocalCommandRootSubmitted,
-		invalidCommandDir:   appconfig.LocalCommandRootInvalid,
+		newCommandDir:       newCommandDir,
+		submittedCommandDir: submittedCommandDir,
+		invalidCommandDir:   invalidCommandDir,
+		settle:              settle,
 	}, nil
 }
 
 // GetMessages looks for new local command documents on the filesystem and parses them into messages
 func (ols *offlineService) GetMessages(log log.T, instanceID string) (messages *ssmmds.GetMessagesOutput, err error) {
+	// the poller and the drop folder watcher must not pick up the same document twice
+	ols.m.Lock()
+	defer ols.m.Unlock()
 	messages = &ssmmds.GetMessagesOutput{}
 
 	// Look for unprocessed locally submitted documents
@@ -72,6 +97,9 @@ func (ols *offlineService) GetMessages(log log.T, instanceID string) (messages *
 	for _, filename := range filenames {
 		docName = filename
 		docPath = filepath.Join(ols.newCommandDir, docName)
+		if strings.HasSuffix(docName, ReadyMarkerSuffix) || !isDocumentWritten(docPath, ols.settle) {
+			continue
+		}
 		log.Debugf("Found local command document %v | %v", docName, docPath)
 
 		requestUuid := uuid.NewV4().String()
@@ -125,6 +153,16 @@ func (ols *offlineService) GetMessages(log log.T, instanceID string) (messages *
 	return messages, nil
 }
 
+// isDocumentWritten checks if the document is completely written, i.e. its ready marker is present
+// or it went unmodified for the settle duration
+func isDocumentWritten(docPath string, settle time.Duration) bool {
+	if fileutil.Exists(docPath + ReadyMarkerSuffix) {
+		return true
+	}
+	info, err := os.Stat(docPath)
+	return err == nil && time.Since(info.ModTime()) >= settle
+}
+
 // TODO:MF: clean up old documents in dstDir?  Or maybe do that in SendReply?  Maybe both
 // moveCommandDocument moves a command into its final destination and attaches the command ID file extension
 func moveCommandDocument(srcDir string, dstDir string, docName string, commandID string) error {
@@ -135,6 +173,10 @@ func moveCommandDocument(srcDir string, dstDir string, docName string, commandID
 		return err
 	}
 	newName := strings.Join([]string{docName, commandID}, ".")
+	// the ready marker of the document is no longer needed once it's picked up
+	if marker := filepath.Join(srcDir, docName+ReadyMarkerSuffix); fileutil.Exists(marker) {
+		fileutil.DeleteFile(marker)
+	}
 	if success, err := fileutil.MoveAndRenameFile(srcDir, docName, dstDir, newName); !success {
 		// Clean up submitted document if we failed to move it (we don't want to keep trying to process it)
 		defer fileutil.DeleteFile(filepath.Join(srcDir, docName))
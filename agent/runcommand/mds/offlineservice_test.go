@@ -17,6 +17,7 @@ package service
 import (
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
 	"github.com/aws/amazon-ssm-agent/agent/log"
@@ -79,6 +80,31 @@ func TestBothVersions(t *testing.T) {
 	assert.Equal(t, 2, FileCount(submittedCommands))
 }
 
+func TestUnsettledDocumentWaitsForReadyMarker(t *testing.T) {
+	service := GetTestService()
+	service.(*offlineService).settle = time.Hour
+
+	defer CleanTestDirs()
+	err := SubmitTestDoc("validcommand20.json")
+	assert.Nil(t, err)
+
+	messages, err := service.GetMessages(logger, "i-bar")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(messages.Messages))
+	assert.Equal(t, 1, FileCount(newCommands))
+
+	err = fileutil.WriteAllText(filepath.Join(newCommands, "validcommand20.json"+ReadyMarkerSuffix), "")
+	assert.Nil(t, err)
+
+	messages, err = service.GetMessages(logger, "i-bar")
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(messages.Messages))
+	assert.Equal(t, 0, FileCount(newCommands))
+	assert.Equal(t, 1, FileCount(submittedCommands))
+}
+
 func GetTestService() Service {
 	CleanTestDirs()
 	return &offlineService{
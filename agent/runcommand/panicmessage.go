@@ -0,0 +1,75 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"fmt"
+	"runtime/debug"
+
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	mdsService "github.com/aws/amazon-ssm-agent/agent/runcommand/mds"
+	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+)
+
+// quarantinePanicMessage is assigned to a variable to allow unittest to override
+var quarantinePanicMessage = docmanager.QuarantinePanicMessage
+
+// recoverMessagePanic is deferred around the processing of a message: if the processing panics, the scrubbed message
+// is quarantined along with the panic and its stack, and the message is failed
+func (s *RunCommandService) recoverMessagePanic(msg *ssmmds.Message) {
+	recovered := recover()
+	if recovered == nil {
+		return
+	}
+	stack := string(debug.Stack())
+	log := s.context.Log()
+	if msg == nil {
+		log.Errorf("processing of a nil message panicked: %v\n%v", recovered, stack)
+		return
+	}
+	var messageID string
+	if msg.MessageId != nil {
+		messageID = *msg.MessageId
+	}
+	log.Errorf("processing of message %v panicked: %v\n%v", messageID, recovered, stack)
+
+	panicMessage := docmanager.PanicMessage{MessageID: messageID, Panic: fmt.Sprint(recovered), Stack: stack}
+	if content, err := jsonutil.Marshal(scrubMessage(log, msg, s.context.AppConfig().Ssm.OutputRedactionPatterns)); err != nil {
+		log.Errorf("failed to marshal the message %v that panicked: %v", messageID, err)
+	} else {
+		panicMessage.Message = []byte(content)
+	}
+	if _, err := quarantinePanicMessage(log, s.config.InstanceID, panicMessage); err != nil {
+		log.Errorf("failed to quarantine the message %v that panicked: %v", messageID, err)
+	}
+
+	if messageID == "" {
+		return
+	}
+	s.tracing.failed(messageID, fmt.Errorf("processing panicked: %v", recovered))
+	s.correlator.remove(messageID)
+	if err := s.service.FailMessage(log, messageID, mdsService.InternalHandlerException); err != nil {
+		sdkutil.HandleAwsError(log, err, s.processorStopPolicy)
+	}
+}
+
+// parseMessageOrQuarantine parses the message, a message whose parsing panics is quarantined, failed and ignored from then on
+func (s *RunCommandService) parseMessageOrQuarantine(msg *ssmmds.Message) (parsed *parsedMessage) {
+	parsed = &parsedMessage{msg: msg, context: s.context, ignored: true}
+	defer s.recoverMessagePanic(msg)
+	return parseMessage(s, msg)
+}
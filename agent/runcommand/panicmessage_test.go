@@ -0,0 +1,88 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+func TestProcessMessageQuarantinesMessageWhoseParsingPanics(t *testing.T) {
+	var quarantined []docmanager.PanicMessage
+	quarantinePanicMessage = func(log log.T, instanceID string, panicMessage docmanager.PanicMessage) (string, error) {
+		assert.Equal(t, testDestination, instanceID)
+		quarantined = append(quarantined, panicMessage)
+		return "", nil
+	}
+	defer func() { quarantinePanicMessage = docmanager.QuarantinePanicMessage }()
+	defer func() { loadDocStateFromSendCommand = parseSendCommandMessage }()
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(appconfig.DefaultConfig())
+	ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+	svc.context = ctx
+	payload := `{"Parameters":{"commands":["echo hello"],"Password":"hunter2"}}`
+	tc.Message.Payload = &payload
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		panic("malformed payload")
+	}
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+
+	assert.NotPanics(t, func() { svc.processMessage(&tc.Message) })
+
+	tc.MdsMock.AssertExpectations(t)
+	tc.ProcessMock.AssertNotCalled(t, "Submit", mock.Anything)
+	if assert.Len(t, quarantined, 1) {
+		assert.Equal(t, *tc.Message.MessageId, quarantined[0].MessageID)
+		assert.Equal(t, "malformed payload", quarantined[0].Panic)
+		assert.Contains(t, quarantined[0].Stack, "TestProcessMessageQuarantinesMessageWhoseParsingPanics")
+		var rawMessage ssmmds.Message
+		assert.NoError(t, json.Unmarshal(quarantined[0].Message, &rawMessage))
+		assert.Equal(t, *tc.Message.MessageId, *rawMessage.MessageId)
+		assert.NotContains(t, *rawMessage.Payload, "hunter2")
+	}
+}
+
+func TestProcessMessagesConcurrentlyQuarantinesMessageWhoseParsingPanics(t *testing.T) {
+	var quarantined []docmanager.PanicMessage
+	quarantinePanicMessage = func(log log.T, instanceID string, panicMessage docmanager.PanicMessage) (string, error) {
+		quarantined = append(quarantined, panicMessage)
+		return "", nil
+	}
+	defer func() { quarantinePanicMessage = docmanager.QuarantinePanicMessage }()
+	defer func() { loadDocStateFromSendCommand = parseSendCommandMessage }()
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		panic("malformed payload")
+	}
+	tc.MdsMock.On("FailMessage", mock.Anything, *tc.Message.MessageId, mock.Anything).Return(nil)
+
+	assert.NotPanics(t, func() { svc.processMessagesConcurrently([]*ssmmds.Message{&tc.Message}, 2) })
+
+	tc.MdsMock.AssertNumberOfCalls(t, "FailMessage", 1)
+	if assert.Len(t, quarantined, 1) {
+		assert.Equal(t, "malformed payload", quarantined[0].Panic)
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/pluginutil"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+)
+
+// persistRawMessageContent is assigned to a variable to allow unittest to override
+var persistRawMessageContent = docmanager.PersistRawMessage
+
+// scrubMessage returns a copy of the message whose payload has the matches of the output redaction patterns
+// that apply to every plugin replaced
+func scrubMessage(log log.T, msg *ssmmds.Message, redactionPatterns []appconfig.OutputRedactionPattern) ssmmds.Message {
+	scrubbed := *msg
+	if msg.Payload != nil {
+		payload, _ := pluginutil.Redact(*msg.Payload, pluginutil.RedactionPatterns(log, redactionPatterns, ""))
+		scrubbed.Payload = &payload
+	}
+	return scrubbed
+}
+
+// persistRawMessage keeps the scrubbed message the document was received in next to its document state,
+// the document is processed even if the message can't be persisted
+func persistRawMessage(log log.T, msg *ssmmds.Message, docState *model.DocumentState, redactionPatterns []appconfig.OutputRedactionPattern) {
+	docInfo := docState.DocumentInformation
+	content, err := jsonutil.Marshal(scrubMessage(log, msg, redactionPatterns))
+	if err != nil {
+		log.Errorf("failed to marshal the message of command %v: %v", docInfo.CommandID, err)
+		return
+	}
+	if err = persistRawMessageContent(log, docInfo.CommandID, docInfo.InstanceID, []byte(content)); err != nil {
+		log.Errorf("failed to persist the message of command %v: %v", docInfo.CommandID, err)
+	}
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// processTestRawMessage processes a send command message with the raw messages persisted as configured,
+// returns the messages persisted by command id
+func processTestRawMessage(t *testing.T, persistRawMessages bool) (ssmmds.Message, map[string][]byte) {
+	persisted := make(map[string][]byte)
+	persistRawMessageContent = func(log log.T, commandID, instanceID string, content []byte) error {
+		persisted[commandID] = content
+		return nil
+	}
+	defer func() { persistRawMessageContent = docmanager.PersistRawMessage }()
+	config := appconfig.DefaultConfig()
+	config.Mds.PersistRawMessages = persistRawMessages
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+	fakeDocState := model.DocumentState{DocumentType: model.SendCommand}
+	fakeDocState.DocumentInformation.CommandID = "commandID"
+	fakeDocState.DocumentInformation.InstanceID = testDestination
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	svc.context = ctx
+	payload := `{"Parameters":{"commands":["echo hello"],"Password":"hunter2"}}`
+	tc.Message.Payload = &payload
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	loadDocStateFromSendCommand = func(context context.T,
+		msg *ssmmds.Message,
+		messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+	tc.ProcessMock.On("Submit", fakeDocState).Return(nil)
+
+	svc.processMessage(&tc.Message)
+
+	tc.ProcessMock.AssertExpectations(t)
+	return tc.Message, persisted
+}
+
+func TestProcessMessagePersistsScrubbedRawMessage(t *testing.T) {
+	message, persisted := processTestRawMessage(t, true)
+
+	content, found := persisted["commandID"]
+	assert.True(t, found)
+	var rawMessage ssmmds.Message
+	assert.NoError(t, json.Unmarshal(content, &rawMessage))
+	assert.Equal(t, *message.MessageId, *rawMessage.MessageId)
+	assert.Equal(t, *message.Topic, *rawMessage.Topic)
+	assert.NotContains(t, *rawMessage.Payload, "hunter2")
+	assert.Contains(t, *rawMessage.Payload, `"****"`)
+	// the message processed is left as received
+	assert.Contains(t, *message.Payload, "hunter2")
+}
+
+func TestProcessMessageRawMessageNotPersistedByDefault(t *testing.T) {
+	_, persisted := processTestRawMessage(t, false)
+
+	assert.Empty(t, persisted)
+}
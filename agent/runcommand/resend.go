@@ -0,0 +1,49 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
+)
+
+// Assign the document state helpers to global variables to allow unittest to override
+var markResultUnacknowledged = docmanager.MarkResultUnacknowledged
+var markResultAcknowledged = docmanager.MarkResultAcknowledged
+var unacknowledgedDocuments = docmanager.UnacknowledgedDocuments
+var getFinalResult = docmanager.GetFinalResult
+
+// resendUnacknowledgedResults sends again the final results of the documents completed while MDS was unreachable,
+// a result MDS still doesn't accept is sent again on the next reconnection
+func (s *RunCommandService) resendUnacknowledgedResults() {
+	log := s.context.Log()
+	instanceID := s.config.InstanceID
+	for _, documentID := range unacknowledgedDocuments(log, instanceID) {
+		res := getFinalResult(log, documentID, instanceID)
+		if res.MessageID == "" {
+			log.Errorf("the final result of document %v can't be rebuilt, skip sending it", documentID)
+			continue
+		}
+		log.Infof("sending the result of %v completed while offline", res.MessageID)
+		payload := FormatPayload(log, "", s.config.AgentInfo, res.PluginResults)
+		if err := processSendReply(log, res.MessageID, s.service, payload, s.processorStopPolicy); err != nil {
+			log.Errorf("failed to send the result of %v, it will be sent on the next reconnection: %v", res.MessageID, err)
+			s.resendResults = true
+			return
+		}
+		if err := markResultAcknowledged(log, documentID, instanceID); err != nil {
+			log.Errorf("failed to record the acknowledged result of %v: %v", res.MessageID, err)
+		}
+	}
+}
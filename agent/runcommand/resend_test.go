@@ -0,0 +1,106 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// setUnacknowledgedTestDocuments replaces the document state helpers with ones serving the given final results
+// and recording the acknowledged documents
+func setUnacknowledgedTestDocuments(results map[string]contracts.DocumentResult, acknowledged *[]string) func() {
+	origUnacknowledgedDocuments, origGetFinalResult, origMarkResultAcknowledged := unacknowledgedDocuments, getFinalResult, markResultAcknowledged
+	unacknowledgedDocuments = func(log log.T, instanceID string) (documentIDs []string) {
+		for documentID := range results {
+			documentIDs = append(documentIDs, documentID)
+		}
+		return
+	}
+	getFinalResult = func(log log.T, documentID, instanceID string) contracts.DocumentResult {
+		return results[documentID]
+	}
+	markResultAcknowledged = func(log log.T, documentID, instanceID string) error {
+		*acknowledged = append(*acknowledged, documentID)
+		delete(results, documentID)
+		return nil
+	}
+	return func() {
+		unacknowledgedDocuments, getFinalResult, markResultAcknowledged = origUnacknowledgedDocuments, origGetFinalResult, origMarkResultAcknowledged
+	}
+}
+
+// TestPollOnceResendsUnacknowledgedResultsOnReconnect tests the results of the documents completed while MDS was unreachable are sent once it's back
+func TestPollOnceResendsUnacknowledgedResultsOnReconnect(t *testing.T) {
+	proc, tc := prepareTestPollOnce()
+	proc.name = mdsName
+	var acknowledged []string
+	results := map[string]contracts.DocumentResult{
+		"command1": {MessageID: "aws.ssm.command1.i-400e1090", Status: contracts.ResultStatusSuccess},
+		"command2": {MessageID: "aws.ssm.command2.i-400e1090", Status: contracts.ResultStatusFailed},
+	}
+	defer setUnacknowledgedTestDocuments(results, &acknowledged)()
+	processMessage = func(svc *RunCommandService, msg *ssmmds.Message) {}
+	noMessages := ssmmds.GetMessagesOutput{
+		Destination:       &testDestination,
+		Messages:          make([]*ssmmds.Message, 0),
+		MessagesRequestId: &testMessageId,
+	}
+
+	// MDS is unreachable, nothing is sent
+	tc.MdsMock.On("GetMessages", mock.AnythingOfType("*log.Mock"), mock.AnythingOfType("string")).Return(&noMessages, fmt.Errorf("unreachable")).Once()
+	proc.pollOnce()
+	assert.True(t, proc.resendResults)
+	tc.MdsMock.AssertNotCalled(t, "SendReply", mock.Anything, mock.Anything, mock.Anything)
+
+	// MDS is back, the results are sent and acknowledged
+	tc.MdsMock.On("GetMessages", mock.AnythingOfType("*log.Mock"), mock.AnythingOfType("string")).Return(&noMessages, nil)
+	tc.MdsMock.On("SendReply", mock.AnythingOfType("*log.Mock"), "aws.ssm.command1.i-400e1090", mock.AnythingOfType("string")).Return(nil).Once()
+	tc.MdsMock.On("SendReply", mock.AnythingOfType("*log.Mock"), "aws.ssm.command2.i-400e1090", mock.AnythingOfType("string")).Return(nil).Once()
+	proc.pollOnce()
+
+	tc.MdsMock.AssertExpectations(t)
+	assert.False(t, proc.resendResults)
+	assert.Len(t, acknowledged, 2)
+	assert.Empty(t, results)
+
+	// nothing is sent again on the next poll
+	proc.pollOnce()
+	tc.MdsMock.AssertNumberOfCalls(t, "SendReply", 2)
+}
+
+// TestResendUnacknowledgedResultsRetriesOnFailure tests a result MDS doesn't accept stays unacknowledged and is sent on the next reconnection
+func TestResendUnacknowledgedResultsRetriesOnFailure(t *testing.T) {
+	proc, tc := prepareTestPollOnce()
+	proc.name = mdsName
+	var acknowledged []string
+	results := map[string]contracts.DocumentResult{
+		"command1": {MessageID: "aws.ssm.command1.i-400e1090", Status: contracts.ResultStatusSuccess},
+	}
+	defer setUnacknowledgedTestDocuments(results, &acknowledged)()
+	tc.MdsMock.On("SendReply", mock.AnythingOfType("*log.Mock"), "aws.ssm.command1.i-400e1090", mock.AnythingOfType("string")).Return(fmt.Errorf("unreachable"))
+
+	proc.resendUnacknowledgedResults()
+
+	tc.MdsMock.AssertExpectations(t)
+	assert.Empty(t, acknowledged)
+	assert.True(t, proc.resendResults)
+}
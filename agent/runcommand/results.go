@@ -0,0 +1,84 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/plugins/pluginutil"
+)
+
+// redactResult returns a copy of the document result whose plugin outputs have the matches of the output redaction patterns
+// of their plugins replaced
+func redactResult(log log.T, res contracts.DocumentResult, redactionPatterns []appconfig.OutputRedactionPattern) contracts.DocumentResult {
+	pluginResults := make(map[string]*contracts.PluginResult, len(res.PluginResults))
+	for pluginID, pluginResult := range res.PluginResults {
+		if pluginResult == nil {
+			continue
+		}
+		redacted := *pluginResult
+		patterns := pluginutil.RedactionPatterns(log, redactionPatterns, redacted.PluginName)
+		if output, ok := redacted.Output.(string); ok {
+			redacted.Output, _ = pluginutil.Redact(output, patterns)
+		}
+		redacted.StandardOutput, _ = pluginutil.Redact(redacted.StandardOutput, patterns)
+		redacted.StandardError, _ = pluginutil.Redact(redacted.StandardError, patterns)
+		pluginResults[pluginID] = &redacted
+	}
+	res.PluginResults = pluginResults
+	return res
+}
+
+// localResultFileName returns the path of the local result of the command the message belongs to
+func localResultFileName(resultsDir, messageID string) string {
+	commandID := messageID
+	if strings.Count(messageID, ".") > 0 {
+		commandID = getCommandID(messageID)
+	}
+	return filepath.Join(resultsDir, commandID+".json")
+}
+
+// writeLocalResult writes the redacted final result of the document to <resultsDir>/<commandID>.json for the local tools to pick up,
+// the file is written through an intermediate file so that it's never read half written
+func writeLocalResult(log log.T, resultsDir string, redactionPatterns []appconfig.OutputRedactionPattern, res contracts.DocumentResult) {
+	if err := fileutil.MakeDirs(resultsDir); err != nil {
+		log.Errorf("failed to create the local results folder %v: %v", resultsDir, err)
+		return
+	}
+	content, err := jsonutil.MarshalIndent(redactResult(log, res, redactionPatterns))
+	if err != nil {
+		log.Errorf("failed to marshal the result of %v: %v", res.MessageID, err)
+		return
+	}
+	fileName := localResultFileName(resultsDir, res.MessageID)
+	intermediateFileName := fileName + ".tmp"
+	if _, err = fileutil.WriteIntoFileWithPermissions(intermediateFileName, content, os.FileMode(int(appconfig.ReadWriteAccess))); err != nil {
+		log.Errorf("failed to write the local result %v: %v", fileName, err)
+		return
+	}
+	if err = os.Rename(intermediateFileName, fileName); err != nil {
+		log.Errorf("failed to write the local result %v: %v", fileName, err)
+		os.Remove(intermediateFileName)
+		return
+	}
+	log.Debugf("wrote the result of %v to %v", res.MessageID, fileName)
+}
@@ -20,6 +20,7 @@ import (
 	"time"
 
 	"github.com/aws/amazon-ssm-agent/agent/sdkutil"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
 	"github.com/carlescere/scheduler"
 )
 
@@ -27,6 +28,8 @@ var lastPollTimeMap map[string]time.Time = make(map[string]time.Time)
 var lock sync.RWMutex
 
 var processMessage = (*RunCommandService).processMessage
+var parseMessage = (*RunCommandService).parseMessage
+var handleMessage = (*RunCommandService).handleMessage
 
 func updateLastPollTime(processorType string, currentTime time.Time) {
 	lock.Lock()
@@ -84,6 +87,31 @@ func (s *RunCommandService) loop() {
 	}
 }
 
+// processMessagesConcurrently parses up to workers messages at once, each message is then handled as soon as
+// the messages received before it are, so that they're still acknowledged and submitted in order
+func (s *RunCommandService) processMessagesConcurrently(messages []*ssmmds.Message, workers int) {
+	parsed := make([]chan *parsedMessage, len(messages))
+	for i := range messages {
+		parsed[i] = make(chan *parsedMessage, 1)
+	}
+	go func() {
+		workerSlots := make(chan struct{}, workers)
+		for i, msg := range messages {
+			workerSlots <- struct{}{}
+			go func(msg *ssmmds.Message, result chan *parsedMessage) {
+				defer func() { <-workerSlots }()
+				result <- s.parseMessageOrQuarantine(msg)
+			}(msg, parsed[i])
+		}
+	}()
+	for i, result := range parsed {
+		func() {
+			defer s.recoverMessagePanic(messages[i])
+			handleMessage(s, <-result)
+		}()
+	}
+}
+
 var scheduleNextRun = func(j *scheduler.Job) {
 	j.SkipWait <- true
 }
@@ -117,6 +145,10 @@ func (s *RunCommandService) stop() {
 		s.messagePollJob.Quit <- true
 	}
 
+	if s.dropWatcher != nil {
+		s.dropWatcher.Close()
+	}
+
 	if s.assocProcessor != nil {
 		s.assocProcessor.Stop()
 	}
@@ -142,14 +174,31 @@ func (s *RunCommandService) pollOnce() {
 	messages, err := s.service.GetMessages(log, s.config.InstanceID)
 	if err != nil {
 		sdkutil.HandleAwsError(log, err, s.processorStopPolicy)
+		if s.name == mdsName {
+			s.resendResults = true
+		}
 		return
 	}
+	if s.resendResults {
+		s.resendResults = false
+		s.resendUnacknowledgedResults()
+	}
 	if len(messages.Messages) > 0 {
 		log.Debugf("Got %v messages", len(messages.Messages))
 	}
 
+	received := clock.Now()
 	for _, msg := range messages.Messages {
-		processMessage(s, msg)
+		if msg != nil && msg.MessageId != nil {
+			s.receipts.add(*msg.MessageId, received)
+		}
+	}
+	if workers := s.context.AppConfig().Mds.MessageParseWorkers; workers > 1 && len(messages.Messages) > 1 {
+		s.processMessagesConcurrently(messages.Messages, workers)
+	} else {
+		for _, msg := range messages.Messages {
+			processMessage(s, msg)
+		}
 	}
 	if s.name == mdsName {
 		log.Debugf("Done poll once")
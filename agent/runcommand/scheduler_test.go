@@ -16,10 +16,19 @@ package runcommand
 
 import (
 	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	mdsService "github.com/aws/amazon-ssm-agent/agent/runcommand/mds"
 	"github.com/aws/amazon-ssm-agent/agent/runcommand/mock"
 	"github.com/aws/aws-sdk-go/service/ssmmds"
 	"github.com/stretchr/testify/assert"
@@ -175,3 +184,104 @@ func TestPollOnceWithGetMessagesReturnError(t *testing.T) {
 	tc.MdsMock.AssertExpectations(t)
 	assert.False(t, isMessageProcessed)
 }
+
+// TestListenDropFolderPollsDroppedDocument tests a document dropped into the watched folder is polled without waiting for the poll job
+func TestListenDropFolderPollsDroppedDocument(t *testing.T) {
+	// prepare test case fields
+	proc, tc := prepareTestPollOnce()
+
+	folder, err := ioutil.TempDir("", "dropfolder")
+	assert.Nil(t, err)
+	defer os.RemoveAll(folder)
+
+	proc.dropWatcher, err = mdsService.NewDropFolderWatcher(tc.ContextMock.Log(), folder, 10*time.Millisecond)
+	assert.Nil(t, err)
+	defer proc.dropWatcher.Close()
+	proc.stopSignal = make(chan bool)
+	defer close(proc.stopSignal)
+
+	getMessageOutput := ssmmds.GetMessagesOutput{
+		Destination:       &testDestination,
+		Messages:          make([]*ssmmds.Message, 1),
+		MessagesRequestId: &testMessageId,
+	}
+	tc.MdsMock.On("GetMessages", mock.AnythingOfType("*log.Mock"), mock.AnythingOfType("string")).Return(&getMessageOutput, nil)
+	processed := make(chan bool, 1)
+	processMessage = func(svc *RunCommandService, msg *ssmmds.Message) {
+		processed <- true
+	}
+
+	go proc.listenDropFolder()
+	assert.Nil(t, fileutil.WriteAllText(filepath.Join(folder, "command.json"), "{}"))
+
+	select {
+	case <-processed:
+	case <-time.After(5 * time.Second):
+		assert.Fail(t, "dropped document not polled")
+	}
+	tc.MdsMock.AssertExpectations(t)
+}
+
+// TestPollOnceParsesMessagesConcurrently tests the messages of a poll are parsed concurrently and handled in order
+func TestPollOnceParsesMessagesConcurrently(t *testing.T) {
+	defer func() {
+		parseMessage = (*RunCommandService).parseMessage
+		handleMessage = (*RunCommandService).handleMessage
+	}()
+	proc, tc := prepareTestPollOnce()
+	config := appconfig.DefaultConfig()
+	config.Mds.MessageParseWorkers = 4
+	contextMock := new(context.Mock)
+	contextMock.On("Log").Return(log.NewMockLog())
+	contextMock.On("AppConfig").Return(config)
+	proc.context = contextMock
+
+	messageCount := 8
+	parseDuration := 50 * time.Millisecond
+	getMessageOutput := ssmmds.GetMessagesOutput{
+		Destination:       &testDestination,
+		MessagesRequestId: &testMessageId,
+	}
+	// the later messages are parsed faster, they still have to wait for the earlier ones to be handled
+	parseDurations := make(map[string]time.Duration)
+	for i := 0; i < messageCount; i++ {
+		messageID := fmt.Sprintf("aws.ssm.command%v.%v", i, testDestination)
+		getMessageOutput.Messages = append(getMessageOutput.Messages, &ssmmds.Message{MessageId: &messageID})
+		parseDurations[messageID] = parseDuration - time.Duration(i)*5*time.Millisecond
+	}
+	tc.MdsMock.On("GetMessages", mock.AnythingOfType("*log.Mock"), mock.AnythingOfType("string")).Return(&getMessageOutput, nil)
+
+	var m sync.Mutex
+	parsing, maxParsing := 0, 0
+	parseMessage = func(svc *RunCommandService, msg *ssmmds.Message) *parsedMessage {
+		m.Lock()
+		parsing++
+		if parsing > maxParsing {
+			maxParsing = parsing
+		}
+		m.Unlock()
+		time.Sleep(parseDurations[*msg.MessageId])
+		m.Lock()
+		parsing--
+		m.Unlock()
+		return &parsedMessage{msg: msg}
+	}
+	var handled []string
+	handleMessage = func(svc *RunCommandService, parsed *parsedMessage) {
+		handled = append(handled, *parsed.msg.MessageId)
+	}
+
+	start := time.Now()
+	proc.pollOnce()
+	elapsed := time.Since(start)
+
+	tc.MdsMock.AssertExpectations(t)
+	for i, message := range getMessageOutput.Messages {
+		if assert.True(t, i < len(handled)) {
+			assert.Equal(t, *message.MessageId, handled[i])
+		}
+	}
+	assert.Equal(t, 4, maxParsing)
+	// parsing one message after the other would take messageCount parse durations
+	assert.True(t, elapsed < time.Duration(messageCount)*parseDuration/2, "parsing took %v", elapsed)
+}
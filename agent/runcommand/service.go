@@ -52,8 +52,6 @@ const (
 	// CancelCommandTopicPrefix is the topic prefix for a cancel command MDS message received from the offline service.
 	CancelCommandTopicPrefixOffline TopicPrefix = "aws.ssm.cancelCommand.offline."
 
-	CancelWorkersLimit = 3
-
 	// mdsname is the core module name for the MDS processor
 	mdsName = "MessagingDeliveryService"
 
@@ -76,6 +74,10 @@ type ExecuterCreator func(ctx context.T) executer.Executer
 type SendDocumentLevelResponse func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string)
 type SendResponse func(messageID string, res contracts.DocumentResult)
 
+// S3DestinationRewriter rewrites the S3 output destination computed for a send command.
+// Returning upload as false disables the S3 upload of the document output.
+type S3DestinationRewriter func(bucket, prefix string) (rewrittenBucket, rewrittenPrefix string, upload bool)
+
 // Processor is an object that can process MDS messages.
 type RunCommandService struct {
 	context              context.T
@@ -92,6 +94,17 @@ type RunCommandService struct {
 	processorStopPolicy *sdkutil.StopPolicy
 	pollAssociations    bool
 	processor           processor.Processor
+	correlator          commandCorrelator
+	tracing             documentTracing
+	dropWatcher         *mdsService.DropFolderWatcher
+	receipts            messageReceipts
+	// resendResults is set while MDS is unreachable, the results it didn't acknowledge are sent again on the next successful poll
+	resendResults bool
+}
+
+// RelatedMessageIDs returns the ids of the in-flight messages of the given command, a command may be split across several messages
+func (s *RunCommandService) RelatedMessageIDs(commandID string) []string {
+	return s.correlator.lookup(commandID)
 }
 
 // NewOfflineProcessor initialize a new offline command document processor
@@ -100,12 +113,24 @@ func NewOfflineService(context context.T) (*RunCommandService, error) {
 	log := messageContext.Log()
 
 	log.Debug("Creating offline command document service")
-	offlineService, err := newOfflineService(log)
+	config := context.AppConfig()
+	offlineService, err := newOfflineService(log, config)
 	if err != nil {
 		return nil, err
 	}
 
-	return NewService(messageContext, offlineName, offlineService, 1, 1, false, []model.DocumentType{model.SendCommandOffline, model.CancelCommandOffline}), nil
+	svc := NewService(messageContext, offlineName, offlineService, 1, 1, false, []model.DocumentType{model.SendCommandOffline, model.CancelCommandOffline})
+	if svc != nil && config.Mds.WatchOfflineDropFolder {
+		dropFolder := config.Mds.OfflineDropFolder
+		if dropFolder == "" {
+			dropFolder = appconfig.LocalCommandRoot
+		}
+		// the poller still picks up the documents if the folder can't be watched
+		if svc.dropWatcher, err = mdsService.NewDropFolderWatcher(log, dropFolder, offlineDocumentSettle(config)); err != nil {
+			log.Errorf("unable to watch the local command folder %v, relying on polling: %v", dropFolder, err)
+		}
+	}
+	return svc, nil
 }
 
 // NewMdsProcessor initializes a new mds processor with the given parameters.
@@ -114,7 +139,7 @@ func NewMDSService(context context.T) *RunCommandService {
 	mdsService := newMdsService(context.AppConfig())
 	config := context.AppConfig()
 
-	return NewService(messageContext, mdsName, mdsService, config.Mds.CommandWorkersLimit, CancelWorkersLimit, true, []model.DocumentType{model.SendCommand, model.CancelCommand})
+	return NewService(messageContext, mdsName, mdsService, config.Mds.CommandWorkersLimit, config.Mds.CancelWorkersLimit, true, []model.DocumentType{model.SendCommand, model.CancelCommand})
 }
 
 // NewProcessor performs common initialization for Mds and Offline processors
@@ -156,7 +181,13 @@ func NewService(ctx context.T, serviceName string, service mdsService.Service, c
 
 	sendResponse := func(messageID string, res contracts.DocumentResult) {
 		pluginID := res.LastPlugin
-		processSendReply(log, messageID, service, FormatPayload(log, pluginID, agentInfo, res.PluginResults), stopPolicy)
+		err := processSendReply(log, messageID, service, FormatPayload(log, pluginID, agentInfo, res.PluginResults), stopPolicy)
+		if err != nil && pluginID == "" && serviceName == mdsName {
+			// the document completed while MDS is unreachable, its result is sent again once MDS is back
+			if err = markResultUnacknowledged(log, getCommandID(messageID), instanceID); err != nil {
+				log.Errorf("failed to record the unacknowledged result of %v: %v", messageID, err)
+			}
+		}
 	}
 
 	var assocProc *associationProcessor.Processor
@@ -178,6 +209,8 @@ func NewService(ctx context.T, serviceName string, service mdsService.Service, c
 		assocProcessor:       assocProc,
 		pollAssociations:     pollAssoc,
 		processor:            processor,
+		// the results left unacknowledged by a previous run are sent on the first successful poll
+		resendResults: serviceName == mdsName,
 	}
 }
 
@@ -196,7 +229,7 @@ func prepareReplyPayloadToUpdateDocumentStatus(agentInfo contracts.AgentInfo, do
 	return
 }
 
-func processSendReply(log log.T, messageID string, mdsService mdsService.Service, payloadDoc messageContracts.SendReplyPayload, processorStopPolicy *sdkutil.StopPolicy) {
+func processSendReply(log log.T, messageID string, mdsService mdsService.Service, payloadDoc messageContracts.SendReplyPayload, processorStopPolicy *sdkutil.StopPolicy) error {
 	payloadB, err := json.Marshal(payloadDoc)
 	if err != nil {
 		log.Error("could not marshal reply payload!", err)
@@ -207,10 +240,16 @@ func processSendReply(log log.T, messageID string, mdsService mdsService.Service
 	if err != nil {
 		sdkutil.HandleAwsError(log, err, processorStopPolicy)
 	}
+	return err
+}
+
+var newOfflineService = func(log log.T, config appconfig.SsmagentConfig) (mdsService.Service, error) {
+	return mdsService.NewOfflineService(log, string(SendCommandTopicPrefixOffline), config.Mds.OfflineDropFolder, offlineDocumentSettle(config))
 }
 
-var newOfflineService = func(log log.T) (mdsService.Service, error) {
-	return mdsService.NewOfflineService(log, string(SendCommandTopicPrefixOffline))
+// offlineDocumentSettle returns how long an offline command document must go unmodified to be considered completely written
+func offlineDocumentSettle(config appconfig.SsmagentConfig) time.Duration {
+	return time.Duration(config.Mds.OfflineDocumentSettleMillis) * time.Millisecond
 }
 
 var newMdsService = func(config appconfig.SsmagentConfig) mdsService.Service {
@@ -23,8 +23,10 @@ import (
 	"encoding/json"
 	"path"
 
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
 	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/docparser"
 	"github.com/aws/amazon-ssm-agent/agent/fileutil"
@@ -93,6 +95,80 @@ func TestProcessMessageWithSendCommandTopicPrefix(t *testing.T) {
 	assert.True(t, *tc.IsDocLevelResponseSent)
 }
 
+// TestProcessMessageInProgressResponseOfOfflineDocuments tests processMessage only sends InProgress for the offline documents when configured to
+func TestProcessMessageInProgressResponseOfOfflineDocuments(t *testing.T) {
+	testCases := []struct {
+		documentType       model.DocumentType
+		sendOfflineEnabled bool
+		inProgressSent     bool
+	}{
+		{model.SendCommand, false, true},
+		{model.SendCommandOffline, false, false},
+		{model.SendCommandOffline, true, true},
+	}
+	for _, testCase := range testCases {
+		config := appconfig.DefaultConfig()
+		config.Mds.SendOfflineInProgressResponse = testCase.sendOfflineEnabled
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+		ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+		fakeDocState := model.DocumentState{DocumentType: testCase.documentType}
+		svc, tc := prepareTestProcessMessage(testTopicSend)
+		svc.context = ctx
+		tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+		loadDocStateFromSendCommand = func(context context.T,
+			msg *ssmmds.Message,
+			messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+			return &fakeDocState, nil
+		}
+		tc.ProcessMock.On("Submit", fakeDocState).Return(nil)
+
+		svc.processMessage(&tc.Message)
+
+		tc.ProcessMock.AssertExpectations(t)
+		assert.Equal(t, testCase.inProgressSent, *tc.IsDocLevelResponseSent, "%v", testCase)
+	}
+}
+
+// TestProcessMessageCorrelatesMessagesOfSameCommand tests processMessage groups the messages sharing a command id
+func TestProcessMessageCorrelatesMessagesOfSameCommand(t *testing.T) {
+	commandID := "2b196342-d7d4-436e-8f09-3883a1116ac3"
+	var fakeDocState = model.DocumentState{
+		DocumentType: model.SendCommand,
+	}
+	fakeDocState.DocumentInformation.CommandID = commandID
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	loadDocStateFromSendCommand = func(context context.T,
+		msg *ssmmds.Message,
+		messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+	tc.ProcessMock.On("Submit", fakeDocState).Return(nil)
+
+	messageIDs := []string{
+		"aws.ssm.part1." + commandID + "." + testDestination,
+		"aws.ssm.part2." + commandID + "." + testDestination,
+	}
+	for _, messageID := range messageIDs {
+		msg := tc.Message
+		msg.MessageId = aws.String(messageID)
+		tc.MdsMock.On("AcknowledgeMessage", mock.Anything, messageID).Return(nil)
+		svc.processMessage(&msg)
+	}
+
+	tc.MdsMock.AssertExpectations(t)
+	assert.Equal(t, messageIDs, svc.RelatedMessageIDs(commandID))
+
+	// a completed message is no longer correlated
+	resultChan := make(chan contracts.DocumentResult, 1)
+	svc.sendResponse = func(messageID string, res contracts.DocumentResult) {}
+	resultChan <- contracts.DocumentResult{MessageID: messageIDs[0], LastPlugin: ""}
+	close(resultChan)
+	svc.listenReply(resultChan)
+	assert.Equal(t, messageIDs[1:], svc.RelatedMessageIDs(commandID))
+}
+
 // TestProcessMessageWithCancelCommandTopicPrefix tests processMessage with CancelCommand topic prefix
 func TestProcessMessageWithCancelCommandTopicPrefix(t *testing.T) {
 	// CancelCommand topic prefix
@@ -142,6 +218,34 @@ func TestProcessMessageWithInvalidCommandTopicPrefix(t *testing.T) {
 	assert.False(t, *tc.IsDocLevelResponseSent)
 }
 
+// TestProcessMessageWithOrchestrationDirCollision tests processMessage fails a command whose orchestration dir is used by another command
+func TestProcessMessageWithOrchestrationDirCollision(t *testing.T) {
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	fakeDocState := model.DocumentState{DocumentType: model.SendCommand}
+	fakeDocState.DocumentInformation.CommandID = "command2"
+	fakeDocState.DocumentInformation.OrchestrationDirectory = "orchestration/shared"
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+	checkOrchestrationDirCollision = func(log log.T, instanceID, documentID, commandID, orchestrationDir string) error {
+		assert.Equal(t, "command2", commandID)
+		assert.Equal(t, "orchestration/shared", orchestrationDir)
+		return &docmanager.OrchestrationDirCollisionError{OrchestrationDir: orchestrationDir, CommandIDs: []string{"command1", commandID}}
+	}
+	defer func() { checkOrchestrationDirCollision = docmanager.CheckOrchestrationDirCollision }()
+	var failure string
+	svc.sendDocLevelResponse = func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
+		assert.Equal(t, contracts.ResultStatusFailed, resultStatus)
+		failure = documentTraceOutput
+	}
+
+	svc.processMessage(&tc.Message)
+
+	assert.Contains(t, failure, "command1")
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.ProcessMock.AssertNotCalled(t, "Submit", mock.Anything)
+}
+
 // TestProcessMessageWithInvalidMessage tests processMessage with invalid message
 func TestProcessMessageWithInvalidMessage(t *testing.T) {
 	// prepare processor and test case fields
@@ -0,0 +1,163 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+)
+
+const (
+	// documentSpanName is the name of the root span covering the whole lifecycle of a document
+	documentSpanName = "Document"
+	// receivedSpanName is the name of the span covering the message from its reception until it's scheduled
+	receivedSpanName = "Received"
+	// scheduledSpanName is the name of the span covering the document from its scheduling until it completes, the plugin spans are its children
+	scheduledSpanName = "Scheduled"
+
+	// span attributes
+	messageIDAttribute = "ssm.message_id"
+	commandIDAttribute = "ssm.command_id"
+	pluginIDAttribute  = "ssm.plugin_id"
+	statusAttribute    = "ssm.status"
+	errorAttribute     = "error"
+)
+
+// Span is a timed step of the lifecycle of a document.
+type Span interface {
+	// SetAttribute records an attribute of the step
+	SetAttribute(key, value string)
+	// End ends the step at the given time
+	End(end time.Time)
+}
+
+// Tracer starts the spans tracing the lifecycle of the documents, e.g. on top of an OpenTelemetry tracer.
+// The parent of the root span of a document is nil.
+type Tracer interface {
+	StartSpan(name string, parent Span, start time.Time, attributes map[string]string) Span
+}
+
+// DocumentTracer traces the lifecycle of the send command documents, it does nothing by default
+var DocumentTracer Tracer = noopTracer{}
+
+// noopTracer is a tracer recording nothing
+type noopTracer struct{}
+
+// StartSpan returns a span recording nothing
+func (noopTracer) StartSpan(name string, parent Span, start time.Time, attributes map[string]string) Span {
+	return noopSpan{}
+}
+
+// noopSpan is a span recording nothing
+type noopSpan struct{}
+
+// SetAttribute does nothing
+func (noopSpan) SetAttribute(key, value string) {}
+
+// End does nothing
+func (noopSpan) End(end time.Time) {}
+
+// documentTrace holds the open spans of a document
+type documentTrace struct {
+	root Span
+	step Span
+}
+
+// documentTracing tracks the traces of the in-flight documents by message id
+type documentTracing struct {
+	traces map[string]*documentTrace
+	m      sync.Mutex
+}
+
+// received starts the trace of the message
+func (d *documentTracing) received(messageID string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.traces == nil {
+		d.traces = make(map[string]*documentTrace)
+	}
+	now := time.Now()
+	attributes := map[string]string{messageIDAttribute: messageID}
+	root := DocumentTracer.StartSpan(documentSpanName, nil, now, attributes)
+	d.traces[messageID] = &documentTrace{
+		root: root,
+		step: DocumentTracer.StartSpan(receivedSpanName, root, now, attributes),
+	}
+}
+
+// scheduled ends the reception of the message once its document is submitted to the processor
+func (d *documentTracing) scheduled(messageID, commandID string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	trace, found := d.traces[messageID]
+	if !found {
+		return
+	}
+	now := time.Now()
+	trace.step.End(now)
+	trace.root.SetAttribute(commandIDAttribute, commandID)
+	trace.step = DocumentTracer.StartSpan(scheduledSpanName, trace.root, now, map[string]string{
+		messageIDAttribute: messageID,
+		commandIDAttribute: commandID,
+	})
+}
+
+// failed ends the trace of a message dropped before its document is scheduled
+func (d *documentTracing) failed(messageID string, err error) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	trace, found := d.traces[messageID]
+	if !found {
+		return
+	}
+	delete(d.traces, messageID)
+	now := time.Now()
+	trace.step.SetAttribute(errorAttribute, err.Error())
+	trace.step.End(now)
+	trace.root.SetAttribute(statusAttribute, string(contracts.ResultStatusFailed))
+	trace.root.End(now)
+}
+
+// resultReceived records the result of a plugin as a child span of the scheduled document,
+// and ends the trace once the document result comes in
+func (d *documentTracing) resultReceived(res contracts.DocumentResult) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	trace, found := d.traces[res.MessageID]
+	if !found {
+		return
+	}
+	if res.LastPlugin != "" {
+		pluginResult, found := res.PluginResults[res.LastPlugin]
+		// progress updates don't end the plugin
+		if !found || pluginResult.Status == contracts.ResultStatusInProgress {
+			return
+		}
+		span := DocumentTracer.StartSpan(res.LastPlugin, trace.step, pluginResult.StartDateTime, map[string]string{
+			messageIDAttribute: res.MessageID,
+			pluginIDAttribute:  res.LastPlugin,
+			statusAttribute:    string(pluginResult.Status),
+		})
+		span.End(pluginResult.EndDateTime)
+		return
+	}
+	delete(d.traces, res.MessageID)
+	now := time.Now()
+	trace.step.End(now)
+	trace.root.SetAttribute(statusAttribute, string(res.Status))
+	trace.root.End(now)
+}
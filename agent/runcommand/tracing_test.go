@@ -0,0 +1,160 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// recordedSpan is a span kept by the recordingTracer
+type recordedSpan struct {
+	name       string
+	parent     *recordedSpan
+	start      time.Time
+	end        time.Time
+	ended      bool
+	attributes map[string]string
+}
+
+func (s *recordedSpan) SetAttribute(key, value string) {
+	s.attributes[key] = value
+}
+
+func (s *recordedSpan) End(end time.Time) {
+	s.end = end
+	s.ended = true
+}
+
+// recordingTracer keeps the spans it starts, in order
+type recordingTracer struct {
+	spans []*recordedSpan
+}
+
+func (r *recordingTracer) StartSpan(name string, parent Span, start time.Time, attributes map[string]string) Span {
+	span := &recordedSpan{name: name, start: start, attributes: make(map[string]string)}
+	if parent != nil {
+		span.parent = parent.(*recordedSpan)
+	}
+	for key, value := range attributes {
+		span.attributes[key] = value
+	}
+	r.spans = append(r.spans, span)
+	return span
+}
+
+func useRecordingTracer() (tracer *recordingTracer, restore func()) {
+	tracer = &recordingTracer{}
+	DocumentTracer = tracer
+	return tracer, func() { DocumentTracer = noopTracer{} }
+}
+
+func TestDocumentTracingOfSuccessfulDocument(t *testing.T) {
+	tracer, restore := useRecordingTracer()
+	defer restore()
+	commandID := "2b196342-d7d4-436e-8f09-3883a1116ac3"
+	fakeDocState := model.DocumentState{DocumentType: model.SendCommand}
+	fakeDocState.DocumentInformation.CommandID = commandID
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, testMessageId).Return(nil)
+	tc.ProcessMock.On("Submit", fakeDocState).Return(nil)
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+
+	svc.processMessage(&tc.Message)
+
+	pluginStart := time.Now()
+	pluginEnd := pluginStart.Add(time.Second)
+	pluginResult := func(status contracts.ResultStatus) *contracts.PluginResult {
+		return &contracts.PluginResult{Status: status, StartDateTime: pluginStart, EndDateTime: pluginEnd}
+	}
+	resultChan := make(chan contracts.DocumentResult, 4)
+	svc.sendResponse = func(messageID string, res contracts.DocumentResult) {}
+	resultChan <- contracts.DocumentResult{MessageID: testMessageId, LastPlugin: "plugin1", PluginResults: map[string]*contracts.PluginResult{
+		"plugin1": pluginResult(contracts.ResultStatusInProgress),
+	}}
+	resultChan <- contracts.DocumentResult{MessageID: testMessageId, LastPlugin: "plugin1", PluginResults: map[string]*contracts.PluginResult{
+		"plugin1": pluginResult(contracts.ResultStatusSuccess),
+	}}
+	resultChan <- contracts.DocumentResult{MessageID: testMessageId, LastPlugin: "plugin2", PluginResults: map[string]*contracts.PluginResult{
+		"plugin2": pluginResult(contracts.ResultStatusSuccess),
+	}}
+	resultChan <- contracts.DocumentResult{MessageID: testMessageId, Status: contracts.ResultStatusSuccess}
+	close(resultChan)
+	svc.listenReply(resultChan)
+
+	// Document -> Received, Document -> Scheduled -> plugin1, plugin2
+	if !assert.Len(t, tracer.spans, 5) {
+		return
+	}
+	root, received, scheduled, plugin1, plugin2 := tracer.spans[0], tracer.spans[1], tracer.spans[2], tracer.spans[3], tracer.spans[4]
+	expected := []struct {
+		span   *recordedSpan
+		name   string
+		parent *recordedSpan
+	}{
+		{root, documentSpanName, nil},
+		{received, receivedSpanName, root},
+		{scheduled, scheduledSpanName, root},
+		{plugin1, "plugin1", scheduled},
+		{plugin2, "plugin2", scheduled},
+	}
+	for _, e := range expected {
+		assert.Equal(t, e.name, e.span.name)
+		assert.Equal(t, e.parent, e.span.parent, e.name)
+		assert.True(t, e.span.ended, e.name)
+		assert.Equal(t, testMessageId, e.span.attributes[messageIDAttribute], e.name)
+	}
+	assert.Equal(t, commandID, root.attributes[commandIDAttribute])
+	assert.Equal(t, commandID, scheduled.attributes[commandIDAttribute])
+	assert.Equal(t, string(contracts.ResultStatusSuccess), root.attributes[statusAttribute])
+	assert.False(t, received.end.After(scheduled.start))
+	for _, plugin := range []*recordedSpan{plugin1, plugin2} {
+		assert.Equal(t, string(contracts.ResultStatusSuccess), plugin.attributes[statusAttribute])
+		assert.Equal(t, plugin.name, plugin.attributes[pluginIDAttribute])
+		assert.Equal(t, pluginStart, plugin.start)
+		assert.Equal(t, pluginEnd, plugin.end)
+	}
+	assert.Empty(t, svc.tracing.traces)
+}
+
+func TestDocumentTracingOfUnparsableMessage(t *testing.T) {
+	tracer, restore := useRecordingTracer()
+	defer restore()
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return nil, fmt.Errorf("invalid payload")
+	}
+
+	svc.processMessage(&tc.Message)
+
+	if assert.Len(t, tracer.spans, 2) {
+		for _, span := range tracer.spans {
+			assert.True(t, span.ended, span.name)
+		}
+		assert.Equal(t, "invalid payload", tracer.spans[1].attributes[errorAttribute])
+		assert.Equal(t, string(contracts.ResultStatusFailed), tracer.spans[0].attributes[statusAttribute])
+	}
+	assert.Empty(t, svc.tracing.traces)
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+)
+
+// maxUnsupportedDocumentNameLength is the longest document name accepted in the list of unsupported documents
+const maxUnsupportedDocumentNameLength = 128
+
+// unsupportedDocsLock guards singletonMapOfUnsupportedSSMDocs along with the file it was loaded from
+var unsupportedDocsLock sync.RWMutex
+var unsupportedDocsFile string
+var unsupportedDocsModTime time.Time
+
+// refreshUnsupportedDocuments reloads the list of unsupported documents when the file changed since it was last loaded,
+// the current list is kept if the file can't be loaded
+func refreshUnsupportedDocuments(log log.T, filePath string, limit int) {
+	unsupportedDocsLock.Lock()
+	defer unsupportedDocsLock.Unlock()
+	if filePath == "" {
+		singletonMapOfUnsupportedSSMDocs, unsupportedDocsFile = nil, ""
+		return
+	}
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		log.Debugf("unable to read the unsupported documents list %v: %v", filePath, err)
+		return
+	}
+	if filePath == unsupportedDocsFile && fileInfo.ModTime().Equal(unsupportedDocsModTime) {
+		return
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		log.Errorf("unable to read the unsupported documents list %v: %v", filePath, err)
+		return
+	}
+	defer file.Close()
+	names, err := loadUnsupportedDocuments(log, json.NewDecoder(file), limit)
+	if err != nil {
+		log.Errorf("unable to load the unsupported documents list %v, keeping the current one: %v", filePath, err)
+		return
+	}
+	log.Infof("loaded %v unsupported documents from %v", len(names), filePath)
+	singletonMapOfUnsupportedSSMDocs, unsupportedDocsFile, unsupportedDocsModTime = names, filePath, fileInfo.ModTime()
+}
+
+// loadUnsupportedDocuments decodes a JSON array of document names, one entry at a time so that an oversized list isn't read in full.
+// The invalid entries are skipped and the list is truncated to the limit, both with a warning.
+func loadUnsupportedDocuments(log log.T, decoder *json.Decoder, limit int) (names map[string]bool, err error) {
+	if token, err := decoder.Token(); err != nil || token != json.Delim('[') {
+		return nil, fmt.Errorf("the list of unsupported documents must be a JSON array of document names")
+	}
+	names = make(map[string]bool)
+	for decoder.More() {
+		if len(names) >= limit {
+			log.Warnf("the list of unsupported documents has more than %v entries, ignoring the rest", limit)
+			return names, nil
+		}
+		var entry interface{}
+		if err = decoder.Decode(&entry); err != nil {
+			return nil, err
+		}
+		name, ok := entry.(string)
+		name = strings.TrimSpace(name)
+		if !ok || name == "" || len(name) > maxUnsupportedDocumentNameLength {
+			log.Warnf("ignoring invalid entry %.64v in the list of unsupported documents", entry)
+			continue
+		}
+		names[name] = true
+	}
+	if _, err = decoder.Token(); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// isUnsupportedDocument checks if the document is one of the documents the agent refuses to run
+func isUnsupportedDocument(documentName string) bool {
+	unsupportedDocsLock.RLock()
+	defer unsupportedDocsLock.RUnlock()
+	return singletonMapOfUnsupportedSSMDocs[documentName]
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// writeUnsupportedDocuments writes the list to a temporary file, returns a function deleting it and resetting the loaded list
+func writeUnsupportedDocuments(t *testing.T, content string) (filePath string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "unsupporteddocs")
+	assert.NoError(t, err)
+	filePath = filepath.Join(dir, "unsupported.json")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(content), 0600))
+	return filePath, func() {
+		refreshUnsupportedDocuments(log.NewMockLog(), "", 0)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLoadUnsupportedDocumentsValidatesEntries(t *testing.T) {
+	content := `["AWS-RunShellScript", "", "   ", 42, null, "` + strings.Repeat("a", maxUnsupportedDocumentNameLength+1) + `", " Custom-Document ", "AWS-RunShellScript"]`
+
+	names, err := loadUnsupportedDocuments(log.NewMockLog(), json.NewDecoder(strings.NewReader(content)), 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"AWS-RunShellScript": true, "Custom-Document": true}, names)
+}
+
+func TestLoadUnsupportedDocumentsCapsOversizedList(t *testing.T) {
+	content := `["document1", "document2", "document3", "document4", {"not": "even read"}`
+
+	names, err := loadUnsupportedDocuments(log.NewMockLog(), json.NewDecoder(strings.NewReader(content)), 3)
+
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{"document1": true, "document2": true, "document3": true}, names)
+}
+
+func TestRefreshUnsupportedDocumentsKeepsListWhenMalformed(t *testing.T) {
+	filePath, cleanup := writeUnsupportedDocuments(t, `["AWS-RunShellScript"]`)
+	defer cleanup()
+	refreshUnsupportedDocuments(log.NewMockLog(), filePath, 10)
+	assert.True(t, isUnsupportedDocument("AWS-RunShellScript"))
+
+	for _, malformed := range []string{`{"AWS-RunShellScript": true}`, `["AWS-RunPowerShellScript"`, ``} {
+		assert.NoError(t, ioutil.WriteFile(filePath, []byte(malformed), 0600))
+		modTime := time.Now().Add(time.Duration(len(malformed)) * time.Second)
+		assert.NoError(t, os.Chtimes(filePath, modTime, modTime))
+
+		refreshUnsupportedDocuments(log.NewMockLog(), filePath, 10)
+
+		assert.True(t, isUnsupportedDocument("AWS-RunShellScript"), malformed)
+		assert.False(t, isUnsupportedDocument("AWS-RunPowerShellScript"), malformed)
+	}
+}
+
+func TestProcessMessageRejectsUnsupportedDocument(t *testing.T) {
+	filePath, cleanup := writeUnsupportedDocuments(t, `["Retired-Document"]`)
+	defer cleanup()
+	config := appconfig.DefaultConfig()
+	config.Mds.UnsupportedDocumentsFile = filePath
+	ctx := new(context.Mock)
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	svc.context = ctx
+	var rejection string
+	svc.sendDocLevelResponse = func(messageID string, resultStatus contracts.ResultStatus, documentTraceOutput string) {
+		assert.Equal(t, contracts.ResultStatusFailed, resultStatus)
+		rejection = documentTraceOutput
+	}
+	fakeDocState := model.DocumentState{DocumentType: model.SendCommand}
+	fakeDocState.DocumentInformation.DocumentName = "Retired-Document"
+	loadDocStateFromSendCommand = func(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+
+	svc.processMessage(&tc.Message)
+
+	assert.Contains(t, rejection, "Retired-Document")
+	tc.ProcessMock.AssertNotCalled(t, "Submit", mock.Anything)
+}
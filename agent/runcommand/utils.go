@@ -25,6 +25,7 @@ import (
 	"github.com/aws/amazon-ssm-agent/agent/appconfig"
 	"github.com/aws/amazon-ssm-agent/agent/context"
 	"github.com/aws/amazon-ssm-agent/agent/contracts"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager"
 	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
 	"github.com/aws/amazon-ssm-agent/agent/docparser"
 	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
@@ -84,6 +85,10 @@ func newDocumentInfo(msg ssmmds.Message, parsedMsg messageContracts.SendCommandP
 	documentInfo.IsCommand = true
 	documentInfo.DocumentStatus = contracts.ResultStatusInProgress
 	documentInfo.DocumentTraceOutput = ""
+	documentInfo.SupersedesCommandID = parsedMsg.SupersedesCommandID
+	documentInfo.SequenceNumber = parsedMsg.SequenceNumber
+	documentInfo.PreconditionCommandID = parsedMsg.PreconditionCommandID
+	documentInfo.OrchestrationRetentionHours = parsedMsg.OrchestrationRetentionHours
 
 	return *documentInfo
 }
@@ -130,6 +135,14 @@ func parseCancelCommandMessage(context context.T, msg *ssmmds.Message, messagesO
 	return &docState, nil
 }
 
+// Assign functions to global variables to allow unittest to override
+var isManagedInstance = platform.IsManagedInstance
+var removeDependencyOnInstanceMetadata = model.RemoveDependencyOnInstanceMetadata
+
+// RewriteS3Destination is invoked after the S3 output destination of a send command is computed,
+// nil keeps the destination from the message payload
+var RewriteS3Destination S3DestinationRewriter
+
 func parseSendCommandMessage(context context.T, msg *ssmmds.Message, messagesOrchestrationRootDir string) (*model.DocumentState, error) {
 	log := context.Log()
 	commandID := getCommandID(*msg.MessageId)
@@ -137,6 +150,12 @@ func parseSendCommandMessage(context context.T, msg *ssmmds.Message, messagesOrc
 	log.Debug("Processing send command message ", *msg.MessageId)
 	log.Trace("Processing send command message ", jsonutil.Indent(*msg.Payload))
 
+	if msg.Destination == nil {
+		errorMsg := "Encountered error while parsing input - destination is missing"
+		log.Errorf(errorMsg)
+		return nil, fmt.Errorf("%v", errorMsg)
+	}
+
 	// parse message to retrieve parameters
 	var parsedMessage messageContracts.SendCommandPayload
 	err := json.Unmarshal([]byte(*msg.Payload), &parsedMessage)
@@ -147,8 +166,19 @@ func parseSendCommandMessage(context context.T, msg *ssmmds.Message, messagesOrc
 	}
 
 	// adapt plugin configuration format from MDS to plugin expected format
+	s3Bucket := parsedMessage.OutputS3BucketName
 	s3KeyPrefix := path.Join(parsedMessage.OutputS3KeyPrefix, parsedMessage.CommandID, *msg.Destination)
+	if RewriteS3Destination != nil {
+		var upload bool
+		if s3Bucket, s3KeyPrefix, upload = RewriteS3Destination(s3Bucket, s3KeyPrefix); !upload {
+			log.Debugf("S3 upload is disabled for command %v", commandID)
+			s3Bucket, s3KeyPrefix = "", ""
+		}
+	}
 
+	if context.AppConfig().Agent.PartitionOrchestrationDirsByDate {
+		messagesOrchestrationRootDir = docmanager.PartitionedOrchestrationDir(messagesOrchestrationRootDir, clock.Now())
+	}
 	messageOrchestrationDirectory := filepath.Join(messagesOrchestrationRootDir, commandID)
 
 	var documentType model.DocumentType
@@ -160,7 +190,7 @@ func parseSendCommandMessage(context context.T, msg *ssmmds.Message, messagesOrc
 	documentInfo := newDocumentInfo(*msg, parsedMessage)
 	parserInfo := docparser.DocumentParserInfo{
 		OrchestrationDir: messageOrchestrationDirectory,
-		S3Bucket:         parsedMessage.OutputS3BucketName,
+		S3Bucket:         s3Bucket,
 		S3Prefix:         s3KeyPrefix,
 		MessageId:        documentInfo.MessageID,
 		DocumentId:       documentInfo.DocumentID,
@@ -203,14 +233,19 @@ func parseSendCommandMessage(context context.T, msg *ssmmds.Message, messagesOrc
 	// Check if it is a managed instance and its executing managed instance incompatible AWS SSM public document.
 	// A few public AWS SSM documents contain code which is not compatible when run on managed instances.
 	// isManagedInstanceIncompatibleAWSSSMDocument makes sure to find such documents at runtime and replace the incompatible code.
-	isMI, err := platform.IsManagedInstance()
+	// The rewriting can be turned off in the agent configuration, or skipped by the document payload.
+	isMI, err := isManagedInstance()
 	if err != nil {
 		log.Errorf("Error determining managed instance. error: %v", err)
 	}
 
 	if isMI && model.IsManagedInstanceIncompatibleAWSSSMDocument(docState.DocumentInformation.DocumentName) {
 		log.Debugf("Running incompatible AWS SSM Document %v on managed instance", docState.DocumentInformation.DocumentName)
-		if err = model.RemoveDependencyOnInstanceMetadata(context, &docState); err != nil {
+		if !context.AppConfig().Mds.RewriteManagedInstanceIncompatibleDocuments {
+			log.Infof("Rewriting of managed instance incompatible documents is disabled, running %v as is", docState.DocumentInformation.DocumentName)
+		} else if parsedMessage.SkipManagedInstanceRewrite {
+			log.Infof("Command %v opted out of the managed instance rewriting, running %v as is", commandID, docState.DocumentInformation.DocumentName)
+		} else if err = removeDependencyOnInstanceMetadata(context, &docState); err != nil {
 			return nil, err
 		}
 	}
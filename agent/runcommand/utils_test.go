@@ -0,0 +1,179 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/platform"
+	messageContracts "github.com/aws/amazon-ssm-agent/agent/runcommand/contracts"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+)
+
+const testSendCommandPayloadFile = "testdata/sampleMsgVersion2_0.json"
+
+// loadSendCommandPayload loads the sample send command payload used to build test messages
+func loadSendCommandPayload(t *testing.T) (payload messageContracts.SendCommandPayload) {
+	if err := json.Unmarshal(loadFile(t, testSendCommandPayloadFile), &payload); err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+// createSendCommandMessage wraps the given payload into a send command MDS message
+func createSendCommandMessage(t *testing.T, payload messageContracts.SendCommandPayload) ssmmds.Message {
+	content, err := jsonutil.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return createMDSMessage(payload.CommandID, content, testTopicSend, testDestination)
+}
+
+func TestParseSendCommandMessageWithS3DestinationRewriter(t *testing.T) {
+	payload := loadSendCommandPayload(t)
+	payload.OutputS3BucketName = "customer-bucket"
+	payload.OutputS3KeyPrefix = "prefix"
+	msg := createSendCommandMessage(t, payload)
+
+	RewriteS3Destination = func(bucket, prefix string) (string, string, bool) {
+		assert.Equal(t, "customer-bucket", bucket)
+		return "local-bucket", "local/" + prefix, true
+	}
+	defer func() { RewriteS3Destination = nil }()
+
+	docState, err := parseSendCommandMessage(context.NewMockDefault(), &msg, "orchestration")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, docState.InstancePluginsInformation)
+	for _, pluginState := range docState.InstancePluginsInformation {
+		assert.Equal(t, "local-bucket", pluginState.Configuration.OutputS3BucketName)
+		assert.Contains(t, pluginState.Configuration.OutputS3KeyPrefix, "local/prefix/"+payload.CommandID+"/"+testDestination)
+	}
+}
+
+func TestParseSendCommandMessageWithS3UploadDisabled(t *testing.T) {
+	payload := loadSendCommandPayload(t)
+	payload.OutputS3BucketName = "customer-bucket"
+	payload.OutputS3KeyPrefix = "prefix"
+	msg := createSendCommandMessage(t, payload)
+
+	RewriteS3Destination = func(bucket, prefix string) (string, string, bool) {
+		return bucket, prefix, false
+	}
+	defer func() { RewriteS3Destination = nil }()
+
+	docState, err := parseSendCommandMessage(context.NewMockDefault(), &msg, "orchestration")
+
+	assert.NoError(t, err)
+	assert.NotEmpty(t, docState.InstancePluginsInformation)
+	for _, pluginState := range docState.InstancePluginsInformation {
+		assert.Empty(t, pluginState.Configuration.OutputS3BucketName)
+	}
+}
+
+func TestParseSendCommandMessageWithNilDestination(t *testing.T) {
+	msg := createSendCommandMessage(t, loadSendCommandPayload(t))
+	msg.Destination = nil
+
+	var err error
+	assert.NotPanics(t, func() {
+		_, err = parseSendCommandMessage(context.NewMockDefault(), &msg, "orchestration")
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "destination is missing")
+}
+
+func TestParseSendCommandMessageWithRetentionHint(t *testing.T) {
+	payload := loadSendCommandPayload(t)
+	payload.OrchestrationRetentionHours = 720
+	msg := createSendCommandMessage(t, payload)
+
+	docState, err := parseSendCommandMessage(context.NewMockDefault(), &msg, "orchestration")
+
+	assert.NoError(t, err)
+	assert.Equal(t, 720, docState.DocumentInformation.OrchestrationRetentionHours)
+}
+
+func TestParseSendCommandMessageWithPartitionedOrchestrationDir(t *testing.T) {
+	origClock := clock
+	defer func() { clock = origClock }()
+	clock = &fakeClock{now: time.Date(2017, time.March, 4, 23, 30, 0, 0, time.UTC)}
+	ctx := new(context.Mock)
+	config := appconfig.SsmagentConfig{}
+	config.Agent.PartitionOrchestrationDirsByDate = true
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	payload := loadSendCommandPayload(t)
+	msg := createSendCommandMessage(t, payload)
+
+	docState, err := parseSendCommandMessage(ctx, &msg, "orchestration")
+
+	assert.NoError(t, err)
+	expected := filepath.Join("orchestration", "2017", "03", "04", payload.CommandID)
+	assert.Equal(t, expected, docState.DocumentInformation.OrchestrationDirectory)
+	for _, pluginState := range docState.InstancePluginsInformation {
+		assert.True(t, strings.HasPrefix(pluginState.Configuration.OrchestrationDirectory, expected))
+	}
+}
+
+// parseIncompatibleDocumentOnManagedInstance parses a managed instance incompatible document on a managed instance,
+// returns whether the document got rewritten
+func parseIncompatibleDocumentOnManagedInstance(t *testing.T, rewriteEnabled, skipRewrite bool) (rewritten bool) {
+	isManagedInstance = func() (bool, error) { return true, nil }
+	removeDependencyOnInstanceMetadata = func(context context.T, docState *model.DocumentState) error {
+		rewritten = true
+		return nil
+	}
+	defer func() {
+		isManagedInstance = platform.IsManagedInstance
+		removeDependencyOnInstanceMetadata = model.RemoveDependencyOnInstanceMetadata
+	}()
+	ctx := new(context.Mock)
+	config := appconfig.SsmagentConfig{}
+	config.Mds.RewriteManagedInstanceIncompatibleDocuments = rewriteEnabled
+	ctx.On("Log").Return(log.NewMockLog())
+	ctx.On("AppConfig").Return(config)
+	payload := loadSendCommandPayload(t)
+	payload.DocumentName = "AWS-ListWindowsInventory"
+	payload.SkipManagedInstanceRewrite = skipRewrite
+	msg := createSendCommandMessage(t, payload)
+
+	_, err := parseSendCommandMessage(ctx, &msg, "orchestration")
+
+	assert.NoError(t, err)
+	return
+}
+
+func TestParseSendCommandMessageRewritesIncompatibleDocumentOnManagedInstance(t *testing.T) {
+	assert.True(t, parseIncompatibleDocumentOnManagedInstance(t, true, false))
+}
+
+func TestParseSendCommandMessageWithManagedInstanceRewriteDisabled(t *testing.T) {
+	assert.False(t, parseIncompatibleDocumentOnManagedInstance(t, false, false))
+}
+
+func TestParseSendCommandMessageWithManagedInstanceRewriteSkipped(t *testing.T) {
+	assert.False(t, parseIncompatibleDocumentOnManagedInstance(t, true, true))
+}
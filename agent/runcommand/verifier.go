@@ -0,0 +1,61 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+)
+
+// MessageVerifier checks the authenticity of an MDS message, it returns an error if the message fails verification.
+type MessageVerifier func(msg *ssmmds.Message) error
+
+// KeySource returns the key the message signatures are computed with.
+type KeySource func() ([]byte, error)
+
+// VerifyMessage is invoked on every valid message before it is acked or persisted,
+// nil disables the verification
+var VerifyMessage MessageVerifier
+
+// NewHMACMessageVerifier returns a verifier expecting the payload digest of the message
+// to be the hex encoded HMAC-SHA256 of its payload, keyed with the key from keySource.
+func NewHMACMessageVerifier(keySource KeySource) MessageVerifier {
+	return func(msg *ssmmds.Message) error {
+		if empty(msg.PayloadDigest) {
+			return errors.New("PayloadDigest is missing")
+		}
+		signature, err := hex.DecodeString(*msg.PayloadDigest)
+		if err != nil {
+			return fmt.Errorf("PayloadDigest is not a valid signature, %v", err)
+		}
+		key, err := keySource()
+		if err != nil {
+			return fmt.Errorf("failed to get the signature key, %v", err)
+		}
+		mac := hmac.New(sha256.New, key)
+		if msg.Payload != nil {
+			mac.Write([]byte(*msg.Payload))
+		}
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return errors.New("payload signature mismatch")
+		}
+		return nil
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var testSignatureKey = []byte("signature key")
+
+// signMessage sets the payload digest of the message to the HMAC of its payload
+func signMessage(msg *ssmmds.Message, payload string) {
+	mac := hmac.New(sha256.New, testSignatureKey)
+	mac.Write([]byte(payload))
+	msg.Payload = aws.String(payload)
+	msg.PayloadDigest = aws.String(hex.EncodeToString(mac.Sum(nil)))
+}
+
+func testKeySource() ([]byte, error) {
+	return testSignatureKey, nil
+}
+
+func TestHMACMessageVerifier(t *testing.T) {
+	verify := NewHMACMessageVerifier(testKeySource)
+
+	msg := ssmmds.Message{}
+	signMessage(&msg, "payload")
+	assert.NoError(t, verify(&msg))
+
+	// tampered payload
+	msg.Payload = aws.String("tampered payload")
+	assert.Error(t, verify(&msg))
+
+	// missing signature
+	msg.PayloadDigest = nil
+	assert.Error(t, verify(&msg))
+}
+
+// TestProcessMessageWithValidSignature tests processMessage accepts a message passing verification
+func TestProcessMessageWithValidSignature(t *testing.T) {
+	VerifyMessage = NewHMACMessageVerifier(testKeySource)
+	defer func() { VerifyMessage = nil }()
+	var fakeDocState = model.DocumentState{
+		DocumentType: model.SendCommand,
+	}
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	signMessage(&tc.Message, "payload")
+	loadDocStateFromSendCommand = func(context context.T,
+		msg *ssmmds.Message,
+		messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		return &fakeDocState, nil
+	}
+	tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+	tc.ProcessMock.On("Submit", fakeDocState).Return(nil)
+
+	svc.processMessage(&tc.Message)
+
+	tc.MdsMock.AssertExpectations(t)
+	tc.ProcessMock.AssertExpectations(t)
+	assert.True(t, *tc.IsDocLevelResponseSent)
+}
+
+// TestProcessMessageWithTamperedSignature tests processMessage rejects a message failing verification before acking it
+func TestProcessMessageWithTamperedSignature(t *testing.T) {
+	VerifyMessage = NewHMACMessageVerifier(testKeySource)
+	defer func() { VerifyMessage = nil }()
+	svc, tc := prepareTestProcessMessage(testTopicSend)
+	signMessage(&tc.Message, "payload")
+	tc.Message.Payload = aws.String("tampered payload")
+	loadDocStateFromSendCommand = func(context context.T,
+		msg *ssmmds.Message,
+		messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+		assert.Fail(t, "a message failing verification must not be parsed")
+		return nil, nil
+	}
+
+	svc.processMessage(&tc.Message)
+
+	tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+	tc.ProcessMock.AssertNotCalled(t, "Submit", mock.Anything)
+	assert.False(t, *tc.IsDocLevelResponseSent)
+}
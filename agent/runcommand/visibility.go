@@ -0,0 +1,72 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/amazon-ssm-agent/agent/metrics"
+	"github.com/aws/amazon-ssm-agent/agent/times"
+)
+
+// errVisibilityExpired is the reason a message whose processing outlasted its visibility is dropped
+var errVisibilityExpired = errors.New("message visibility expired before it was acknowledged")
+
+// Assign the clock to a global variable to allow unittest to override
+var clock times.Clock = times.DefaultClock
+
+// messageReceipts records when the in-flight messages were received
+type messageReceipts struct {
+	received map[string]time.Time
+	m        sync.Mutex
+}
+
+// add records the reception of the message
+func (r *messageReceipts) add(messageID string, received time.Time) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.received == nil {
+		r.received = make(map[string]time.Time)
+	}
+	r.received[messageID] = received
+}
+
+// remove forgets the message and returns when it was received, found is false if its reception wasn't recorded
+func (r *messageReceipts) remove(messageID string) (received time.Time, found bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	received, found = r.received[messageID]
+	delete(r.received, messageID)
+	return
+}
+
+// isVisibilityExpired checks if the message received at the given time is still processed past its visibility,
+// MDS has then redelivered it already and the redelivery is the one to process. A visibility of 0 never expires.
+func isVisibilityExpired(log log.T, visibilitySeconds int, received time.Time) bool {
+	if visibilitySeconds <= 0 {
+		return false
+	}
+	visibility := time.Duration(visibilitySeconds) * time.Second
+	elapsed := clock.Now().Sub(received)
+	if elapsed <= visibility {
+		return false
+	}
+	log.Warnf("message processed %v after its reception, beyond its %v visibility, skip acknowledging it in favor of its redelivery", elapsed, visibility)
+	metrics.DefaultSink.IncrCounter(metrics.ExpiredMessages, 1)
+	return true
+}
@@ -0,0 +1,122 @@
+// Copyright 2017 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package runcommand implements runcommand core processing module
+package runcommand
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/context"
+	"github.com/aws/amazon-ssm-agent/agent/docmanager/model"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/service/ssmmds"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+// fakeClock is a clock whose time only moves when the test sets it
+type fakeClock struct {
+	now time.Time
+}
+
+// Now returns the time set by the test
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+// After returns a channel that never receives
+func (c *fakeClock) After(d time.Duration) chan struct{} {
+	return make(chan struct{})
+}
+
+// TestProcessMessageWithExpiredVisibility tests processMessage only acks the messages processed within their visibility
+func TestProcessMessageWithExpiredVisibility(t *testing.T) {
+	received := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	testCases := []struct {
+		visibilitySeconds int
+		processingTime    time.Duration
+		acked             bool
+	}{
+		{0, time.Hour, true},
+		{30, 10 * time.Second, true},
+		{30, 31 * time.Second, false},
+	}
+	origClock := clock
+	defer func() { clock = origClock }()
+	for _, testCase := range testCases {
+		fake := &fakeClock{now: received}
+		clock = fake
+		config := appconfig.DefaultConfig()
+		config.Mds.MessageVisibilitySeconds = testCase.visibilitySeconds
+		ctx := new(context.Mock)
+		ctx.On("Log").Return(log.NewMockLog())
+		ctx.On("AppConfig").Return(config)
+		ctx.On("With", mock.AnythingOfType("string")).Return(ctx)
+		fakeDocState := model.DocumentState{DocumentType: model.SendCommand}
+		fakeDocState.DocumentInformation.CommandID = "commandID"
+		svc, tc := prepareTestProcessMessage(testTopicSend)
+		svc.context = ctx
+		svc.receipts.add(*tc.Message.MessageId, received)
+		// the message is slow to process, e.g. because of a slow disk
+		loadDocStateFromSendCommand = func(context context.T,
+			msg *ssmmds.Message,
+			messagesOrchestrationRootDir string) (*model.DocumentState, error) {
+			fake.now = fake.now.Add(testCase.processingTime)
+			return &fakeDocState, nil
+		}
+		tc.MdsMock.On("AcknowledgeMessage", mock.Anything, *tc.Message.MessageId).Return(nil)
+		tc.ProcessMock.On("Submit", fakeDocState).Return(nil)
+
+		svc.processMessage(&tc.Message)
+
+		if testCase.acked {
+			tc.MdsMock.AssertExpectations(t)
+			tc.ProcessMock.AssertExpectations(t)
+		} else {
+			tc.MdsMock.AssertNotCalled(t, "AcknowledgeMessage", mock.Anything, mock.Anything)
+			tc.MdsMock.AssertNotCalled(t, "FailMessage", mock.Anything, mock.Anything, mock.Anything)
+			tc.ProcessMock.AssertNotCalled(t, "Submit", mock.Anything)
+			assert.Empty(t, svc.correlator.lookup("commandID"))
+		}
+		assert.Equal(t, testCase.acked, *tc.IsDocLevelResponseSent, "%v", testCase)
+		_, found := svc.receipts.remove(*tc.Message.MessageId)
+		assert.False(t, found)
+	}
+}
+
+// TestPollOnceRecordsMessageReceptions tests pollOnce records when each message was received for processMessage to pick up
+func TestPollOnceRecordsMessageReceptions(t *testing.T) {
+	received := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	origClock := clock
+	clock = &fakeClock{now: received}
+	defer func() { clock = origClock }()
+	proc, tc := prepareTestPollOnce()
+	messageID := "aws.ssm.commandID.i-400e1090"
+	getMessageOutput := ssmmds.GetMessagesOutput{
+		Destination:       &testDestination,
+		Messages:          []*ssmmds.Message{{MessageId: &messageID}},
+		MessagesRequestId: &testMessageId,
+	}
+	tc.MdsMock.On("GetMessages", mock.AnythingOfType("*log.Mock"), mock.AnythingOfType("string")).Return(&getMessageOutput, nil)
+	var recorded time.Time
+	processMessage = func(svc *RunCommandService, msg *ssmmds.Message) {
+		recorded, _ = svc.receipts.remove(*msg.MessageId)
+	}
+
+	proc.pollOnce()
+
+	assert.Equal(t, received, recorded)
+}
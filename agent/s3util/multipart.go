@@ -0,0 +1,199 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package s3util contains methods for interacting with S3.
+package s3util
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/aws/amazon-ssm-agent/agent/appconfig"
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/jsonutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+)
+
+const (
+	// multipartThreshold is the size from which a file is uploaded in parts that can be resumed
+	multipartThreshold = 16 * 1024 * 1024
+	// multipartPartSize is the size of the parts of a file uploaded in parts, S3 requires at least 5MB but for the last part
+	multipartPartSize = 8 * 1024 * 1024
+	// maxPartAttempts is the number of times the upload of a part is tried before the upload is given up
+	maxPartAttempts = 3
+	// uploadStateSuffix is appended to the name of a file being uploaded in parts to name the record of its uploaded parts
+	uploadStateSuffix = ".s3upload"
+	// noSuchUploadErrorCode is returned by S3 for a multipart upload that was aborted or expired
+	noSuchUploadErrorCode = "NoSuchUpload"
+)
+
+// multipartUploadAPI is the part of the S3 API a multipart upload needs
+type multipartUploadAPI interface {
+	CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error)
+	CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error)
+}
+
+// uploadedPart is a part of a file S3 acknowledged
+type uploadedPart struct {
+	PartNumber int64
+	ETag       string
+}
+
+// multipartUploadState records the parts of a file uploaded so far so that a failed upload resumes with the missing parts
+type multipartUploadState struct {
+	Bucket   string
+	Key      string
+	UploadID string
+	Size     int64
+	PartSize int64
+	Parts    []uploadedPart
+}
+
+// resumes checks if the recorded upload is the upload of the same file to the same object
+func (s multipartUploadState) resumes(bucketName, objectKey string, size, partSize int64) bool {
+	return s.UploadID != "" && s.Bucket == bucketName && s.Key == objectKey && s.Size == size && s.PartSize == partSize
+}
+
+// isUploaded checks if the part was already uploaded
+func (s multipartUploadState) isUploaded(partNumber int64) bool {
+	for _, part := range s.Parts {
+		if part.PartNumber == partNumber {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadInParts uploads a file to s3 in parts. The parts S3 acknowledged are recorded next to the file,
+// the next upload of the same file to the same object resumes with the parts that are missing.
+func uploadInParts(log log.T, client multipartUploadAPI, bucketName, objectKey, filePath string, partSize int64) (err error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	size := fileInfo.Size()
+	statePath := filePath + uploadStateSuffix
+
+	var state multipartUploadState
+	if jsonutil.UnmarshalFile(statePath, &state) == nil && state.resumes(bucketName, objectKey, size, partSize) {
+		log.Infof("Resuming the upload of %v to s3://%v/%v, %v parts are already uploaded", filePath, bucketName, objectKey, len(state.Parts))
+	} else {
+		output, err := client.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(objectKey),
+			ContentType: aws.String("text/plain"),
+		})
+		if err != nil {
+			return err
+		}
+		state = multipartUploadState{
+			Bucket:   bucketName,
+			Key:      objectKey,
+			UploadID: aws.StringValue(output.UploadId),
+			Size:     size,
+			PartSize: partSize,
+		}
+		saveUploadState(log, statePath, state)
+	}
+
+	for partNumber, offset := int64(1), int64(0); offset < size; partNumber, offset = partNumber+1, offset+partSize {
+		if state.isUploaded(partNumber) {
+			continue
+		}
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		var eTag string
+		if eTag, err = uploadPart(log, client, state, partNumber, io.NewSectionReader(file, offset, length)); err != nil {
+			if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == noSuchUploadErrorCode {
+				// the upload expired or was aborted, the next upload starts over
+				removeUploadState(log, statePath)
+			}
+			log.Errorf("Failed uploading part %v of %v to s3://%v/%v err:%v", partNumber, filePath, bucketName, objectKey, err)
+			return err
+		}
+		state.Parts = append(state.Parts, uploadedPart{PartNumber: partNumber, ETag: eTag})
+		saveUploadState(log, statePath, state)
+	}
+
+	completedParts := make([]*s3.CompletedPart, 0, len(state.Parts))
+	for _, part := range state.Parts {
+		completedParts = append(completedParts, &s3.CompletedPart{PartNumber: aws.Int64(part.PartNumber), ETag: aws.String(part.ETag)})
+	}
+	if _, err = client.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(bucketName),
+		Key:             aws.String(objectKey),
+		UploadId:        aws.String(state.UploadID),
+		MultipartUpload: &s3.CompletedMultipartUpload{Parts: completedParts},
+	}); err != nil {
+		return err
+	}
+	removeUploadState(log, statePath)
+	return nil
+}
+
+// uploadPart uploads a part of the file, retrying a failed attempt, and returns the ETag S3 acknowledged it with
+func uploadPart(log log.T, client multipartUploadAPI, state multipartUploadState, partNumber int64, body io.ReadSeeker) (eTag string, err error) {
+	for attempt := 1; attempt <= maxPartAttempts; attempt++ {
+		if _, err = body.Seek(0, io.SeekStart); err != nil {
+			return
+		}
+		var output *s3.UploadPartOutput
+		if output, err = client.UploadPart(&s3.UploadPartInput{
+			Bucket:     aws.String(state.Bucket),
+			Key:        aws.String(state.Key),
+			UploadId:   aws.String(state.UploadID),
+			PartNumber: aws.Int64(partNumber),
+			Body:       body,
+		}); err == nil {
+			return aws.StringValue(output.ETag), nil
+		}
+		if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == noSuchUploadErrorCode {
+			return
+		}
+		log.Debugf("Attempt %v to upload part %v failed: %v", attempt, partNumber, err)
+	}
+	return "", fmt.Errorf("failed to upload part %v after %v attempts: %v", partNumber, maxPartAttempts, err)
+}
+
+// saveUploadState records the progress of the upload, the upload still goes on if it can't be recorded
+func saveUploadState(log log.T, statePath string, state multipartUploadState) {
+	content, err := jsonutil.Marshal(state)
+	if err == nil {
+		_, err = fileutil.WriteIntoFileWithPermissions(statePath, content, os.FileMode(int(appconfig.ReadWriteAccess)))
+	}
+	if err != nil {
+		log.Debugf("Failed to record the progress of the upload in %v: %v", statePath, err)
+	}
+}
+
+// removeUploadState deletes the record of the upload
+func removeUploadState(log log.T, statePath string) {
+	if !fileutil.Exists(statePath) {
+		return
+	}
+	if err := fileutil.DeleteFile(statePath); err != nil {
+		log.Debugf("Error deleting file %v: %v", statePath, err)
+	}
+}
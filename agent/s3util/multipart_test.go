@@ -0,0 +1,144 @@
+// Copyright 2016 Amazon.com, Inc. or its affiliates. All Rights Reserved.
+//
+// Licensed under the Apache License, Version 2.0 (the "License"). You may not
+// use this file except in compliance with the License. A copy of the
+// License is located at
+//
+// http://aws.amazon.com/apache2.0/
+//
+// or in the "license" file accompanying this file. This file is distributed
+// on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+// either express or implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+// Package s3util contains methods for interacting with S3.
+package s3util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/aws/amazon-ssm-agent/agent/fileutil"
+	"github.com/aws/amazon-ssm-agent/agent/log"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeMultipartClient keeps the parts uploaded to it, the parts listed in failures fail that many times
+type fakeMultipartClient struct {
+	uploads   int
+	parts     map[int64]string
+	attempts  []int64
+	failures  map[int64]int
+	completed []*s3.CompletedPart
+}
+
+func newFakeMultipartClient() *fakeMultipartClient {
+	return &fakeMultipartClient{parts: make(map[int64]string), failures: make(map[int64]int)}
+}
+
+func (c *fakeMultipartClient) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	c.uploads++
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(fmt.Sprintf("upload-%v", c.uploads))}, nil
+}
+
+func (c *fakeMultipartClient) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	partNumber := aws.Int64Value(input.PartNumber)
+	c.attempts = append(c.attempts, partNumber)
+	if c.failures[partNumber] > 0 {
+		c.failures[partNumber]--
+		return nil, fmt.Errorf("connection reset")
+	}
+	content, err := ioutil.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	c.parts[partNumber] = string(content)
+	return &s3.UploadPartOutput{ETag: aws.String(fmt.Sprintf("etag-%v", partNumber))}, nil
+}
+
+func (c *fakeMultipartClient) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	c.completed = input.MultipartUpload.Parts
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+// object assembles the completed parts
+func (c *fakeMultipartClient) object() string {
+	var object string
+	for _, part := range c.completed {
+		object += c.parts[aws.Int64Value(part.PartNumber)]
+	}
+	return object
+}
+
+func writeUploadedFile(t *testing.T, content string) (filePath string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "s3util")
+	assert.NoError(t, err)
+	filePath = filepath.Join(dir, "stdout")
+	assert.NoError(t, ioutil.WriteFile(filePath, []byte(content), 0600))
+	return filePath, func() { os.RemoveAll(dir) }
+}
+
+func TestUploadInParts(t *testing.T) {
+	content := strings.Repeat("0123456789", 3) + "abcde"
+	filePath, cleanup := writeUploadedFile(t, content)
+	defer cleanup()
+	client := newFakeMultipartClient()
+	// a transient failure is retried
+	client.failures[2] = maxPartAttempts - 1
+
+	err := uploadInParts(log.NewMockLog(), client, "bucket", "prefix/stdout", filePath, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.uploads)
+	assert.Len(t, client.completed, 4)
+	assert.Equal(t, content, client.object())
+	assert.False(t, fileutil.Exists(filePath+uploadStateSuffix))
+}
+
+func TestUploadInPartsResumesAfterPartFailure(t *testing.T) {
+	content := strings.Repeat("0123456789", 3) + "abcde"
+	filePath, cleanup := writeUploadedFile(t, content)
+	defer cleanup()
+	client := newFakeMultipartClient()
+	client.failures[3] = maxPartAttempts
+
+	err := uploadInParts(log.NewMockLog(), client, "bucket", "prefix/stdout", filePath, 10)
+
+	assert.Error(t, err)
+	assert.Nil(t, client.completed)
+	assert.True(t, fileutil.Exists(filePath+uploadStateSuffix))
+
+	// the next upload only sends the parts that are missing, in the same multipart upload
+	client.attempts = nil
+	err = uploadInParts(log.NewMockLog(), client, "bucket", "prefix/stdout", filePath, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 1, client.uploads)
+	assert.Equal(t, []int64{3, 4}, client.attempts)
+	assert.Len(t, client.completed, 4)
+	assert.Equal(t, content, client.object())
+	assert.False(t, fileutil.Exists(filePath+uploadStateSuffix))
+}
+
+func TestUploadInPartsStartsOverForAnotherObject(t *testing.T) {
+	content := strings.Repeat("0123456789", 3)
+	filePath, cleanup := writeUploadedFile(t, content)
+	defer cleanup()
+	client := newFakeMultipartClient()
+	client.failures[2] = maxPartAttempts
+	assert.Error(t, uploadInParts(log.NewMockLog(), client, "bucket", "prefix/stdout", filePath, 10))
+
+	client.attempts = nil
+	err := uploadInParts(log.NewMockLog(), client, "bucket", "other/stdout", filePath, 10)
+
+	assert.NoError(t, err)
+	assert.Equal(t, 2, client.uploads)
+	assert.Equal(t, []int64{1, 2, 3}, client.attempts)
+	assert.Equal(t, content, client.object())
+}
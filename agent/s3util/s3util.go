@@ -64,7 +64,7 @@ func NewAmazonS3Util(log log.T, bucketName string) *AmazonS3Util {
 	}
 }
 
-// S3Upload uploads a file to s3.
+// S3Upload uploads a file to s3, a large file is uploaded in parts so that a failed upload resumes where it stopped.
 func (u *AmazonS3Util) S3Upload(log log.T, bucketName string, objectKey string, filePath string) (err error) {
 	file, err := os.Open(filePath)
 	if err != nil {
@@ -74,14 +74,21 @@ func (u *AmazonS3Util) S3Upload(log log.T, bucketName string, objectKey string,
 	defer file.Close()
 
 	log.Infof("Uploading %v to s3://%v/%v", filePath, bucketName, objectKey)
-	params := &s3manager.UploadInput{
-		Bucket:      aws.String(bucketName),
-		Key:         aws.String(objectKey),
-		Body:        file,
-		ContentType: aws.String("text/plain"),
+	if fileInfo, statErr := file.Stat(); statErr == nil && fileInfo.Size() >= multipartThreshold {
+		err = uploadInParts(log, u.myUploader.S3, bucketName, objectKey, filePath, multipartPartSize)
+	} else {
+		params := &s3manager.UploadInput{
+			Bucket:      aws.String(bucketName),
+			Key:         aws.String(objectKey),
+			Body:        file,
+			ContentType: aws.String("text/plain"),
+		}
+		var result *s3manager.UploadOutput
+		if result, err = u.myUploader.Upload(params); err == nil {
+			log.Infof("Successfully uploaded file to %v", result.Location)
+		}
 	}
-	if result, err := u.myUploader.Upload(params); err == nil {
-		log.Infof("Successfully uploaded file to ", result.Location)
+	if err == nil {
 		if _, aclErr := u.myUploader.S3.PutObjectAcl(&s3.PutObjectAclInput{
 			Bucket: aws.String(bucketName),
 			Key:    aws.String(objectKey),
@@ -32,6 +32,20 @@ const (
 	ShutDown State = 3
 )
 
+// CancelReason tells why a job was canceled.
+type CancelReason string
+
+const (
+	// CancelReasonUserRequested indicates a job canceled by a cancel command.
+	CancelReasonUserRequested CancelReason = "UserRequested"
+
+	// CancelReasonSuperseded indicates a job canceled because a newer command replaced it.
+	CancelReasonSuperseded CancelReason = "Superseded"
+
+	// CancelReasonTimeout indicates a job canceled because it ran out of time.
+	CancelReasonTimeout CancelReason = "Timeout"
+)
+
 // CancelFlag is an object that is passed to any job submitted to a task in order to
 // communicated job cancellation. Job cancellation has to be cooperative.
 type CancelFlag interface {
@@ -60,9 +74,24 @@ type CancelFlag interface {
 	Wait() (state State)
 }
 
+// reasonedCancelFlag is implemented by the cancel flags that record why the job was canceled.
+type reasonedCancelFlag interface {
+	Reason() CancelReason
+}
+
+// CancelReasonOf returns the reason the job of the given flag was canceled with,
+// empty if no reason was given or the flag doesn't record reasons.
+func CancelReasonOf(flag CancelFlag) CancelReason {
+	if reasoned, ok := flag.(reasonedCancelFlag); ok {
+		return reasoned.Reason()
+	}
+	return ""
+}
+
 // ChanneledCancelFlag is a default implementation of the task.CancelFlag interface.
 type ChanneledCancelFlag struct {
 	state  State
+	reason CancelReason
 	ch     chan struct{}
 	closed bool
 	m      sync.RWMutex
@@ -101,11 +130,24 @@ func (t *ChanneledCancelFlag) Wait() (state State) {
 	return t.State()
 }
 
+// Reason returns the reason this flag was canceled with.
+func (t *ChanneledCancelFlag) Reason() CancelReason {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	return t.reason
+}
+
 // Set sets the state of this flag and wakes up waiting callers.
 func (t *ChanneledCancelFlag) Set(state State) {
+	t.SetWithReason(state, "")
+}
+
+// SetWithReason sets the state of this flag along with the reason of the change, and wakes up waiting callers.
+func (t *ChanneledCancelFlag) SetWithReason(state State, reason CancelReason) {
 	t.m.Lock()
 	defer t.m.Unlock()
 	t.state = state
+	t.reason = reason
 
 	// close channel to wake up routines that are waiting
 	if !t.closed {
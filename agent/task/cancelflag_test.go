@@ -38,6 +38,22 @@ func TestCanceled(t *testing.T) {
 
 }
 
+// TestCancelReason tests that the reason of the cancellation is returned along with the state
+func TestCancelReason(t *testing.T) {
+	cancelFlag := NewChanneledCancelFlag()
+	assert.Equal(t, CancelReason(""), CancelReasonOf(cancelFlag))
+
+	cancelFlag.SetWithReason(Canceled, CancelReasonSuperseded)
+	assert.True(t, cancelFlag.Canceled())
+	assert.Equal(t, CancelReasonSuperseded, CancelReasonOf(cancelFlag))
+
+	cancelFlag.Set(ShutDown)
+	assert.Equal(t, CancelReason(""), cancelFlag.Reason())
+
+	// flags that don't record reasons have none
+	assert.Equal(t, CancelReason(""), CancelReasonOf(new(MockCancelFlag)))
+}
+
 // TestWait tests that the Wait method blocks the caller and returns the
 // correct state once unblocked
 func TestWait(t *testing.T) {
@@ -54,6 +54,16 @@ func (t *JobStore) GetJob(jobID string) (token *JobToken, found bool) {
 	return s, ok
 }
 
+// JobIDs returns the ids of the jobs of this task.
+func (t *JobStore) JobIDs() (jobIDs []string) {
+	t.m.RLock()
+	defer t.m.RUnlock()
+	for jobID := range t.jobs {
+		jobIDs = append(jobIDs, jobID)
+	}
+	return
+}
+
 // DeleteJob deletes the job with the given jobID.
 func (t *JobStore) DeleteJob(jobID string) {
 	t.m.Lock()
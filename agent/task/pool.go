@@ -15,6 +15,7 @@ package task
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
@@ -35,6 +36,9 @@ type Pool interface {
 	// Returns true if the job has been found and canceled, false if the job was not found.
 	Cancel(jobID string) bool
 
+	// CancelWithReason cancels the given job like Cancel, the job can read the reason from its CancelFlag.
+	CancelWithReason(jobID string, reason CancelReason) bool
+
 	// Shutdown cancels all the jobs and shuts down the workers.
 	Shutdown()
 
@@ -43,8 +47,15 @@ type Pool interface {
 	// workers terminated before the timeout or false if the timeout expired.
 	ShutdownAndWait(timeout time.Duration) (finished bool)
 
+	// ShutdownAndWaitProtected shuts down the pool like ShutdownAndWait, except for the jobs protected reports true for:
+	// they're left running until protectedTimeout elapses, then shut down, not canceled, so that they can be resumed later.
+	ShutdownAndWaitProtected(timeout time.Duration, protectedTimeout time.Duration, protected func(jobID string) bool) (finished bool)
+
 	// HasJob returns if jobStore has specified job
 	HasJob(jobID string) bool
+
+	// JobIDs returns the ids of the jobs submitted to the pool that haven't completed yet
+	JobIDs() []string
 }
 
 // pool implements a task pool where all jobs are managed by a root task
@@ -133,7 +144,77 @@ func (p *pool) ShutdownAndWait(timeout time.Duration) (finished bool) {
 		case <-timeoutTimer:
 			p.log.Debugf("Pool shutdown timed out with %d workers still running, start cancelling jobs...", workersRunning)
 			// wait for the worker pool to react to the cancel flag and fail the ongoing jobs
-			p.CancelAll()
+			p.cancelAll(CancelReasonTimeout)
+		case <-exitTimer:
+			p.log.Debugf("Pool eventual timeout with %d workers still running ", workersRunning)
+			return false
+		}
+	}
+	return true
+}
+
+// ShutdownAndWaitProtected shuts down the jobs protected doesn't report and closes the pool, the unprotected jobs still running
+// after timeout are canceled and the protected jobs still running after protectedTimeout are shut down.
+// Returns true if all workers terminated before both timeouts and the cancel wait duration elapsed.
+func (p *pool) ShutdownAndWaitProtected(timeout time.Duration, protectedTimeout time.Duration, protected func(jobID string) bool) (finished bool) {
+	protectedJobs := make(map[string]*JobToken)
+	shutDownJobs := make(map[string]*JobToken)
+	for _, jobID := range p.jobStore.JobIDs() {
+		token, found := p.jobStore.GetJob(jobID)
+		if !found {
+			continue
+		}
+		if protected(jobID) {
+			protectedJobs[jobID] = token
+			continue
+		}
+		shutDownJobs[jobID] = token
+		p.shutDownJob(jobID)
+	}
+
+	p.mut.Lock()
+	if !p.isShutdown {
+		close(p.jobQueue)
+		p.isShutdown = true
+	}
+	p.mut.Unlock()
+
+	timeoutTimer := p.clock.After(timeout)
+	// no protected job, no deadline to wait for
+	var protectedTimer chan struct{}
+	exitTimeout := timeout
+	if len(protectedJobs) > 0 {
+		p.log.Infof("letting the protected jobs %v finish before shutting down", jobIDs(protectedJobs))
+		protectedTimer = p.clock.After(protectedTimeout)
+		if protectedTimeout > exitTimeout {
+			exitTimeout = protectedTimeout
+		}
+	}
+	exitTimer := p.clock.After(exitTimeout + p.cancelDuration)
+	workersRunning := p.nWorkers
+	for workersRunning > 0 {
+		select {
+		case <-p.doneWorker:
+			workersRunning--
+			if workersRunning == 0 {
+				p.log.Debug("Pool shutdown normally.")
+				return true
+			}
+			p.log.Debugf("Pool worker done; %d still running", workersRunning)
+
+		case <-timeoutTimer:
+			p.log.Debugf("Pool shutdown timed out with %d workers still running, cancelling the unprotected jobs...", workersRunning)
+			// the jobs shut down are out of the job store, their flags are canceled directly
+			for _, token := range shutDownJobs {
+				if token.cancelFlag.ShutDown() {
+					token.cancelFlag.SetWithReason(Canceled, CancelReasonTimeout)
+				}
+			}
+		case <-protectedTimer:
+			p.log.Infof("protected jobs still running after %v, shutting them down so they resume later", protectedTimeout)
+			for jobID := range protectedJobs {
+				p.shutDownJob(jobID)
+			}
 		case <-exitTimer:
 			p.log.Debugf("Pool eventual timeout with %d workers still running ", workersRunning)
 			return false
@@ -142,6 +223,25 @@ func (p *pool) ShutdownAndWait(timeout time.Duration) (finished bool) {
 	return true
 }
 
+// shutDownJob sets the ShutDown state of the job and removes it from the pool
+func (p *pool) shutDownJob(jobID string) {
+	token, found := p.jobStore.GetJob(jobID)
+	if !found {
+		return
+	}
+	p.jobStore.DeleteJob(jobID)
+	token.cancelFlag.Set(ShutDown)
+}
+
+// jobIDs returns the ids of the given jobs
+func jobIDs(jobs map[string]*JobToken) (ids []string) {
+	for id := range jobs {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return
+}
+
 // start starts the workers of this pool
 func (p *pool) start(jobProcessor func(JobToken)) {
 	for i := 0; i < p.nWorkers; i++ {
@@ -189,8 +289,18 @@ func (p *pool) HasJob(jobID string) bool {
 	return found
 }
 
+// JobIDs returns the ids of the jobs queued or running in the pool
+func (p *pool) JobIDs() []string {
+	return p.jobStore.JobIDs()
+}
+
 // Cancel cancels the job with the given id.
 func (p *pool) Cancel(jobID string) (canceled bool) {
+	return p.CancelWithReason(jobID, "")
+}
+
+// CancelWithReason cancels the job with the given id for the given reason.
+func (p *pool) CancelWithReason(jobID string, reason CancelReason) (canceled bool) {
 	jobToken, found := p.jobStore.GetJob(jobID)
 	if !found {
 		return false
@@ -199,18 +309,23 @@ func (p *pool) Cancel(jobID string) (canceled bool) {
 	// delete job to avoid multiple cancelations
 	p.jobStore.DeleteJob(jobID)
 
-	jobToken.cancelFlag.Set(Canceled)
+	jobToken.cancelFlag.SetWithReason(Canceled, reason)
 	return true
 }
 
 // CancelAll cancels all the running jobs.
 func (p *pool) CancelAll() {
+	p.cancelAll("")
+}
+
+// cancelAll cancels all the running jobs for the given reason.
+func (p *pool) cancelAll(reason CancelReason) {
 	// remove jobs from task and save them to a local variable
 	jobs := p.jobStore.DeleteAllJobs()
 
 	// cancel each job
 	for _, token := range jobs {
-		token.cancelFlag.Set(Canceled)
+		token.cancelFlag.SetWithReason(Canceled, reason)
 	}
 }
 
@@ -119,3 +119,73 @@ func exercisePool(t *testing.T, pool Pool, jobID string, shouldCancel bool) {
 	// see that job completes
 	assert.True(t, <-jobState)
 }
+
+func TestShutdownAndWaitProtected(t *testing.T) {
+	pool := NewPool(logger, 2, 100*time.Millisecond, times.DefaultClock)
+	started := make(chan bool, 2)
+	stopped := make(chan State, 2)
+	var protectedStopped time.Time
+	job := func(protected bool) Job {
+		return func(cancelFlag CancelFlag) {
+			started <- true
+			state := cancelFlag.Wait()
+			if protected {
+				protectedStopped = time.Now()
+			}
+			stopped <- state
+		}
+	}
+	assert.NoError(t, pool.Submit(logger, "protected", job(true)))
+	assert.NoError(t, pool.Submit(logger, "unprotected", job(false)))
+	<-started
+	<-started
+
+	protectedTimeout := 300 * time.Millisecond
+	start := time.Now()
+	finished := pool.ShutdownAndWaitProtected(20*time.Millisecond, protectedTimeout, func(jobID string) bool { return jobID == "protected" })
+
+	assert.True(t, finished)
+	// the unprotected job is shut down right away, the protected one once its deadline is hit, neither is canceled
+	assert.Equal(t, ShutDown, <-stopped)
+	assert.Equal(t, ShutDown, <-stopped)
+	assert.True(t, protectedStopped.Sub(start) >= protectedTimeout)
+}
+
+func TestShutdownAndWaitProtectedJobCompletes(t *testing.T) {
+	pool := NewPool(logger, 1, 100*time.Millisecond, times.DefaultClock)
+	release := make(chan bool)
+	interrupted := true
+	assert.NoError(t, pool.Submit(logger, "protected", func(cancelFlag CancelFlag) {
+		<-release
+		interrupted = cancelFlag.Canceled() || cancelFlag.ShutDown()
+	}))
+	time.AfterFunc(50*time.Millisecond, func() { close(release) })
+
+	start := time.Now()
+	finished := pool.ShutdownAndWaitProtected(10*time.Millisecond, 5*time.Second, func(jobID string) bool { return true })
+
+	assert.True(t, finished)
+	assert.True(t, time.Since(start) < 5*time.Second)
+	// the protected job completes without being asked to stop
+	assert.False(t, interrupted)
+}
+
+func TestShutdownAndWaitProtectedCancelsJobsWithTimeoutReason(t *testing.T) {
+	pool := NewPool(logger, 1, 100*time.Millisecond, times.DefaultClock)
+	started := make(chan bool)
+	reason := make(chan CancelReason, 1)
+	assert.NoError(t, pool.Submit(logger, "unprotected", func(cancelFlag CancelFlag) {
+		close(started)
+		// the job ignores the shut down and only stops once it's canceled
+		for !cancelFlag.Canceled() {
+			time.Sleep(time.Millisecond)
+		}
+		reason <- CancelReasonOf(cancelFlag)
+	}))
+	<-started
+
+	finished := pool.ShutdownAndWaitProtected(20*time.Millisecond, time.Second, func(jobID string) bool { return false })
+
+	assert.True(t, finished)
+	assert.Equal(t, CancelReasonTimeout, <-reason)
+}
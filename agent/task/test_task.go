@@ -38,6 +38,11 @@ func (mockPool *MockedPool) Cancel(jobID string) bool {
 	return mockPool.Called(jobID).Bool(0)
 }
 
+// CancelWithReason mocks the method with the same name.
+func (mockPool *MockedPool) CancelWithReason(jobID string, reason CancelReason) bool {
+	return mockPool.Called(jobID, reason).Bool(0)
+}
+
 // Shutdown mocks the method with the same name.
 func (mockPool *MockedPool) Shutdown() {
 	mockPool.Called()
@@ -49,12 +54,24 @@ func (mockPool *MockedPool) ShutdownAndWait(timeout time.Duration) (finished boo
 	return args.Bool(0)
 }
 
+// ShutdownAndWaitProtected mocks the method with the same name.
+func (mockPool *MockedPool) ShutdownAndWaitProtected(timeout time.Duration, protectedTimeout time.Duration, protected func(jobID string) bool) (finished bool) {
+	args := mockPool.Called(timeout, protectedTimeout, protected)
+	return args.Bool(0)
+}
+
 // ShutdownAndWait mocks the method with the same name.
 func (mockPool *MockedPool) HasJob(jobID string) bool {
 	args := mockPool.Called(jobID)
 	return args.Bool(0)
 }
 
+// JobIDs mocks the method with the same name.
+func (mockPool *MockedPool) JobIDs() []string {
+	args := mockPool.Called()
+	return args.Get(0).([]string)
+}
+
 // MockCancelFlag mocks a cancel flag.
 type MockCancelFlag struct {
 	mock.Mock